@@ -86,6 +86,7 @@ func NewHTTPServer(config c.Config, reqch chan<- Request) Server {
 	mux := http.NewServeMux()
 	mux.HandleFunc(s.urlPrefix, s.systemHandler)
 	mux.HandleFunc("/debug/vars", s.expvarHandler)
+	mux.Handle("/metrics", c.DefaultMetrics)
 	s.srv = &http.Server{
 		Addr:           s.laddr,
 		Handler:        mux,
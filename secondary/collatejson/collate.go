@@ -27,8 +27,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"sort"
 	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 // ErrorNumberType means configured number type is not supported by codec.
@@ -37,6 +43,16 @@ var ErrorNumberType = errors.New("collatejson.numberType")
 // ErrorOutputLen means output buffer has insufficient length.
 var ErrorOutputLen = errors.New("collatejson.outputLen")
 
+// ErrorInvalidArray means input is not a binary encoded JSON array.
+var ErrorInvalidArray = errors.New("collatejson.invalidArray")
+
+// ErrorInvalidOriginals means original strings, that follow a binary
+// encoded JSON document with collated strings, are malformed.
+var ErrorInvalidOriginals = errors.New("collatejson.invalidOriginals")
+
+// ErrorInvalidNumber means input is not a valid JSON number.
+var ErrorInvalidNumber = errors.New("collatejson.invalidNumber")
+
 // Length is an internal type used for prefixing length
 // of arrays and properties.
 type Length int64
@@ -70,17 +86,23 @@ type Codec struct {
 	arrayLenPrefix    bool        // if true, first sort arrays based on its length
 	propertyLenPrefix bool        // if true, first sort properties based on length
 	doMissing         bool        // if true, handle missing values (for N1QL)
-	numberType        interface{} // "float64" | "int64" | "decimal"
+	numberType        interface{} // "float64" | "int64" | "decimal" | "json.Number"
+	descending        []bool      // top-level array items to sort descending
 	//-- unicode
-	//backwards        bool
-	//hiraganaQ        bool
-	//caseLevel        bool
-	//numeric          bool
-	//nfkd              bool
-	//utf8              bool
-	//strength          colltab.Level
-	//alternate         collate.AlternateHandling
-	//language          language.Tag
+	nfkd      bool
+	utf8      bool
+	language  language.Tag
+	options   []collate.Option
+	originals bool // if true, append original strings of collated strings
+	collator  *collate.Collator
+	buf       collate.Buffer
+	keys      [][]byte // collation keys, in the order they are encoded
+	strings   []string // original strings, in the order they are encoded
+	//-- streaming
+	props    []propSpan // properties of objects being encoded
+	scratch  []byte     // for sorting properties and decoding strings
+	digits   []byte     // for decoding numbers
+	inverted []byte     // for decoding descending items
 }
 
 // NewCodec creates a new codec object and returns a reference to it.
@@ -89,7 +111,9 @@ func NewCodec(propSize int) *Codec {
 		arrayLenPrefix:    false,
 		propertyLenPrefix: true,
 		doMissing:         true,
-		numberType:        float64(0.0),
+		numberType:        json.Number("0"),
+		utf8:              true,
+		originals:         true,
 	}
 }
 
@@ -112,7 +136,11 @@ func (codec *Codec) UseMissing(what bool) {
 }
 
 // NumberType chooses type of encoding / decoding for JSON numbers. Can be
-// "float64", "int64", "decimal". Default is "float64"
+// "float64", "int64", "decimal", "json.Number". With "json.Number", numbers
+// are encoded with their exact value, integers and decimals of any size
+// and precision, and they are decoded back in their shortest form.
+// Numbers that can be represented as float64 are encoded same as with
+// "float64", hence they sort alike. Default is "json.Number".
 func (codec *Codec) NumberType(what string) {
 	switch what {
 	case "float64":
@@ -121,23 +149,62 @@ func (codec *Codec) NumberType(what string) {
 		codec.numberType = int64(0)
 	case "decimal":
 		codec.numberType = "0"
+	case "json.Number":
+		codec.numberType = json.Number("0")
 	}
 }
 
+// SortbyDescending sorts items of a top-level array, at positions for which
+// desc is true, in descending order. Binary representation of such items is
+// inverted and they are decoded back without configuring the codec. Default
+// is to sort all items in ascending order.
+func (codec *Codec) SortbyDescending(desc []bool) {
+	codec.descending = desc
+}
+
+// EncodeOriginals appends original strings, in the order they occur in the
+// document, to the binary representation of a document whose strings are
+// encoded by unicode collation. Collated strings can not be decoded back,
+// while the appended strings are neither compared nor can they be trimmed
+// as a prefix. Use `false` for keys that are only compared, like scan keys.
+// Default is `true`.
+func (codec *Codec) EncodeOriginals(what bool) {
+	codec.originals = what
+}
+
 // Encode json documents to order preserving binary representation.
 // `code` is the output buffer for encoding and expected to have
-// enough capacity, atleast 3x of input `text`.
+// enough capacity, atleast 3x of input `text`. When strings are encoded by
+// unicode collation, output buffer is re-allocated if it is insufficient
+// for the collation keys.
 func (codec *Codec) Encode(text, code []byte) ([]byte, error) {
 	code = code[:0]
 	if cap(code) < (3 * len(text)) {
 		return nil, ErrorOutputLen
 	}
 
-	var m interface{}
-	if err := json.Unmarshal(text, &m); err != nil {
+	m, err := codec.unmarshal(text)
+	if err != nil {
 		return nil, err
 	}
-	return codec.json2code(m, code)
+	if codec.isCollating() {
+		codec.keys, codec.strings = codec.keys[:0], codec.strings[:0]
+		if n := 3*len(text) + codec.collationKeys(m); cap(code) < n {
+			code = make([]byte, 0, n)
+		}
+	}
+	out, err := codec.json2code(m, code)
+	if _, ok := m.([]interface{}); ok && err == nil && codec.descending != nil {
+		out, err = codec.invertItems(out)
+	}
+	if err == nil && codec.isCollating() && codec.originals {
+		for _, s := range codec.strings {
+			out = append(out, TypeString)
+			out = suffixEncodeString([]byte(s), out)
+			out = append(out, Terminator)
+		}
+	}
+	return out, err
 }
 
 // Decode a slice of byte into json string and return them as
@@ -148,10 +215,121 @@ func (codec *Codec) Decode(code, text []byte) ([]byte, error) {
 	if cap(text) < len(code) {
 		return nil, ErrorOutputLen
 	}
-	text, _, err := codec.code2json(code, text)
+	strs, err := readOriginals(code)
+	if err != nil {
+		return nil, err
+	}
+	codec.strings = strs
+	text, _, err = codec.code2json(code, text)
+	codec.strings = nil
 	return text, err
 }
 
+// unmarshal JSON text, numbers are unmarshalled as json.Number if they are
+// to be encoded with their exact value.
+func (codec *Codec) unmarshal(text []byte) (interface{}, error) {
+	var m interface{}
+	if _, ok := codec.numberType.(json.Number); !ok {
+		err := json.Unmarshal(text, &m)
+		return m, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(text))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	} else if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrorInvalidNumber // trailing data after JSON value
+	}
+	return m, nil
+}
+
+// readOriginals returns original strings appended after the binary
+// representation of a document, nil if there are none.
+func readOriginals(code []byte) ([]string, error) {
+	if len(code) == 0 || code[0] == Terminator {
+		return nil, nil
+	}
+	remaining, err := skipItem(code)
+	if err != nil {
+		return nil, nil // let the decoder report malformed code
+	}
+
+	var strs []string
+	for len(remaining) > 0 {
+		if remaining[0] != TypeString {
+			return nil, ErrorInvalidOriginals
+		}
+		s := make([]byte, 0)
+		if s, remaining, err = suffixDecodeString(remaining[1:], s); err != nil {
+			return nil, ErrorInvalidOriginals
+		}
+		strs = append(strs, string(s))
+	}
+	return strs, nil
+}
+
+// TrimLastItem expects `code` to be binary representation of a JSON array
+// and returns its prefix that encodes all but the last item of the array.
+// Encoded prefixes of two arrays are byte-wise equal only if all their
+// items, other than the last one, are equal. Returned slice shares the
+// underlying buffer of `code`.
+func TrimLastItem(code []byte) ([]byte, error) {
+	if len(code) == 0 || code[0] != TypeArray {
+		return nil, ErrorInvalidArray
+	}
+
+	var err error
+	last, remaining := 1, code[1:]
+	for len(remaining) > 0 && remaining[0] != Terminator {
+		last = len(code) - len(remaining)
+		if remaining, err = skipItem(remaining); err != nil {
+			return nil, err
+		}
+	}
+	if len(remaining) == 0 {
+		return nil, ErrorInvalidArray
+	}
+	return code[:last], nil
+}
+
+// invertItems inverts binary representation of top-level array items, that
+// are configured to sort descending, in place. Every encoded item ends with
+// a Terminator that can not occur inside the item, hence inverting its bytes
+// reverses the sort order of the item.
+func (codec *Codec) invertItems(code []byte) ([]byte, error) {
+	var err error
+	items := code[1:]
+	if codec.arrayLenPrefix {
+		if items, err = skipItem(items); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; len(items) > 0 && items[0] != Terminator; i++ {
+		remaining, err := skipItem(items)
+		if err != nil {
+			return nil, err
+		}
+		if i < len(codec.descending) && codec.descending[i] {
+			invert(items[:len(items)-len(remaining)])
+		}
+		items = remaining
+	}
+	return code, nil
+}
+
+// isDescending checks whether an encoded item starts with inverted type-byte.
+func isDescending(b byte) bool {
+	return b >= ^TypeObj
+}
+
+func invert(code []byte) []byte {
+	for i, b := range code {
+		code[i] = ^b
+	}
+	return code
+}
+
 // local function that encodes basic json types to binary representation.
 // composite types recursively call this function.
 func (codec *Codec) json2code(val interface{}, code []byte) ([]byte, error) {
@@ -178,6 +356,14 @@ func (codec *Codec) json2code(val interface{}, code []byte) ([]byte, error) {
 			code = append(code, Terminator)
 		}
 
+	case json.Number:
+		code = append(code, TypeNumber)
+		cs, err = codec.normalizeNumber(value, code[1:])
+		if err == nil {
+			code = code[:len(code)+len(cs)]
+			code = append(code, Terminator)
+		}
+
 	case int:
 		code = append(code, TypeNumber)
 		cs = EncodeInt([]byte(strconv.Itoa(value)), code[1:])
@@ -194,6 +380,13 @@ func (codec *Codec) json2code(val interface{}, code []byte) ([]byte, error) {
 		if codec.doMissing && MissingLiteral.Equal(value) {
 			code = append(code, TypeMissing)
 			code = append(code, Terminator)
+		} else if codec.isCollating() {
+			key := codec.keys[0]
+			codec.keys = codec.keys[1:]
+			code = append(code, TypeString)
+			cs = suffixEncodeString(key, code[1:])
+			code = code[:len(code)+len(cs)]
+			code = append(code, Terminator)
 		} else {
 			code = append(code, TypeString)
 			cs = suffixEncodeString([]byte(value), code[1:])
@@ -253,6 +446,35 @@ func (codec *Codec) json2code(val interface{}, code []byte) ([]byte, error) {
 	return code, err
 }
 
+// collationKeys computes collation keys of strings in val, in the order
+// they are encoded by json2code, and returns the size of output buffer
+// required to encode them.
+func (codec *Codec) collationKeys(val interface{}) (n int) {
+	switch value := val.(type) {
+	case string:
+		if codec.doMissing && MissingLiteral.Equal(value) {
+			return 0
+		}
+		key := append([]byte(nil), codec.EncodeUnicodeString(value)...)
+		codec.keys = append(codec.keys, key)
+		codec.strings = append(codec.strings, value)
+		// Terminators inside the key are escaped, type-byte and two
+		// Terminators end the item.
+		return 2*len(key) + 3
+
+	case []interface{}:
+		for _, val := range value {
+			n += codec.collationKeys(val)
+		}
+
+	case map[string]interface{}:
+		for _, key := range codec.sortProps(value) {
+			n += codec.collationKeys(key) + codec.collationKeys(value[key])
+		}
+	}
+	return n
+}
+
 var null = []byte("null")
 var boolTrue = []byte("true")
 var boolFalse = []byte("false")
@@ -265,6 +487,15 @@ func (codec *Codec) code2json(code, text []byte) ([]byte, []byte, error) {
 	var ts, remaining, datum []byte
 	var err error
 
+	if isDescending(code[0]) {
+		if remaining, err = skipItem(code); err != nil {
+			return text, code, err
+		}
+		item := invert(append([]byte(nil), code[:len(code)-len(remaining)]...))
+		text, _, err = codec.code2json(item, text)
+		return text, remaining, err
+	}
+
 	switch code[0] {
 	case Terminator:
 		remaining = code
@@ -298,13 +529,17 @@ func (codec *Codec) code2json(code, text []byte) ([]byte, []byte, error) {
 		text = append(text, ts...)
 
 	case TypeString:
-		s := make([]byte, 0)
-		s, remaining, err = suffixDecodeString(code[1:], s)
+		var s []byte
+		s, remaining, err = suffixDecodeString(code[1:], codec.scratch[:0])
 		if err == nil {
-			s, err = json.Marshal(string(s))
-			if err == nil {
-				text = append(text, s...)
-			}
+			codec.scratch = s
+		}
+		if err == nil && len(codec.strings) > 0 {
+			// collated string, decode its original string
+			s, codec.strings = []byte(codec.strings[0]), codec.strings[1:]
+		}
+		if err == nil {
+			text, err = appendJSONString(text, s)
 		}
 
 	case TypeArray:
@@ -407,6 +642,26 @@ func (codec *Codec) code2json(code, text []byte) ([]byte, []byte, error) {
 	return text, remaining, err
 }
 
+// appendJSONString appends `s` as JSON string to `text`, same as
+// json.Marshal does. Strings that need not be escaped are appended without
+// allocating.
+func appendJSONString(text, s []byte) ([]byte, error) {
+	for _, c := range s {
+		switch {
+		case c < 0x20, c >= utf8.RuneSelf, c == '"', c == '\\',
+			c == '<', c == '>', c == '&':
+			js, err := json.Marshal(string(s))
+			if err != nil {
+				return text, err
+			}
+			return append(text, js...), nil
+		}
+	}
+	text = append(text, '"')
+	text = append(text, s...)
+	return append(text, '"'), nil
+}
+
 // local function that sorts JSON property objects based on property names.
 func (codec *Codec) sortProps(props map[string]interface{}) []string {
 	keys := make([]string, 0, len(props))
@@ -432,6 +687,61 @@ func getDatum(code []byte) (datum []byte, remaining []byte) {
 	return code[:i], code[i+1:]
 }
 
+// skip an encoded item, basic or composite, and return the remaining code
+// that starts after the item's Terminator.
+func skipItem(code []byte) ([]byte, error) {
+	var err error
+
+	if isDescending(code[0]) {
+		remaining, err := skipItem(invert(append([]byte(nil), code...)))
+		if err != nil {
+			return nil, err
+		}
+		return code[len(code)-len(remaining):], nil
+	}
+
+	switch code[0] {
+	case TypeString:
+		// string is suffix encoded, Terminator inside the string is
+		// escaped as {Terminator, 1}, and it ends with two Terminators.
+		for i := 1; i < len(code)-1; i++ {
+			if code[i] != Terminator {
+				continue
+			}
+			switch code[i+1] {
+			case 1:
+				i++
+			case Terminator:
+				return code[i+2:], nil
+			default:
+				return nil, ErrorSuffixDecoding
+			}
+		}
+		return nil, ErrorSuffixDecoding
+
+	case TypeArray, TypeObj:
+		code = code[1:]
+		for len(code) > 0 && code[0] != Terminator {
+			if code, err = skipItem(code); err != nil {
+				return nil, err
+			}
+		}
+		if len(code) == 0 {
+			return nil, ErrorInvalidArray
+		}
+		return code[1:], nil
+
+	case Terminator:
+		return nil, ErrorInvalidArray
+	}
+
+	if bytes.IndexByte(code, Terminator) < 0 {
+		return nil, ErrorInvalidArray
+	}
+	_, code = getDatum(code)
+	return code, nil
+}
+
 func (codec *Codec) normalizeFloat(value float64, code []byte) ([]byte, error) {
 	switch codec.numberType.(type) {
 	case float64:
@@ -441,13 +751,106 @@ func (codec *Codec) normalizeFloat(value float64, code []byte) ([]byte, error) {
 	case int64:
 		return EncodeInt([]byte(strconv.Itoa(int(value))), code), nil
 
-	case string:
+	case string, json.Number:
 		cs := EncodeFloat([]byte(strconv.FormatFloat(value, 'e', -1, 64)), code)
 		return cs, nil
 	}
 	return nil, ErrorNumberType
 }
 
+// normalizeNumber encodes exact value of JSON number, like EncodeFloat
+// does for floating point number in its scientific notation.
+func (codec *Codec) normalizeNumber(value json.Number, code []byte) ([]byte, error) {
+	sign, digits, exp, err := parseNumber(string(value))
+	if err != nil {
+		return nil, err
+	} else if len(digits) == 0 {
+		return append(code, ZERO), nil
+	}
+
+	// [-]d.ddde<exp>, where exponent is for the leading digit
+	x := [128]byte{}
+	text := append(x[:0], sign...)
+	text = append(text, digits[0])
+	if len(digits) > 1 {
+		text = append(text, DOT)
+		text = append(text, digits[1:]...)
+	}
+	text = append(text, 'e')
+	text = strconv.AppendInt(text, exp-1, 10)
+	return EncodeFloat(text, code), nil
+}
+
+// parseNumber returns the sign, significant digits, without leading and
+// trailing zeros, and exponent of JSON number `text`, such that its value
+// is 0.<digits> * 10^exp. Digits are empty for zero.
+func parseNumber(text string) (sign, digits string, exp int64, err error) {
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	mant := text
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		mant = text[:i]
+		if exp, err = strconv.ParseInt(text[i+1:], 10, 32); err != nil {
+			return "", "", 0, ErrorInvalidNumber
+		}
+	}
+	point := len(mant)
+	if i := strings.IndexByte(mant, DOT); i >= 0 {
+		point, mant = i, mant[:i]+mant[i+1:]
+	}
+	if len(mant) == 0 || strings.Trim(mant, "0123456789") != "" {
+		return "", "", 0, ErrorInvalidNumber
+	}
+
+	trimmed := strings.TrimLeft(mant, "0")
+	point -= len(mant) - len(trimmed)
+	digits = strings.TrimRight(trimmed, "0")
+	if len(digits) == 0 {
+		return "", "", 0, nil
+	}
+	return sign, digits, exp + int64(point), nil
+}
+
+// denormalizeNumber converts number decoded by DecodeFloat, in the form
+// of [+-]0.<digits>e[+-]<exp>, to its shortest JSON text.
+func denormalizeNumber(text []byte) ([]byte, error) {
+	sign, digits, exp, err := parseNumber(strings.TrimLeft(string(text), "+"))
+	if err != nil {
+		return nil, err
+	} else if len(digits) == 0 {
+		return []byte{ZERO}, nil
+	}
+
+	out := append(make([]byte, 0, len(digits)+24), sign...)
+	ndigits := int64(len(digits))
+	switch {
+	case exp >= ndigits && exp <= 21: // integer
+		out = append(out, digits...)
+		out = append(out, bytes.Repeat([]byte{ZERO}, int(exp-ndigits))...)
+
+	case exp > 0 && exp < ndigits: // decimal point within the digits
+		out = append(out, digits[:exp]...)
+		out = append(out, DOT)
+		out = append(out, digits[exp:]...)
+
+	case exp <= 0 && exp > -6: // small decimal
+		out = append(out, ZERO, DOT)
+		out = append(out, bytes.Repeat([]byte{ZERO}, int(-exp))...)
+		out = append(out, digits...)
+
+	default: // scientific notation
+		out = append(out, digits[0])
+		if ndigits > 1 {
+			out = append(out, DOT)
+			out = append(out, digits[1:]...)
+		}
+		out = append(out, 'e')
+		out = strconv.AppendInt(out, exp-1, 10)
+	}
+	return out, nil
+}
+
 func (codec *Codec) denormalizeFloat(text []byte) ([]byte, error) {
 	var err error
 	var f float64
@@ -466,6 +869,9 @@ func (codec *Codec) denormalizeFloat(text []byte) ([]byte, error) {
 			return []byte(strconv.FormatFloat(f, 'f', -1, 64)), nil
 		}
 
+	case json.Number:
+		return denormalizeNumber(text)
+
 	default:
 		return text, nil
 	}
@@ -170,6 +170,147 @@ func TestCodecNoLength(t *testing.T) {
 	}
 }
 
+func TestTrimLastItem(t *testing.T) {
+	var samples = [][2]string{
+		{`["docid"]`, `[]`},
+		{`[10,"doc\u0000id"]`, `[10]`},
+		{`["a\u0000b",[1,{"k":"v"}],"docid"]`, `["a\u0000b",[1,{"k":"v"}]]`},
+		{`[null,true,"x","docid"]`, `[null,true,"x"]`},
+	}
+	codec := NewCodec(128)
+	for _, tcase := range samples {
+		sample, ref := tcase[0], tcase[1]
+
+		code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		refcode, err := codec.Encode([]byte(ref), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix, err := TrimLastItem(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// prefix does not include the array Terminator.
+		if !bytes.Equal(prefix, refcode[:len(refcode)-1]) {
+			t.Errorf("expected %q, got %q for %v", refcode, prefix, sample)
+		}
+	}
+
+	if _, err := TrimLastItem([]byte{TypeNull, Terminator}); err == nil {
+		t.Errorf("expected error for non-array input")
+	}
+}
+
+func TestDescending(t *testing.T) {
+	// sorted by first item descending and second item ascending
+	var samples = []string{
+		`["b",[1,"x"],"doc1"]`,
+		`["b",{"k":"v"},"doc1"]`,
+		`["a\u0000b",null,"doc2"]`,
+		`["a",10,"doc1"]`,
+		`["a",10,"doc2"]`,
+		`["a",20,"doc1"]`,
+		`[10,"a","doc1"]`,
+		`[2,"a","doc1"]`,
+		`[null,true,"doc3"]`,
+	}
+	codec := NewCodec(128)
+	codec.SortbyDescending([]bool{true, false})
+	var prev []byte
+	for _, sample := range samples {
+		code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev != nil && bytes.Compare(prev, code) >= 0 {
+			t.Errorf("expected %v to sort after previous sample", sample)
+		}
+		prev = code
+
+		text, err := codec.Decode(code, make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ref, out interface{}
+		json.Unmarshal([]byte(sample), &ref)
+		json.Unmarshal(text, &out)
+		if !reflect.DeepEqual(ref, out) {
+			t.Errorf("expected %v, got %v", sample, string(text))
+		}
+
+		// prefix of descending item is computed as for ascending items
+		items := ref.([]interface{})
+		refjson, _ := json.Marshal(items[:len(items)-1])
+		refcode, err := codec.Encode(refjson, make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix, err := TrimLastItem(code)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(prefix, refcode[:len(refcode)-1]) {
+			t.Errorf("expected %q, got %q for %v", refcode, prefix, sample)
+		}
+	}
+}
+
+func TestExactNumber(t *testing.T) {
+	var samples = []string{
+		"-12345678901234567890.5",
+		"-9223372036854775808",
+		"-1.5",
+		"-0.000001",
+		"0",
+		"0.0000012",
+		"1",
+		"1.5",
+		"9007199254740992",
+		"9007199254740993",
+		"9223372036854775807",
+		"9223372036854775808",
+		"12345678901234567890.123456789",
+		"1e400",
+	}
+	codec := NewCodec(16)
+	var prev []byte
+	for _, sample := range samples {
+		code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev != nil && bytes.Compare(prev, code) >= 0 {
+			t.Errorf("expected %v to sort after previous sample", sample)
+		}
+		prev = code
+
+		text, err := codec.Decode(code, make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(text) != sample {
+			t.Errorf("expected %v, got %v", sample, string(text))
+		}
+	}
+
+	// numbers that are exact in float64 are encoded alike
+	fcodec := NewCodec(16)
+	fcodec.NumberType("float64")
+	for _, sample := range []string{"10", "1e1", "10.0", "-0.125", "1.5e300"} {
+		code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := fcodec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(code, ref) {
+			t.Errorf("expected %q, got %q for %v", ref, code, sample)
+		}
+	}
+}
+
 func TestCodecJSON(t *testing.T) {
 	codec := NewCodec(128)
 	codec.SortbyArrayLen(true)
@@ -0,0 +1,601 @@
+//  Copyright (c) 2013 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not
+//  use this file except in compliance with the License. You may obtain a copy
+//  of the License at http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//  WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//  License for the specific language governing permissions and limitations
+//  under the License.
+
+// streaming encoder, that tokenizes JSON text directly into its binary
+// representation, and streaming decoder, that decodes binary representation
+// directly into JSON text.
+
+package collatejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrorInvalidJSON means input is not a valid JSON text.
+var ErrorInvalidJSON = errors.New("collatejson.invalidJSON")
+
+// StreamEncode encodes json documents to order preserving binary
+// representation, same as Encode, by tokenizing `text` directly into the
+// output buffer `code`, without unmarshalling it into intermediate maps and
+// interfaces. Property objects are sorted in place, using scratch buffers
+// of the codec that are re-used by subsequent calls. Output buffer is
+// re-allocated if its capacity is insufficient. Codec that encodes strings
+// by unicode collation falls back to Encode.
+func (codec *Codec) StreamEncode(text, code []byte) ([]byte, error) {
+	if codec.isCollating() {
+		// Encode expects 3x of text, but numbers are encoded in place and
+		// short ones take more, like `5` that is encoded in 7 bytes.
+		if n := 4*len(text) + 16; cap(code) < n {
+			code = make([]byte, 0, n)
+		}
+		return codec.Encode(text, code)
+	}
+
+	e := streamEncoder{codec: codec, text: text, code: code[:0]}
+	e.skipSpace()
+	isArray := e.pos < len(text) && text[e.pos] == '['
+	if err := e.value(); err != nil {
+		return nil, err
+	}
+	if e.skipSpace(); e.pos != len(text) {
+		return nil, ErrorInvalidJSON // trailing data after JSON value
+	}
+	if isArray && codec.descending != nil {
+		return codec.invertItems(e.code)
+	}
+	return e.code, nil
+}
+
+// propSpan locates an encoded property, its key is code[key:value] and its
+// value is code[value:end].
+type propSpan struct {
+	key, value, end int
+}
+
+type streamEncoder struct {
+	codec *Codec
+	text  []byte
+	pos   int
+	code  []byte
+}
+
+func (e *streamEncoder) skipSpace() {
+	for e.pos < len(e.text) {
+		switch e.text[e.pos] {
+		case ' ', '\t', '\r', '\n':
+			e.pos++
+		default:
+			return
+		}
+	}
+}
+
+// next skips white space and returns the next byte, 0 at end of text.
+func (e *streamEncoder) next() byte {
+	if e.skipSpace(); e.pos < len(e.text) {
+		return e.text[e.pos]
+	}
+	return 0
+}
+
+// reserve makes sure that output buffer can take n more bytes without
+// re-allocation, encoding functions for numbers expect so.
+func (e *streamEncoder) reserve(n int) {
+	if cap(e.code)-len(e.code) < n {
+		code := make([]byte, len(e.code), 2*cap(e.code)+n)
+		copy(code, e.code)
+		e.code = code
+	}
+}
+
+func (e *streamEncoder) value() error {
+	switch c := e.next(); c {
+	case '{':
+		return e.object()
+	case '[':
+		return e.array()
+	case '"':
+		return e.str()
+	case 't':
+		return e.literal(boolTrue, TypeTrue)
+	case 'f':
+		return e.literal(boolFalse, TypeFalse)
+	case 'n':
+		return e.literal(null, TypeNull)
+	default:
+		if c == '-' || (c >= '0' && c <= '9') {
+			return e.number()
+		}
+	}
+	return ErrorInvalidJSON
+}
+
+func (e *streamEncoder) literal(lit []byte, typ byte) error {
+	if !bytes.HasPrefix(e.text[e.pos:], lit) {
+		return ErrorInvalidJSON
+	}
+	e.pos += len(lit)
+	e.code = append(e.code, typ, Terminator)
+	return nil
+}
+
+func (e *streamEncoder) number() error {
+	start := e.pos
+	if e.text[e.pos] == '-' {
+		e.pos++
+	}
+	if e.pos < len(e.text) && e.text[e.pos] == '0' {
+		e.pos++
+	} else if e.digits() == 0 {
+		return ErrorInvalidJSON
+	}
+	if e.pos < len(e.text) && e.text[e.pos] == '.' {
+		e.pos++
+		if e.digits() == 0 {
+			return ErrorInvalidJSON
+		}
+	}
+	if e.pos < len(e.text) && (e.text[e.pos] == 'e' || e.text[e.pos] == 'E') {
+		e.pos++
+		if e.pos < len(e.text) && (e.text[e.pos] == '+' || e.text[e.pos] == '-') {
+			e.pos++
+		}
+		if e.digits() == 0 {
+			return ErrorInvalidJSON
+		}
+	}
+	token := string(e.text[start:e.pos])
+
+	var cs []byte
+	var err error
+	e.reserve(2*len(token) + 32)
+	e.code = append(e.code, TypeNumber)
+	if _, ok := e.codec.numberType.(json.Number); ok {
+		cs, err = e.codec.normalizeNumber(json.Number(token), e.code[len(e.code):])
+	} else {
+		var f float64
+		if f, err = strconv.ParseFloat(token, 64); err != nil {
+			return err
+		}
+		cs, err = e.codec.normalizeFloat(f, e.code[len(e.code):])
+	}
+	if err != nil {
+		return err
+	}
+	e.code = append(e.code, cs...)
+	e.code = append(e.code, Terminator)
+	return nil
+}
+
+func (e *streamEncoder) digits() int {
+	start := e.pos
+	for e.pos < len(e.text) && e.text[e.pos] >= '0' && e.text[e.pos] <= '9' {
+		e.pos++
+	}
+	return e.pos - start
+}
+
+// str decodes JSON string and suffix encodes it, like suffixEncodeString,
+// in a single pass.
+func (e *streamEncoder) str() error {
+	e.pos++ // opening quote
+	e.code = append(e.code, TypeString)
+	start := len(e.code)
+	for {
+		if e.pos >= len(e.text) {
+			return ErrorInvalidJSON
+		}
+		switch c := e.text[e.pos]; {
+		case c == '"':
+			e.pos++
+			if e.codec.doMissing && e.isMissing(e.code[start:]) {
+				e.code = append(e.code[:start-1], TypeMissing, Terminator)
+				return nil
+			}
+			e.code = append(e.code, Terminator, Terminator)
+			return nil
+
+		case c == '\\':
+			if err := e.escape(); err != nil {
+				return err
+			}
+
+		case c < 0x20:
+			return ErrorInvalidJSON
+
+		case c < utf8.RuneSelf:
+			e.code = append(e.code, c)
+			e.pos++
+
+		default:
+			r, size := utf8.DecodeRune(e.text[e.pos:])
+			if r == utf8.RuneError && size == 1 {
+				e.appendRune(r) // same as encoding/json
+			} else {
+				e.code = append(e.code, e.text[e.pos:e.pos+size]...)
+			}
+			e.pos += size
+		}
+	}
+}
+
+func (e *streamEncoder) escape() error {
+	if e.pos+1 >= len(e.text) {
+		return ErrorInvalidJSON
+	}
+	c := e.text[e.pos+1]
+	e.pos += 2
+	switch c {
+	case '"', '\\', '/':
+		e.code = append(e.code, c)
+	case 'b':
+		e.code = append(e.code, '\b')
+	case 'f':
+		e.code = append(e.code, '\f')
+	case 'n':
+		e.code = append(e.code, '\n')
+	case 'r':
+		e.code = append(e.code, '\r')
+	case 't':
+		e.code = append(e.code, '\t')
+	case 'u':
+		r, ok := hex4(e.text[e.pos:])
+		if !ok {
+			return ErrorInvalidJSON
+		}
+		e.pos += 4
+		if utf16.IsSurrogate(r) {
+			// invalid surrogate pair is replaced, like encoding/json does.
+			r2, ok := rune(0), false
+			if bytes.HasPrefix(e.text[e.pos:], []byte(`\u`)) {
+				r2, ok = hex4(e.text[e.pos+2:])
+			}
+			if dec := utf16.DecodeRune(r, r2); ok && dec != utf8.RuneError {
+				r, e.pos = dec, e.pos+6
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		if r == 0 { // escape Terminator
+			e.code = append(e.code, Terminator, 1)
+		} else {
+			e.appendRune(r)
+		}
+	default:
+		return ErrorInvalidJSON
+	}
+	return nil
+}
+
+func (e *streamEncoder) appendRune(r rune) {
+	x := [utf8.UTFMax]byte{}
+	n := utf8.EncodeRune(x[:], r)
+	e.code = append(e.code, x[:n]...)
+}
+
+func (e *streamEncoder) isMissing(s []byte) bool {
+	return len(s) == len(MissingLiteral) && string(s) == string(MissingLiteral)
+}
+
+// hex4 decodes 4 hex digits of `\uXXXX` escape.
+func hex4(text []byte) (rune, bool) {
+	if len(text) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range text[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c = c - '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
+
+func (e *streamEncoder) array() error {
+	e.pos++ // '['
+	start := len(e.code)
+	e.code = append(e.code, TypeArray)
+	n := 0
+	if e.next() == ']' {
+		e.pos++
+	} else {
+		for {
+			if err := e.value(); err != nil {
+				return err
+			}
+			n++
+			c := e.next()
+			e.pos++
+			if c == ']' {
+				break
+			} else if c != ',' {
+				return ErrorInvalidJSON
+			}
+		}
+	}
+	if e.codec.arrayLenPrefix {
+		e.insertLength(start+1, n)
+	}
+	e.code = append(e.code, Terminator)
+	return nil
+}
+
+func (e *streamEncoder) object() error {
+	e.pos++ // '{'
+	start := len(e.code)
+	e.code = append(e.code, TypeObj)
+	base := len(e.codec.props)
+	if e.next() == '}' {
+		e.pos++
+	} else {
+		for {
+			if e.next() != '"' {
+				return ErrorInvalidJSON
+			}
+			prop := propSpan{key: len(e.code)}
+			if err := e.str(); err != nil {
+				return err
+			}
+			prop.value = len(e.code)
+			if e.next() != ':' {
+				return ErrorInvalidJSON
+			}
+			e.pos++
+			if err := e.value(); err != nil {
+				return err
+			}
+			prop.end = len(e.code)
+			e.codec.props = append(e.codec.props, prop)
+
+			c := e.next()
+			e.pos++
+			if c == '}' {
+				break
+			} else if c != ',' {
+				return ErrorInvalidJSON
+			}
+		}
+	}
+	n := e.sortProps(e.codec.props[base:])
+	e.codec.props = e.codec.props[:base]
+	if e.codec.propertyLenPrefix {
+		e.insertLength(start+1, n)
+	}
+	e.code = append(e.code, Terminator)
+	return nil
+}
+
+// sortProps sorts encoded properties, at the end of output buffer, by their
+// keys and returns the number of properties. Like unmarshalling into a map,
+// last of the properties with same key is retained.
+func (e *streamEncoder) sortProps(props []propSpan) int {
+	if len(props) == 0 {
+		return 0
+	}
+	key := func(p propSpan) []byte { return e.code[p.key:p.value] }
+
+	sorted := true
+	for i := 1; i < len(props) && sorted; i++ {
+		sorted = bytes.Compare(key(props[i-1]), key(props[i])) < 0
+	}
+	if sorted {
+		return len(props)
+	}
+
+	// insertion sort is stable, objects are expected to be small.
+	for i := 1; i < len(props); i++ {
+		for j := i; j > 0 && bytes.Compare(key(props[j-1]), key(props[j])) > 0; j-- {
+			props[j-1], props[j] = props[j], props[j-1]
+		}
+	}
+
+	base := props[0].key
+	for _, p := range props {
+		if p.key < base {
+			base = p.key
+		}
+	}
+	scratch := append(e.codec.scratch[:0], e.code[base:]...)
+	e.codec.scratch = scratch
+
+	skey := func(p propSpan) []byte { return scratch[p.key-base : p.value-base] }
+	n, out := 0, e.code[:base]
+	for i, p := range props {
+		if i+1 < len(props) && bytes.Equal(skey(p), skey(props[i+1])) {
+			continue // duplicate key, last one wins
+		}
+		out = append(out, scratch[p.key-base:p.end-base]...)
+		n++
+	}
+	e.code = out
+	return n
+}
+
+// insertLength inserts encoded length `n` at offset `at` of output buffer.
+func (e *streamEncoder) insertLength(at, n int) {
+	x := [32]byte{}
+	lc, _ := e.codec.json2code(Length(n), x[:0])
+	l := len(e.code)
+	e.code = append(e.code, lc...)
+	copy(e.code[at+len(lc):], e.code[at:l])
+	copy(e.code[at:], lc)
+}
+
+// StreamDecode decodes binary representation `code` to JSON text, same as
+// Decode, by appending directly to the output buffer `text`, which is
+// re-allocated if its capacity is insufficient. Original strings of
+// collated strings and descending items are decoded using scratch buffers
+// of the codec, that are re-used by subsequent calls.
+func (codec *Codec) StreamDecode(code, text []byte) ([]byte, error) {
+	d := streamDecoder{codec: codec, text: text[:0]}
+	if len(code) == 0 || code[0] == Terminator {
+		return d.text, nil
+	}
+	if remaining, err := skipItem(code); err == nil {
+		for rs := remaining; len(rs) > 0; {
+			if rs[0] != TypeString {
+				return nil, ErrorInvalidOriginals
+			} else if rs, err = skipItem(rs); err != nil {
+				return nil, ErrorInvalidOriginals
+			}
+		}
+		d.originals = remaining
+	}
+	if _, err := d.item(code); err != nil {
+		return nil, err
+	}
+	return d.text, nil
+}
+
+type streamDecoder struct {
+	codec     *Codec
+	text      []byte
+	originals []byte // original strings yet to be decoded
+}
+
+// item decodes an encoded item and returns the code remaining after it.
+func (d *streamDecoder) item(code []byte) ([]byte, error) {
+	if len(code) == 0 {
+		return nil, ErrorInvalidArray
+	}
+	if isDescending(code[0]) {
+		return d.descending(code)
+	}
+
+	switch code[0] {
+	case TypeString:
+		return d.str(code)
+
+	case TypeArray:
+		d.text = append(d.text, '[')
+		remaining, err := d.items(code[1:], d.codec.arrayLenPrefix, false)
+		d.text = append(d.text, ']')
+		return remaining, err
+
+	case TypeObj:
+		d.text = append(d.text, '{')
+		remaining, err := d.items(code[1:], d.codec.propertyLenPrefix, true)
+		d.text = append(d.text, '}')
+		return remaining, err
+	}
+
+	if bytes.IndexByte(code, Terminator) < 0 {
+		return nil, ErrorInvalidArray
+	}
+	datum, remaining := getDatum(code)
+	switch code[0] {
+	case TypeMissing:
+		d.text = append(d.text, MissingLiteral...)
+
+	case TypeNull:
+		d.text = append(d.text, null...)
+
+	case TypeTrue:
+		d.text = append(d.text, boolTrue...)
+
+	case TypeFalse:
+		d.text = append(d.text, boolFalse...)
+
+	case TypeLength:
+		_, ts := DecodeInt(datum[1:], d.codec.digits[:0])
+		d.codec.digits = ts
+		d.text = append(d.text, ts...)
+
+	case TypeNumber:
+		ts := DecodeFloat(datum[1:], d.codec.digits[:0])
+		d.codec.digits = ts
+		ts, err := d.codec.denormalizeFloat(ts)
+		if err != nil {
+			return nil, err
+		}
+		d.text = append(d.text, bytes.TrimLeft(ts, "+")...)
+
+	default:
+		return nil, ErrorInvalidArray
+	}
+	return remaining, nil
+}
+
+// descending decodes an item whose binary representation is inverted, by
+// inverting the code into a scratch buffer.
+func (d *streamDecoder) descending(code []byte) ([]byte, error) {
+	buf := invert(append(d.codec.inverted[:0], code...))
+	d.codec.inverted = buf
+	remaining, err := d.item(buf)
+	if err != nil {
+		return nil, err
+	}
+	return code[len(code)-len(remaining):], nil
+}
+
+func (d *streamDecoder) str(code []byte) ([]byte, error) {
+	// skipItem makes sure that the string is terminated before decoding.
+	remaining, err := skipItem(code)
+	if err != nil {
+		return nil, err
+	}
+	var s []byte
+	if len(d.originals) > 0 {
+		// collated string, decode its original string instead.
+		s, d.originals, err = suffixDecodeString(
+			d.originals[1:], d.codec.scratch[:0])
+	} else {
+		s, _, err = suffixDecodeString(code[1:], d.codec.scratch[:0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.codec.scratch = s
+	d.text, err = appendJSONString(d.text, s)
+	return remaining, err
+}
+
+// items decodes items of an array, or key-value pairs of an object if
+// props is true, and returns the code remaining after its Terminator.
+func (d *streamDecoder) items(code []byte, lenPrefix, props bool) ([]byte, error) {
+	var err error
+	if lenPrefix && len(code) > 0 {
+		// length is only for sorting, items are followed by a Terminator.
+		if code, err = skipItem(code); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; len(code) > 0 && code[0] != Terminator; i++ {
+		if i > 0 {
+			d.text = append(d.text, ',')
+		}
+		if code, err = d.item(code); err != nil {
+			return nil, err
+		}
+		if props {
+			d.text = append(d.text, ':')
+			if code, err = d.item(code); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(code) == 0 {
+		return nil, ErrorInvalidArray
+	}
+	return code[1:], nil
+}
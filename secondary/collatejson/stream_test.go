@@ -0,0 +1,194 @@
+//  Copyright (c) 2013 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not
+//  use this file except in compliance with the License. You may obtain a copy
+//  of the License at http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//  WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//  License for the specific language governing permissions and limitations
+//  under the License.
+
+package collatejson
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var streamSamples = []string{
+	`{"b":1,"a":2,"b":3}`,
+	`{"key":{"z":[],"y":{}},"":null}`,
+	`[" \"quoted\" \\ \/ \b\f\n\r\t ","é\u0000x","😀","\ud800"]`,
+	"[\"\xff invalid utf8\",\"ü<>&\"]",
+	`["~[]{}falsenilNA~",{"~[]{}falsenilNA~":1}]`,
+	` [ 1 , -0 , 0.5e-3 , 12345678901234567890 , 1E+2 ] `,
+}
+
+var streamInvalid = []string{
+	``, `[`, `[1,]`, `{"a"}`, `{"a":1,}`, `{1:2}`, `"abc`, "\"a\tb\"",
+	`01`, `1.`, `-`, `1e`, `tru`, `nul`, `[1] x`, `"\x"`, `"\u12"`,
+}
+
+// streamTestSamples returns documents of testdata corpus, testcases and
+// streamSamples.
+func streamTestSamples(t *testing.T) []string {
+	var samples []string
+	files, err := filepath.Glob(filepath.Join(testData, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, ".ref") {
+			continue
+		}
+		for _, line := range readLines(file, t) {
+			samples = append(samples, string(line))
+		}
+	}
+	for _, tcase := range testcases {
+		samples = append(samples, tcase.text)
+	}
+	return append(samples, streamSamples...)
+}
+
+func TestStreamEncode(t *testing.T) {
+	samples := streamTestSamples(t)
+	configs := map[string]func(*Codec){
+		"default": func(codec *Codec) {},
+		"decimal": func(codec *Codec) { codec.NumberType("decimal") },
+		"float64": func(codec *Codec) { codec.NumberType("float64") },
+		"arrayLen": func(codec *Codec) {
+			codec.SortbyArrayLen(true)
+			codec.SortbyPropertyLen(false)
+		},
+		"descending": func(codec *Codec) {
+			codec.SortbyDescending([]bool{true, false, true})
+		},
+		"nomissing": func(codec *Codec) { codec.UseMissing(false) },
+	}
+	for name, config := range configs {
+		codec := NewCodec(16)
+		config(codec)
+		for _, sample := range samples {
+			ref, referr := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+			code, err := codec.StreamEncode([]byte(sample), make([]byte, 0, 8))
+			if (referr == nil) != (err == nil) {
+				t.Errorf("%v: expected error %v, got %v for %v",
+					name, referr, err, sample)
+			} else if !bytes.Equal(ref, code) {
+				t.Errorf("%v: expected %q, got %q for %v", name, ref, code, sample)
+			}
+		}
+	}
+
+	codec := NewCodec(16)
+	for _, sample := range streamInvalid {
+		if _, err := codec.StreamEncode([]byte(sample), nil); err == nil {
+			t.Errorf("expected error for %q", sample)
+		}
+	}
+}
+
+func TestStreamEncodeCollated(t *testing.T) {
+	codec := NewCodec(16)
+	codec.SortbyUTF8(false)
+	// encoded numbers are longer than 3x of their text.
+	for _, sample := range []string{`5`, `[1,2,3]`, `["a",-1]`} {
+		ref, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, err := codec.StreamEncode([]byte(sample), nil)
+		if err != nil {
+			t.Errorf("unexpected error %v for %v", err, sample)
+		} else if !bytes.Equal(ref, code) {
+			t.Errorf("expected %q, got %q for %v", ref, code, sample)
+		}
+	}
+}
+
+func TestStreamDecode(t *testing.T) {
+	samples := streamTestSamples(t)
+	configs := map[string]func(*Codec){
+		"default": func(codec *Codec) {},
+		"decimal": func(codec *Codec) { codec.NumberType("decimal") },
+		"float64": func(codec *Codec) { codec.NumberType("float64") },
+		"arrayLen": func(codec *Codec) {
+			codec.SortbyArrayLen(true)
+			codec.SortbyPropertyLen(false)
+		},
+		"descending": func(codec *Codec) {
+			codec.SortbyDescending([]bool{true, false, true})
+		},
+		"collated": func(codec *Codec) {
+			codec.SortbyUTF8(false)
+			codec.SortbyDescending([]bool{false, true})
+		},
+	}
+	for name, config := range configs {
+		codec := NewCodec(16)
+		config(codec)
+		for _, sample := range samples {
+			code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+			if err != nil {
+				continue
+			}
+			ref, referr := codec.Decode(code, make([]byte, 0, 3*len(code)))
+			text, err := codec.StreamDecode(code, make([]byte, 0, 8))
+			if (referr == nil) != (err == nil) {
+				t.Errorf("%v: expected error %v, got %v for %v",
+					name, referr, err, sample)
+			} else if !bytes.Equal(ref, text) {
+				t.Errorf("%v: expected %s, got %s for %v", name, ref, text, sample)
+			}
+		}
+	}
+
+	codec := NewCodec(16)
+	code, _ := codec.Encode([]byte(`["a",[1]]`), make([]byte, 0, 64))
+	for i := 1; i < len(code)-1; i++ {
+		if _, err := codec.StreamDecode(code[:i], nil); err == nil {
+			t.Errorf("expected error for truncated %q", code[:i])
+		}
+	}
+	code = append(code, TypeNull, Terminator)
+	if _, err := codec.StreamDecode(code, nil); err != ErrorInvalidOriginals {
+		t.Errorf("expected %v, got %v", ErrorInvalidOriginals, err)
+	}
+}
+
+func BenchmarkStreamEncode(b *testing.B) {
+	codec := NewCodec(128)
+	text := []byte(testcases[0].text)
+	code := make([]byte, 0, 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codec.StreamEncode(text, code)
+	}
+}
+
+func BenchmarkStreamEncodeKey(b *testing.B) {
+	codec := NewCodec(16)
+	text, _ := ioutil.ReadFile(filepath.Join(testData, "numbers"))
+	text = []byte(`["` + strings.Repeat("key", 10) + `",` +
+		strings.Replace(strings.TrimSpace(string(text)), "\n", ",", -1) +
+		`,"docid"]`)
+	code := make([]byte, 0, 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codec.StreamEncode(text, code)
+	}
+}
+
+func BenchmarkStreamDecode(b *testing.B) {
+	codec := NewCodec(128)
+	code, _ := codec.Encode([]byte(testcases[0].text), make([]byte, 0, 1024))
+	text := make([]byte, 0, 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codec.StreamDecode(code, text)
+	}
+}
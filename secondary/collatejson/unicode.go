@@ -8,55 +8,41 @@
 //  License for the specific language governing permissions and limitations
 //  under the License.
 
-// +build ignore
-
 package collatejson
 
 import (
-//"code.google.com/p/go.text/collate"
-//"code.google.com/p/go.text/collate/colltab"
-//"code.google.com/p/go.text/language"
-//"code.google.com/p/go.text/unicode/norm"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
 )
 
-// UnicodeCollationPriority sets collate.Collator properties for unicode
-// collation.
-func (codec *Codec) UnicodeCollationPriority(
-	strength colltab.Level, alternate collate.AlternateHandling,
-	backwards, hiraganaQ, caseLevel, numeric bool) {
-	codec.strength = strength
-	codec.alternate = alternate
-	codec.backwards = backwards
-	codec.hiraganaQ = hiraganaQ
-	codec.caseLevel = caseLevel
-	codec.numeric = numeric
+// UnicodeCollationOptions sets collate.Collator options, like
+// collate.IgnoreCase and collate.IgnoreDiacritics, for unicode collation.
+// Strings are collated using root collation order if language is not set.
+func (codec *Codec) UnicodeCollationOptions(options ...collate.Option) {
+	codec.options = options
+	codec.collator = nil
 }
 
 // SetLanguage uses language tag while doing unicode collation.
 func (codec *Codec) SetLanguage(l language.Tag) {
 	codec.language = l
+	codec.collator = nil
 }
 
 // EncodeUnicodeString encodes string in utf8 encoding to binary sequence based
-// on UTF8, NFKD or go.text/collate algorithms.
+// on UTF8, NFKD or x/text/collate algorithms.
 func (codec *Codec) EncodeUnicodeString(value string) (code []byte) {
-	bs := []byte(value)
 	if codec.utf8 {
-		code = []byte(bs)
+		code = []byte(value)
 	} else if codec.nfkd {
-		code = norm.NFKD.Bytes([]byte(bs)) // canonical decomposed
+		code = norm.NFKD.Bytes([]byte(value)) // canonical decomposed
 	} else {
-		// TODO: Try to understand the performance implication of collate.Buffer
-		// object
-		buf := &collate.Buffer{}
-		c := collate.New(codec.language)
-		c.Strength = codec.strength
-		c.Alternate = codec.alternate
-		c.Backwards = codec.backwards
-		c.HiraganaQuaternary = codec.hiraganaQ
-		c.CaseLevel = codec.caseLevel
-		c.Numeric = codec.numeric
-		code = c.Key(buf, []byte(bs))
+		if codec.collator == nil {
+			codec.collator = collate.New(codec.language, codec.options...)
+		}
+		codec.buf.Reset()
+		code = codec.collator.KeyFromString(&codec.buf, value)
 	}
 	return code
 }
@@ -66,7 +52,15 @@ func (codec *Codec) SortbyNFKD(what bool) {
 	codec.nfkd = what
 }
 
-// SortbyUTF8 will do plain binary comparision for strings.
+// SortbyUTF8 will do plain binary comparision for strings. Use `false` to
+// sort strings by unicode collation, see SetLanguage and SortbyNFKD.
+// Default is `true`.
 func (codec *Codec) SortbyUTF8(what bool) {
 	codec.utf8 = what
 }
+
+// isCollating returns true if strings are encoded by their unicode
+// collation, instead of plain binary comparision.
+func (codec *Codec) isCollating() bool {
+	return !codec.utf8
+}
@@ -8,13 +8,109 @@
 //  License for the specific language governing permissions and limitations
 //  under the License.
 
-// +build ignore
-
 package collatejson
 
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func TestUnicodeCollation(t *testing.T) {
+	// German, case and accent insensitive
+	var samples = [][]string{
+		{`["Apfel","doc1"]`, `["apfel","doc2"]`, `["äpfel","doc3"]`},
+		{`["Äre","doc1"]`, `["are","doc2"]`},
+		{`["Zebra","doc1"]`},
+		{`["zürich",10,"doc1"]`},
+		{`["zürich",{"Ort":"Stadt"},"doc1"]`},
+	}
+	codec := NewCodec(16)
+	codec.SortbyUTF8(false)
+	codec.SetLanguage(language.German)
+	codec.UnicodeCollationOptions(collate.IgnoreCase, collate.IgnoreDiacritics)
+
+	scanKey := NewCodec(16)
+	scanKey.SortbyUTF8(false)
+	scanKey.SetLanguage(language.German)
+	scanKey.UnicodeCollationOptions(collate.IgnoreCase, collate.IgnoreDiacritics)
+	scanKey.EncodeOriginals(false)
+
+	var prev []byte
+	for _, equals := range samples {
+		var prefix []byte
+		for _, sample := range equals {
+			code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prev != nil && bytes.Compare(prev, code) >= 0 {
+				t.Errorf("expected %v to sort after previous sample", sample)
+			}
+			prev = code
+
+			text, err := codec.Decode(code, make([]byte, 0, 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ref, out interface{}
+			json.Unmarshal([]byte(sample), &ref)
+			json.Unmarshal(text, &out)
+			if !reflect.DeepEqual(ref, out) {
+				t.Errorf("expected %v, got %v", sample, string(text))
+			}
+
+			// equal secondary keys share the prefix of their scan key
+			items := ref.([]interface{})
+			refjson, _ := json.Marshal(items[:1])
+			refcode, err := scanKey.Encode(refjson, make([]byte, 0, 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+			refcode = refcode[:len(refcode)-1]
+			if prefix != nil && !bytes.Equal(prefix, refcode) {
+				t.Errorf("expected %q, got %q for %v", prefix, refcode, sample)
+			} else if !bytes.HasPrefix(code, refcode) {
+				t.Errorf("expected %q as prefix of %q", refcode, code)
+			}
+			prefix = refcode
+		}
+	}
+}
+
+func TestCollationBuffer(t *testing.T) {
+	// collation keys are larger than the input, output buffer is grown
+	sample := []byte(`["こんにちは世界","カタカナ","doc1"]`)
+	codec := NewCodec(16)
+	codec.SortbyUTF8(false)
+	codec.SetLanguage(language.Japanese)
+	code, err := codec.Encode(sample, make([]byte, 0, 3*len(sample)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := codec.Decode(code, make([]byte, 0, 3*len(code)))
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(text, sample) {
+		t.Errorf("expected %s, got %s", sample, text)
+	}
+
+	// decoded by a codec that is not configured for collation
+	text, err = NewCodec(16).Decode(code, make([]byte, 0, 3*len(code)))
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(text, sample) {
+		t.Errorf("expected %s, got %s", sample, text)
+	}
+}
+
 func BenchmarkUtf8(b *testing.B) {
 	s := "prográmming"
-	codec := NewCodec()
+	codec := NewCodec(16)
 	codec.SortbyUTF8(true)
 	for i := 0; i < b.N; i++ {
 		codec.EncodeUnicodeString(s)
@@ -23,7 +119,8 @@ func BenchmarkUtf8(b *testing.B) {
 
 func BenchmarkNFKD(b *testing.B) {
 	s := "prográmming"
-	codec := NewCodec()
+	codec := NewCodec(16)
+	codec.SortbyUTF8(false)
 	codec.SortbyNFKD(true)
 	for i := 0; i < b.N; i++ {
 		codec.EncodeUnicodeString(s)
@@ -32,7 +129,8 @@ func BenchmarkNFKD(b *testing.B) {
 
 func BenchmarkStringCollate(b *testing.B) {
 	s := "prográmming"
-	codec := NewCodec()
+	codec := NewCodec(16)
+	codec.SortbyUTF8(false)
 	for i := 0; i < b.N; i++ {
 		codec.EncodeUnicodeString(s)
 	}
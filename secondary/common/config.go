@@ -108,6 +108,35 @@ var SystemConfig = Config{
 		"timeout, in milliseconds, for sending periodic Sync messages.",
 		500,
 	},
+	"projector.maxKeySize": ConfigValue{
+		4096,
+		"maximum size, in bytes, of secondary-key for an index, larger " +
+			"keys, or entries of array index, are not indexed by projector " +
+			"and reported in the index's error",
+		4096,
+	},
+	"projector.jsEvaluationTimeout": ConfigValue{
+		100,
+		"timeout, in milliseconds, for evaluating a document with an " +
+			"index's JavaScript expressions, 0 for no timeout",
+		100,
+	},
+	"projector.maxWhereFilterDocs": ConfigValue{
+		100000,
+		"maximum number of documents, across all vbuckets, tracked for a " +
+			"partial index to drop mutations of documents that were never " +
+			"indexed. Each tracked document costs about 50 bytes plus the " +
+			"size of its docid in projector memory, per index. 0 disables " +
+			"tracking",
+		100000,
+	},
+	"projector.sendExpirations": ConfigValue{
+		false,
+		"send expired documents to indexers as Expiration command, " +
+			"otherwise as Deletion. Enable only if all indexers in the " +
+			"cluster handle Expiration, older indexers drop the command",
+		false,
+	},
 	// projector adminport parameters
 	"projector.adminport.name": ConfigValue{
 		"projector.adminport",
@@ -199,6 +228,18 @@ var SystemConfig = Config{
 			"router to downstream client",
 		1000 * 1024, // bytes
 	},
+	"endpoint.dataport.compression": ConfigValue{
+		"none",
+		"compression for transmission data from router to downstream " +
+			"client, one of none, snappy, gzip",
+		"none",
+	},
+	"endpoint.dataport.compressionThreshold": ConfigValue{
+		1024,
+		"payloads smaller than threshold, in bytes, are transmitted " +
+			"uncompressed",
+		1024, // bytes
+	},
 	// indexer dataport parameters
 	"projector.dataport.indexer.genServerChanSize": ConfigValue{
 		64,
@@ -241,6 +282,16 @@ var SystemConfig = Config{
 		"size of the buffered channels used to stream request and response.",
 		16,
 	},
+	"queryport.indexer.compression": ConfigValue{
+		"none",
+		"compression for responses sent to client, one of none, snappy, gzip",
+		"none",
+	},
+	"queryport.indexer.compressionThreshold": ConfigValue{
+		1024,
+		"responses smaller than threshold, in bytes, are sent uncompressed",
+		1024, // bytes
+	},
 	// queryport client configuration
 	"queryport.client.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -279,11 +330,35 @@ var SystemConfig = Config{
 			"from the pool before considering the creation of a new one",
 		1,
 	},
+	"queryport.client.multiplex": ConfigValue{
+		false,
+		"multiplex concurrent requests on a single connection to indexer, " +
+			"enable only if all indexers support request streams",
+		false,
+	},
+	"queryport.client.streamWindow": ConfigValue{
+		256,
+		"number of responses indexer can send on a multiplexed request " +
+			"before client grants more credits",
+		256,
+	},
 	"indexer.scanTimeout": ConfigValue{
 		120000,
 		"timeout, in milliseconds, timeout for index scan processing",
 		120000,
 	},
+	"indexer.statisticsBins": ConfigValue{
+		16,
+		"number of equi-depth histogram bins computed for index " +
+			"statistics, 0 disables histogram",
+		16,
+	},
+	"indexer.slowScanThreshold": ConfigValue{
+		1000,
+		"threshold, in milliseconds, above which a scan is logged with its " +
+			"request parameters, 0 disables slow-scan log",
+		1000,
+	},
 	"indexer.adminPort": ConfigValue{
 		"9100",
 		"port for index ddl and status operations",
@@ -339,6 +414,18 @@ var SystemConfig = Config{
 		"Number of Writer Threads for a Slice",
 		1,
 	},
+	"indexer.mutation_queue.memory_percent": ConfigValue{
+		20,
+		"Percentage of settings.memory_quota shared by the mutation queues " +
+			"of all buckets, stream readers are throttled beyond that",
+		20,
+	},
+	"indexer.mutation_queue.min_memory": ConfigValue{
+		uint64(64 * 1024 * 1024),
+		"Minimum memory in bytes for a bucket's mutation queue, also used " +
+			"when settings.memory_quota is not set",
+		uint64(64 * 1024 * 1024),
+	},
 
 	"indexer.sync_period": ConfigValue{
 		uint64(100),
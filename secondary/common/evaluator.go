@@ -8,7 +8,8 @@ type Evaluator interface {
 	// Return the bucket name for which this evaluator is applicable.
 	Bucket() string
 
-	// StreamBeginData is generated for downstream.
+	// StreamBeginData is generated for downstream, called for every
+	// evaluator when vbucket stream begins from `seqno`.
 	StreamBeginData(vbno uint16, vbuuid, seqno uint64) (data interface{})
 
 	// Sync is generated for downstream.
@@ -20,6 +21,10 @@ type Evaluator interface {
 	// StreamEnd is generated for downstream.
 	StreamEndData(vbno uint16, vbuuid, seqno uint64) (data interface{})
 
+	// GetStatistics return evaluator's statistics, like the number of
+	// upserts, deletions and expirations sent downstream.
+	GetStatistics() map[string]interface{}
+
 	// TransformRoute will transform document consumable by
 	// downstream, returns data to be published to endpoints.
 	TransformRoute(vbuuid uint64, m *mc.UprEvent, data map[string]interface{}) error
@@ -11,7 +11,13 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 type IndexKey []byte
@@ -59,6 +65,50 @@ const (
 	ForestDB           = "ForestDB"
 )
 
+// IndexTypeFromString maps the USING clause of a create index statement to
+// the storage engine for the index. "gsi" and unknown values default to
+// ForestDB.
+func IndexTypeFromString(using string) IndexType {
+	switch strings.ToLower(using) {
+	case "llrb", "memdb":
+		return Llrb
+	case "leveldb":
+		return LevelDB
+	default:
+		return ForestDB
+	}
+}
+
+// Consistency specifies the consistency criteria for an index scan.
+type Consistency uint32
+
+const (
+	// AnyConsistency indexer would serve the scan from its latest
+	// available snapshot.
+	AnyConsistency Consistency = iota + 1
+	// SessionConsistency indexer would serve the scan from a snapshot that
+	// is atleast as recent as KV's sequence numbers when the request
+	// arrived, aka request_plus.
+	SessionConsistency
+	// QueryConsistency indexer would serve the scan from a snapshot that
+	// is atleast as recent as the timestamp vector supplied with the
+	// request, aka at_plus.
+	QueryConsistency
+)
+
+func (cons Consistency) String() string {
+	switch cons {
+	case AnyConsistency:
+		return "ANY_CONSISTENCY"
+	case SessionConsistency:
+		return "SESSION_CONSISTENCY"
+	case QueryConsistency:
+		return "QUERY_CONSISTENCY"
+	default:
+		return "UNKNOWN_CONSISTENCY"
+	}
+}
+
 type IndexState int
 
 const (
@@ -117,6 +167,65 @@ type IndexDefn struct {
 	WhereExpr       string          `json:"where,omitempty"`
 	Deferred        bool            `json:"deferred,omitempty"`
 	Nodes           []string        `json:"nodes,omitempty"`
+
+	// partitioned index, partition `i` is hosted by Nodes[i] and
+	// PartitionBounds, JSON encoded values of the partition key sorted in
+	// collation order, are the upper bounds (exclusive) of partitions
+	// 0..N-2 for RANGE partitioning. Partitions lists the partitions hosted
+	// by the indexer node this definition was sent to.
+	PartitionBounds []string      `json:"partitionBounds,omitempty"`
+	Partitions      []PartitionId `json:"partitions,omitempty"`
+
+	// string values of secondary keys are sorted and compared as per
+	// Collation, nil to compare them byte-wise as UTF8.
+	Collation *Collation `json:"collation,omitempty"`
+}
+
+// ErrInvalidCollation is returned for an index collation whose language
+// is not a valid BCP 47 tag.
+var ErrInvalidCollation = errors.New("invalid collation language")
+
+// Collation specifies unicode collation of strings in index keys, as per
+// the conventions of Language, a BCP 47 tag like "de" or "ja". Root
+// collation order is used if Language is empty.
+type Collation struct {
+	Language          string `json:"language,omitempty"`
+	CaseInsensitive   bool   `json:"caseInsensitive,omitempty"`
+	AccentInsensitive bool   `json:"accentInsensitive,omitempty"`
+}
+
+// Apply configures codec to encode strings as per the collation, nil
+// collation leaves codec as is.
+func (c *Collation) Apply(codec *collatejson.Codec) error {
+	if c == nil {
+		return nil
+	}
+
+	var options []collate.Option
+	if c.CaseInsensitive {
+		options = append(options, collate.IgnoreCase)
+	}
+	if c.AccentInsensitive {
+		options = append(options, collate.IgnoreDiacritics)
+	}
+	if c.Language != "" {
+		tag, err := language.Parse(c.Language)
+		if err != nil {
+			return ErrInvalidCollation
+		}
+		codec.SetLanguage(tag)
+	}
+	codec.UnicodeCollationOptions(options...)
+	codec.SortbyUTF8(false)
+	return nil
+}
+
+func (c *Collation) String() string {
+	if c == nil {
+		return "utf8"
+	}
+	return fmt.Sprintf("%v(caseInsensitive:%v,accentInsensitive:%v)",
+		c.Language, c.CaseInsensitive, c.AccentInsensitive)
 }
 
 //IndexInst is an instance of an Index(aka replica)
@@ -143,9 +252,119 @@ func (idx IndexDefn) String() string {
 	str += fmt.Sprintf("\n\t\tPartitionScheme: %v ", idx.PartitionScheme)
 	str += fmt.Sprintf("PartitionKey: %v ", idx.PartitionKey)
 	str += fmt.Sprintf("WhereExpr: %v ", idx.WhereExpr)
+	if idx.Collation != nil {
+		str += fmt.Sprintf("Collation: %v ", idx.Collation)
+	}
+	if idx.IsPartitioned() {
+		str += fmt.Sprintf("\n\t\tNodes: %v ", idx.Nodes)
+		str += fmt.Sprintf("PartitionBounds: %v ", idx.PartitionBounds)
+		str += fmt.Sprintf("Partitions: %v ", idx.Partitions)
+	}
 	return str
 
 }
+
+// IsPartitioned returns true if the index is spread across several indexer
+// nodes, using one of KEY, HASH or RANGE partition schemes.
+func (idx IndexDefn) IsPartitioned() bool {
+	switch idx.PartitionScheme {
+	case KEY, HASH, RANGE:
+		return true
+	}
+	return false
+}
+
+// NumPartitions returns the number of partitions of the index.
+func (idx IndexDefn) NumPartitions() int {
+	if idx.IsPartitioned() && len(idx.Nodes) > 0 {
+		return len(idx.Nodes)
+	}
+	return 1
+}
+
+// IsArrayIndex returns true if one of the secondary expressions of the
+// index is an array expression, see ArrayExpr(), or a JavaScript map
+// function, see IsJSMapFunction().
+func (idx IndexDefn) IsArrayIndex() bool {
+	for _, expr := range idx.SecExprs {
+		if _, _, ok := ArrayExpr(expr); ok {
+			return true
+		} else if idx.ExprType == JavaScript && IsJSMapFunction(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJSMapFunction returns true if JavaScript expression is a map function,
+// `function (doc, meta) { emit(doc.name); }`, that emits the keys to index
+// like the map function of a view. Every emitted key is indexed as a
+// separate entry.
+func IsJSMapFunction(expr string) bool {
+	expr, _ = KeyOrder(expr)
+	if !strings.HasPrefix(expr, "function") {
+		return false
+	}
+	rest := expr[len("function"):]
+	return len(rest) > 0 && strings.IndexByte(" \t\r\n(", rest[0]) >= 0
+}
+
+// ArrayExpr parses secondary expressions of the form `DISTINCT <expr>` or
+// `ALL <expr>`, where <expr>, typically `ARRAY v FOR v IN field END`,
+// evaluates to an array and every element of the array is indexed as a
+// separate entry. Returns <expr> and whether the modifier is DISTINCT, ok
+// is false if expr is not an array expression.
+func ArrayExpr(expr string) (arrExpr string, distinct, ok bool) {
+	expr = strings.TrimSpace(expr)
+	i := strings.IndexAny(expr, " \t\r\n(")
+	if i < 0 {
+		return "", false, false
+	}
+
+	switch strings.ToUpper(expr[:i]) {
+	case "DISTINCT":
+		distinct = true
+	case "ALL":
+	default:
+		return "", false, false
+	}
+	return strings.TrimSpace(expr[i:]), distinct, true
+}
+
+// Descending returns whether index entries are sorted in descending order of
+// each of the secondary expressions, nil if all of them are ascending.
+func (idx IndexDefn) Descending() []bool {
+	var desc []bool
+	for i, expr := range idx.SecExprs {
+		if _, ok := KeyOrder(expr); ok {
+			if desc == nil {
+				desc = make([]bool, len(idx.SecExprs))
+			}
+			desc[i] = true
+		}
+	}
+	return desc
+}
+
+// KeyOrder parses the optional ASC or DESC suffix of a secondary
+// expression, `<expr> DESC`. Returns <expr> and whether the index is sorted
+// in descending order of the expression.
+func KeyOrder(expr string) (keyExpr string, desc bool) {
+	expr = strings.TrimSpace(expr)
+	i := strings.LastIndexAny(expr, " \t\r\n")
+	if i < 0 {
+		return expr, false
+	}
+
+	switch strings.ToUpper(expr[i+1:]) {
+	case "DESC":
+		return strings.TrimSpace(expr[:i+1]), true
+	case "ASC":
+		return strings.TrimSpace(expr[:i+1]), false
+	}
+	return expr, false
+}
+
 func (idx IndexInst) String() string {
 
 	str := "\n"
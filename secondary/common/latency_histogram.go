@@ -0,0 +1,125 @@
+package common
+
+import "sync/atomic"
+import "time"
+
+// latency histogram splits every power-of-two range of nanoseconds into
+// 2^latencySubBits linear sub-buckets, values are recorded with a relative
+// error less than 1/2^latencySubBits.
+const latencySubBits = 5
+const latencySubCount = 1 << latencySubBits
+
+// latencyMaxShift bounds the largest recordable value to roughly 73
+// minutes, larger values are recorded in the last bucket.
+const latencyMaxShift = 36
+
+const latencyNumBuckets = (latencyMaxShift + 2) * latencySubCount
+
+// LatencyHistogram is an HDR style histogram of durations. It is safe for
+// concurrent use, recording is lock free.
+type LatencyHistogram struct {
+	counts [latencyNumBuckets]uint64
+	sum    int64
+	max    int64
+}
+
+// NewLatencyHistogram creates an empty histogram.
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{}
+}
+
+// Add duration `d` to histogram.
+func (h *LatencyHistogram) Add(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddUint64(&h.counts[latencyBucket(v)], 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+// Count of durations recorded in histogram.
+func (h *LatencyHistogram) Count() (count uint64) {
+	for i := range h.counts {
+		count += atomic.LoadUint64(&h.counts[i])
+	}
+	return count
+}
+
+// Mean of durations recorded in histogram.
+func (h *LatencyHistogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum) / int64(count))
+}
+
+// Max is the largest duration recorded in histogram.
+func (h *LatencyHistogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max))
+}
+
+// Percentile returns the duration below which `q` percent of recorded
+// durations fall, `q` is in the range [0, 100].
+func (h *LatencyHistogram) Percentile(q float64) time.Duration {
+	counts := make([]uint64, latencyNumBuckets)
+	total := uint64(0)
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(float64(total)*q/100 + 0.5)
+	if target < 1 {
+		target = 1
+	} else if target > total {
+		target = total
+	}
+	cumulative := uint64(0)
+	for i, count := range counts {
+		if cumulative += count; cumulative >= target {
+			if upper := latencyUpper(i); upper < h.Max() {
+				return upper
+			}
+			break
+		}
+	}
+	return h.Max()
+}
+
+// bucket index for value `v`, values less than 2*latencySubCount are
+// recorded exactly.
+func latencyBucket(v int64) int {
+	bitlen := uint(0)
+	for x := v; x > 0; x >>= 1 {
+		bitlen++
+	}
+	shift := uint(0)
+	if bitlen > latencySubBits+1 {
+		shift = bitlen - latencySubBits - 1
+	}
+	if shift > latencyMaxShift {
+		return latencyNumBuckets - 1
+	}
+	return int(shift)*latencySubCount + int(v>>shift)
+}
+
+// largest value recorded in bucket `i`.
+func latencyUpper(i int) time.Duration {
+	if i < 2*latencySubCount {
+		return time.Duration(i)
+	}
+	shift := uint(i/latencySubCount - 1)
+	m := int64(i%latencySubCount + latencySubCount)
+	return time.Duration((m+1)<<shift - 1)
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := NewLatencyHistogram()
+	if h.Percentile(99) != 0 || h.Mean() != 0 {
+		t.Fatalf("expected zero percentile for empty histogram")
+	}
+
+	for i := 1; i <= 1000; i++ {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+	if count := h.Count(); count != 1000 {
+		t.Fatalf("expected count 1000, got %v", count)
+	}
+	if max := h.Max(); max != time.Second {
+		t.Fatalf("expected max %v, got %v", time.Second, max)
+	}
+	if mean := h.Mean(); mean != 500500*time.Microsecond {
+		t.Fatalf("expected mean %v, got %v", 500500*time.Microsecond, mean)
+	}
+	for _, q := range []float64{50, 90, 99, 99.9} {
+		ref := time.Duration(q*10) * time.Millisecond
+		d := h.Percentile(q)
+		if d < ref || float64(d-ref) > float64(ref)/latencySubCount {
+			t.Errorf("expected p%v within %v of %v, got %v",
+				q, 100.0/latencySubCount, ref, d)
+		}
+	}
+	if p100 := h.Percentile(100); p100 != time.Second {
+		t.Errorf("expected p100 %v, got %v", time.Second, p100)
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	for _, v := range []int64{0, 1, 63, 64, 65, 1000, 123456789, 1 << 40} {
+		i := latencyBucket(v)
+		if upper := int64(latencyUpper(i)); upper < v {
+			t.Errorf("value %v exceeds upper %v of bucket %v", v, upper, i)
+		} else if i > 0 && int64(latencyUpper(i-1)) >= v {
+			t.Errorf("value %v fits in bucket %v before %v", v, i-1, i)
+		}
+	}
+	if i := latencyBucket(1 << 62); i != latencyNumBuckets-1 {
+		t.Errorf("expected overflow in last bucket, got %v", i)
+	}
+}
@@ -0,0 +1,357 @@
+package common
+
+import "bytes"
+import "fmt"
+import "io"
+import "math"
+import "net/http"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// LatencyBuckets are default upper bounds, in seconds, for latency
+// histograms.
+var LatencyBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+	0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// DefaultMetrics is the process wide registry of metrics, daemons expose
+// it on their /metrics endpoint.
+var DefaultMetrics = NewMetrics()
+
+// Metrics is a registry of metric families that can be exposed in
+// Prometheus text format. Metrics are safe for concurrent use.
+type Metrics struct {
+	mu         sync.Mutex
+	families   map[string]*metricFamily
+	collectors []func()
+}
+
+// NewMetrics creates an empty registry of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string // counter, gauge or histogram
+	labels  []string
+	buckets []float64 // upper bounds for histograms
+
+	mu     sync.Mutex
+	series map[string]interface{} // label-values -> *Metric or *Histogram
+	values map[string][]string
+}
+
+// NewCounter registers a counter family, identified by `name` and
+// partitioned by `labels`.
+func (m *Metrics) NewCounter(name, help string, labels ...string) *MetricVec {
+	return &MetricVec{m.register(name, help, "counter", nil, labels)}
+}
+
+// NewGauge registers a gauge family, identified by `name` and
+// partitioned by `labels`.
+func (m *Metrics) NewGauge(name, help string, labels ...string) *MetricVec {
+	return &MetricVec{m.register(name, help, "gauge", nil, labels)}
+}
+
+// NewHistogram registers a histogram family, identified by `name` and
+// partitioned by `labels`, observations are counted in `buckets`.
+func (m *Metrics) NewHistogram(
+	name, help string, buckets []float64, labels ...string) *HistogramVec {
+
+	bs := append([]float64(nil), buckets...)
+	sort.Float64s(bs)
+	return &HistogramVec{m.register(name, help, "histogram", bs, labels)}
+}
+
+// OnCollect registers a callback that is invoked before every exposition,
+// can be used to refresh gauges that are sampled from other components.
+func (m *Metrics) OnCollect(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, fn)
+}
+
+func (m *Metrics) register(
+	name, help, typ string, buckets []float64,
+	labels []string) *metricFamily {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.families[name]; ok {
+		if f.typ != typ || len(f.labels) != len(labels) {
+			panic(fmt.Errorf("metric %q registered with different type", name))
+		}
+		return f
+	}
+	f := &metricFamily{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]interface{}),
+		values:  make(map[string][]string),
+	}
+	m.families[name] = f
+	return f
+}
+
+// WriteText writes all metrics in Prometheus text exposition format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	collectors := append([]func(){}, m.collectors...)
+	families := make([]*metricFamily, 0, len(m.families))
+	for _, f := range m.families {
+		families = append(families, f)
+	}
+	m.mu.Unlock()
+
+	for _, fn := range collectors {
+		fn()
+	}
+
+	sort.Sort(familiesByName(families))
+	buf := new(bytes.Buffer)
+	for _, f := range families {
+		f.writeText(buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP exposes metrics for scraping.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := m.WriteText(w); err != nil {
+		Errorf("metrics: %v\n", err)
+	}
+}
+
+func (f *metricFamily) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Errorf("metric %q expects %v label values, got %v",
+			f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = create()
+		f.series[key] = s
+		f.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+func (f *metricFamily) remove(values []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	delete(f.series, key)
+	delete(f.values, key)
+}
+
+func (f *metricFamily) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.series = make(map[string]interface{})
+	f.values = make(map[string][]string)
+}
+
+func (f *metricFamily) writeText(buf *bytes.Buffer) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := make([]string, len(keys))
+	series := make([]interface{}, len(keys))
+	for i, key := range keys {
+		pairs := make([]string, 0, len(f.labels))
+		for j, value := range f.values[key] {
+			pairs = append(pairs, f.labels[j]+`="`+escapeLabel(value)+`"`)
+		}
+		labels[i], series[i] = strings.Join(pairs, ","), f.series[key]
+	}
+	f.mu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+	for i := range keys {
+		switch s := series[i].(type) {
+		case *Metric:
+			writeSample(buf, f.name, labels[i], "", s.Value())
+
+		case *Histogram:
+			counts, sum, count := s.snapshot()
+			cumulative := uint64(0)
+			for j, upper := range f.buckets {
+				cumulative += counts[j]
+				le := `le="` + formatFloat(upper) + `"`
+				writeSample(buf, f.name+"_bucket", labels[i], le, float64(cumulative))
+			}
+			writeSample(buf, f.name+"_bucket", labels[i], `le="+Inf"`, float64(count))
+			writeSample(buf, f.name+"_sum", labels[i], "", sum)
+			writeSample(buf, f.name+"_count", labels[i], "", float64(count))
+		}
+	}
+}
+
+func writeSample(buf *bytes.Buffer, name, labels, extra string, value float64) {
+	buf.WriteString(name)
+	if labels != "" && extra != "" {
+		labels += ","
+	}
+	if labels += extra; labels != "" {
+		buf.WriteString("{" + labels + "}")
+	}
+	buf.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, +1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+type familiesByName []*metricFamily
+
+func (fs familiesByName) Len() int           { return len(fs) }
+func (fs familiesByName) Less(i, j int) bool { return fs[i].name < fs[j].name }
+func (fs familiesByName) Swap(i, j int)      { fs[i], fs[j] = fs[j], fs[i] }
+
+// MetricVec is a family of counters or gauges partitioned by labels.
+type MetricVec struct {
+	family *metricFamily
+}
+
+// With returns the metric for label `values`, creating it if missing.
+func (v *MetricVec) With(values ...string) *Metric {
+	return v.family.get(values, func() interface{} { return new(Metric) }).(*Metric)
+}
+
+// Delete removes the metric for label `values`.
+func (v *MetricVec) Delete(values ...string) {
+	v.family.remove(values)
+}
+
+// Reset removes all metrics of this family.
+func (v *MetricVec) Reset() {
+	v.family.reset()
+}
+
+// Metric is a single counter or gauge.
+type Metric struct {
+	bits uint64
+}
+
+// Add `delta` to metric.
+func (m *Metric) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&m.bits)
+		val := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&m.bits, old, val) {
+			return
+		}
+	}
+}
+
+// Inc increments metric by 1.
+func (m *Metric) Inc() {
+	m.Add(1)
+}
+
+// Set metric to `value`, only meant for gauges.
+func (m *Metric) Set(value float64) {
+	atomic.StoreUint64(&m.bits, math.Float64bits(value))
+}
+
+// Value of metric.
+func (m *Metric) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.bits))
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	family *metricFamily
+}
+
+// With returns the histogram for label `values`, creating it if missing.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	create := func() interface{} {
+		return &Histogram{
+			upper:  v.family.buckets,
+			counts: make([]uint64, len(v.family.buckets)),
+		}
+	}
+	return v.family.get(values, create).(*Histogram)
+}
+
+// Delete removes the histogram for label `values`.
+func (v *HistogramVec) Delete(values ...string) {
+	v.family.remove(values)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	upper  []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe a value.
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.upper, value)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveSince observes the time elapsed since `start`, in seconds.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) snapshot() (counts []uint64, sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.counts...), h.sum, h.count
+}
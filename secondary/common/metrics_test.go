@@ -0,0 +1,57 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricsWriteText(t *testing.T) {
+	m := NewMetrics()
+	requests := m.NewCounter("requests_total", "Total requests.")
+	depth := m.NewGauge("queue_depth", "Queue depth,\nper bucket.", "bucket")
+	latency := m.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "index")
+	m.NewGauge("unused", "Family without series is skipped.", "x")
+
+	requests.With().Inc()
+	requests.With().Add(2)
+	depth.With(`de"f`).Set(10)
+	depth.With("gone").Set(1)
+	depth.Delete("gone")
+	latency.With("idx").Observe(0.05)
+	latency.With("idx").Observe(0.5)
+	latency.With("idx").Observe(5)
+
+	ref := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{index="idx",le="0.1"} 1
+latency_seconds_bucket{index="idx",le="1"} 2
+latency_seconds_bucket{index="idx",le="+Inf"} 3
+latency_seconds_sum{index="idx"} 5.55
+latency_seconds_count{index="idx"} 3
+# HELP queue_depth Queue depth,\nper bucket.
+# TYPE queue_depth gauge
+queue_depth{bucket="de\"f"} 10
+# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total 3
+`
+	buf := new(bytes.Buffer)
+	if err := m.WriteText(buf); err != nil {
+		t.Fatal(err)
+	} else if out := buf.String(); out != ref {
+		t.Fatalf("expected\n%s\ngot\n%s", ref, out)
+	}
+}
+
+func TestMetricsOnCollect(t *testing.T) {
+	m := NewMetrics()
+	gauge := m.NewGauge("sampled", "Sampled gauge.")
+	m.OnCollect(func() { gauge.With().Set(42) })
+
+	buf := new(bytes.Buffer)
+	if err := m.WriteText(buf); err != nil {
+		t.Fatal(err)
+	} else if gauge.With().Value() != 42 {
+		t.Fatalf("expected collector to set gauge, got %v", gauge.With().Value())
+	}
+}
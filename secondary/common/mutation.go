@@ -29,6 +29,8 @@ const (
 	StreamBegin                    // control command
 	StreamEnd                      // control command
 	Snapshot                       // control command
+	Expiration                     // data command
+	KeySizeError                   // control command
 )
 
 // Payload either carries `vbmap` or `vbs`.
@@ -244,6 +246,21 @@ func (kv *KeyVersions) AddDeletion(uuid uint64, oldkey []byte) {
 	kv.addKey(uuid, Deletion, nil, oldkey)
 }
 
+// AddExpiration add a new keyversion for same OpExpiration, document is
+// deleted by kv on expiry of its TTL. Indexers handle it as Deletion,
+// projector sends it only if "projector.sendExpirations" is enabled, as
+// indexers of older version drop this command.
+func (kv *KeyVersions) AddExpiration(uuid uint64, oldkey []byte) {
+	kv.addKey(uuid, Expiration, nil, oldkey)
+}
+
+// AddKeySizeError add a keyversion command to report that the secondary
+// key, or some of the array entries, of the document are larger than the
+// maximum key size and are not indexed.
+func (kv *KeyVersions) AddKeySizeError(uuid uint64) {
+	kv.addKey(uuid, KeySizeError, nil, nil)
+}
+
 // AddUpsertDeletion add a keyversion command to delete old entry.
 func (kv *KeyVersions) AddUpsertDeletion(uuid uint64, oldkey []byte) {
 	kv.addKey(uuid, UpsertDeletion, nil, oldkey)
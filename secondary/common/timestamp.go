@@ -83,6 +83,32 @@ func (ts *TsVbuuid) AsRecent(other *TsVbuuid) bool {
 	return true
 }
 
+// SeqnosAsRecent will check whether timestamp `ts` is atleast as recent as
+// timestamp `other`, only for vbuckets that have a non-zero seqno in `other`.
+// Vbuuids are compared only when `other` carries a vbuuid for the vbucket,
+// which allows `other` to be a partial vector, like the ones supplied by
+// index scans requesting session or query consistency.
+func (ts *TsVbuuid) SeqnosAsRecent(other *TsVbuuid) bool {
+	if ts == nil || other == nil {
+		return false
+	}
+	if ts.Bucket != other.Bucket || len(ts.Seqnos) != len(other.Seqnos) {
+		return false
+	}
+	for i, seqno := range other.Seqnos {
+		if seqno == 0 {
+			continue
+		}
+		if other.Vbuuids[i] != 0 && ts.Vbuuids[i] != other.Vbuuids[i] {
+			return false
+		}
+		if ts.Seqnos[i] < seqno {
+			return false
+		}
+	}
+	return true
+}
+
 // Len return number of entries in the timestamp.
 func (ts *TsVbuuid) Len() int {
 	length := 0
@@ -95,6 +95,37 @@ func TestAsRecent(t *testing.T) {
 	}
 }
 
+func TestSeqnosAsRecent(t *testing.T) {
+	ts := NewTsVbuuid("default", 8)
+	ts.Seqnos = []uint64{10, 20, 30, 40, 50, 60, 70, 80}
+	ts.Vbuuids = []uint64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// partial vector without vbuuids.
+	other := NewTsVbuuid("default", 8)
+	other.Seqnos[1], other.Seqnos[6] = 20, 65
+	if ts.SeqnosAsRecent(other) == false {
+		t.Fatal("expected true")
+	}
+	other.Seqnos[6] = 71
+	if ts.SeqnosAsRecent(other) == true {
+		t.Fatal("expected false")
+	}
+	// partial vector with vbuuids.
+	other.Seqnos[6], other.Vbuuids[6] = 70, 7
+	if ts.SeqnosAsRecent(other) == false {
+		t.Fatal("expected true")
+	}
+	other.Vbuuids[6] = 100
+	if ts.SeqnosAsRecent(other) == true {
+		t.Fatal("expected false")
+	}
+	// different bucket.
+	other = NewTsVbuuid("beer-sample", 8)
+	if ts.SeqnosAsRecent(other) == true {
+		t.Fatal("expected false")
+	}
+}
+
 func BenchmarkCompareVbuuuids(b *testing.B) {
 	ts1 := NewTsVbuuid("default", 1024)
 	for i := uint64(1); i < uint64(1024); i++ {
@@ -21,6 +21,8 @@ var commandNames = map[byte]string{
 	c.StreamBegin:    "StreamBegin",
 	c.StreamEnd:      "StreamEnd",
 	c.Snapshot:       "Snapshot",
+	c.Expiration:     "Expiration",
+	c.KeySizeError:   "KeySizeError",
 }
 
 // Application starts a new dataport application to receive mutations from the
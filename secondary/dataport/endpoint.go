@@ -72,13 +72,18 @@ func NewRouterEndpoint(
 		harakiriTm: time.Duration(config["harakiriTimeout"].Int()),
 	}
 	endpoint.ch = make(chan []interface{}, endpoint.keyChSize)
-	endpoint.conn = conn
-	// TODO: add configuration params for transport flags.
+	endpoint.conn = &meteredConn{conn, sentBytesMetric.With(topic, raddr)}
 	flags := transport.TransportFlag(0).SetProtobuf()
+	flags, err = flags.SetCompression(config["compression"].String())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 	maxPayload := config["maxPayload"].Int()
 	endpoint.pkt = transport.NewTransportPacket(maxPayload, flags)
 	endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
 	endpoint.pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	endpoint.pkt.SetCompressionThreshold(config["compressionThreshold"].Int())
 
 	endpoint.logPrefix = fmt.Sprintf(
 		"ENDP[<-(%v,%4x)<-%v #%v]",
@@ -172,9 +177,13 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 			endpoint.logPrefix, mutationCount, raddr)
 		if mutationCount > 0 {
 			flushCount++
+			// flush blocks while remote is applying back-pressure.
 			err = buffers.flushBuffers(endpoint.conn, endpoint.pkt)
 			if err != nil {
 				c.Errorf("%v flushBuffers() %v\n", endpoint.logPrefix, err)
+			} else if harakiri != nil {
+				// time spent blocked on remote is not inactivity.
+				harakiri = time.After(endpoint.harakiriTm * time.Millisecond)
 			}
 		}
 		mutationCount = 0
@@ -0,0 +1,33 @@
+package dataport
+
+import "net"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+var sentBytesMetric = c.DefaultMetrics.NewCounter(
+	"dataport_sent_bytes_total",
+	"Number of bytes sent by endpoint to downstream.",
+	"topic", "raddr")
+
+var receivedBytesMetric = c.DefaultMetrics.NewCounter(
+	"dataport_received_bytes_total",
+	"Number of bytes received by dataport server from upstream host.",
+	"host")
+
+// meteredConn counts bytes read from and written to a connection.
+type meteredConn struct {
+	net.Conn
+	metric *c.Metric
+}
+
+func (conn *meteredConn) Read(b []byte) (n int, err error) {
+	n, err = conn.Conn.Read(b)
+	conn.metric.Add(float64(n))
+	return n, err
+}
+
+func (conn *meteredConn) Write(b []byte) (n int, err error) {
+	n, err = conn.Conn.Write(b)
+	conn.metric.Add(float64(n))
+	return n, err
+}
@@ -61,6 +61,14 @@ func TestAddDeletion(t *testing.T) {
 	testKeyVersions(t, vb)
 }
 
+func TestAddExpiration(t *testing.T) {
+	kv := kvExpirations()
+	vbno, vbuuid, nMuts := uint16(10), uint64(1000), 10
+	vb := common.NewVbKeyVersions("default", vbno, vbuuid, nMuts)
+	addKeyVersions(vb, []*common.KeyVersions{kv}, 1, nMuts)
+	testKeyVersions(t, vb)
+}
+
 func TestAddSync(t *testing.T) {
 	seqno, docid, maxCount := uint64(10), []byte(nil), 1
 	kv := common.NewKeyVersions(seqno, docid, maxCount)
@@ -351,6 +359,15 @@ func kvDeletions() *common.KeyVersions {
 	return kv
 }
 
+func kvExpirations() *common.KeyVersions {
+	seqno, docid, maxCount := uint64(10), []byte("document-name"), 10
+	kv := common.NewKeyVersions(seqno, docid, maxCount)
+	kv.AddExpiration(1, []byte("varanasi"))
+	kv.AddExpiration(2, []byte("pune"))
+	kv.AddExpiration(3, []byte("mahe"))
+	return kv
+}
+
 func addKeyVersions(vb *common.VbKeyVersions, kvs []*common.KeyVersions, seqno uint64, nMuts int) uint64 {
 	ln := len(kvs)
 	for i := 0; i < nMuts; i++ {
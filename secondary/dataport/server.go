@@ -457,6 +457,8 @@ func doReceive(
 	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
 
 	msg := serverMessage{raddr: conn.RemoteAddr().String()}
+	host, _, _ := net.SplitHostPort(msg.raddr)
+	rconn := &meteredConn{conn, receivedBytesMetric.With(host)}
 
 	// create it here to avoid repeated allocation.
 	started := make(keeper)  // id() -> activeVb
@@ -489,7 +491,7 @@ loop:
 		timeoutMs := readDeadline * time.Millisecond
 		conn.SetReadDeadline(time.Now().Add(timeoutMs))
 		msg.cmd, msg.err, msg.args = 0, nil, nil
-		if payload, err := pkt.Receive(conn); err != nil {
+		if payload, err := pkt.Receive(rconn); err != nil {
 			msg.cmd, msg.err = serverCmdError, err
 			reqch <- []interface{}{msg}
 			c.Errorf("%v worker %q exit: %v\n", prefix, msg.raddr, err)
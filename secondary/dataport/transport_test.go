@@ -61,6 +61,73 @@ func TestPktVbmap(t *testing.T) {
 	}
 }
 
+func TestPktCompression(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	vbmapRef := &c.VbConnectionMap{
+		Bucket:   "default",
+		Vbuckets: []uint16{1, 2, 3, 4},
+		Vbuuids:  []uint64{10, 20, 30, 40},
+	}
+	tc := newTestConnection()
+
+	for _, compression := range []string{"snappy", "gzip"} {
+		flags, err := transport.TransportFlag(0).SetProtobuf().SetCompression(compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt := transport.NewTransportPacket(1000*1024, flags)
+		pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+		pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+		pkt.SetCompressionThreshold(1024)
+
+		tc.reset()
+		if err := pkt.Send(tc, vbsRef); err != nil {
+			t.Fatal(err)
+		}
+		sent := tc.woff
+		if payload, err := pkt.Receive(tc); err != nil {
+			t.Fatal(err)
+		} else {
+			vbs := protobuf2VbKeyVersions(payload.([]*protobuf.VbKeyVersions))
+			if len(vbsRef) != len(vbs) {
+				t.Fatalf("Mismatch in length with %v", compression)
+			}
+			for i, vb := range vbs {
+				if vb.Equal(vbsRef[i]) == false {
+					t.Fatalf("Mismatch in VbKeyVersions with %v", compression)
+				}
+			}
+		}
+
+		// packets below threshold are sent uncompressed
+		tc.reset()
+		if err := pkt.Send(tc, vbmapRef); err != nil {
+			t.Fatal(err)
+		}
+		if payload, err := pkt.Receive(tc); err != nil {
+			t.Fatal(err)
+		} else {
+			vbmap := protobuf2Vbmap(payload.(*protobuf.VbConnectionMap))
+			if !vbmap.Equal(vbmapRef) {
+				t.Fatalf("Mismatch in Vbmap with %v", compression)
+			}
+		}
+
+		pkt = transport.NewTransportPacket(1000*1024, transport.TransportFlag(0).SetProtobuf())
+		pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+		tc.reset()
+		pkt.Send(tc, vbsRef)
+		if sent >= tc.woff {
+			t.Errorf("Expected %v compressed packet, %v >= %v", compression, sent, tc.woff)
+		}
+	}
+
+	if _, err := transport.TransportFlag(0).SetCompression("lzma"); err == nil {
+		t.Errorf("Expected error for unknown compression")
+	}
+}
+
 func BenchmarkSendVbKeyVersions(b *testing.B) {
 	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
 	vbs := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
@@ -0,0 +1,271 @@
+package memcached
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+)
+
+// Cluster is a single node couchbase cluster for testing, made of an
+// UprServer serving the data port and a fake ns_server serving the REST
+// API used by projector and indexer, that is /pools, /pools/default,
+// bucket maps and node-services including the streaming end-point.
+type Cluster struct {
+	Upr *UprServer
+
+	mu       sync.Mutex
+	lis      net.Listener
+	buckets  map[string]*Bucket
+	services map[string]int
+	rev      int
+	watchers map[chan bool]bool // node-services streaming clients
+	finch    chan bool
+}
+
+// NewCluster creates a cluster listening on localhost, with buckets
+// named `buckets` each having `numVbuckets` vbuckets.
+func NewCluster(numVbuckets int, buckets ...string) (*Cluster, error) {
+	bmap := make(map[string]*Bucket)
+	for _, name := range buckets {
+		bmap[name] = NewBucket(name, numVbuckets)
+	}
+	upr, err := NewUprServer("127.0.0.1:0", bmap)
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		upr.Close()
+		return nil, err
+	}
+	cluster := &Cluster{
+		Upr:     upr,
+		lis:     lis,
+		buckets: bmap,
+		services: map[string]int{
+			"kv":   addrPort(upr.Addr()),
+			"mgmt": addrPort(lis.Addr().String()),
+		},
+		watchers: make(map[chan bool]bool),
+		finch:    make(chan bool),
+	}
+	go func() {
+		err := http.Serve(lis, cluster)
+		log.Printf("Cluster %v stopped serving: %v\n", cluster.Addr(), err)
+	}()
+	return cluster, nil
+}
+
+// Addr returns the <host:port> address of ns_server, to be used as the
+// cluster address.
+func (cluster *Cluster) Addr() string {
+	return cluster.lis.Addr().String()
+}
+
+// Bucket returns bucket `name`, nil if there is no such bucket.
+func (cluster *Cluster) Bucket(name string) *Bucket {
+	return cluster.buckets[name]
+}
+
+// SetService advertises service `name` on `port` of this node, streaming
+// clients of node-services are notified of the change.
+func (cluster *Cluster) SetService(name string, port int) {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.services[name] = port
+	cluster.rev++
+	for ch := range cluster.watchers {
+		select {
+		case ch <- true:
+		default: // already notified
+		}
+	}
+}
+
+// Close the cluster.
+func (cluster *Cluster) Close() {
+	close(cluster.finch)
+	cluster.lis.Close()
+	cluster.Upr.Close()
+}
+
+// ServeHTTP implements http.Handler interface.
+func (cluster *Cluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/pools":
+		cluster.writeJSON(w, cluster.pools())
+
+	case path == "/pools/default":
+		cluster.writeJSON(w, cluster.pool())
+
+	case path == "/pools/default/buckets":
+		buckets := make([]*couchbase.Bucket, 0, len(cluster.buckets))
+		for name := range cluster.buckets {
+			buckets = append(buckets, cluster.bucketMap(name))
+		}
+		cluster.writeJSON(w, buckets)
+
+	case strings.HasPrefix(path, "/pools/default/buckets/"),
+		strings.HasPrefix(path, "/pools/default/b/"):
+		name := path[strings.LastIndex(path, "/")+1:]
+		if _, ok := cluster.buckets[name]; !ok {
+			http.Error(w, "Requested resource not found.", http.StatusNotFound)
+			return
+		}
+		cluster.writeJSON(w, cluster.bucketMap(name))
+
+	case path == "/pools/default/nodeServices":
+		cluster.writeJSON(w, cluster.nodeServices())
+
+	case path == "/pools/default/nodeServicesStreaming":
+		cluster.streamNodeServices(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (cluster *Cluster) streamNodeServices(
+	w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	notifych := make(chan bool, 1)
+	cluster.mu.Lock()
+	cluster.watchers[notifych] = true
+	cluster.mu.Unlock()
+	defer func() {
+		cluster.mu.Lock()
+		delete(cluster.watchers, notifych)
+		cluster.mu.Unlock()
+	}()
+
+	var closech <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closech = cn.CloseNotify()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	for {
+		data, err := json.Marshal(cluster.nodeServices())
+		if err != nil {
+			return
+		}
+		// ns_server separates streamed objects with newlines.
+		if _, err := fmt.Fprintf(w, "%s\n\n\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-notifych:
+		case <-closech:
+			return
+		case <-cluster.finch:
+			return
+		}
+	}
+}
+
+func (cluster *Cluster) pools() *couchbase.Pools {
+	return &couchbase.Pools{
+		ImplementationVersion: "3.0.0-fake",
+		IsAdmin:               true,
+		UUID:                  "fakeclusteruuid",
+		Pools: []couchbase.RestPool{{
+			Name:         "default",
+			StreamingURI: "/poolsStreaming/default",
+			URI:          "/pools/default",
+		}},
+	}
+}
+
+func (cluster *Cluster) pool() map[string]interface{} {
+	return map[string]interface{}{
+		"name":  "default",
+		"nodes": []couchbase.Node{cluster.node()},
+		"buckets": map[string]string{
+			"uri":              "/pools/default/buckets",
+			"terseBucketsBase": "/pools/default/b",
+		},
+	}
+}
+
+func (cluster *Cluster) node() couchbase.Node {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	return couchbase.Node{
+		ClusterMembership: "active",
+		CouchAPIBase:      "http://" + cluster.Addr() + "/",
+		Hostname:          cluster.Addr(),
+		Ports:             map[string]int{"direct": cluster.services["kv"]},
+		Status:            "healthy",
+		Version:           "3.0.0-fake",
+		ThisNode:          true,
+	}
+}
+
+func (cluster *Cluster) bucketMap(name string) *couchbase.Bucket {
+	numVbuckets := cluster.buckets[name].NumVbuckets()
+	vbmap := make([][]int, numVbuckets)
+	for i := range vbmap {
+		vbmap[i] = []int{0}
+	}
+	return &couchbase.Bucket{
+		AuthType:     "sasl",
+		Type:         "membase",
+		Name:         name,
+		NodeLocator:  "vbucket",
+		URI:          "/pools/default/buckets/" + name,
+		StreamingURI: "/pools/default/bucketsStreaming/" + name,
+		UUID:         name + "uuid",
+		VBSMJson: couchbase.VBucketServerMap{
+			HashAlgorithm: "CRC",
+			ServerList:    []string{cluster.Upr.Addr()},
+			VBucketMap:    vbmap,
+		},
+		NodesJSON: []couchbase.Node{cluster.node()},
+	}
+}
+
+func (cluster *Cluster) nodeServices() *couchbase.PoolServices {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	services := make(map[string]int)
+	for name, port := range cluster.services {
+		services[name] = port
+	}
+	return &couchbase.PoolServices{
+		Rev: cluster.rev,
+		NodesExt: []couchbase.NodeServices{{
+			Services: services,
+			Hostname: "127.0.0.1",
+			ThisNode: true,
+		}},
+	}
+}
+
+func (cluster *Cluster) writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func addrPort(addr string) int {
+	_, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	return n
+}
@@ -0,0 +1,520 @@
+package memcached
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+)
+
+// snapshot types and stream-end flags of UPR.
+const (
+	uprSnapshotMemory  = uint32(0x1)
+	uprStreamEndOK     = uint32(0x0)
+	uprStreamEndState  = uint32(0x2) // vbucket state changed, failover
+	uprStreamReqExtras = 48
+)
+
+// UprServer is a UPR producer speaking memcached binary protocol over TCP,
+// streaming from in-memory buckets, meant for testing projector and indexer
+// without a couchbase cluster. It supports SASL PLAIN authentication,
+// select-bucket, vbucket-seqno stats, UPR_OPEN, UPR_CONTROL,
+// UPR_FAILOVERLOG, UPR_STREAMREQ including rollback, UPR_CLOSESTREAM,
+// NOOP and buffer-ack based flow control.
+type UprServer struct {
+	mu      sync.Mutex
+	lis     net.Listener
+	buckets map[string]*Bucket
+	conns   map[*uprConn]bool
+}
+
+// NewUprServer listens on `laddr` and serves UPR streams from `buckets`.
+func NewUprServer(laddr string, buckets map[string]*Bucket) (*UprServer, error) {
+	lis, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &UprServer{
+		lis:     lis,
+		buckets: buckets,
+		conns:   make(map[*uprConn]bool),
+	}
+	go s.listen()
+	return s, nil
+}
+
+// Addr returns the <host:port> address of the server.
+func (s *UprServer) Addr() string {
+	return s.lis.Addr().String()
+}
+
+// Close the server and all its connections.
+func (s *UprServer) Close() error {
+	err := s.lis.Close()
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[*uprConn]bool)
+	s.mu.Unlock()
+	for c := range conns {
+		c.close()
+	}
+	return err
+}
+
+func (s *UprServer) listen() {
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			log.Printf("UprServer %v stopped listening: %v\n", s.Addr(), err)
+			return
+		}
+		c := newUprConn(s, conn)
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		go c.run()
+	}
+}
+
+func (s *UprServer) bucket(name string) *Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[name]
+}
+
+// uprStream is an active stream of vbucket over a connection.
+type uprStream struct {
+	vbno    uint16
+	opaque  uint32
+	vbuuid  uint64
+	closech chan bool
+}
+
+// uprConn is a connection from consumer.
+type uprConn struct {
+	server *UprServer
+	conn   net.Conn
+	wmu    sync.Mutex // serialize writes to conn
+	bucket *Bucket
+	finch  chan bool
+
+	// protected by mu, cond is signalled on buffer-ack and close.
+	mu      sync.Mutex
+	cond    *sync.Cond
+	streams map[uint16]*uprStream
+	bufsize uint32 // connection_buffer_size, 0 disables flow control
+	unacked uint32 // bytes sent and not acknowledged
+	noop    time.Duration
+	noopOn  bool
+	closed  bool
+}
+
+func newUprConn(s *UprServer, conn net.Conn) *uprConn {
+	c := &uprConn{
+		server:  s,
+		conn:    conn,
+		bucket:  s.bucket("default"), // unauthenticated connections
+		finch:   make(chan bool),
+		streams: make(map[uint16]*uprStream),
+		noop:    180 * time.Second,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *uprConn) run() {
+	defer func() {
+		c.close()
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		c.server.mu.Unlock()
+	}()
+	for {
+		req, err := ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		res := c.dispatch(&req)
+		if res == nil {
+			continue
+		}
+		res.Opcode, res.Opaque = req.Opcode, req.Opaque
+		if err := c.transmit(res); err != nil {
+			return
+		}
+	}
+}
+
+func (c *uprConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.finch)
+	c.conn.Close()
+	c.cond.Broadcast()
+}
+
+func (c *uprConn) transmit(pkt interface {
+	Transmit(w io.Writer) (int, error)
+}) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := pkt.Transmit(c.conn)
+	return err
+}
+
+func (c *uprConn) dispatch(req *transport.MCRequest) *transport.MCResponse {
+	switch req.Opcode {
+	case transport.SASL_LIST_MECHS:
+		return &transport.MCResponse{Body: []byte("PLAIN")}
+
+	case transport.SASL_AUTH:
+		// PLAIN authentication of bucket name is as good as selecting it.
+		parts := bytes.Split(req.Body, []byte{0})
+		if len(parts) == 3 {
+			if b := c.server.bucket(string(parts[1])); b != nil {
+				c.bucket = b
+			}
+		}
+		return &transport.MCResponse{}
+
+	case transport.SELECT_BUCKET:
+		if b := c.server.bucket(string(req.Key)); b != nil {
+			c.bucket = b
+			return &transport.MCResponse{}
+		}
+		return &transport.MCResponse{Status: transport.KEY_ENOENT}
+
+	case transport.STAT:
+		c.handleStats(req)
+		return &transport.MCResponse{} // terminates the stats
+
+	case transport.UPR_OPEN, transport.UPR_NOOP:
+		if req.Opcode == transport.UPR_NOOP { // consumer replies to noop
+			return nil
+		}
+		return &transport.MCResponse{}
+
+	case transport.UPR_CONTROL:
+		return c.handleControl(req)
+
+	case transport.UPR_BUFFERACK:
+		if len(req.Extras) >= 4 {
+			c.ack(binary.BigEndian.Uint32(req.Extras))
+		}
+		return nil
+
+	case transport.UPR_FAILOVERLOG:
+		vb := c.vbucket(req.VBucket)
+		if vb == nil {
+			return &transport.MCResponse{Status: transport.NOT_MY_VBUCKET}
+		}
+		return &transport.MCResponse{Body: encodeFailoverLog(vb.FailoverLog())}
+
+	case transport.UPR_STREAMREQ:
+		return c.handleStreamRequest(req)
+
+	case transport.UPR_CLOSESTREAM:
+		c.mu.Lock()
+		s, ok := c.streams[req.VBucket]
+		if ok {
+			delete(c.streams, req.VBucket)
+			close(s.closech)
+			c.cond.Broadcast()
+		}
+		c.mu.Unlock()
+		if !ok {
+			return &transport.MCResponse{Status: transport.KEY_ENOENT}
+		}
+		return &transport.MCResponse{}
+	}
+	return &transport.MCResponse{Status: transport.UNKNOWN_COMMAND}
+}
+
+func (c *uprConn) vbucket(vbno uint16) *VBucket {
+	if c.bucket == nil {
+		return nil
+	}
+	return c.bucket.VBucket(vbno)
+}
+
+// handleStats supports "vbucket-seqno" stats, sent as a series of
+// responses one for each stat.
+func (c *uprConn) handleStats(req *transport.MCRequest) {
+	if c.bucket == nil || string(req.Key) != "vbucket-seqno" {
+		return
+	}
+	for vbno := 0; vbno < c.bucket.NumVbuckets(); vbno++ {
+		vb := c.bucket.VBucket(uint16(vbno))
+		stats := [][2]string{
+			{fmt.Sprintf("vb_%d:high_seqno", vbno),
+				strconv.FormatUint(vb.HighSeqno(), 10)},
+			{fmt.Sprintf("vb_%d:uuid", vbno),
+				strconv.FormatUint(vb.FailoverLog()[0][0], 10)},
+		}
+		for _, stat := range stats {
+			res := &transport.MCResponse{
+				Opcode: req.Opcode,
+				Opaque: req.Opaque,
+				Key:    []byte(stat[0]),
+				Body:   []byte(stat[1]),
+			}
+			if err := c.transmit(res); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *uprConn) handleControl(req *transport.MCRequest) *transport.MCResponse {
+	val := string(req.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch string(req.Key) {
+	case "connection_buffer_size":
+		n, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return &transport.MCResponse{Status: transport.EINVAL}
+		}
+		c.bufsize = uint32(n)
+
+	case "enable_noop":
+		if val == "true" && !c.noopOn {
+			c.noopOn = true
+			go c.runNoop()
+		}
+
+	case "set_noop_interval":
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			return &transport.MCResponse{Status: transport.EINVAL}
+		}
+		c.noop = time.Duration(n) * time.Second
+	}
+	return &transport.MCResponse{}
+}
+
+func (c *uprConn) handleStreamRequest(
+	req *transport.MCRequest) *transport.MCResponse {
+
+	if len(req.Extras) != uprStreamReqExtras {
+		return &transport.MCResponse{Status: transport.EINVAL}
+	}
+	start := binary.BigEndian.Uint64(req.Extras[8:16])
+	end := binary.BigEndian.Uint64(req.Extras[16:24])
+	vbuuid := binary.BigEndian.Uint64(req.Extras[24:32])
+
+	vb := c.vbucket(req.VBucket)
+	if vb == nil {
+		return &transport.MCResponse{Status: transport.NOT_MY_VBUCKET}
+	} else if end < start {
+		return &transport.MCResponse{Status: transport.ERANGE}
+	} else if seqno, ok := vb.rollback(vbuuid, start); ok {
+		res := &transport.MCResponse{
+			Status: transport.ROLLBACK,
+			Extras: make([]byte, 8),
+		}
+		binary.BigEndian.PutUint64(res.Extras, seqno)
+		return res
+	}
+
+	c.mu.Lock()
+	if _, ok := c.streams[req.VBucket]; ok {
+		c.mu.Unlock()
+		return &transport.MCResponse{Status: transport.KEY_EEXISTS}
+	}
+	flog := vb.FailoverLog()
+	s := &uprStream{
+		vbno:    req.VBucket,
+		opaque:  req.Opaque,
+		vbuuid:  flog[0][0],
+		closech: make(chan bool),
+	}
+	c.streams[req.VBucket] = s
+	c.mu.Unlock()
+
+	// stream shall begin only after the response.
+	res := &transport.MCResponse{
+		Opcode: req.Opcode,
+		Opaque: req.Opaque,
+		Body:   encodeFailoverLog(flog),
+	}
+	if err := c.transmit(res); err != nil {
+		c.close()
+		return nil
+	}
+	go c.runStream(s, vb, start, end)
+	return nil
+}
+
+// runStream sends items of vbucket from `start` to `end`, waiting for new
+// items until `end` is reached or stream is closed.
+func (c *uprConn) runStream(s *uprStream, vb *VBucket, start, end uint64) {
+	defer func() {
+		c.mu.Lock()
+		if c.streams[s.vbno] == s {
+			delete(c.streams, s.vbno)
+		}
+		c.mu.Unlock()
+	}()
+
+	seqno := start
+	for {
+		items, vbuuid, changed := vb.since(seqno, end)
+		if vbuuid != s.vbuuid { // failover
+			c.send(s, uprStreamEnd(s, uprStreamEndState))
+			return
+		}
+		if len(items) > 0 {
+			last := items[len(items)-1].Seqno
+			if !c.send(s, uprSnapshot(s, items[0].Seqno, last)) {
+				return
+			}
+			for _, item := range items {
+				if !c.send(s, uprItem(s, item)) {
+					return
+				}
+			}
+			seqno = last
+		}
+		if seqno >= end {
+			c.send(s, uprStreamEnd(s, uprStreamEndOK))
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-s.closech:
+			return
+		case <-c.finch:
+			return
+		}
+	}
+}
+
+// send a stream message after waiting for consumer to acknowledge enough
+// of the buffer. Returns false if stream or connection is closed.
+func (c *uprConn) send(s *uprStream, req *transport.MCRequest) bool {
+	c.mu.Lock()
+	for !c.closed && !isClosed(s.closech) &&
+		c.bufsize > 0 && c.unacked >= c.bufsize {
+
+		c.cond.Wait()
+	}
+	if c.closed || isClosed(s.closech) {
+		c.mu.Unlock()
+		return false
+	}
+	c.unacked += uint32(req.Size())
+	c.mu.Unlock()
+
+	if err := c.transmit(req); err != nil {
+		c.close()
+		return false
+	}
+	return true
+}
+
+func (c *uprConn) ack(n uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > c.unacked {
+		n = c.unacked
+	}
+	c.unacked -= n
+	c.cond.Broadcast()
+}
+
+func (c *uprConn) runNoop() {
+	for {
+		c.mu.Lock()
+		interval := c.noop
+		c.mu.Unlock()
+
+		select {
+		case <-time.After(interval):
+		case <-c.finch:
+			return
+		}
+		if err := c.transmit(&transport.MCRequest{Opcode: transport.UPR_NOOP}); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+func isClosed(ch chan bool) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func uprSnapshot(s *uprStream, start, end uint64) *transport.MCRequest {
+	req := &transport.MCRequest{
+		Opcode:  transport.UPR_SNAPSHOT,
+		VBucket: s.vbno,
+		Opaque:  s.opaque,
+		Extras:  make([]byte, 20),
+	}
+	binary.BigEndian.PutUint64(req.Extras[:8], start)
+	binary.BigEndian.PutUint64(req.Extras[8:16], end)
+	binary.BigEndian.PutUint32(req.Extras[16:20], uprSnapshotMemory)
+	return req
+}
+
+func uprItem(s *uprStream, item *Item) *transport.MCRequest {
+	req := &transport.MCRequest{
+		Opcode:  item.Opcode,
+		VBucket: s.vbno,
+		Opaque:  s.opaque,
+		Cas:     item.Cas,
+		Key:     item.Key,
+	}
+	if item.Opcode == transport.UPR_MUTATION {
+		// by_seqno, rev_seqno, flags, expiration, lock_time, nmeta, nru
+		req.Extras = make([]byte, 31)
+		binary.BigEndian.PutUint32(req.Extras[16:20], item.Flags)
+		binary.BigEndian.PutUint32(req.Extras[20:24], item.Expiry)
+		req.Body = item.Value
+	} else {
+		// by_seqno, rev_seqno, nmeta
+		req.Extras = make([]byte, 18)
+	}
+	binary.BigEndian.PutUint64(req.Extras[:8], item.Seqno)
+	binary.BigEndian.PutUint64(req.Extras[8:16], item.RevSeqno)
+	return req
+}
+
+func uprStreamEnd(s *uprStream, flags uint32) *transport.MCRequest {
+	req := &transport.MCRequest{
+		Opcode:  transport.UPR_STREAMEND,
+		VBucket: s.vbno,
+		Opaque:  s.opaque,
+		Extras:  make([]byte, 4),
+	}
+	binary.BigEndian.PutUint32(req.Extras, flags)
+	return req
+}
+
+func encodeFailoverLog(flog [][2]uint64) []byte {
+	body := make([]byte, 16*len(flog))
+	for i, entry := range flog {
+		binary.BigEndian.PutUint64(body[i*16:], entry[0])
+		binary.BigEndian.PutUint64(body[i*16+8:], entry[1])
+	}
+	return body
+}
@@ -0,0 +1,83 @@
+package memcached
+
+import (
+	"testing"
+	"time"
+
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+)
+
+func TestUprServer(t *testing.T) {
+	cluster, err := NewCluster(8, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	b := cluster.Bucket("default")
+	vbno, _ := b.Set("doc1", []byte(`{"age": 10}`), 0, 0)
+	b.Set("doc1", []byte(`{"age": 20}`), 0, 0)
+	b.Expire("doc1")
+
+	bucket, err := couchbase.GetBucket("http://"+cluster.Addr(), "default", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+
+	flogs, err := bucket.GetFailoverLogs([]uint16{vbno})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flog := flogs[vbno]
+	vbuuid, _, _ := flog.Latest()
+
+	feed, err := bucket.StartUprFeed("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	// stream from a failed over branch shall be asked to rollback.
+	newuuid := b.VBucket(vbno).Failover(2)
+	err = feed.UprRequestStream(vbno, 0, 0, vbuuid, 3, 3, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, feed)
+	if e.Opcode != transport.UPR_STREAMREQ || e.Status != transport.ROLLBACK {
+		t.Fatalf("expected rollback, got %v %v", e.Opcode, e.Status)
+	} else if e.Seqno != 2 {
+		t.Fatalf("expected rollback to 2, got %v", e.Seqno)
+	}
+
+	b.Delete("doc1")
+	err = feed.UprRequestStream(vbno, 0, 0, newuuid, 0, 3, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := []transport.CommandCode{
+		transport.UPR_STREAMREQ, transport.UPR_SNAPSHOT,
+		transport.UPR_MUTATION, transport.UPR_MUTATION,
+		transport.UPR_DELETION, transport.UPR_STREAMEND,
+	}
+	for i, ref := range refs {
+		if e := nextEvent(t, feed); e.Opcode != ref {
+			t.Fatalf("event %v expected %v, got %v", i, ref, e.Opcode)
+		} else if e.Opcode == transport.UPR_DELETION && e.Seqno != 3 {
+			t.Fatalf("expected deletion at seqno 3, got %v", e.Seqno)
+		}
+	}
+}
+
+func nextEvent(t *testing.T, feed *couchbase.UprFeed) *mc.UprEvent {
+	select {
+	case e := <-feed.C:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event")
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package memcached
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+)
+
+// Item is a mutation, deletion or expiration of a document in a vbucket.
+type Item struct {
+	Opcode   transport.CommandCode // UPR_MUTATION, UPR_DELETION, UPR_EXPIRATION
+	Key      []byte
+	Value    []byte
+	Flags    uint32
+	Expiry   uint32
+	Cas      uint64
+	Seqno    uint64
+	RevSeqno uint64
+}
+
+// VBucket is an in-memory vbucket remembering every item in the order of
+// their seqno, so that streams can be requested from any seqno.
+type VBucket struct {
+	mu      sync.Mutex
+	vbno    uint16
+	items   []*Item
+	revs    map[string]uint64 // key -> rev-seqno
+	flog    [][2]uint64       // failover log, [vbuuid, seqno], latest first
+	changed chan bool         // closed and renewed on every change
+}
+
+func newVBucket(vbno uint16) *VBucket {
+	return &VBucket{
+		vbno:    vbno,
+		revs:    make(map[string]uint64),
+		flog:    [][2]uint64{{uint64(rand.Int63()), 0}},
+		changed: make(chan bool),
+	}
+}
+
+// HighSeqno returns the seqno of the latest item in vbucket.
+func (vb *VBucket) HighSeqno() uint64 {
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+	return vb.highSeqno()
+}
+
+// FailoverLog returns a copy of vbucket's failover log, latest entry
+// first.
+func (vb *VBucket) FailoverLog() [][2]uint64 {
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+	return append([][2]uint64(nil), vb.flog...)
+}
+
+// Failover simulates a failover of vbucket that lost all items after
+// `seqno`, active streams are ended and consumers that have seen items
+// after `seqno` will be asked to rollback. Returns the new vbuuid.
+func (vb *VBucket) Failover(seqno uint64) uint64 {
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+
+	n := len(vb.items)
+	for n > 0 && vb.items[n-1].Seqno > seqno {
+		n--
+	}
+	vb.items = vb.items[:n]
+	vbuuid := uint64(rand.Int63())
+	vb.flog = append([][2]uint64{{vbuuid, vb.highSeqno()}}, vb.flog...)
+	vb.notify()
+	return vbuuid
+}
+
+func (vb *VBucket) add(item *Item) uint64 {
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+
+	item.Seqno = vb.highSeqno() + 1
+	item.RevSeqno = vb.revs[string(item.Key)] + 1
+	vb.revs[string(item.Key)] = item.RevSeqno
+	vb.items = append(vb.items, item)
+	vb.notify()
+	return item.Seqno
+}
+
+// since returns items after `seqno` and upto `end`, the current vbuuid and
+// the channel that will be closed on next change to the vbucket.
+func (vb *VBucket) since(
+	seqno, end uint64) (items []*Item, vbuuid uint64, changed chan bool) {
+
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+
+	for _, item := range vb.items {
+		if item.Seqno > end {
+			break
+		} else if item.Seqno > seqno {
+			items = append(items, item)
+		}
+	}
+	return items, vb.flog[0][0], vb.changed
+}
+
+// rollback returns the seqno that a consumer, that has seen items upto
+// `start` on branch `vbuuid`, shall rollback to before streaming from
+// vbucket. ok is false if rollback is not needed.
+func (vb *VBucket) rollback(vbuuid, start uint64) (seqno uint64, ok bool) {
+	vb.mu.Lock()
+	defer vb.mu.Unlock()
+
+	if start == 0 {
+		return 0, false
+	}
+	for i, entry := range vb.flog {
+		if entry[0] != vbuuid {
+			continue
+		}
+		// branch is valid upto the beginning of next branch.
+		upto := vb.highSeqno()
+		if i > 0 {
+			upto = vb.flog[i-1][1]
+		}
+		if start > upto {
+			return upto, true
+		}
+		return 0, false
+	}
+	return 0, true // unknown branch
+}
+
+func (vb *VBucket) highSeqno() uint64 {
+	if len(vb.items) == 0 {
+		return vb.flog[0][1]
+	}
+	return vb.items[len(vb.items)-1].Seqno
+}
+
+func (vb *VBucket) notify() {
+	close(vb.changed)
+	vb.changed = make(chan bool)
+}
+
+// Bucket is an in-memory couchbase bucket.
+type Bucket struct {
+	Name     string
+	vbuckets []*VBucket
+	cas      uint64
+}
+
+// NewBucket returns a new bucket with `numVbuckets` vbuckets, that shall
+// be a power of 2.
+func NewBucket(name string, numVbuckets int) *Bucket {
+	b := &Bucket{Name: name, vbuckets: make([]*VBucket, numVbuckets)}
+	for i := range b.vbuckets {
+		b.vbuckets[i] = newVBucket(uint16(i))
+	}
+	return b
+}
+
+// NumVbuckets returns the number of vbuckets in bucket.
+func (b *Bucket) NumVbuckets() int {
+	return len(b.vbuckets)
+}
+
+// VBucket returns vbucket `vbno`, nil if there is no such vbucket.
+func (b *Bucket) VBucket(vbno uint16) *VBucket {
+	if int(vbno) >= len(b.vbuckets) {
+		return nil
+	}
+	return b.vbuckets[vbno]
+}
+
+// VBHash finds the vbucket for the given key, same as couchbase.
+func (b *Bucket) VBHash(key string) uint16 {
+	crc := crc32.ChecksumIEEE([]byte(key))
+	return uint16((crc >> 16) & 0x7fff & (uint32(len(b.vbuckets)) - 1))
+}
+
+// Set document `key` to `value`, returns the vbucket and seqno of the
+// mutation.
+func (b *Bucket) Set(
+	key string, value []byte, flags, expiry uint32) (uint16, uint64) {
+
+	item := &Item{
+		Opcode: transport.UPR_MUTATION,
+		Key:    []byte(key),
+		Value:  value,
+		Flags:  flags,
+		Expiry: expiry,
+	}
+	return b.add(item)
+}
+
+// Delete document `key`, returns the vbucket and seqno of the deletion.
+func (b *Bucket) Delete(key string) (uint16, uint64) {
+	return b.add(&Item{Opcode: transport.UPR_DELETION, Key: []byte(key)})
+}
+
+// Expire document `key`, returns the vbucket and seqno of the expiration.
+func (b *Bucket) Expire(key string) (uint16, uint64) {
+	return b.add(&Item{Opcode: transport.UPR_EXPIRATION, Key: []byte(key)})
+}
+
+func (b *Bucket) add(item *Item) (uint16, uint64) {
+	item.Cas = atomic.AddUint64(&b.cas, 1)
+	vbno := b.VBHash(string(item.Key))
+	return vbno, b.vbuckets[vbno].add(item)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/couchbase/indexing/secondary/collatejson"
+)
+
+// Back index maps a docid to the encoded keys of its main index entries.
+// A document with a single entry stores the encoded key as is. Documents
+// of array indexes can have many entries, their keys are stored after the
+// backEntryKeySet marker, each key prefixed by its length as uvarint.
+// Marker can not be mistaken for an encoded key, as collatejson never
+// starts an encoded value with Terminator.
+const backEntryKeySet = collatejson.Terminator
+
+var ErrInvalidBackEntry = errors.New("Invalid back index entry")
+
+// encodeBackEntry returns back index entry for keys, nil if there are no
+// keys.
+func encodeBackEntry(keys [][]byte) []byte {
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return keys[0]
+	}
+
+	size := 1
+	for _, key := range keys {
+		size += binary.MaxVarintLen64 + len(key)
+	}
+
+	entry := make([]byte, 1, size)
+	entry[0] = backEntryKeySet
+	var lenbuf [binary.MaxVarintLen64]byte
+	for _, key := range keys {
+		n := binary.PutUvarint(lenbuf[:], uint64(len(key)))
+		entry = append(entry, lenbuf[:n]...)
+		entry = append(entry, key...)
+	}
+	return entry
+}
+
+// decodeBackEntry returns encoded keys from back index entry.
+func decodeBackEntry(entry []byte) ([][]byte, error) {
+	if len(entry) == 0 {
+		return nil, nil
+	} else if entry[0] != backEntryKeySet {
+		return [][]byte{entry}, nil
+	}
+
+	var keys [][]byte
+	for entry = entry[1:]; len(entry) > 0; {
+		l, n := binary.Uvarint(entry)
+		if n <= 0 || uint64(len(entry)-n) < l {
+			return nil, ErrInvalidBackEntry
+		}
+		keys = append(keys, entry[n:n+int(l)])
+		entry = entry[n+int(l):]
+	}
+	return keys, nil
+}
+
+// diffKeys returns keys in oldkeys but not in newkeys as deleted, and keys
+// in newkeys but not in oldkeys as added.
+func diffKeys(oldkeys, newkeys [][]byte) (deleted, added [][]byte) {
+	if len(oldkeys) == 1 && len(newkeys) == 1 {
+		if string(oldkeys[0]) == string(newkeys[0]) {
+			return nil, nil
+		}
+		return oldkeys, newkeys
+	}
+
+	oldset := make(map[string]bool, len(oldkeys))
+	for _, key := range oldkeys {
+		oldset[string(key)] = true
+	}
+	newset := make(map[string]bool, len(newkeys))
+	for _, key := range newkeys {
+		if newset[string(key)] {
+			continue
+		}
+		newset[string(key)] = true
+		if !oldset[string(key)] {
+			added = append(added, key)
+		}
+	}
+	for _, key := range oldkeys {
+		if !newset[string(key)] {
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted, added
+}
+
+// encodedKeys returns encoded form of keys, skipping nil keys.
+func encodedKeys(keys []Key) [][]byte {
+	encoded := make([][]byte, 0, len(keys))
+	for i := range keys {
+		if key := keys[i].Encoded(); key != nil {
+			encoded = append(encoded, key)
+		}
+	}
+	return encoded
+}
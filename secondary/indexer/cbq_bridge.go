@@ -111,7 +111,7 @@ func (cbq *cbqBridge) handleCreate(w http.ResponseWriter, r *http.Request) {
 
 	idxDefn := common.IndexDefn{DefnId: common.IndexDefnId(defnID),
 		Name:            indexinfo.Name,
-		Using:           common.ForestDB,
+		Using:           common.IndexTypeFromString(indexinfo.Using),
 		Bucket:          indexinfo.Bucket,
 		IsPrimary:       indexinfo.IsPrimary,
 		SecExprs:        indexinfo.SecExprs,
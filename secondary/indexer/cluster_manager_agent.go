@@ -353,7 +353,7 @@ func (meta *metaNotifier) OnIndexCreate(indexDefn *common.IndexDefn) error {
 	common.Debugf("clustMgrAgent::OnIndexCreate Notification "+
 		"Received for Create Index %v", indexDefn)
 
-	pc := meta.makeDefaultPartitionContainer()
+	pc := meta.makeDefaultPartitionContainer(indexDefn)
 
 	idxInst := common.IndexInst{InstId: common.IndexInstId(indexDefn.DefnId),
 		Defn:  *indexDefn,
@@ -492,14 +492,26 @@ func (meta *metaNotifier) OnIndexDelete(defnId common.IndexDefnId) error {
 	return nil
 }
 
-func (meta *metaNotifier) makeDefaultPartitionContainer() common.PartitionContainer {
+func (meta *metaNotifier) makeDefaultPartitionContainer(
+	indexDefn *common.IndexDefn) common.PartitionContainer {
 
 	pc := common.NewKeyPartitionContainer()
 
-	//Add one partition for now
 	addr := net.JoinHostPort("", meta.config["streamMaintPort"].String())
 	endpt := []common.Endpoint{common.Endpoint(addr)}
 
+	//partitioned index, add the partitions hosted by this node
+	if indexDefn.IsPartitioned() {
+		for _, partnId := range indexDefn.Partitions {
+			partnDefn := common.KeyPartitionDefn{Id: partnId,
+				Endpts: endpt}
+			pc.AddPartition(partnId, partnDefn)
+		}
+		return pc
+	}
+
+	//Add one partition for now
+
 	partnDefn := common.KeyPartitionDefn{Id: common.PartitionId(1),
 		Endpts: endpt}
 	pc.AddPartition(common.PartitionId(1), partnDefn)
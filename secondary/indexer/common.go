@@ -12,6 +12,7 @@ package indexer
 import (
 	"fmt"
 	"github.com/couchbase/indexing/secondary/common"
+	"unsafe"
 )
 
 type StreamAddressMap map[common.StreamId]common.Endpoint
@@ -106,6 +107,25 @@ type MutationKeys struct {
 	partnkeys [][]byte             // list of partition keys
 }
 
+//Size returns the approximate memory, in bytes, held by the mutation.
+//partnkeys share their backing arrays with keys and are only counted
+//for the slice headers.
+func (mk *MutationKeys) Size() int64 {
+
+	size := unsafe.Sizeof(*mk) + unsafe.Sizeof(MutationMeta{})
+	size += uintptr(len(mk.docid) + len(mk.commands))
+	size += uintptr(len(mk.uuids)) * unsafe.Sizeof(common.IndexInstId(0))
+	size += uintptr(len(mk.keys)+len(mk.oldkeys)+len(mk.partnkeys)) *
+		unsafe.Sizeof([]byte(nil))
+	for _, key := range mk.keys {
+		size += uintptr(len(key))
+	}
+	for _, key := range mk.oldkeys {
+		size += uintptr(len(key))
+	}
+	return int64(size)
+}
+
 //MutationSnapshot represents snapshot information of KV
 type MutationSnapshot struct {
 	snapType uint32
@@ -43,6 +43,14 @@ const DEFAULT_NUM_STREAM_READER_WORKERS = 8
 //up mutations before processing
 const MAX_STREAM_READER_WORKER_BUFFER = 1000
 
+//Time in milliseconds for a throttled stream reader worker
+//to poll for memory in the mutation queue
+const QUEUE_MEMORY_POLL_INTERVAL = 5
+
+//Time in milliseconds after which a throttled stream reader
+//worker asks again for the mutation queue to be drained
+const QUEUE_OVERFLOW_NOTIFY_INTERVAL = 1000
+
 //Max number of snapshot to be retained per index.
 //Older snapshots are deleted.
 const MAX_SNAPSHOTS_PER_INDEX = 5
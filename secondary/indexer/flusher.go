@@ -350,7 +350,7 @@ func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId) {
 
 			f.processUpsert(mut, i)
 
-		case common.Deletion:
+		case common.Deletion, common.Expiration:
 			f.processDelete(mut, i)
 
 		case common.UpsertDeletion:
@@ -380,10 +380,21 @@ func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId) {
 func (f *flusher) processUpsert(mut *MutationKeys, i int) {
 
 	var key Key
+	var keys []Key
 	var value Value
 	var err error
 
-	if key, err = NewKey(mut.keys[i]); err != nil {
+	idxInst, _ := f.indexInstMap[mut.uuids[i]]
+
+	//array index entry carries a secondary key for every array element
+	desc, collation := idxInst.Defn.Descending(), idxInst.Defn.Collation
+	isArray := idxInst.Defn.IsArrayIndex()
+	if isArray {
+		keys, err = NewArrayKeys(mut.keys[i], desc, collation)
+	} else {
+		key, err = NewKeyWithCollation(mut.keys[i], desc, collation, false)
+	}
+	if err != nil {
 
 		common.Errorf("Flusher::processUpsert Error Generating Key"+
 			"From Mutation: %v. Skipped. Error: %v", mut.keys[i], err)
@@ -398,8 +409,6 @@ func (f *flusher) processUpsert(mut *MutationKeys, i int) {
 		return
 	}
 
-	idxInst, _ := f.indexInstMap[mut.uuids[i]]
-
 	partnId := idxInst.Pc.GetPartitionIdByPartitionKey(mut.partnkeys[i])
 
 	var partnInstMap PartitionInstMap
@@ -412,9 +421,14 @@ func (f *flusher) processUpsert(mut *MutationKeys, i int) {
 
 	if partnInst := partnInstMap[partnId]; ok {
 		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.keys[i]))
-		if err := slice.Insert(key, value); err != nil {
+		if isArray {
+			err = slice.InsertArray(keys, value)
+		} else {
+			err = slice.Insert(key, value)
+		}
+		if err != nil {
 			common.Errorf("Flusher::processUpsert Error Inserting Key: %v "+
-				"Value: %v in Slice: %v. Error: %v", key, value, slice.Id(), err)
+				"Value: %v in Slice: %v. Error: %v", mut.keys[i], value, slice.Id(), err)
 		}
 	} else {
 		common.Errorf("Flusher::processUpsert Partition Instance not found "+
@@ -82,6 +82,44 @@ func (f *ForestDBIterator) Seek(key []byte) {
 	f.Get()
 }
 
+// SeekLast positions the iterator at the last key <= end, a nil end seeks
+// to the last key. Use Prev() to iterate in reverse order.
+func (f *ForestDBIterator) SeekLast(end []byte) {
+	if f.iter != nil {
+		f.iter.Close()
+		f.iter = nil
+	}
+	var err error
+	f.iter, err = f.db.IteratorInit([]byte{}, end, forestdb.ITR_NONE|forestdb.ITR_NO_DELETES)
+	if err != nil {
+		f.valid = false
+		return
+	}
+	if err = f.iter.SeekMax(); err != nil {
+		f.valid = false
+		return
+	}
+	f.valid = true
+	f.Get()
+}
+
+func (f *ForestDBIterator) Prev() {
+	var err error
+	err = f.iter.Prev()
+	if err != nil {
+		f.valid = false
+		return
+	}
+
+	//free the doc allocated by forestdb
+	if f.curr != nil {
+		f.curr.Close()
+		f.curr = nil
+	}
+
+	f.Get()
+}
+
 func (f *ForestDBIterator) Next() {
 	var err error
 	err = f.iter.Next()
@@ -102,8 +102,8 @@ func NewForestDBSlice(path string, sliceId SliceId, idxDefnId common.IndexDefnId
 
 //kv represents a key/value pair in storage format
 type kv struct {
-	k Key
-	v Value
+	keys []Key
+	v    Value
 }
 
 //fdbSlice represents a forestdb slice
@@ -176,7 +176,21 @@ func (fdb *fdbSlice) DecrRef() {
 //it will be returned as error.
 func (fdb *fdbSlice) Insert(k Key, v Value) error {
 
-	fdb.cmdCh <- kv{k: k, v: v}
+	var keys []Key
+	if k.Encoded() != nil {
+		keys = []Key{k}
+	}
+	fdb.cmdCh <- kv{keys: keys, v: v}
+	return fdb.fatalDbErr
+
+}
+
+//InsertArray will insert the keys of an array index entry,
+//replacing existing keys of the docid. Internally the request
+//is buffered and executed async.
+func (fdb *fdbSlice) InsertArray(keys []Key, v Value) error {
+
+	fdb.cmdCh <- kv{keys: keys, v: v}
 	return fdb.fatalDbErr
 
 }
@@ -206,7 +220,7 @@ loop:
 			case kv:
 				cmd := c.(kv)
 				start := time.Now()
-				fdb.insert(cmd.k, cmd.v, workerId)
+				fdb.insert(cmd.keys, cmd.v, workerId)
 				elapsed := time.Since(start)
 				fdb.totalFlushTime += elapsed
 			case []byte:
@@ -234,36 +248,50 @@ loop:
 
 }
 
-//insert does the actual insert in forestdb
-func (fdb *fdbSlice) insert(k Key, v Value, workerId int) {
+//insert does the actual insert in forestdb. Existing keys of the docid
+//are diffed with the new keys, only changed entries are written.
+func (fdb *fdbSlice) insert(keys []Key, v Value, workerId int) {
 
 	var err error
-	var oldkey Key
+	var oldkeys [][]byte
 
-	common.Tracef("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Set Key - %s "+
-		"Value - %s", fdb.id, fdb.idxInstId, k, v)
+	common.Tracef("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Set Keys - %v "+
+		"Value - %s", fdb.id, fdb.idxInstId, keys, v)
 
 	//check if the docid exists in the back index
-	if oldkey, err = fdb.getBackIndexEntry(v.Docid(), workerId); err != nil {
+	if oldkeys, err = fdb.getBackIndexEntry(v.Docid(), workerId); err != nil {
 		fdb.checkFatalDbError(err)
 		common.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error locating "+
 			"backindex entry %v", fdb.id, fdb.idxInstId, err)
 		return
-	} else if oldkey.Encoded() != nil {
-		//TODO: Handle the case if old-value from backindex matches with the
-		//new-value(false mutation). Skip It.
+	}
+
+	newkeys := encodedKeys(keys)
+	deleted, added := diffKeys(oldkeys, newkeys)
+
+	//false mutation, secondary keys of the docid have not changed
+	if len(deleted) == 0 && len(added) == 0 {
+		common.Tracef("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Unchanged Keys for "+
+			"Doc Id %v. Skipped.", fdb.id, fdb.idxInstId, v.Docid())
+		return
+	}
 
-		//there is already an entry in main index for this docid
-		//delete from main index
-		if err = fdb.main[workerId].DeleteKV(oldkey.Encoded()); err != nil {
+	//delete main index entries no longer present in the document
+	for _, oldkey := range deleted {
+		if err = fdb.main[workerId].DeleteKV(oldkey); err != nil {
 			fdb.checkFatalDbError(err)
 			common.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error deleting "+
 				"entry from main index %v", fdb.id, fdb.idxInstId, err)
 			return
 		}
-		atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey.Encoded())))
+		atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey)))
+	}
+
+	//if there are no keys, only the back index entry needs to be removed
+	if len(newkeys) == 0 {
+		common.Tracef("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Received NIL Key for "+
+			"Doc Id %v.", fdb.id, fdb.idxInstId, v.Docid())
 
-		//delete from back index
 		if err = fdb.back[workerId].DeleteKV(v.Docid()); err != nil {
 			fdb.checkFatalDbError(err)
 			common.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error deleting "+
@@ -271,32 +299,29 @@ func (fdb *fdbSlice) insert(k Key, v Value, workerId int) {
 			return
 		}
 		atomic.AddInt64(&fdb.delete_bytes, int64(len(v.Docid())))
-	}
-
-	//if the Key is nil, nothing needs to be done
-	if k.Encoded() == nil {
-		common.Tracef("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Received NIL Key for "+
-			"Doc Id %v. Skipped.", fdb.id, fdb.idxInstId, v.Docid())
 		return
 	}
 
-	//set the back index entry <docid, encodedkey>
-	if err = fdb.back[workerId].SetKV([]byte(v.Docid()), k.Encoded()); err != nil {
+	//set the back index entry <docid, encodedkeys>
+	backEntry := encodeBackEntry(newkeys)
+	if err = fdb.back[workerId].SetKV([]byte(v.Docid()), backEntry); err != nil {
 		fdb.checkFatalDbError(err)
 		common.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error in Back Index Set. "+
-			"Skipped Key %s. Value %s. Error %v", fdb.id, fdb.idxInstId, v, k, err)
+			"Skipped Keys %v. Value %s. Error %v", fdb.id, fdb.idxInstId, keys, v, err)
 		return
 	}
-	atomic.AddInt64(&fdb.insert_bytes, int64(len(v.Docid())+len(k.Encoded())))
+	atomic.AddInt64(&fdb.insert_bytes, int64(len(v.Docid())+len(backEntry)))
 
 	//set in main index
-	if err = fdb.main[workerId].SetKV(k.Encoded(), v.Encoded()); err != nil {
-		fdb.checkFatalDbError(err)
-		common.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error in Main Index Set. "+
-			"Skipped Key %s. Value %s. Error %v", fdb.id, fdb.idxInstId, k, v, err)
-		return
+	for _, key := range added {
+		if err = fdb.main[workerId].SetKV(key, v.Encoded()); err != nil {
+			fdb.checkFatalDbError(err)
+			common.Errorf("ForestDBSlice::insert \n\tSliceId %v IndexInstId %v Error in Main Index Set. "+
+				"Skipped Key %v. Value %s. Error %v", fdb.id, fdb.idxInstId, key, v, err)
+			return
+		}
+		atomic.AddInt64(&fdb.insert_bytes, int64(len(key)+len(v.Encoded())))
 	}
-	atomic.AddInt64(&fdb.insert_bytes, int64(len(k.Encoded())+len(v.Encoded())))
 }
 
 //delete does the actual delete in forestdb
@@ -305,33 +330,35 @@ func (fdb *fdbSlice) delete(docid []byte, workerId int) {
 	common.Tracef("ForestDBSlice::delete \n\tSliceId %v IndexInstId %v. Delete Key - %s",
 		fdb.id, fdb.idxInstId, docid)
 
-	var oldkey Key
+	var oldkeys [][]byte
 	var err error
 
-	if oldkey, err = fdb.getBackIndexEntry(docid, workerId); err != nil {
+	if oldkeys, err = fdb.getBackIndexEntry(docid, workerId); err != nil {
 		fdb.checkFatalDbError(err)
 		common.Errorf("ForestDBSlice::delete \n\tSliceId %v IndexInstId %v. Error locating "+
 			"backindex entry for Doc %s. Error %v", fdb.id, fdb.idxInstId, docid, err)
 		return
 	}
 
-	//if the oldkey is nil, nothing needs to be done. This is the case of deletes
+	//if there are no oldkeys, nothing needs to be done. This is the case of deletes
 	//which happened before index was created.
-	if oldkey.Encoded() == nil {
+	if len(oldkeys) == 0 {
 		common.Tracef("ForestDBSlice::delete \n\tSliceId %v IndexInstId %v Received NIL Key for "+
 			"Doc Id %v. Skipped.", fdb.id, fdb.idxInstId, docid)
 		return
 	}
 
 	//delete from main index
-	if err = fdb.main[workerId].DeleteKV(oldkey.Encoded()); err != nil {
-		fdb.checkFatalDbError(err)
-		common.Errorf("ForestDBSlice::delete \n\tSliceId %v IndexInstId %v. Error deleting "+
-			"entry from main index for Doc %s. Key %v. Error %v", fdb.id, fdb.idxInstId,
-			docid, oldkey, err)
-		return
+	for _, oldkey := range oldkeys {
+		if err = fdb.main[workerId].DeleteKV(oldkey); err != nil {
+			fdb.checkFatalDbError(err)
+			common.Errorf("ForestDBSlice::delete \n\tSliceId %v IndexInstId %v. Error deleting "+
+				"entry from main index for Doc %s. Key %v. Error %v", fdb.id, fdb.idxInstId,
+				docid, oldkey, err)
+			return
+		}
+		atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey)))
 	}
-	atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey.Encoded())))
 
 	//delete from the back index
 	if err = fdb.back[workerId].DeleteKV(docid); err != nil {
@@ -344,14 +371,13 @@ func (fdb *fdbSlice) delete(docid []byte, workerId int) {
 
 }
 
-//getBackIndexEntry returns an existing back index entry
-//given the docid
-func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) (Key, error) {
+//getBackIndexEntry returns the encoded keys of an existing
+//back index entry given the docid
+func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) ([][]byte, error) {
 
 	common.Tracef("ForestDBSlice::getBackIndexEntry \n\tSliceId %v IndexInstId %v Get BackIndex Key - %s",
 		fdb.id, fdb.idxInstId, docid)
 
-	var k Key
 	var kbyte []byte
 	var err error
 
@@ -361,12 +387,10 @@ func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) (Key, error)
 	//forestdb reports get in a non-existent key as an
 	//error, skip that
 	if err != nil && err != forestdb.RESULT_KEY_NOT_FOUND {
-		return k, err
+		return nil, err
 	}
 
-	k, err = NewKeyFromEncodedBytes(kbyte)
-
-	return k, err
+	return decodeBackEntry(kbyte)
 }
 
 //checkFatalDbError checks if the error returned from DB
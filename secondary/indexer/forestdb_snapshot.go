@@ -54,6 +54,8 @@ type fdbSnapshot struct {
 
 	refCount int          //Reader count for this snapshot
 	lock     sync.RWMutex //lock to atomically increment the refCount
+
+	stats statsCache // statistics computed on the snapshot
 }
 
 func (s *fdbSnapshot) Open() error {
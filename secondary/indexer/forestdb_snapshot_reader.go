@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/couchbase/indexing/secondary/collatejson"
 	"github.com/couchbase/indexing/secondary/common"
 )
 
@@ -62,7 +63,7 @@ func (s *fdbSnapshot) KeySet(stopch StopChannel) (chan Key, chan error) {
 	cherr := make(chan error)
 
 	nilKey, _ := NewKeyFromEncodedBytes(nil)
-	go s.GetKeySetForKeyRange(nilKey, nilKey, Both, chkey, cherr, stopch)
+	go s.GetKeySetForKeyRange(nilKey, nilKey, Both, false, chkey, cherr, stopch)
 	return chkey, cherr
 }
 
@@ -82,10 +83,40 @@ func (s *fdbSnapshot) KeyRange(low, high Key, inclusion Inclusion,
 	chkey := make(chan Key)
 	cherr := make(chan error)
 
-	go s.GetKeySetForKeyRange(low, high, inclusion, chkey, cherr, stopch)
+	go s.GetKeySetForKeyRange(low, high, inclusion, false, chkey, cherr, stopch)
 	return chkey, cherr, Asc
 }
 
+func (s *fdbSnapshot) DistinctKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go s.GetKeySetForKeyRange(low, high, inclusion, true, chkey, cherr, stopch)
+	return chkey, cherr, Asc
+}
+
+func (s *fdbSnapshot) ReverseKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go s.GetReverseKeySetForKeyRange(low, high, inclusion, false, chkey, cherr, stopch)
+	return chkey, cherr, Desc
+}
+
+func (s *fdbSnapshot) ReverseDistinctKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go s.GetReverseKeySetForKeyRange(low, high, inclusion, true, chkey, cherr, stopch)
+	return chkey, cherr, Desc
+}
+
 func (s *fdbSnapshot) ValueRange(low, high Key, inclusion Inclusion,
 	stopch StopChannel) (chan Value, chan error, SortOrder) {
 
@@ -99,11 +130,19 @@ func (s *fdbSnapshot) ValueRange(low, high Key, inclusion Inclusion,
 // TODO: Refactor db scan to support inclusion options
 // Currently, for the given low, high predicates, it will return rows
 // for which row >= low and row < high
+// If distinct is true, only the first entry of every secondary key is
+// returned and rest of the entries, that differ only by docid, are skipped.
 func (s *fdbSnapshot) GetKeySetForKeyRange(low Key, high Key,
-	inclusion Inclusion, chkey chan Key, cherr chan error, stopch StopChannel) {
+	inclusion Inclusion, distinct bool, chkey chan Key, cherr chan error,
+	stopch StopChannel) {
 
 	defer close(chkey)
 
+	var filter *distinctFilter
+	if distinct {
+		filter = new(distinctFilter)
+	}
+
 	common.Debugf("ForestDB Received Key Low - %s High - %s for Scan",
 		low.String(), high.String())
 
@@ -125,7 +164,7 @@ func (s *fdbSnapshot) GetKeySetForKeyRange(low Key, high Key,
 
 		// Discard equal keys if low inclusion is requested
 		if inclusion == Neither || inclusion == High {
-			readEqualKeys(low, lowkey, it, chkey, cherr, stopch, true)
+			readEqualKeys(low, lowkey, it, chkey, cherr, stopch, nil, true)
 		}
 	}
 
@@ -167,13 +206,92 @@ loop:
 				break loop
 			}
 
+			if filter.skip(key) {
+				continue
+			}
+
 			chkey <- key
 		}
 	}
 
 	// Include equal keys if high inclusion is requested
 	if inclusion == Both || inclusion == High {
-		readEqualKeys(high, highkey, it, chkey, cherr, stopch, false)
+		readEqualKeys(high, highkey, it, chkey, cherr, stopch, filter, false)
+	}
+}
+
+// GetReverseKeySetForKeyRange is same as GetKeySetForKeyRange, except that
+// keys are returned in reverse order, starting from the high key. Index
+// entries equal a scan key if the encoded scan key, without array
+// terminator, is a prefix of the encoded entry.
+func (s *fdbSnapshot) GetReverseKeySetForKeyRange(low Key, high Key,
+	inclusion Inclusion, distinct bool, chkey chan Key, cherr chan error,
+	stopch StopChannel) {
+
+	defer close(chkey)
+
+	var filter *distinctFilter
+	if distinct {
+		filter = new(distinctFilter)
+	}
+
+	common.Debugf("ForestDB Received Key Low - %s High - %s for Reverse Scan",
+		low.String(), high.String())
+
+	it, err := newFDBSnapshotIterator(s)
+	if err != nil {
+		cherr <- err
+		return
+	}
+	defer closeIterator(it)
+
+	var lowkey []byte
+	if lowkey = low.Encoded(); lowkey != nil {
+		// Low key prefix computed by removing last byte
+		lowkey = lowkey[:len(lowkey)-1]
+	}
+
+	if highkey := high.Encoded(); highkey == nil {
+		it.SeekLast(nil)
+	} else {
+		it.SeekLast(highKeyLimit(highkey, inclusion == Both || inclusion == High))
+	}
+
+	var key Key
+	for ; it.Valid(); it.Prev() {
+
+		select {
+
+		case <-stopch:
+			// stop signalled, end processing
+			return
+
+		default:
+			common.Tracef("ForestDB Got Key - %s", string(it.Key()))
+
+			// if we have reached past the low key, no need to scan further
+			if lowkey != nil {
+				if bytes.HasPrefix(it.Key(), lowkey) {
+					if inclusion == Neither || inclusion == High {
+						return
+					}
+				} else if bytes.Compare(it.Key(), lowkey) < 0 {
+					return
+				}
+			}
+
+			if key, err = NewKeyFromEncodedBytes(it.Key()); err != nil {
+				common.Errorf("Error Converting from bytes %v to key %v. Skipping row",
+					it.Key(), err)
+				panic(err)
+			}
+
+			if filter.skip(key) {
+				continue
+			}
+
+			chkey <- key
+		}
 	}
 }
 
@@ -274,7 +392,7 @@ func (s *fdbSnapshot) CountRange(low Key, high Key, inclusion Inclusion,
 
 	chkey := make(chan Key)
 	cherr := make(chan error)
-	go s.GetKeySetForKeyRange(low, high, inclusion, chkey, cherr, stopch)
+	go s.GetKeySetForKeyRange(low, high, inclusion, false, chkey, cherr, stopch)
 
 	var count uint64
 loop:
@@ -292,6 +410,16 @@ loop:
 	return count, nil
 }
 
+//Statistician interface
+func (s *fdbSnapshot) StatisticsRange(low, high Key, inclusion Inclusion,
+	nbins int, stopch StopChannel) (*RangeStatistics, error) {
+
+	common.Debugf("ForestDB Received Key Low - %s High - %s Bins - %v for Statistics",
+		low.String(), high.String(), nbins)
+
+	return s.stats.statisticsRange(s, low, high, inclusion, nbins, stopch)
+}
+
 // Keys are encoded in the form of an array [..., primaryKey]
 // Scannable key is the subarray with [0:l] where l is the max prefix fields
 // This method is used to transform key bytes received from index storage
@@ -317,27 +445,18 @@ func ReadScanKey(kbytes []byte, nfields int) (k []byte, err error) {
 	return
 }
 
-// For checking equality, its a two step operation:
-// 1. Compare jsoncollate encoded prefix
-// 2. If encoded prefix is equal, compare decoded full keys
+// Index entries equal the scan key if its jsoncollate encoded prefix,
+// kPrefix, is a prefix of the encoded entry. Encoded keys are compared
+// instead of decoded keys, as strings that are equal per the collation of
+// index keys may differ in their decoded form.
 func readEqualKeys(k Key, kPrefix []byte, it *ForestDBIterator,
-	chkey chan Key, cherr chan error, stopch StopChannel, discard bool) {
+	chkey chan Key, cherr chan error, stopch StopChannel,
+	filter *distinctFilter, discard bool) {
 
-	var err error
-	var t []interface{}
-	jsonKey := k.Raw()
-	if jsonKey == nil {
-		return
-	}
-	err = json.Unmarshal(jsonKey, &t)
-	if err != nil {
-		cherr <- err
+	if k.Encoded() == nil {
 		return
 	}
 
-	// Number of interested prefix fields for scan
-	nfields := len(t)
-
 loop:
 	for ; it.Valid(); it.Next() {
 		l := len(kPrefix)
@@ -353,25 +472,13 @@ loop:
 		default:
 			// Is prefix equal ?
 			if cmp == 0 {
-				key, err1 := NewKeyFromEncodedBytes(it.Key())
-				if err1 != nil {
-					cherr <- err1
-					return
-				}
-				jsonCurrKey, err2 := ReadScanKey(key.Raw(), nfields)
+				key, err := NewKeyFromEncodedBytes(it.Key())
 				if err != nil {
-					cherr <- err2
+					cherr <- err
 					return
 				}
-
-				// Compare full json key
-				cmp = bytes.Compare(jsonKey, jsonCurrKey)
-				if cmp == 0 {
-					if !discard {
-						chkey <- key
-					}
-				} else {
-					break loop
+				if !discard && !filter.skip(key) {
+					chkey <- key
 				}
 			} else {
 				break loop
@@ -380,6 +487,34 @@ loop:
 	}
 }
 
+// distinctFilter is used to skip index entries that has the same secondary
+// key as the previously returned entry. Entries are expected to be read in
+// sorted order, so that all entries of a secondary key are adjacent to each
+// other. A nil filter does not skip any entry.
+type distinctFilter struct {
+	last []byte // encoded secondary key of the previous entry
+}
+
+func (f *distinctFilter) skip(key Key) bool {
+	if f == nil {
+		return false
+	}
+
+	// Index entry is encoded as [secKey..., docid], ignore docid
+	secKey, err := collatejson.TrimLastItem(key.Encoded())
+	if err != nil {
+		common.Errorf("ForestDB distinct scan: Unable to read secondary key "+
+			"from %v (%v)", key.Encoded(), err)
+		return false
+	}
+
+	if f.last != nil && bytes.Equal(f.last, secKey) {
+		return true
+	}
+	f.last = append(f.last[:0], secKey...)
+	return false
+}
+
 func closeIterator(it *ForestDBIterator) {
 	err := it.Close()
 	if err != nil {
@@ -58,6 +58,16 @@ type Ranger interface {
 		chan Key, chan error, SortOrder)
 	ValueRange(low, high Key, inclusion Inclusion, stopch StopChannel) (
 		chan Value, chan error, SortOrder)
+	// DistinctKeyRange is same as KeyRange, except that only one entry is
+	// returned for every secondary key, ignoring the docid of the entries.
+	DistinctKeyRange(low, high Key, inclusion Inclusion, stopch StopChannel) (
+		chan Key, chan error, SortOrder)
+	// ReverseKeyRange and ReverseDistinctKeyRange are same as KeyRange and
+	// DistinctKeyRange, except that entries are returned in reverse order.
+	ReverseKeyRange(low, high Key, inclusion Inclusion, stopch StopChannel) (
+		chan Key, chan error, SortOrder)
+	ReverseDistinctKeyRange(low, high Key, inclusion Inclusion,
+		stopch StopChannel) (chan Key, chan error, SortOrder)
 }
 
 // RangeCounter is a class of algorithms that can count a range efficiently
@@ -66,8 +76,16 @@ type RangeCounter interface {
 		uint64, error)
 }
 
+// Statistician is a class of algorithms that can compute statistics, like
+// min/max keys, unique keys and histogram, for a range of keys.
+type Statistician interface {
+	StatisticsRange(low, high Key, inclusion Inclusion, nbins int,
+		stopch StopChannel) (*RangeStatistics, error)
+}
+
 type IndexReader interface {
 	Counter
 	Ranger
 	RangeCounter
+	Statistician
 }
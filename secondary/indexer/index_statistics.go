@@ -0,0 +1,349 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"math"
+	"sort"
+	"sync"
+)
+
+var ErrStatisticsAborted = errors.New("Index statistics aborted")
+
+// maxCachedStatistics bounds the number of ranges whose statistics are
+// cached by a snapshot.
+const maxCachedStatistics = 64
+
+// RangeStatistics captures statistics of index entries for a range of keys.
+// Bins, if computed, is an equi-depth histogram of the range, where every
+// bin holds statistics for a sub-range with roughly the same number of
+// entries. Entries sharing the same secondary key always fall in the same
+// bin.
+type RangeStatistics struct {
+	Count     uint64 // number of index entries
+	Unique    uint64 // number of unique secondary keys
+	Estimated bool   // whether Unique is estimated, refer mergeStatistics
+	Min       Key    // first index entry in the range
+	Max       Key    // last index entry in the range
+	Bins      []*RangeStatistics
+}
+
+func (s *RangeStatistics) update(key Key, unique bool) {
+	if s.Count == 0 {
+		s.Min = key
+	}
+	s.Max = key
+	s.Count++
+	if unique {
+		s.Unique++
+	}
+}
+
+// mergeStatistics combines statistics computed over different sets of index
+// entries, like slices and partitions of an index, re-binning them into a
+// histogram of nbins bins.
+//
+// Bins of all the sets are split at the secondary keys that start a bin,
+// and entries of a bin spanning more than one split are spread evenly
+// across them, hence entries sharing a secondary key still fall in the same
+// bin. Sets that do not share secondary keys, like range partitions, are
+// merged exactly. Bins of sets that share secondary keys, like partitions
+// hashed on docid, overlap and their unique keys are estimated assuming the
+// keys are shared, in which case Estimated is set.
+func mergeStatistics(all []*RangeStatistics, nbins int) *RangeStatistics {
+	merged := new(RangeStatistics)
+	var pieces []*RangeStatistics
+	for _, stats := range all {
+		if stats == nil || stats.Count == 0 {
+			continue
+		}
+		if merged.Count == 0 || stats.Min.Compare(merged.Min) < 0 {
+			merged.Min = stats.Min
+		}
+		if merged.Count == 0 || stats.Max.Compare(merged.Max) > 0 {
+			merged.Max = stats.Max
+		}
+		merged.Count += stats.Count
+		if len(stats.Bins) > 0 {
+			pieces = append(pieces, stats.Bins...)
+		} else {
+			pieces = append(pieces, stats)
+		}
+	}
+	if len(pieces) == 0 {
+		return merged
+	}
+	sort.Sort(statsByMinKey(pieces))
+
+	var splits [][]byte
+	for _, piece := range pieces {
+		secKey := statsKey(piece.Min)
+		if n := len(splits); n == 0 || !bytes.Equal(splits[n-1], secKey) {
+			splits = append(splits, secKey)
+		}
+	}
+	units := make([]statsUnit, len(splits))
+	for _, piece := range pieces {
+		minKey, maxKey := statsKey(piece.Min), statsKey(piece.Max)
+		first := sort.Search(len(splits), func(i int) bool {
+			return bytes.Compare(splits[i], minKey) >= 0
+		})
+		last := sort.Search(len(splits), func(i int) bool {
+			return bytes.Compare(splits[i], maxKey) > 0
+		}) - 1
+
+		n := float64(last - first + 1)
+		for i := first; i <= last; i++ {
+			units[i].add(float64(piece.Count)/n, float64(piece.Unique)/n)
+		}
+		// bins are in order of min keys, first of them is the min of unit.
+		if u := &units[first]; !u.hasMin {
+			u.min, u.hasMin = piece.Min, true
+		}
+		if u := &units[last]; !u.hasMax || piece.Max.Compare(u.max) > 0 {
+			u.max, u.hasMax = piece.Max, true
+		}
+	}
+
+	// round cumulative counts, so that bins add up to the merged count.
+	var count, unique float64
+	var bin *RangeStatistics
+	depth := histogramDepth(merged.Count, nbins)
+	for i := range units {
+		u := &units[i]
+		binCount := uint64(math.Floor(count + u.count + 0.5))
+		binCount -= uint64(math.Floor(count + 0.5))
+		binUnique := uint64(math.Floor(unique + u.unique + 0.5))
+		binUnique -= uint64(math.Floor(unique + 0.5))
+		count, unique = count+u.count, unique+u.unique
+		merged.Estimated = merged.Estimated || u.estimated
+		if nbins <= 0 {
+			continue
+		}
+
+		if bin == nil || (bin.Count >= depth && binCount > 0) {
+			bin = &RangeStatistics{Min: u.min}
+			merged.Bins = append(merged.Bins, bin)
+		}
+		bin.Count += binCount
+		bin.Unique += binUnique
+		bin.Estimated = bin.Estimated || u.estimated
+		// max of a unit is not known if all its bins span the next unit.
+		if bin.Max = u.min; u.hasMax {
+			bin.Max = u.max
+		}
+	}
+	merged.Unique = uint64(math.Floor(unique + 0.5))
+	for _, bin := range merged.Bins {
+		if bin.Unique == 0 {
+			bin.Unique = 1
+		}
+	}
+	if merged.Unique == 0 {
+		merged.Unique = 1
+	}
+	return merged
+}
+
+// statsUnit accumulates entries of bins falling between two splits.
+type statsUnit struct {
+	count, unique  float64
+	estimated      bool
+	nbins          int
+	min, max       Key
+	hasMin, hasMax bool
+}
+
+func (u *statsUnit) add(count, unique float64) {
+	u.count += count
+	if u.nbins > 0 { // overlapping bins, unique keys are assumed shared
+		u.unique = math.Max(u.unique, unique)
+		u.estimated = true
+	} else {
+		u.unique = unique
+	}
+	u.nbins++
+}
+
+type statsByMinKey []*RangeStatistics
+
+func (s statsByMinKey) Len() int {
+	return len(s)
+}
+
+func (s statsByMinKey) Less(i, j int) bool {
+	return s[i].Min.Compare(s[j].Min) < 0
+}
+
+func (s statsByMinKey) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// statsBuilder accumulates statistics for index entries that are added in
+// sort order.
+type statsBuilder struct {
+	depth uint64 // number of entries per histogram bin, 0 disables histogram
+	stats *RangeStatistics
+	bin   *RangeStatistics
+	last  []byte // secondary key of the last entry added
+}
+
+func newStatsBuilder(depth uint64) *statsBuilder {
+	return &statsBuilder{
+		depth: depth,
+		stats: new(RangeStatistics),
+	}
+}
+
+func (b *statsBuilder) add(key Key) {
+	secKey := statsKey(key)
+	unique := b.last == nil || !bytes.Equal(b.last, secKey)
+	if unique {
+		b.last = append(b.last[:0], secKey...)
+	}
+
+	b.stats.update(key, unique)
+	if b.depth > 0 {
+		// start a new bin only at the boundary of a secondary key
+		if b.bin == nil || (unique && b.bin.Count >= b.depth) {
+			b.bin = new(RangeStatistics)
+			b.stats.Bins = append(b.stats.Bins, b.bin)
+		}
+		b.bin.update(key, unique)
+	}
+}
+
+func (b *statsBuilder) statistics() *RangeStatistics {
+	return b.stats
+}
+
+// histogramDepth computes number of entries per bin to split count entries
+// into nbins.
+func histogramDepth(count uint64, nbins int) uint64 {
+	if nbins <= 0 {
+		return 0
+	}
+	return (count + uint64(nbins) - 1) / uint64(nbins)
+}
+
+// statsKey returns the encoded secondary key of an index entry, ignoring
+// its docid. Primary index entries are made of docid alone, in which case
+// the whole entry is returned.
+func statsKey(key Key) []byte {
+	code := key.Encoded()
+	secKey, err := collatejson.TrimLastItem(code)
+	if err != nil || len(secKey) <= 1 {
+		return code
+	}
+	return secKey
+}
+
+// statisticsReader is a snapshot that can count and iterate a range of keys.
+type statisticsReader interface {
+	Ranger
+	RangeCounter
+}
+
+type statsCacheKey struct {
+	low, high string
+	inclusion Inclusion
+	nbins     int
+}
+
+// statsCache implements Statistician interface for snapshots. Snapshots are
+// immutable, hence statistics of a range are computed once and served from
+// cache for the lifetime of the snapshot. Zero value is ready to use.
+type statsCache struct {
+	mu    sync.Mutex
+	stats map[statsCacheKey]*RangeStatistics
+}
+
+func (c *statsCache) statisticsRange(reader statisticsReader,
+	low, high Key, inclusion Inclusion, nbins int,
+	stopch StopChannel) (*RangeStatistics, error) {
+
+	key := statsCacheKey{
+		low:       string(low.Encoded()),
+		high:      string(high.Encoded()),
+		inclusion: inclusion,
+		nbins:     nbins,
+	}
+	c.mu.Lock()
+	stats, ok := c.stats[key]
+	c.mu.Unlock()
+	if ok {
+		return stats, nil
+	}
+
+	stats, err := computeStatistics(reader, low, high, inclusion, nbins, stopch)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.stats == nil || len(c.stats) >= maxCachedStatistics {
+		c.stats = make(map[statsCacheKey]*RangeStatistics)
+	}
+	c.stats[key] = stats
+	c.mu.Unlock()
+	return stats, nil
+}
+
+// computeStatistics walks the range of keys in `reader` to compute its
+// statistics. Equi-depth histogram needs the number of entries in the range
+// upfront, hence range is counted before the walk when nbins is non-zero.
+func computeStatistics(reader statisticsReader,
+	low, high Key, inclusion Inclusion, nbins int,
+	stopch StopChannel) (*RangeStatistics, error) {
+
+	var depth uint64
+	if nbins > 0 {
+		count, err := reader.CountRange(low, high, inclusion, stopch)
+		if err != nil {
+			return nil, err
+		} else if isStopped(stopch) {
+			return nil, ErrStatisticsAborted
+		}
+		depth = histogramDepth(count, nbins)
+	}
+
+	chkey, cherr, _ := reader.KeyRange(low, high, inclusion, stopch)
+
+	builder := newStatsBuilder(depth)
+loop:
+	for {
+		select {
+		case key, ok := <-chkey:
+			if !ok {
+				break loop
+			}
+			builder.add(key)
+		case err := <-cherr:
+			return nil, err
+		}
+	}
+	// key range is closed early when stopped, statistics are partial.
+	if isStopped(stopch) {
+		return nil, ErrStatisticsAborted
+	}
+	return builder.statistics(), nil
+}
+
+func isStopped(stopch StopChannel) bool {
+	select {
+	case <-stopch:
+		return true
+	default:
+		return false
+	}
+}
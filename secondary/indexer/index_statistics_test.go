@@ -0,0 +1,197 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func newStatisticsTestReader(t *testing.T, nkeys, ndocs int) rangeReader {
+	tree := newLlrbTree()
+	for i := 0; i < nkeys; i++ {
+		for j := 0; j < ndocs; j++ {
+			entry := fmt.Sprintf(`["key-%03d","doc-%03d-%d"]`, i, i, j)
+			key, err := NewKey([]byte(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree.Set(key.Encoded(), nil)
+		}
+	}
+	return newRangeReader(tree.Snapshot())
+}
+
+func TestStatisticsRange(t *testing.T) {
+	r := newStatisticsTestReader(t, 100, 2)
+	nilKey, _ := NewKeyFromEncodedBytes(nil)
+
+	stats, err := r.StatisticsRange(nilKey, nilKey, Both, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.Count != 200 || stats.Unique != 100 {
+		t.Fatalf("unexpected count %v, unique %v", stats.Count, stats.Unique)
+	} else if len(stats.Bins) != 10 {
+		t.Fatalf("expected 10 bins, got %v", len(stats.Bins))
+	}
+	for i, bin := range stats.Bins {
+		if bin.Count != 20 || bin.Unique != 10 {
+			t.Errorf("unexpected bin %v, count %v, unique %v",
+				i, bin.Count, bin.Unique)
+		}
+	}
+
+	low, _ := NewKey([]byte(`["key-010"]`))
+	high, _ := NewKey([]byte(`["key-019"]`))
+	stats, err = r.StatisticsRange(low, high, Low, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.Count != 18 || stats.Unique != 9 || stats.Bins != nil {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+}
+
+func TestStatisticsCache(t *testing.T) {
+	r := newStatisticsTestReader(t, 10, 1)
+	nilKey, _ := NewKeyFromEncodedBytes(nil)
+
+	stats, err := r.StatisticsRange(nilKey, nilKey, Both, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := r.StatisticsRange(nilKey, nilKey, Both, 2, nil); cached != stats {
+		t.Fatalf("expected statistics to be cached")
+	}
+	if other, _ := r.StatisticsRange(nilKey, nilKey, Both, 5, nil); other == stats {
+		t.Fatalf("expected statistics for different bins")
+	}
+
+	for i := 0; i < 2*maxCachedStatistics; i++ {
+		key, _ := NewKey([]byte(fmt.Sprintf(`["key-%03d"]`, i)))
+		r.StatisticsRange(key, key, Both, 0, nil)
+	}
+	if n := len(r.stats.stats); n > maxCachedStatistics {
+		t.Fatalf("expected at most %v cached statistics, got %v",
+			maxCachedStatistics, n)
+	}
+}
+
+func TestStatisticsAborted(t *testing.T) {
+	r := newStatisticsTestReader(t, 100, 1)
+	nilKey, _ := NewKeyFromEncodedBytes(nil)
+
+	stopch := make(StopChannel)
+	close(stopch)
+	if _, err := r.StatisticsRange(nilKey, nilKey, Both, 0, stopch); err != ErrStatisticsAborted {
+		t.Fatalf("expected %v, got %v", ErrStatisticsAborted, err)
+	}
+	if _, err := r.StatisticsRange(nilKey, nilKey, Both, 4, stopch); err != ErrStatisticsAborted {
+		t.Fatalf("expected %v, got %v", ErrStatisticsAborted, err)
+	}
+	// aborted statistics are not cached.
+	if len(r.stats.stats) != 0 {
+		t.Fatalf("expected no cached statistics")
+	}
+}
+
+// testRangeStatistics returns statistics of keys in [first, last), with
+// documents `docs` for every key.
+func testRangeStatistics(
+	t *testing.T, first, last int, docs []int, nbins int) *RangeStatistics {
+
+	tree := newLlrbTree()
+	for i := first; i < last; i++ {
+		for _, j := range docs {
+			entry := fmt.Sprintf(`["key-%03d","doc-%03d-%d"]`, i, i, j)
+			key, err := NewKey([]byte(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree.Set(key.Encoded(), nil)
+		}
+	}
+	nilKey, _ := NewKeyFromEncodedBytes(nil)
+	stats, err := newRangeReader(tree.Snapshot()).StatisticsRange(
+		nilKey, nilKey, Both, nbins, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+// verifyBins checks that bins add up to the merged statistics and equal
+// secondary keys do not span bins.
+func verifyBins(t *testing.T, stats *RangeStatistics, nbins int) {
+	if len(stats.Bins) == 0 || len(stats.Bins) > nbins {
+		t.Fatalf("expected upto %v bins, got %v", nbins, len(stats.Bins))
+	}
+	var count uint64
+	for i, bin := range stats.Bins {
+		count += bin.Count
+		if bin.Min.Compare(bin.Max) > 0 {
+			t.Errorf("bin %v min %v is after max %v", i, bin.Min, bin.Max)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := statsKey(stats.Bins[i-1].Max)
+		if bytes.Compare(prev, statsKey(bin.Min)) >= 0 {
+			t.Errorf("bin %v overlaps bin %v", i, i-1)
+		}
+	}
+	if count != stats.Count {
+		t.Errorf("expected bins to add up to %v, got %v", stats.Count, count)
+	}
+}
+
+func TestMergeStatistics(t *testing.T) {
+	// range partitions do not share secondary keys.
+	stats := mergeStatistics([]*RangeStatistics{
+		testRangeStatistics(t, 50, 100, []int{0, 1}, 10),
+		testRangeStatistics(t, 0, 50, []int{0, 1}, 10),
+	}, 10)
+	if stats.Count != 200 || stats.Unique != 100 || stats.Estimated {
+		t.Fatalf("unexpected count %v, unique %v, estimated %v",
+			stats.Count, stats.Unique, stats.Estimated)
+	}
+	verifyBins(t, stats, 10)
+	for i, bin := range stats.Bins {
+		if bin.Count != 20 || bin.Unique != 10 || bin.Estimated {
+			t.Errorf("unexpected bin %v, count %v, unique %v",
+				i, bin.Count, bin.Unique)
+		}
+	}
+
+	// hash partitions share secondary keys.
+	stats = mergeStatistics([]*RangeStatistics{
+		testRangeStatistics(t, 0, 100, []int{0}, 10),
+		testRangeStatistics(t, 0, 100, []int{1}, 10),
+	}, 10)
+	if stats.Count != 200 || stats.Unique != 100 || !stats.Estimated {
+		t.Fatalf("unexpected count %v, unique %v, estimated %v",
+			stats.Count, stats.Unique, stats.Estimated)
+	}
+	verifyBins(t, stats, 10)
+
+	// partially overlapping partitions.
+	stats = mergeStatistics([]*RangeStatistics{
+		testRangeStatistics(t, 0, 100, []int{0}, 10),
+		testRangeStatistics(t, 5, 105, []int{1, 2}, 10),
+		testRangeStatistics(t, 37, 40, []int{3}, 10),
+	}, 10)
+	if stats.Count != 303 || !stats.Estimated {
+		t.Fatalf("unexpected count %v, estimated %v", stats.Count, stats.Estimated)
+	} else if stats.Unique < 100 || stats.Unique > 203 {
+		t.Fatalf("unique %v out of bounds", stats.Unique)
+	}
+	verifyBins(t, stats, 10)
+
+	// statistics without histogram.
+	stats = mergeStatistics([]*RangeStatistics{
+		testRangeStatistics(t, 0, 10, []int{0}, 0),
+		nil,
+		testRangeStatistics(t, 10, 15, []int{0}, 0),
+	}, 0)
+	if stats.Count != 15 || stats.Unique != 15 || stats.Bins != nil {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+}
@@ -27,6 +27,10 @@ type IndexWriter interface {
 	//Persist a key/value pair
 	Insert(key Key, value Value) error
 
+	//Persist keys of an array index entry, replacing any existing keys of
+	//the docid
+	InsertArray(keys []Key, value Value) error
+
 	//Delete a key/value pair by docId
 	Delete(docid []byte) error
 
@@ -49,6 +49,8 @@ var (
 	ErrIndexerInRecovery        = errors.New("Indexer In Recovery")
 	ErrKVConnect                = errors.New("Error Connecting KV")
 	ErrUnknownBucket            = errors.New("Unknown Bucket")
+	ErrIndexRollbackFailed      = errors.New("Rollback Failed. Index Needs To Be Rebuilt")
+	ErrKeySizeExceeded          = errors.New("Secondary Key Too Large. Some Documents Are Not Indexed")
 )
 
 type indexer struct {
@@ -382,6 +384,14 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 		idx.tkCmdCh <- msg
 		<-idx.tkCmdCh
 
+	case STREAM_READER_QUEUE_OVERFLOW:
+		//fwd the message to timekeeper
+		idx.tkCmdCh <- msg
+		<-idx.tkCmdCh
+
+	case STREAM_READER_KEY_SIZE_ERROR:
+		idx.handleKeySizeError(msg)
+
 	case TK_STABILITY_TIMESTAMP:
 		//send TS to Mutation Manager
 		ts := msg.(*MsgTKStabilityTS).GetTimestamp()
@@ -1499,9 +1509,9 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 	//get all partitions for this index
 	partnDefnList := indexInst.Pc.GetAllPartitions()
 
+	//partition container has only the partitions hosted by
+	//this indexer node
 	for i, partnDefn := range partnDefnList {
-		//TODO: Ignore partitions which do not belong to this
-		//indexer node(based on the endpoints)
 		partnInst := PartitionInst{Defn: partnDefn,
 			Sc: NewHashedSliceContainer()}
 
@@ -1513,10 +1523,10 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 		if _, e := os.Stat(storage_dir); e != nil {
 			common.CrashOnError(e)
 		}
-		path := filepath.Join(storage_dir, IndexPath(&indexInst, SliceId(0)))
+		path := filepath.Join(storage_dir,
+			IndexPath(&indexInst, partnDefn.GetPartitionId(), SliceId(0)))
 		//add a single slice per partition for now
-		if slice, err := NewForestDBSlice(path,
-			0, indexInst.Defn.DefnId, indexInst.InstId, idx.config); err == nil {
+		if slice, err := NewSlice(path, 0, indexInst, idx.config); err == nil {
 			partnInst.Sc.AddSlice(0, slice)
 			common.Infof("Indexer::initPartnInstance Initialized Slice: \n\t Index: %v Slice: %v",
 				indexInst.InstId, slice)
@@ -2236,6 +2246,9 @@ func (idx *indexer) processRollback(streamId common.StreamId,
 
 	if res.GetMsgType() != MSG_ERROR {
 		rollbackTs := res.(*MsgRollback).GetRollbackTs()
+		if rebuildList := res.(*MsgRollback).GetRebuildList(); len(rebuildList) != 0 {
+			idx.resetIndexForRebuild(rebuildList)
+		}
 		return rollbackTs, nil
 	} else {
 		common.Fatalf("Indexer::processRollback Error during Rollback %v", res)
@@ -2245,6 +2258,56 @@ func (idx *indexer) processRollback(streamId common.StreamId,
 
 }
 
+//resetIndexForRebuild moves the indexes which failed to rollback out of
+//their stream and back to READY state with an error, so these can be built
+//again. Other indexes of the bucket continue from the rollback timestamp.
+func (idx *indexer) resetIndexForRebuild(instIdList []common.IndexInstId) {
+
+	common.Errorf("Indexer::resetIndexForRebuild \n\tRollback Failed For "+
+		"Index %v. Index needs to be rebuilt.", instIdList)
+
+	idx.bulkUpdateState(instIdList, common.INDEX_STATE_READY)
+	idx.bulkUpdateStream(instIdList, common.NIL_STREAM)
+	idx.bulkUpdateError(instIdList, ErrIndexRollbackFailed.Error())
+
+	msgUpdateIndexInstMap := &MsgUpdateInstMap{indexInstMap: idx.indexInstMap}
+	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+		common.CrashOnError(err)
+	}
+
+	if idx.enableManager {
+		if err := idx.updateMetaInfoForIndexList(instIdList, true, true, true); err != nil {
+			common.CrashOnError(err)
+		}
+	}
+}
+
+//handleKeySizeError records in the index error state that projector did
+//not index some documents, as their secondary keys are larger than the
+//maximum key size. Error is updated in metadata only once, till it is reset.
+func (idx *indexer) handleKeySizeError(msg Message) {
+
+	instId := msg.(*MsgKeySizeError).GetIndexInstId()
+	docid := msg.(*MsgKeySizeError).GetDocid()
+
+	common.Errorf("Indexer::handleKeySizeError \n\tKey Size Exceeded For "+
+		"Index %v Docid %s", instId, docid)
+
+	idxInst, ok := idx.indexInstMap[instId]
+	if !ok || idxInst.Error == ErrKeySizeExceeded.Error() {
+		return
+	}
+
+	instIdList := []common.IndexInstId{instId}
+	idx.bulkUpdateError(instIdList, ErrKeySizeExceeded.Error())
+
+	if idx.enableManager {
+		if err := idx.updateMetaInfoForIndexList(instIdList, false, false, true); err != nil {
+			common.CrashOnError(err)
+		}
+	}
+}
+
 //helper function to init streamFlush map for all streams
 func (idx *indexer) initStreamFlushMap() {
 
@@ -2432,13 +2495,22 @@ func (idx *indexer) initFromPersistedState() error {
 
 		newpc := common.NewKeyPartitionContainer()
 
-		//Add one partition for now
-		partnId := common.PartitionId(0)
 		addr := net.JoinHostPort("", idx.config["streamMaintPort"].String())
 		endpt := []common.Endpoint{common.Endpoint(addr)}
-		partnDefn := common.KeyPartitionDefn{Id: partnId,
-			Endpts: endpt}
-		newpc.AddPartition(partnId, partnDefn)
+		if inst.Defn.IsPartitioned() {
+			//partitions of the index hosted by this node
+			for _, partnId := range inst.Defn.Partitions {
+				partnDefn := common.KeyPartitionDefn{Id: partnId,
+					Endpts: endpt}
+				newpc.AddPartition(partnId, partnDefn)
+			}
+		} else {
+			//Add one partition for now
+			partnId := common.PartitionId(0)
+			partnDefn := common.KeyPartitionDefn{Id: partnId,
+				Endpts: endpt}
+			newpc.AddPartition(partnId, partnDefn)
+		}
 
 		inst.Pc = newpc
 
@@ -15,6 +15,8 @@ import (
 	"fmt"
 	"github.com/couchbase/indexing/secondary/collatejson"
 	"github.com/couchbase/indexing/secondary/common"
+	"strconv"
+	"sync"
 )
 
 // Key is an array of JSON objects, per encoding/json
@@ -37,7 +39,125 @@ type Valuedata struct {
 
 var KEY_SEPARATOR []byte = []byte{0xff, 0xff, 0xff, 0xff}
 
+// keyBufPool recycles buffers for encoding and decoding keys, encoded key
+// is copied out of the buffer.
+var keyBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, MAX_SEC_KEY_LEN)
+		return &buf
+	},
+}
+
+func getKeyBuf() *[]byte {
+	return keyBufPool.Get().(*[]byte)
+}
+
+// putKeyBuf returns buffer to the pool, unless it has grown large while
+// encoding an unusually large key.
+func putKeyBuf(bufp *[]byte, buf []byte) {
+	if cap(buf) <= 4*MAX_SEC_KEY_LEN {
+		*bufp = buf[:0]
+		keyBufPool.Put(bufp)
+	}
+}
+
+// keyCodecs pools codecs by sort order of key items and collation of the
+// index, codecs are not safe for concurrent use. There is a pool for every
+// distinct configuration of an index.
+var keyCodecs = struct {
+	sync.RWMutex
+	pools map[string]*sync.Pool
+}{pools: make(map[string]*sync.Pool)}
+
+func newKeyCodec(desc []bool, collation *common.Collation) (*collatejson.Codec, error) {
+	jsoncodec := collatejson.NewCodec(16)
+	jsoncodec.SortbyDescending(desc)
+	if err := collation.Apply(jsoncodec); err != nil {
+		return nil, err
+	}
+	return jsoncodec, nil
+}
+
+// keyCodecId appends to id the configuration of codecs for desc and
+// collation.
+func keyCodecId(id []byte, desc []bool, collation *common.Collation) []byte {
+	for _, d := range desc {
+		if d {
+			id = append(id, '1')
+		} else {
+			id = append(id, '0')
+		}
+	}
+	if collation != nil {
+		id = append(id, '/')
+		id = strconv.AppendBool(id, collation.CaseInsensitive)
+		id = append(id, '/')
+		id = strconv.AppendBool(id, collation.AccentInsensitive)
+		id = append(id, '/')
+		id = append(id, collation.Language...)
+	}
+	return id
+}
+
+// getKeyCodec returns a codec configured for desc and collation, along with
+// the pool it is to be put back to.
+func getKeyCodec(desc []bool,
+	collation *common.Collation) (*sync.Pool, *collatejson.Codec, error) {
+
+	var idbuf [64]byte
+	id := keyCodecId(idbuf[:0], desc, collation)
+
+	keyCodecs.RLock()
+	pool, ok := keyCodecs.pools[string(id)]
+	keyCodecs.RUnlock()
+	if ok {
+		return pool, pool.Get().(*collatejson.Codec), nil
+	}
+
+	jsoncodec, err := newKeyCodec(desc, collation)
+	if err != nil {
+		return nil, nil, err
+	}
+	// configuration is retained by the pool.
+	desc = append([]bool(nil), desc...)
+	if collation != nil {
+		c := *collation
+		collation = &c
+	}
+	pool = &sync.Pool{
+		New: func() interface{} {
+			jsoncodec, _ := newKeyCodec(desc, collation)
+			return jsoncodec
+		},
+	}
+
+	keyCodecs.Lock()
+	if p, ok := keyCodecs.pools[string(id)]; ok {
+		pool = p
+	} else {
+		keyCodecs.pools[string(id)] = pool
+	}
+	keyCodecs.Unlock()
+	return pool, jsoncodec, nil
+}
+
 func NewKey(data []byte) (Key, error) {
+	return NewKeyWithOrder(data, nil)
+}
+
+// NewKeyWithOrder returns a key whose items, at positions for which desc is
+// true, are encoded to sort in descending order.
+func NewKeyWithOrder(data []byte, desc []bool) (Key, error) {
+	return NewKeyWithCollation(data, desc, nil, false)
+}
+
+// NewKeyWithCollation is same as NewKeyWithOrder, except that strings are
+// encoded to sort as per collation, if it is not nil. Scan keys are only
+// compared with index entries, they are encoded without the original
+// strings so that they are a prefix of the entries they are equal to.
+func NewKeyWithCollation(data []byte, desc []bool,
+	collation *common.Collation, scanKey bool) (Key, error) {
+
 	var err error
 	var key Key
 
@@ -48,17 +168,52 @@ func NewKey(data []byte) (Key, error) {
 		return key, nil
 	}
 
-	// TODO: Refactor to reuse tmp buffer
-	jsoncodec := collatejson.NewCodec(16)
-	buf := make([]byte, 0, MAX_SEC_KEY_LEN)
-	if buf, err = jsoncodec.Encode(data, buf); err != nil {
+	pool, jsoncodec, err := getKeyCodec(desc, collation)
+	if err != nil {
+		return key, err
+	}
+	jsoncodec.EncodeOriginals(!scanKey)
+	bufp := getKeyBuf()
+	buf, err := jsoncodec.StreamEncode(data, *bufp)
+	pool.Put(jsoncodec)
+	if err != nil {
+		putKeyBuf(bufp, *bufp)
 		return key, err
 	}
 
 	key.encoded = append([]byte(nil), buf...)
+	putKeyBuf(bufp, buf)
 	return key, nil
 }
 
+// NewArrayKeys returns keys of an array index entry, data is a JSON array of
+// secondary keys, one for every element of the indexed array. See
+// NewKeyWithCollation for desc and collation.
+func NewArrayKeys(data []byte, desc []bool,
+	collation *common.Collation) ([]Key, error) {
+
+	if bytes.Compare([]byte("[]"), data) == 0 || len(data) == 0 {
+		return nil, nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(entries))
+	for _, entry := range entries {
+		key, err := NewKeyWithCollation(entry, desc, collation, false)
+		if err != nil {
+			return nil, err
+		}
+		if key.Encoded() != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
 func NewValue(docid []byte, vbucket Vbucket, seqno Seqno) (Value, error) {
 
 	var val Value
@@ -108,17 +263,21 @@ func (k *Key) Encoded() []byte {
 
 func (k *Key) Raw() []byte {
 
-	var err error
 	if k.raw == nil && k.encoded != nil {
-		jsoncodec := collatejson.NewCodec(16)
-		// TODO: Refactor to reuse tmp buffer
-		buf := make([]byte, 0, MAX_SEC_KEY_LEN)
-		if buf, err = jsoncodec.Decode(k.encoded, buf); err != nil {
+		// descending items and collated strings are decoded without
+		// configuring the codec.
+		pool, jsoncodec, _ := getKeyCodec(nil, nil)
+		bufp := getKeyBuf()
+		buf, err := jsoncodec.StreamDecode(k.encoded, *bufp)
+		pool.Put(jsoncodec)
+		if err != nil {
+			putKeyBuf(bufp, *bufp)
 			common.Errorf("KV::Raw Error Decoding Key %v, Err %v", k.encoded,
 				err)
 			return nil
 		}
 		k.raw = append([]byte(nil), buf...)
+		putKeyBuf(bufp, buf)
 	}
 
 	return k.raw
@@ -1044,8 +1044,31 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 				endpoints = append(endpoints, string(e))
 			}
 		}
-		protoInst.SinglePartn = &protobuf.SinglePartition{
-			Endpoints: endpoints,
+		defn := indexInst.Defn
+		if !defn.IsPartitioned() {
+			protoInst.SinglePartn = &protobuf.SinglePartition{
+				Endpoints: endpoints,
+			}
+			return
+		}
+
+		//For partitioned index, projector shall route mutations of
+		//the partitions hosted by this node to its endpoints
+		var partitions []uint32
+		for _, p := range partnDefn {
+			partitions = append(partitions, uint32(p.GetPartitionId()))
+		}
+		numPartitions := uint32(defn.NumPartitions())
+
+		switch defn.PartitionScheme {
+		case c.KEY, c.HASH:
+			protoInst.HashPartn = protobuf.NewHashPartition(
+				endpoints, numPartitions, partitions)
+		case c.RANGE:
+			bounds, err := EncodePartitionBounds(defn.PartitionBounds)
+			c.CrashOnError(err)
+			protoInst.RangePartn = protobuf.NewRangePartition(
+				endpoints, bounds, partitions)
 		}
 	}
 }
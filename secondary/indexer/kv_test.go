@@ -0,0 +1,45 @@
+package indexer
+
+import (
+	"bytes"
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func TestKeyRaw(t *testing.T) {
+	collation := &common.Collation{Language: "de", CaseInsensitive: true}
+	testcases := []struct {
+		desc      []bool
+		collation *common.Collation
+	}{
+		{nil, nil},
+		{[]bool{true, false}, nil},
+		{nil, collation},
+		{[]bool{false, true}, collation},
+	}
+	data := []byte(`["Äpfel",[10,-2.5,"x"],{"b":null,"a":true},"doc1"]`)
+	for _, tc := range testcases {
+		for i := 0; i < 2; i++ { // codec is re-used from the pool
+			key, err := NewKeyWithCollation(data, tc.desc, tc.collation, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, _ = NewKeyFromEncodedBytes(key.Encoded())
+			ref := `["Äpfel",[10,-2.5,"x"],{"a":true,"b":null},"doc1"]`
+			if raw := key.Raw(); !bytes.Equal(raw, []byte(ref)) {
+				t.Errorf("%v %v: expected %s, got %s", tc.desc, tc.collation, ref, raw)
+			}
+		}
+	}
+
+	// differently configured codecs are not shared.
+	k1, _ := NewKeyWithOrder([]byte(`[1,"doc1"]`), nil)
+	k2, _ := NewKeyWithOrder([]byte(`[1,"doc1"]`), []bool{true})
+	if bytes.Equal(k1.Encoded(), k2.Encoded()) {
+		t.Errorf("expected descending key to differ")
+	}
+	_, err := NewKeyWithCollation(data, nil, &common.Collation{Language: "!"}, false)
+	if err != common.ErrInvalidCollation {
+		t.Errorf("expected %v, got %v", common.ErrInvalidCollation, err)
+	}
+}
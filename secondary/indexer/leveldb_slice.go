@@ -0,0 +1,742 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Main index, back index and index meta share a single leveldb database,
+// each keyspace is identified by a prefix on the key.
+var (
+	leveldbMainPrefix = []byte("m")
+	leveldbBackPrefix = []byte("b")
+	leveldbMetaPrefix = []byte("s")
+)
+
+var (
+	ErrSnapshotNotRetained = errors.New("Snapshot not available for rollback")
+)
+
+//NewLeveldbSlice initializes a new slice with leveldb backend.
+//Main index, back index and snapshot meta are kept in a single database
+//under path, as separate keyspaces. Insert and Delete are executed
+//synchronously and can be called concurrently with other slice methods.
+//Committed snapshots are retained as leveldb snapshots, so that slice can
+//be rolled back to any of them. Only the latest committed snapshot is
+//recovered when slice is reopened.
+//Returns error in case slice cannot be initialized.
+func NewLeveldbSlice(path string, sliceId SliceId, idxDefnId common.IndexDefnId,
+	idxInstId common.IndexInstId, sysconf common.Config) (*leveldbSlice, error) {
+
+	opts := &opt.Options{}
+	if memQuota := sysconf["settings.memory_quota"].Uint64(); memQuota > 0 {
+		opts.BlockCacheCapacity = int(memQuota)
+	}
+
+	db, err := leveldb.OpenFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	slice := &leveldbSlice{
+		path:      path,
+		id:        sliceId,
+		db:        db,
+		idxDefnId: idxDefnId,
+		idxInstId: idxInstId,
+	}
+
+	if err = slice.recover(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	common.Debugf("LeveldbSlice:NewLeveldbSlice \n\t Created New Slice Id %v IndexInstId %v "+
+		"Count %v", sliceId, idxInstId, slice.count)
+
+	return slice, nil
+}
+
+//leveldbSlice represents a leveldb slice
+type leveldbSlice struct {
+	path string
+	id   SliceId //slice id
+
+	refCount int
+	lock     sync.RWMutex
+	db       *leveldb.DB
+
+	writeLock sync.Mutex             // serializes updates, snapshots and rollback
+	count     uint64                 // number of entries in main index
+	dataSize  int64                  // size of main and back index entries
+	snapshots *snapshotInfoContainer // committed snapshots retained for rollback
+	latest    *leveldbSnapshotRef    // latest snapshot, till the next one
+
+	idxDefnId common.IndexDefnId
+	idxInstId common.IndexInstId
+
+	status        SliceStatus
+	isActive      bool
+	isSoftDeleted bool
+	isSoftClosed  bool
+
+	fatalDbErr error //store any fatal DB error
+
+	// Statistics
+	get_bytes, insert_bytes, delete_bytes int64
+}
+
+//recover loads snapshot meta and computes the size of index. Data written
+//after the latest commit might have been persisted, it is overwritten when
+//mutations are replayed from the timestamp of the latest snapshot.
+func (ldb *leveldbSlice) recover() error {
+	iter := ldb.db.NewIterator(util.BytesPrefix(leveldbMainPrefix), nil)
+	for iter.Next() {
+		ldb.count++
+		ldb.dataSize += int64(len(iter.Key()) - len(leveldbMainPrefix) + len(iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	iter = ldb.db.NewIterator(util.BytesPrefix(leveldbBackPrefix), nil)
+	for iter.Next() {
+		ldb.dataSize += int64(len(iter.Key()) - len(leveldbBackPrefix) + len(iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	infos, err := ldb.getSnapshotsMeta()
+	if err != nil {
+		return err
+	}
+
+	ldb.snapshots = NewSnapshotInfoContainer(nil)
+	if len(infos) == 0 {
+		return nil
+	}
+
+	//older snapshots are not available after restart, retain the latest
+	latest := infos[0].(*leveldbSnapshotInfo)
+	snap, err := ldb.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	latest.snap = newLeveldbSnapshotRef(snap)
+	latest.Count = ldb.count
+	latest.DataSize = ldb.dataSize
+	ldb.snapshots.Add(latest)
+
+	return ldb.updateSnapshotsMeta(ldb.snapshots.List())
+}
+
+func (ldb *leveldbSlice) IncrRef() {
+	ldb.lock.Lock()
+	defer ldb.lock.Unlock()
+
+	ldb.refCount++
+}
+
+func (ldb *leveldbSlice) DecrRef() {
+	ldb.lock.Lock()
+	defer ldb.lock.Unlock()
+
+	ldb.refCount--
+	if ldb.refCount == 0 {
+		if ldb.isSoftClosed {
+			tryCloseLeveldbSlice(ldb)
+		}
+		if ldb.isSoftDeleted {
+			tryDeleteLeveldbSlice(ldb)
+		}
+	}
+}
+
+//Insert will insert the given key/value pair into slice. Main and back
+//index are updated in a single batch. If leveldb has encountered any
+//fatal error condition, it will be returned as error.
+func (ldb *leveldbSlice) Insert(k Key, v Value) error {
+	var keys []Key
+	if k.Encoded() != nil {
+		keys = []Key{k}
+	}
+	return ldb.InsertArray(keys, v)
+}
+
+//InsertArray will insert the keys of an array index entry into slice,
+//replacing existing keys of the docid. Only changed entries are written,
+//in a single batch.
+func (ldb *leveldbSlice) InsertArray(keys []Key, v Value) error {
+	ldb.writeLock.Lock()
+	defer ldb.writeLock.Unlock()
+
+	if ldb.fatalDbErr != nil {
+		return ldb.fatalDbErr
+	}
+
+	common.Tracef("LeveldbSlice::insert \n\tSliceId %v IndexInstId %v Set Keys - %v "+
+		"Value - %s", ldb.id, ldb.idxInstId, keys, v)
+
+	oldEntry, err := ldb.getBackIndexEntry(v.Docid())
+	if err == nil {
+		var oldkeys [][]byte
+		if oldkeys, err = decodeBackEntry(oldEntry); err == nil {
+			newkeys := encodedKeys(keys)
+			deleted, added := diffKeys(oldkeys, newkeys)
+
+			//false mutation, secondary keys of the docid have not changed
+			if len(deleted) == 0 && len(added) == 0 {
+				common.Tracef("LeveldbSlice::insert \n\tSliceId %v IndexInstId %v Unchanged Keys for "+
+					"Doc Id %v. Skipped.", ldb.id, ldb.idxInstId, v.Docid())
+				return nil
+			}
+			err = ldb.updateEntry(v.Docid(), v.Encoded(), oldEntry,
+				encodeBackEntry(newkeys), deleted, added)
+		}
+	}
+
+	if err != nil {
+		ldb.checkFatalDbError(err)
+		common.Errorf("LeveldbSlice::insert \n\tSliceId %v IndexInstId %v Error in Index Set. "+
+			"Skipped Keys %v. Value %s. Error %v", ldb.id, ldb.idxInstId, keys, v, err)
+	}
+	return err
+}
+
+//Delete will delete the given document from slice. If leveldb has
+//encountered any fatal error condition, it will be returned as error.
+func (ldb *leveldbSlice) Delete(docid []byte) error {
+	ldb.writeLock.Lock()
+	defer ldb.writeLock.Unlock()
+
+	if ldb.fatalDbErr != nil {
+		return ldb.fatalDbErr
+	}
+
+	common.Tracef("LeveldbSlice::delete \n\tSliceId %v IndexInstId %v. Delete Key - %s",
+		ldb.id, ldb.idxInstId, docid)
+
+	oldEntry, err := ldb.getBackIndexEntry(docid)
+	if err == nil {
+		var oldkeys [][]byte
+		//if there is no entry, nothing needs to be done. This is the case of
+		//deletes which happened before index was created.
+		if oldkeys, err = decodeBackEntry(oldEntry); err == nil && len(oldkeys) > 0 {
+			err = ldb.updateEntry(docid, nil, oldEntry, nil, oldkeys, nil)
+		}
+	}
+
+	if err != nil {
+		ldb.checkFatalDbError(err)
+		common.Errorf("LeveldbSlice::delete \n\tSliceId %v IndexInstId %v. Error deleting "+
+			"entry for Doc %s. Error %v", ldb.id, ldb.idxInstId, docid, err)
+	}
+	return err
+}
+
+//getBackIndexEntry returns an existing back index entry given the docid,
+//nil if there is no entry.
+func (ldb *leveldbSlice) getBackIndexEntry(docid []byte) ([]byte, error) {
+	entry, err := ldb.db.Get(leveldbKey(leveldbBackPrefix, docid), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	atomic.AddInt64(&ldb.get_bytes, int64(len(entry)))
+	return entry, err
+}
+
+//updateEntry replaces oldEntry of docid in back index with newEntry, removes
+//deleted keys from and adds added keys with value val to main index, in a
+//single batch. A nil newEntry removes the back index entry. Caller should
+//hold the writeLock.
+func (ldb *leveldbSlice) updateEntry(docid, val, oldEntry, newEntry []byte,
+	deleted, added [][]byte) error {
+
+	var count int
+	var delSize, insSize int64
+
+	batch := new(leveldb.Batch)
+	for _, oldkey := range deleted {
+		mainKey := leveldbKey(leveldbMainPrefix, oldkey)
+		oldval, err := ldb.db.Get(mainKey, nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return err
+		}
+		batch.Delete(mainKey)
+		count--
+		delSize += int64(len(oldkey) + len(oldval))
+	}
+
+	if oldEntry != nil {
+		delSize += int64(len(docid) + len(oldEntry))
+	}
+	if newEntry == nil {
+		batch.Delete(leveldbKey(leveldbBackPrefix, docid))
+	} else {
+		batch.Put(leveldbKey(leveldbBackPrefix, docid), newEntry)
+		insSize += int64(len(docid) + len(newEntry))
+	}
+
+	for _, key := range added {
+		batch.Put(leveldbKey(leveldbMainPrefix, key), val)
+		count++
+		insSize += int64(len(key) + len(val))
+	}
+
+	if err := ldb.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	ldb.count += uint64(count)
+	atomic.AddInt64(&ldb.dataSize, insSize-delSize)
+	atomic.AddInt64(&ldb.insert_bytes, insSize)
+	atomic.AddInt64(&ldb.delete_bytes, delSize)
+	return nil
+}
+
+//checkFatalDbError checks if the error returned from DB
+//is fatal and stores it. This error will be returned
+//to caller on next DB operation
+func (ldb *leveldbSlice) checkFatalDbError(err error) {
+	if lerrors.IsCorrupted(err) || err == leveldb.ErrClosed {
+		ldb.fatalDbErr = err
+	}
+}
+
+// Creates an open snapshot handle from snapshot info
+// Snapshot info is obtained from NewSnapshot() or GetSnapshots() API
+// Returns error if snapshot handle cannot be created.
+func (ldb *leveldbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	snapInfo := info.(*leveldbSnapshotInfo)
+	if snapInfo.snap == nil {
+		return nil, ErrSnapshotNotRetained
+	}
+
+	s := &leveldbSnapshot{slice: ldb,
+		rangeReader: newRangeReader(&leveldbMainIndex{
+			snap:  snapInfo.snap.snap,
+			count: snapInfo.Count,
+		}),
+		idxDefnId: ldb.idxDefnId,
+		idxInstId: ldb.idxInstId,
+		snap:      snapInfo.snap,
+		count:     snapInfo.Count,
+		ts:        snapInfo.Timestamp(),
+		committed: info.IsCommitted(),
+	}
+
+	common.Debugf("LeveldbSlice::OpenSnapshot \n\tSliceId %v IndexInstId %v Creating New "+
+		"Snapshot %v committed:%v", ldb.id, ldb.idxInstId, s, s.committed)
+	if err := s.Open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//Rollback slice to given snapshot. Return error if
+//not possible
+func (ldb *leveldbSlice) Rollback(info SnapshotInfo) error {
+	snapInfo := info.(*leveldbSnapshotInfo)
+	if snapInfo.snap == nil || !snapInfo.snap.acquire() {
+		return ErrSnapshotNotRetained
+	}
+	defer snapInfo.snap.release()
+
+	ldb.writeLock.Lock()
+	defer ldb.writeLock.Unlock()
+
+	sic := NewSnapshotInfoContainer(ldb.snapshots.List())
+	sic.RemoveRecentThanTS(info.Timestamp())
+
+	//revert every entry changed after the snapshot, along with the
+	//valid snapshot list, in a single batch
+	batch := new(leveldb.Batch)
+	for _, prefix := range [][]byte{leveldbMainPrefix, leveldbBackPrefix} {
+		if err := ldb.revertKeyspace(batch, snapInfo.snap.snap, prefix); err != nil {
+			common.Errorf("LeveldbSlice::Rollback \n\tSliceId %v IndexInstId %v. Error Rollback "+
+				"Index to Snapshot %v. Error %v", ldb.id, ldb.idxInstId, info, err)
+			return err
+		}
+	}
+
+	if err := ldb.putSnapshotsMeta(batch, sic.List()); err != nil {
+		return err
+	}
+
+	if err := ldb.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		ldb.checkFatalDbError(err)
+		common.Errorf("LeveldbSlice::Rollback \n\tSliceId %v IndexInstId %v. Error Rollback "+
+			"Index to Snapshot %v. Error %v", ldb.id, ldb.idxInstId, info, err)
+		return err
+	}
+
+	//latest snapshot is more recent than the rollback
+	ldb.setSnapshots(sic, nil)
+	ldb.count = snapInfo.Count
+	atomic.StoreInt64(&ldb.dataSize, snapInfo.DataSize)
+	return nil
+}
+
+//revertKeyspace adds the changes required to bring keyspace identified by
+//prefix back to its state in snap.
+func (ldb *leveldbSlice) revertKeyspace(batch *leveldb.Batch,
+	snap *leveldb.Snapshot, prefix []byte) error {
+
+	cur := ldb.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer cur.Release()
+	old := snap.NewIterator(util.BytesPrefix(prefix), nil)
+	defer old.Release()
+
+	curValid, oldValid := cur.Next(), old.Next()
+	for curValid || oldValid {
+		cmp := 0
+		if !oldValid {
+			cmp = -1
+		} else if !curValid {
+			cmp = 1
+		} else {
+			cmp = bytes.Compare(cur.Key(), old.Key())
+		}
+
+		switch {
+		case cmp < 0:
+			batch.Delete(cur.Key())
+			curValid = cur.Next()
+		case cmp > 0:
+			batch.Put(old.Key(), old.Value())
+			oldValid = old.Next()
+		default:
+			if !bytes.Equal(cur.Value(), old.Value()) {
+				batch.Put(old.Key(), old.Value())
+			}
+			curValid, oldValid = cur.Next(), old.Next()
+		}
+	}
+
+	if err := cur.Error(); err != nil {
+		return err
+	}
+	return old.Error()
+}
+
+//RollbackToZero rollbacks the slice to initial state. Return error if
+//not possible
+func (ldb *leveldbSlice) RollbackToZero() error {
+	ldb.writeLock.Lock()
+	defer ldb.writeLock.Unlock()
+
+	batch := new(leveldb.Batch)
+	iter := ldb.db.NewIterator(nil, nil)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	if err := ldb.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		ldb.checkFatalDbError(err)
+		common.Errorf("LeveldbSlice::Rollback \n\tSliceId %v IndexInstId %v. Error Rollback "+
+			"Index to Zero. Error %v", ldb.id, ldb.idxInstId, err)
+		return err
+	}
+
+	ldb.setSnapshots(NewSnapshotInfoContainer(nil), nil)
+	ldb.count = 0
+	atomic.StoreInt64(&ldb.dataSize, 0)
+	return nil
+}
+
+//NewSnapshot captures the current state of the slice. Committed snapshots
+//are retained for rollback and the snapshot list is synced to disk, along
+//with the outstanding writes.
+func (ldb *leveldbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo, error) {
+	ldb.writeLock.Lock()
+	defer ldb.writeLock.Unlock()
+
+	snap, err := ldb.db.GetSnapshot()
+	if err != nil {
+		ldb.checkFatalDbError(err)
+		return nil, err
+	}
+
+	//slice refers the new snapshot as latest, so that caller can open it
+	ref := newLeveldbSnapshotRef(snap)
+	newSnapshotInfo := &leveldbSnapshotInfo{
+		Ts:        ts,
+		Committed: commit,
+		Count:     ldb.count,
+		DataSize:  atomic.LoadInt64(&ldb.dataSize),
+		snap:      ref,
+	}
+
+	sic := ldb.snapshots
+	if commit {
+		sic = NewSnapshotInfoContainer(ldb.snapshots.List())
+		sic.Add(newSnapshotInfo)
+
+		if sic.Len() > MAX_SNAPSHOTS_PER_INDEX {
+			sic.RemoveOldest()
+		}
+
+		if err = ldb.updateSnapshotsMeta(sic.List()); err != nil {
+			common.Errorf("LeveldbSlice::Commit \n\tSliceId %v IndexInstId %v Error in "+
+				"Index Commit %v", ldb.id, ldb.idxInstId, err)
+			ref.release()
+			return nil, err
+		}
+		ref.acquire() //retained for rollback
+	}
+	ldb.setSnapshots(sic, ref)
+
+	return newSnapshotInfo, nil
+}
+
+//setSnapshots replaces the retained and latest snapshots of the slice,
+//releasing the snapshots no longer referred by the slice. References to
+//the new snapshots are passed by the caller.
+func (ldb *leveldbSlice) setSnapshots(sic *snapshotInfoContainer,
+	latest *leveldbSnapshotRef) {
+
+	retained := make(map[SnapshotInfo]bool)
+	for _, info := range sic.List() {
+		retained[info] = true
+	}
+	for _, info := range ldb.snapshots.List() {
+		if !retained[info] {
+			info.(*leveldbSnapshotInfo).snap.release()
+		}
+	}
+	if ldb.latest != nil {
+		ldb.latest.release()
+	}
+	ldb.snapshots, ldb.latest = sic, latest
+}
+
+func (ldb *leveldbSlice) Close() {
+	ldb.lock.Lock()
+	defer ldb.lock.Unlock()
+
+	common.Infof("LeveldbSlice::Close \n\tClosing Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v", ldb.id, ldb.idxInstId, ldb.idxDefnId)
+
+	if ldb.refCount > 0 {
+		ldb.isSoftClosed = true
+	} else {
+		tryCloseLeveldbSlice(ldb)
+	}
+}
+
+//Destroy removes the database from disk.
+//Slice is not recoverable after this.
+func (ldb *leveldbSlice) Destroy() {
+	ldb.lock.Lock()
+	defer ldb.lock.Unlock()
+
+	if ldb.refCount > 0 {
+		common.Infof("LeveldbSlice::Destroy \n\tSoftdeleted Slice Id %v, IndexInstId %v, "+
+			"IndexDefnId %v", ldb.id, ldb.idxInstId, ldb.idxDefnId)
+		ldb.isSoftDeleted = true
+	} else {
+		tryDeleteLeveldbSlice(ldb)
+	}
+}
+
+//Id returns the Id for this Slice
+func (ldb *leveldbSlice) Id() SliceId {
+	return ldb.id
+}
+
+// Path returns the database directory for this Slice
+func (ldb *leveldbSlice) Path() string {
+	return ldb.path
+}
+
+//IsActive returns if the slice is active
+func (ldb *leveldbSlice) IsActive() bool {
+	return ldb.isActive
+}
+
+//SetActive sets the active state of this slice
+func (ldb *leveldbSlice) SetActive(isActive bool) {
+	ldb.isActive = isActive
+}
+
+//Status returns the status for this slice
+func (ldb *leveldbSlice) Status() SliceStatus {
+	return ldb.status
+}
+
+//SetStatus set new status for this slice
+func (ldb *leveldbSlice) SetStatus(status SliceStatus) {
+	ldb.status = status
+}
+
+//IndexInstId returns the Index InstanceId this
+//slice is associated with
+func (ldb *leveldbSlice) IndexInstId() common.IndexInstId {
+	return ldb.idxInstId
+}
+
+//IndexDefnId returns the Index DefnId this slice
+//is associated with
+func (ldb *leveldbSlice) IndexDefnId() common.IndexDefnId {
+	return ldb.idxDefnId
+}
+
+// Returns snapshot info list
+func (ldb *leveldbSlice) GetSnapshots() ([]SnapshotInfo, error) {
+	ldb.writeLock.Lock()
+	defer ldb.writeLock.Unlock()
+
+	return ldb.snapshots.List(), nil
+}
+
+//Compact compacts the whole database, discarding overwritten and
+//deleted entries not referred by any retained snapshot.
+func (ldb *leveldbSlice) Compact() error {
+	ldb.IncrRef()
+	defer ldb.DecrRef()
+
+	return ldb.db.CompactRange(util.Range{})
+}
+
+//Statistics returns size of index entries as data size and size of the
+//database directory as disk size, compaction manager compacts the slice
+//once the difference crosses the fragmentation threshold.
+func (ldb *leveldbSlice) Statistics() (StorageStatistics, error) {
+	var sts StorageStatistics
+
+	err := filepath.Walk(ldb.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			sts.DiskSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return sts, err
+	}
+
+	sts.DataSize = atomic.LoadInt64(&ldb.dataSize)
+	sts.GetBytes = atomic.LoadInt64(&ldb.get_bytes)
+	sts.InsertBytes = atomic.LoadInt64(&ldb.insert_bytes)
+	sts.DeleteBytes = atomic.LoadInt64(&ldb.delete_bytes)
+
+	return sts, nil
+}
+
+func (ldb *leveldbSlice) String() string {
+
+	str := fmt.Sprintf("SliceId: %v ", ldb.id)
+	str += fmt.Sprintf("File: %v ", ldb.path)
+	str += fmt.Sprintf("Index: %v ", ldb.idxInstId)
+
+	return str
+
+}
+
+//updateSnapshotsMeta persists the snapshot list, syncing all the
+//outstanding writes to disk.
+func (ldb *leveldbSlice) updateSnapshotsMeta(infos []SnapshotInfo) error {
+	batch := new(leveldb.Batch)
+	if err := ldb.putSnapshotsMeta(batch, infos); err != nil {
+		return err
+	}
+
+	if err := ldb.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		ldb.checkFatalDbError(err)
+		return errors.New("Failed to update snapshots list -" + err.Error())
+	}
+	return nil
+}
+
+func (ldb *leveldbSlice) putSnapshotsMeta(batch *leveldb.Batch, infos []SnapshotInfo) error {
+	val, err := json.Marshal(infos)
+	if err != nil {
+		return errors.New("Failed to update snapshots list -" + err.Error())
+	}
+
+	batch.Put(leveldbKey(leveldbMetaPrefix, snapshotMetaListKey), val)
+	return nil
+}
+
+func (ldb *leveldbSlice) getSnapshotsMeta() ([]SnapshotInfo, error) {
+	var tmp []*leveldbSnapshotInfo
+	var snapList []SnapshotInfo
+
+	data, err := ldb.db.Get(leveldbKey(leveldbMetaPrefix, snapshotMetaListKey), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return []SnapshotInfo(nil), nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &tmp); err != nil {
+		return snapList, errors.New("Failed to retrieve snapshots list -" + err.Error())
+	}
+
+	for i := range tmp {
+		snapList = append(snapList, tmp[i])
+	}
+
+	return snapList, nil
+}
+
+func tryDeleteLeveldbSlice(ldb *leveldbSlice) {
+	common.Infof("LeveldbSlice::Destroy \n\tDestroying Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v", ldb.id, ldb.idxInstId, ldb.idxDefnId)
+
+	tryCloseLeveldbSlice(ldb)
+
+	//cleanup the disk directory
+	if err := os.RemoveAll(ldb.path); err != nil {
+		common.Errorf("LeveldbSlice::Destroy \n\t Error Cleaning Up Slice Id %v, "+
+			"IndexInstId %v, IndexDefnId %v. Error %v", ldb.id, ldb.idxInstId, ldb.idxDefnId, err)
+	}
+}
+
+func tryCloseLeveldbSlice(ldb *leveldbSlice) {
+	ldb.writeLock.Lock()
+	ldb.setSnapshots(NewSnapshotInfoContainer(nil), nil)
+	ldb.writeLock.Unlock()
+
+	if err := ldb.db.Close(); err != nil && err != leveldb.ErrClosed {
+		common.Errorf("LeveldbSlice::Close \n\t Error Closing Slice Id %v, "+
+			"IndexInstId %v, IndexDefnId %v. Error %v", ldb.id, ldb.idxInstId, ldb.idxDefnId, err)
+	}
+}
+
+//leveldbKey returns key prefixed with the keyspace identifier
+func leveldbKey(prefix, key []byte) []byte {
+	k := make([]byte, 0, len(prefix)+len(key))
+	k = append(k, prefix...)
+	return append(k, key...)
+}
@@ -0,0 +1,315 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func leveldbTestInsert(t *testing.T, slice Slice, secKey int, docid string) {
+	b, _ := json.Marshal([]interface{}{secKey, docid})
+	k, err := NewKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValue([]byte(docid), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := slice.Insert(k, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func leveldbTestCommit(t *testing.T, slice Slice, seqno uint64) SnapshotInfo {
+	ts := common.NewTsVbuuid("default", 1)
+	ts.Seqnos[0] = seqno
+	ts.Snapshots[0] = [2]uint64{seqno, seqno}
+	info, err := slice.NewSnapshot(ts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// scan secondary keys in range [low, high] of latest snapshot
+func leveldbTestScan(t *testing.T, slice Slice, low, high int) []string {
+	infos, err := slice.GetSnapshots()
+	if err != nil || len(infos) == 0 {
+		t.Fatalf("No snapshots available (%v)", err)
+	}
+	snap, err := slice.OpenSnapshot(infos[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	lowKey, _ := NewKey([]byte(fmt.Sprintf("[%d]", low)))
+	highKey, _ := NewKey([]byte(fmt.Sprintf("[%d]", high)))
+	chkey, _, _ := snap.KeyRange(lowKey, highKey, Both, make(StopChannel))
+
+	var keys []string
+	for k := range chkey {
+		var entry []interface{}
+		json.Unmarshal(k.Raw(), &entry)
+		keys = append(keys, fmt.Sprint(entry...))
+	}
+	return keys
+}
+
+func TestLeveldbSlice(t *testing.T) {
+	path, err := ioutil.TempDir("", "leveldb_slice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	slice, err := NewLeveldbSlice(path, 0, 1, 1, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		leveldbTestInsert(t, slice, i, fmt.Sprintf("doc%d", i))
+	}
+	info := leveldbTestCommit(t, slice, 10)
+
+	// update, delete and insert after the snapshot
+	leveldbTestInsert(t, slice, 20, "doc2")
+	slice.Delete([]byte("doc3"))
+	leveldbTestInsert(t, slice, 4, "doc10")
+	leveldbTestCommit(t, slice, 20)
+
+	keys := leveldbTestScan(t, slice, 2, 5)
+	if fmt.Sprint(keys) != "[4doc10 4doc4 5doc5]" {
+		t.Errorf("Unexpected scan result %v", keys)
+	}
+
+	if err := slice.Rollback(info); err != nil {
+		t.Fatal(err)
+	}
+	keys = leveldbTestScan(t, slice, 2, 5)
+	if fmt.Sprint(keys) != "[2doc2 3doc3 4doc4 5doc5]" {
+		t.Errorf("Unexpected scan result after rollback %v", keys)
+	}
+	if infos, _ := slice.GetSnapshots(); len(infos) != 1 {
+		t.Errorf("Expected 1 snapshot after rollback, got %v", len(infos))
+	}
+
+	// reopen the slice
+	slice.Close()
+	slice, err = NewLeveldbSlice(path, 0, 1, 1, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	infos, _ := slice.GetSnapshots()
+	if len(infos) != 1 || infos[0].Timestamp().Seqnos[0] != 10 {
+		t.Fatalf("Unexpected snapshots after reopen %v", infos)
+	}
+	snap, err := slice.OpenSnapshot(infos[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	if count, _ := snap.CountTotal(nil); count != 10 {
+		t.Errorf("Expected 10 entries after reopen, got %v", count)
+	}
+}
+
+func TestLeveldbSliceReleaseSnapshots(t *testing.T) {
+	path, err := ioutil.TempDir("", "leveldb_slice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	slice, err := NewLeveldbSlice(path, 0, 1, 1, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := func(info SnapshotInfo) bool {
+		return atomic.LoadInt32(&info.(*leveldbSnapshotInfo).snap.refs) == 0
+	}
+
+	// in-memory snapshot is released once superseded and closed.
+	leveldbTestInsert(t, slice, 1, "doc1")
+	ts := common.NewTsVbuuid("default", 1)
+	mem1, _ := slice.NewSnapshot(ts, false)
+	mem2, _ := slice.NewSnapshot(ts, false)
+	snap, err := slice.OpenSnapshot(mem2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem3, _ := slice.NewSnapshot(ts, false)
+	if !released(mem1) || released(mem2) || released(mem3) {
+		t.Fatalf("expected only first snapshot to be released")
+	}
+	snap.Close()
+	if !released(mem2) {
+		t.Fatalf("expected closed snapshot to be released")
+	}
+	if _, err := slice.OpenSnapshot(mem1); err != ErrSnapshotNotRetained {
+		t.Fatalf("expected %v, got %v", ErrSnapshotNotRetained, err)
+	}
+
+	// committed snapshots are released once no longer retained.
+	var infos []SnapshotInfo
+	for i := 0; i <= MAX_SNAPSHOTS_PER_INDEX; i++ {
+		leveldbTestInsert(t, slice, i, fmt.Sprintf("doc%d", i))
+		infos = append(infos, leveldbTestCommit(t, slice, uint64(10*(i+1))))
+	}
+	if !released(mem3) || !released(infos[0]) {
+		t.Fatalf("expected superseded and oldest snapshots to be released")
+	}
+	if err := slice.Rollback(infos[2]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(infos); i++ {
+		if released(infos[i]) != (i > 2) {
+			t.Errorf("snapshot %v: expected released %v after rollback", i, i > 2)
+		}
+	}
+
+	slice.Close()
+	for i, info := range infos {
+		if !released(info) {
+			t.Errorf("snapshot %v: expected released on close", i)
+		}
+	}
+}
+
+func TestLeveldbSliceFalseMutation(t *testing.T) {
+	path, err := ioutil.TempDir("", "leveldb_slice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	slice, err := NewLeveldbSlice(path, 0, 1, 1, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	leveldbTestInsert(t, slice, 1, "doc1")
+	sts, _ := slice.Statistics()
+	leveldbTestInsert(t, slice, 1, "doc1")
+	if after, _ := slice.Statistics(); after.InsertBytes != sts.InsertBytes ||
+		after.DeleteBytes != sts.DeleteBytes {
+		t.Errorf("Unchanged key rewritten to slice")
+	}
+
+	leveldbTestInsert(t, slice, 2, "doc1")
+	leveldbTestCommit(t, slice, 1)
+	if keys := leveldbTestScan(t, slice, 0, 5); fmt.Sprint(keys) != "[2doc1]" {
+		t.Errorf("Unexpected scan result %v", keys)
+	}
+}
+
+func leveldbTestInsertArray(t *testing.T, slice Slice, secKeys []int, docid string) {
+	entries := make([][]interface{}, 0, len(secKeys))
+	for _, secKey := range secKeys {
+		entries = append(entries, []interface{}{secKey, docid})
+	}
+	b, _ := json.Marshal(entries)
+	keys, err := NewArrayKeys(b, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValue([]byte(docid), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := slice.InsertArray(keys, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeveldbSliceArray(t *testing.T) {
+	path, err := ioutil.TempDir("", "leveldb_slice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	slice, err := NewLeveldbSlice(path, 0, 1, 1, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	leveldbTestInsertArray(t, slice, []int{1, 2, 3}, "doc1")
+	leveldbTestInsertArray(t, slice, []int{2}, "doc2")
+	leveldbTestCommit(t, slice, 1)
+	keys := leveldbTestScan(t, slice, 0, 5)
+	if fmt.Sprint(keys) != "[1doc1 2doc1 2doc2 3doc1]" {
+		t.Errorf("Unexpected scan result %v", keys)
+	}
+
+	// update overlapping set of elements
+	leveldbTestInsertArray(t, slice, []int{3, 4}, "doc1")
+	leveldbTestCommit(t, slice, 2)
+	keys = leveldbTestScan(t, slice, 0, 5)
+	if fmt.Sprint(keys) != "[2doc2 3doc1 4doc1]" {
+		t.Errorf("Unexpected scan result after update %v", keys)
+	}
+
+	slice.Delete([]byte("doc1"))
+	leveldbTestInsertArray(t, slice, nil, "doc2")
+	leveldbTestCommit(t, slice, 3)
+	if keys = leveldbTestScan(t, slice, 0, 5); len(keys) != 0 {
+		t.Errorf("Unexpected scan result after delete %v", keys)
+	}
+	if sts, _ := slice.Statistics(); sts.DataSize != 0 {
+		t.Errorf("Expected no data after delete, got %v", sts.DataSize)
+	}
+}
+
+func TestLeveldbSliceReverse(t *testing.T) {
+	path, err := ioutil.TempDir("", "leveldb_slice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	slice, err := NewLeveldbSlice(path, 0, 1, 1, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	for i := 0; i < 10; i++ {
+		leveldbTestInsert(t, slice, i, fmt.Sprintf("doc%d", i))
+	}
+	info := leveldbTestCommit(t, slice, 10)
+	snap, err := slice.OpenSnapshot(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	lowKey, _ := NewKey([]byte("[2]"))
+	highKey, _ := NewKey([]byte("[5]"))
+	chkey, _, _ := snap.ReverseKeyRange(lowKey, highKey, Low, make(StopChannel))
+
+	var keys []string
+	for k := range chkey {
+		var entry []interface{}
+		json.Unmarshal(k.Raw(), &entry)
+		keys = append(keys, fmt.Sprint(entry...))
+	}
+	if fmt.Sprint(keys) != "[4doc4 3doc3 2doc2]" {
+		t.Errorf("Unexpected reverse scan result %v", keys)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync"
+	"sync/atomic"
+)
+
+type leveldbSnapshotInfo struct {
+	Ts        *common.TsVbuuid
+	Committed bool
+	Count     uint64 // number of entries in main index
+	DataSize  int64
+
+	// leveldb snapshot is not persisted.
+	snap *leveldbSnapshotRef
+}
+
+// leveldbSnapshotRef counts references to a leveldb snapshot, held by the
+// slice for retained and latest snapshots, and by open snapshot handles.
+// Snapshot pins older versions of entries from compaction, hence it is
+// released as soon as it is no longer referred.
+type leveldbSnapshotRef struct {
+	snap *leveldb.Snapshot
+	refs int32
+}
+
+// newLeveldbSnapshotRef returns a reference to `snap`, held by the caller.
+func newLeveldbSnapshotRef(snap *leveldb.Snapshot) *leveldbSnapshotRef {
+	return &leveldbSnapshotRef{snap: snap, refs: 1}
+}
+
+// acquire a reference, returns false if snapshot is already released.
+func (r *leveldbSnapshotRef) acquire() bool {
+	for {
+		refs := atomic.LoadInt32(&r.refs)
+		if refs <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&r.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release a reference, snapshot is released along with the last one.
+func (r *leveldbSnapshotRef) release() {
+	if atomic.AddInt32(&r.refs, -1) == 0 {
+		r.snap.Release()
+	}
+}
+
+func (info *leveldbSnapshotInfo) Timestamp() *common.TsVbuuid {
+	return info.Ts
+}
+
+func (info *leveldbSnapshotInfo) IsCommitted() bool {
+	return info.Committed
+}
+
+func (info *leveldbSnapshotInfo) String() string {
+	return fmt.Sprintf("SnapshotInfo: count: %v, committed: %v",
+		info.Count, info.Committed)
+}
+
+// leveldbMainIndex is the forward index of a leveldb snapshot.
+type leveldbMainIndex struct {
+	snap  *leveldb.Snapshot
+	count uint64
+}
+
+// Iterate implements kvIterable interface.
+func (m *leveldbMainIndex) Iterate(low []byte, fn func(key, val []byte) bool) error {
+	r := util.BytesPrefix(leveldbMainPrefix)
+	if low != nil {
+		r.Start = leveldbKey(leveldbMainPrefix, low)
+	}
+
+	iter := m.snap.NewIterator(r, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		// iterator reuses its buffers
+		key := append([]byte(nil), iter.Key()[len(leveldbMainPrefix):]...)
+		val := append([]byte(nil), iter.Value()...)
+		if !fn(key, val) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// IterateReverse implements kvIterable interface.
+func (m *leveldbMainIndex) IterateReverse(high []byte, fn func(key, val []byte) bool) error {
+	r := util.BytesPrefix(leveldbMainPrefix)
+	if high != nil {
+		r.Limit = leveldbKey(leveldbMainPrefix, high)
+	}
+
+	iter := m.snap.NewIterator(r, nil)
+	defer iter.Release()
+
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		// iterator reuses its buffers
+		key := append([]byte(nil), iter.Key()[len(leveldbMainPrefix):]...)
+		val := append([]byte(nil), iter.Value()...)
+		if !fn(key, val) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// Len implements kvIterable interface.
+func (m *leveldbMainIndex) Len() (uint64, error) {
+	return m.count, nil
+}
+
+type leveldbSnapshot struct {
+	rangeReader
+
+	slice Slice
+	snap  *leveldbSnapshotRef // released when snapshot is closed
+	count uint64
+
+	idxDefnId common.IndexDefnId //index definition id
+	idxInstId common.IndexInstId //index instance id
+	ts        *common.TsVbuuid   //timestamp
+	committed bool
+
+	refCount int          //Reader count for this snapshot
+	lock     sync.RWMutex //lock to atomically increment the refCount
+}
+
+func (s *leveldbSnapshot) Open() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.refCount == 0 {
+		if !s.snap.acquire() {
+			return ErrSnapshotNotRetained
+		}
+		s.slice.IncrRef()
+	}
+	s.refCount++
+	return nil
+}
+
+func (s *leveldbSnapshot) IsOpen() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.refCount > 0
+}
+
+func (s *leveldbSnapshot) Id() SliceId {
+	return s.slice.Id()
+}
+
+func (s *leveldbSnapshot) IndexInstId() common.IndexInstId {
+	return s.idxInstId
+}
+
+func (s *leveldbSnapshot) IndexDefnId() common.IndexDefnId {
+	return s.idxDefnId
+}
+
+func (s *leveldbSnapshot) Timestamp() *common.TsVbuuid {
+	return s.ts
+}
+
+//Close the snapshot
+func (s *leveldbSnapshot) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.refCount <= 0 {
+		common.Errorf("LeveldbSnapshot::Close Close operation requested " +
+			"on already closed snapshot")
+		return errors.New("Snapshot Already Closed")
+	}
+
+	s.refCount--
+	if s.refCount == 0 {
+		s.snap.release()
+		s.slice.DecrRef()
+	}
+	return nil
+}
+
+func (s *leveldbSnapshot) String() string {
+
+	str := fmt.Sprintf("Index: %v ", s.idxInstId)
+	str += fmt.Sprintf("SliceId: %v ", s.slice.Id())
+	str += fmt.Sprintf("Count: %v ", s.count)
+	str += fmt.Sprintf("TS: %v ", s.ts)
+	return str
+}
+
+func (s *leveldbSnapshot) Info() SnapshotInfo {
+	return &leveldbSnapshotInfo{
+		Ts:        s.ts,
+		Committed: s.committed,
+		Count:     s.count,
+		snap:      s.snap,
+	}
+}
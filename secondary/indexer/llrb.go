@@ -0,0 +1,330 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+)
+
+// llrbTree is a left-leaning red-black tree, as described by Robert
+// Sedgewick, ordering byte keys lexicographically.
+//
+// Tree supports copy-on-write snapshots. Every node is tagged with the
+// generation of the tree in which it was created, and a node is modified
+// in place only if it belongs to the current generation, otherwise it is
+// cloned. Snapshot() returns the current root and moves the tree to the next
+// generation, hence nodes reachable from a snapshot are never modified and
+// snapshots can be read concurrently with the writer without any locking.
+//
+// Writer methods (Set, Delete, Snapshot) are not thread-safe.
+type llrbTree struct {
+	root  *llrbNode
+	count uint64
+	bytes uint64 // size of keys and values held by the tree
+	gen   uint64
+}
+
+type llrbNode struct {
+	key, val    []byte
+	left, right *llrbNode
+	black       bool   // color of the link from parent
+	gen         uint64 // tree generation that created this node
+}
+
+// llrbRoot is an immutable version of the tree.
+type llrbRoot struct {
+	root  *llrbNode
+	count uint64
+	bytes uint64
+}
+
+func newLlrbTree() *llrbTree {
+	return &llrbTree{gen: 1}
+}
+
+// Get returns value for the key, nil if key is not present.
+func (t *llrbTree) Get(key []byte) []byte {
+	return t.current().Get(key)
+}
+
+// Set key to value, replacing the old value if key is already present.
+func (t *llrbTree) Set(key, val []byte) {
+	var old []byte
+	t.root, old = t.insert(t.root, key, val)
+	t.root.black = true
+	if old == nil {
+		t.count++
+		t.bytes += uint64(len(key) + len(val))
+	} else {
+		t.bytes += uint64(len(val)) - uint64(len(old))
+	}
+}
+
+// Delete key from the tree and return its value, nil if key is not present.
+func (t *llrbTree) Delete(key []byte) []byte {
+	val := t.Get(key)
+	if val == nil {
+		return nil
+	}
+
+	t.root = t.own(t.root)
+	if !isRed(t.root.left) && !isRed(t.root.right) {
+		t.root.black = false
+	}
+	t.root = t.delete(t.root, key)
+	if t.root != nil {
+		t.root.black = true
+	}
+	t.count--
+	t.bytes -= uint64(len(key) + len(val))
+	return val
+}
+
+// Snapshot returns an immutable version of the tree, further changes to the
+// tree are not visible through the returned version.
+func (t *llrbTree) Snapshot() *llrbRoot {
+	r := t.current()
+	t.gen++
+	return r
+}
+
+// current version of the tree, valid only till the next change.
+func (t *llrbTree) current() *llrbRoot {
+	return &llrbRoot{root: t.root, count: t.count, bytes: t.bytes}
+}
+
+// Reset tree to an older version obtained from Snapshot().
+func (t *llrbTree) Reset(r *llrbRoot) {
+	if r == nil {
+		r = &llrbRoot{}
+	}
+	t.root, t.count, t.bytes = r.root, r.count, r.bytes
+	// nodes shared with r must not be modified anymore
+	t.gen++
+}
+
+// Get returns value for the key, nil if key is not present.
+func (r *llrbRoot) Get(key []byte) []byte {
+	h := r.root
+	for h != nil {
+		switch cmp := bytes.Compare(key, h.key); {
+		case cmp < 0:
+			h = h.left
+		case cmp > 0:
+			h = h.right
+		default:
+			return h.val
+		}
+	}
+	return nil
+}
+
+// Count returns number of keys.
+func (r *llrbRoot) Count() uint64 {
+	return r.count
+}
+
+// Range calls fn for every key >= low, in sort order, till fn returns false.
+// A nil low starts from the first key.
+func (r *llrbRoot) Range(low []byte, fn func(key, val []byte) bool) {
+	r.root.rangeFrom(low, fn)
+}
+
+// RangeReverse calls fn for every key < high, in reverse sort order, till fn
+// returns false. A nil high starts from the last key.
+func (r *llrbRoot) RangeReverse(high []byte, fn func(key, val []byte) bool) {
+	r.root.rangeBefore(high, fn)
+}
+
+// Iterate implements kvIterable interface.
+func (r *llrbRoot) Iterate(low []byte, fn func(key, val []byte) bool) error {
+	r.Range(low, fn)
+	return nil
+}
+
+// IterateReverse implements kvIterable interface.
+func (r *llrbRoot) IterateReverse(high []byte, fn func(key, val []byte) bool) error {
+	r.RangeReverse(high, fn)
+	return nil
+}
+
+// Len implements kvIterable interface.
+func (r *llrbRoot) Len() (uint64, error) {
+	return r.count, nil
+}
+
+func (h *llrbNode) rangeFrom(low []byte, fn func(key, val []byte) bool) bool {
+	if h == nil {
+		return true
+	}
+	if low == nil || bytes.Compare(h.key, low) >= 0 {
+		if !h.left.rangeFrom(low, fn) {
+			return false
+		}
+		if !fn(h.key, h.val) {
+			return false
+		}
+	}
+	return h.right.rangeFrom(low, fn)
+}
+
+func (h *llrbNode) rangeBefore(high []byte, fn func(key, val []byte) bool) bool {
+	if h == nil {
+		return true
+	}
+	if high == nil || bytes.Compare(h.key, high) < 0 {
+		if !h.right.rangeBefore(high, fn) {
+			return false
+		}
+		if !fn(h.key, h.val) {
+			return false
+		}
+	}
+	return h.left.rangeBefore(high, fn)
+}
+
+// own returns a node that can be modified in the current generation.
+func (t *llrbTree) own(h *llrbNode) *llrbNode {
+	if h == nil || h.gen == t.gen {
+		return h
+	}
+	n := *h
+	n.gen = t.gen
+	return &n
+}
+
+// insert key into sub-tree h, returns the new sub-tree and the old value
+// of the key, if present.
+func (t *llrbTree) insert(h *llrbNode, key, val []byte) (*llrbNode, []byte) {
+	if h == nil {
+		return &llrbNode{key: key, val: val, gen: t.gen}, nil
+	}
+
+	var old []byte
+	h = t.own(h)
+	switch cmp := bytes.Compare(key, h.key); {
+	case cmp < 0:
+		h.left, old = t.insert(h.left, key, val)
+	case cmp > 0:
+		h.right, old = t.insert(h.right, key, val)
+	default:
+		old, h.val = h.val, val
+	}
+	return t.balance(h), old
+}
+
+// delete key, which is known to be present, from sub-tree h.
+func (t *llrbTree) delete(h *llrbNode, key []byte) *llrbNode {
+	h = t.own(h)
+	if bytes.Compare(key, h.key) < 0 {
+		if !isRed(h.left) && !isRed(h.left.left) {
+			h = t.moveRedLeft(h)
+		}
+		h.left = t.delete(h.left, key)
+
+	} else {
+		if isRed(h.left) {
+			h = t.rotateRight(h)
+		}
+		if bytes.Equal(key, h.key) && h.right == nil {
+			return nil
+		}
+		if !isRed(h.right) && !isRed(h.right.left) {
+			h = t.moveRedRight(h)
+		}
+		if bytes.Equal(key, h.key) {
+			min := h.right
+			for min.left != nil {
+				min = min.left
+			}
+			h.key, h.val = min.key, min.val
+			h.right = t.deleteMin(h.right)
+		} else {
+			h.right = t.delete(h.right, key)
+		}
+	}
+	return t.balance(h)
+}
+
+func (t *llrbTree) deleteMin(h *llrbNode) *llrbNode {
+	h = t.own(h)
+	if h.left == nil {
+		return nil
+	}
+	if !isRed(h.left) && !isRed(h.left.left) {
+		h = t.moveRedLeft(h)
+	}
+	h.left = t.deleteMin(h.left)
+	return t.balance(h)
+}
+
+// Following methods expect h to be owned by the current generation.
+
+func (t *llrbTree) rotateLeft(h *llrbNode) *llrbNode {
+	x := t.own(h.right)
+	h.right = x.left
+	x.left = h
+	x.black = h.black
+	h.black = false
+	return x
+}
+
+func (t *llrbTree) rotateRight(h *llrbNode) *llrbNode {
+	x := t.own(h.left)
+	h.left = x.right
+	x.right = h
+	x.black = h.black
+	h.black = false
+	return x
+}
+
+func (t *llrbTree) flipColors(h *llrbNode) {
+	h.black = !h.black
+	h.left = t.own(h.left)
+	h.left.black = !h.left.black
+	h.right = t.own(h.right)
+	h.right.black = !h.right.black
+}
+
+func (t *llrbTree) moveRedLeft(h *llrbNode) *llrbNode {
+	t.flipColors(h)
+	if isRed(h.right.left) {
+		h.right = t.rotateRight(h.right)
+		h = t.rotateLeft(h)
+		t.flipColors(h)
+	}
+	return h
+}
+
+func (t *llrbTree) moveRedRight(h *llrbNode) *llrbNode {
+	t.flipColors(h)
+	if isRed(h.left.left) {
+		h = t.rotateRight(h)
+		t.flipColors(h)
+	}
+	return h
+}
+
+func (t *llrbTree) balance(h *llrbNode) *llrbNode {
+	if isRed(h.right) && !isRed(h.left) {
+		h = t.rotateLeft(h)
+	}
+	if isRed(h.left) && isRed(h.left.left) {
+		h = t.rotateRight(h)
+	}
+	if isRed(h.left) && isRed(h.right) {
+		t.flipColors(h)
+	}
+	return h
+}
+
+func isRed(h *llrbNode) bool {
+	return h != nil && !h.black
+}
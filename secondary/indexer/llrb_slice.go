@@ -0,0 +1,348 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+	"sync/atomic"
+)
+
+//NewLlrbSlice initializes a new memory resident slice with llrb backend.
+//Both main and back index are kept in copy-on-write llrb trees, so that
+//snapshots are cheap and can be scanned while the slice is updated.
+//Index data is not persisted, committed snapshots are valid only for the
+//lifetime of the slice.
+func NewLlrbSlice(path string, sliceId SliceId, idxDefnId common.IndexDefnId,
+	idxInstId common.IndexInstId, sysconf common.Config) (*llrbSlice, error) {
+
+	slice := &llrbSlice{
+		path:      path,
+		id:        sliceId,
+		idxDefnId: idxDefnId,
+		idxInstId: idxInstId,
+		main:      newLlrbTree(),
+		back:      newLlrbTree(),
+		snapshots: NewSnapshotInfoContainer(nil),
+	}
+
+	common.Debugf("LlrbSlice:NewLlrbSlice \n\t Created New Slice Id %v IndexInstId %v",
+		sliceId, idxInstId)
+
+	return slice, nil
+}
+
+//llrbSlice represents a memory resident llrb slice
+type llrbSlice struct {
+	path string
+	id   SliceId //slice id
+
+	refCount int
+	lock     sync.RWMutex
+
+	writeLock sync.Mutex // serializes updates and snapshots
+	main      *llrbTree  // forward index <encodedkey, encodedvalue>
+	back      *llrbTree  // reverse index <docid, encodedkeys>
+	snapshots *snapshotInfoContainer
+
+	idxDefnId common.IndexDefnId
+	idxInstId common.IndexInstId
+
+	status   SliceStatus
+	isActive bool
+
+	// Statistics
+	get_bytes, insert_bytes, delete_bytes int64
+}
+
+func (mdb *llrbSlice) IncrRef() {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+
+	mdb.refCount++
+}
+
+func (mdb *llrbSlice) DecrRef() {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+
+	mdb.refCount--
+}
+
+//Insert will insert the given key/value pair into slice. Unlike forestdb
+//slice, the request is executed synchronously.
+func (mdb *llrbSlice) Insert(k Key, v Value) error {
+	var keys []Key
+	if k.Encoded() != nil {
+		keys = []Key{k}
+	}
+	return mdb.InsertArray(keys, v)
+}
+
+//InsertArray will insert the keys of an array index entry into slice,
+//replacing existing keys of the docid.
+func (mdb *llrbSlice) InsertArray(keys []Key, v Value) error {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	common.Tracef("LlrbSlice::insert \n\tSliceId %v IndexInstId %v Set Keys - %v "+
+		"Value - %s", mdb.id, mdb.idxInstId, keys, v)
+
+	oldkeys, err := mdb.getBackIndexEntry(v.Docid())
+	if err != nil {
+		common.Errorf("LlrbSlice::insert \n\tSliceId %v IndexInstId %v Error locating "+
+			"backindex entry %v", mdb.id, mdb.idxInstId, err)
+		return err
+	}
+
+	newkeys := encodedKeys(keys)
+	deleted, added := diffKeys(oldkeys, newkeys)
+
+	//false mutation, secondary keys of the docid have not changed
+	if len(deleted) == 0 && len(added) == 0 {
+		common.Tracef("LlrbSlice::insert \n\tSliceId %v IndexInstId %v Unchanged Keys for "+
+			"Doc Id %v. Skipped.", mdb.id, mdb.idxInstId, v.Docid())
+		return nil
+	}
+
+	for _, oldkey := range deleted {
+		mdb.main.Delete(oldkey)
+		atomic.AddInt64(&mdb.delete_bytes, int64(len(oldkey)))
+	}
+
+	//if there are no keys, only the back index entry needs to be removed
+	if len(newkeys) == 0 {
+		mdb.back.Delete(v.Docid())
+		atomic.AddInt64(&mdb.delete_bytes, int64(len(v.Docid())))
+		return nil
+	}
+
+	backEntry := encodeBackEntry(newkeys)
+	mdb.back.Set(v.Docid(), backEntry)
+	atomic.AddInt64(&mdb.insert_bytes, int64(len(v.Docid())+len(backEntry)))
+
+	for _, key := range added {
+		mdb.main.Set(key, v.Encoded())
+		atomic.AddInt64(&mdb.insert_bytes, int64(len(key)+len(v.Encoded())))
+	}
+	return nil
+}
+
+//Delete will delete the given document from slice.
+func (mdb *llrbSlice) Delete(docid []byte) error {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	common.Tracef("LlrbSlice::delete \n\tSliceId %v IndexInstId %v. Delete Key - %s",
+		mdb.id, mdb.idxInstId, docid)
+
+	oldkeys, err := mdb.getBackIndexEntry(docid)
+	if err != nil {
+		common.Errorf("LlrbSlice::delete \n\tSliceId %v IndexInstId %v. Error locating "+
+			"backindex entry for Doc %s. Error %v", mdb.id, mdb.idxInstId, docid, err)
+		return err
+	}
+
+	//if there are no oldkeys, nothing needs to be done. This is the case of
+	//deletes which happened before index was created.
+	if len(oldkeys) == 0 {
+		return nil
+	}
+
+	for _, oldkey := range oldkeys {
+		mdb.main.Delete(oldkey)
+		atomic.AddInt64(&mdb.delete_bytes, int64(len(oldkey)))
+	}
+
+	mdb.back.Delete(docid)
+	atomic.AddInt64(&mdb.delete_bytes, int64(len(docid)))
+	return nil
+}
+
+//getBackIndexEntry returns the encoded keys of an existing back index
+//entry given the docid. Caller should hold the writeLock.
+func (mdb *llrbSlice) getBackIndexEntry(docid []byte) ([][]byte, error) {
+	entry := mdb.back.Get(docid)
+	atomic.AddInt64(&mdb.get_bytes, int64(len(entry)))
+	return decodeBackEntry(entry)
+}
+
+// Creates an open snapshot handle from snapshot info
+// Snapshot info is obtained from NewSnapshot() or GetSnapshots() API
+func (mdb *llrbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	snapInfo := info.(*llrbSnapshotInfo)
+	s := &llrbSnapshot{slice: mdb,
+		rangeReader: newRangeReader(snapInfo.main),
+		idxDefnId:   mdb.idxDefnId,
+		idxInstId:   mdb.idxInstId,
+		main:        snapInfo.main,
+		back:        snapInfo.back,
+		ts:          snapInfo.Timestamp(),
+		committed:   info.IsCommitted(),
+	}
+
+	common.Debugf("LlrbSlice::OpenSnapshot \n\tSliceId %v IndexInstId %v Creating New "+
+		"Snapshot %v committed:%v", mdb.id, mdb.idxInstId, s, s.committed)
+	err := s.Open()
+
+	return s, err
+}
+
+//Rollback slice to given snapshot.
+func (mdb *llrbSlice) Rollback(info SnapshotInfo) error {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	snapInfo := info.(*llrbSnapshotInfo)
+	mdb.main.Reset(snapInfo.main)
+	mdb.back.Reset(snapInfo.back)
+	mdb.snapshots.RemoveRecentThanTS(info.Timestamp())
+
+	common.Debugf("LlrbSlice::Rollback \n\tSliceId %v IndexInstId %v. Rollback "+
+		"to Snapshot %v", mdb.id, mdb.idxInstId, info)
+	return nil
+}
+
+//RollbackToZero rollbacks the slice to initial state.
+func (mdb *llrbSlice) RollbackToZero() error {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	mdb.main.Reset(nil)
+	mdb.back.Reset(nil)
+	mdb.snapshots.RemoveAll()
+	return nil
+}
+
+//NewSnapshot captures the current state of the slice. Since the slice is
+//memory resident, commit only retains the snapshot in the list of snapshots
+//available for rollback.
+func (mdb *llrbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo, error) {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	newSnapshotInfo := &llrbSnapshotInfo{
+		Ts:        ts,
+		Committed: commit,
+		main:      mdb.main.Snapshot(),
+		back:      mdb.back.Snapshot(),
+	}
+
+	if commit {
+		mdb.snapshots.Add(newSnapshotInfo)
+		if mdb.snapshots.Len() > MAX_SNAPSHOTS_PER_INDEX {
+			mdb.snapshots.RemoveOldest()
+		}
+	}
+
+	return newSnapshotInfo, nil
+}
+
+func (mdb *llrbSlice) Close() {
+	common.Infof("LlrbSlice::Close \n\tClosing Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v", mdb.id, mdb.idxInstId, mdb.idxDefnId)
+}
+
+//Destroy releases the index data held by the slice. Open snapshots remain
+//valid till they are closed.
+func (mdb *llrbSlice) Destroy() {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	common.Infof("LlrbSlice::Destroy \n\tDestroying Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v", mdb.id, mdb.idxInstId, mdb.idxDefnId)
+
+	mdb.main = newLlrbTree()
+	mdb.back = newLlrbTree()
+	mdb.snapshots.RemoveAll()
+}
+
+//Id returns the Id for this Slice
+func (mdb *llrbSlice) Id() SliceId {
+	return mdb.id
+}
+
+// Path returns the path for this Slice, it is used only for identification
+// as no data is persisted.
+func (mdb *llrbSlice) Path() string {
+	return mdb.path
+}
+
+//IsActive returns if the slice is active
+func (mdb *llrbSlice) IsActive() bool {
+	return mdb.isActive
+}
+
+//SetActive sets the active state of this slice
+func (mdb *llrbSlice) SetActive(isActive bool) {
+	mdb.isActive = isActive
+}
+
+//Status returns the status for this slice
+func (mdb *llrbSlice) Status() SliceStatus {
+	return mdb.status
+}
+
+//SetStatus set new status for this slice
+func (mdb *llrbSlice) SetStatus(status SliceStatus) {
+	mdb.status = status
+}
+
+//IndexInstId returns the Index InstanceId this
+//slice is associated with
+func (mdb *llrbSlice) IndexInstId() common.IndexInstId {
+	return mdb.idxInstId
+}
+
+//IndexDefnId returns the Index DefnId this slice
+//is associated with
+func (mdb *llrbSlice) IndexDefnId() common.IndexDefnId {
+	return mdb.idxDefnId
+}
+
+// Returns snapshot info list
+func (mdb *llrbSlice) GetSnapshots() ([]SnapshotInfo, error) {
+	mdb.writeLock.Lock()
+	defer mdb.writeLock.Unlock()
+
+	return mdb.snapshots.List(), nil
+}
+
+//Compact is a no-op, llrb does not fragment.
+func (mdb *llrbSlice) Compact() error {
+	return nil
+}
+
+//Statistics returns size of data held by the slice, disk size is always 0,
+//so the slice is never picked up for compaction.
+func (mdb *llrbSlice) Statistics() (StorageStatistics, error) {
+	var sts StorageStatistics
+
+	mdb.writeLock.Lock()
+	sts.DataSize = int64(mdb.main.bytes + mdb.back.bytes)
+	mdb.writeLock.Unlock()
+
+	sts.GetBytes = atomic.LoadInt64(&mdb.get_bytes)
+	sts.InsertBytes = atomic.LoadInt64(&mdb.insert_bytes)
+	sts.DeleteBytes = atomic.LoadInt64(&mdb.delete_bytes)
+
+	return sts, nil
+}
+
+func (mdb *llrbSlice) String() string {
+
+	str := fmt.Sprintf("SliceId: %v ", mdb.id)
+	str += fmt.Sprintf("Path: %v ", mdb.path)
+	str += fmt.Sprintf("Index: %v ", mdb.idxInstId)
+
+	return str
+
+}
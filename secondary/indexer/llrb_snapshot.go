@@ -0,0 +1,128 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+)
+
+type llrbSnapshotInfo struct {
+	Ts        *common.TsVbuuid
+	Committed bool
+
+	main *llrbRoot // version of forward index
+	back *llrbRoot // version of reverse index
+}
+
+func (info *llrbSnapshotInfo) Timestamp() *common.TsVbuuid {
+	return info.Ts
+}
+
+func (info *llrbSnapshotInfo) IsCommitted() bool {
+	return info.Committed
+}
+
+func (info *llrbSnapshotInfo) String() string {
+	return fmt.Sprintf("SnapshotInfo: count: %v, committed: %v",
+		info.main.Count(), info.Committed)
+}
+
+// llrbSnapshot is an immutable version of llrb slice, since llrb versions
+// are never modified, no resource is held by an open snapshot other than
+// the memory referred by it.
+type llrbSnapshot struct {
+	rangeReader
+
+	slice Slice
+
+	main *llrbRoot // forward index
+	back *llrbRoot // reverse index
+
+	idxDefnId common.IndexDefnId //index definition id
+	idxInstId common.IndexInstId //index instance id
+	ts        *common.TsVbuuid   //timestamp
+	committed bool
+
+	refCount int          //Reader count for this snapshot
+	lock     sync.RWMutex //lock to atomically increment the refCount
+}
+
+func (s *llrbSnapshot) Open() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.refCount == 0 {
+		s.slice.IncrRef()
+	}
+	s.refCount++
+	return nil
+}
+
+func (s *llrbSnapshot) IsOpen() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.refCount > 0
+}
+
+func (s *llrbSnapshot) Id() SliceId {
+	return s.slice.Id()
+}
+
+func (s *llrbSnapshot) IndexInstId() common.IndexInstId {
+	return s.idxInstId
+}
+
+func (s *llrbSnapshot) IndexDefnId() common.IndexDefnId {
+	return s.idxDefnId
+}
+
+func (s *llrbSnapshot) Timestamp() *common.TsVbuuid {
+	return s.ts
+}
+
+//Close the snapshot
+func (s *llrbSnapshot) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.refCount <= 0 {
+		common.Errorf("LlrbSnapshot::Close Close operation requested " +
+			"on already closed snapshot")
+		return errors.New("Snapshot Already Closed")
+	}
+
+	s.refCount--
+	if s.refCount == 0 {
+		s.slice.DecrRef()
+	}
+	return nil
+}
+
+func (s *llrbSnapshot) String() string {
+
+	str := fmt.Sprintf("Index: %v ", s.idxInstId)
+	str += fmt.Sprintf("SliceId: %v ", s.slice.Id())
+	str += fmt.Sprintf("Count: %v ", s.main.Count())
+	str += fmt.Sprintf("TS: %v ", s.ts)
+	return str
+}
+
+func (s *llrbSnapshot) Info() SnapshotInfo {
+	return &llrbSnapshotInfo{
+		Ts:        s.ts,
+		Committed: s.committed,
+		main:      s.main,
+		back:      s.back,
+	}
+}
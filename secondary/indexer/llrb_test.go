@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// verify llrb invariants and return black height of the sub-tree
+func verifyLlrb(t *testing.T, h *llrbNode, low, high []byte) int {
+	if h == nil {
+		return 1
+	}
+	if low != nil && bytes.Compare(h.key, low) <= 0 {
+		t.Fatalf("key %s out of order", h.key)
+	}
+	if high != nil && bytes.Compare(h.key, high) >= 0 {
+		t.Fatalf("key %s out of order", h.key)
+	}
+	if isRed(h.right) {
+		t.Fatalf("right leaning red link at %s", h.key)
+	}
+	if isRed(h) && isRed(h.left) {
+		t.Fatalf("consecutive red links at %s", h.key)
+	}
+	lh := verifyLlrb(t, h.left, low, h.key)
+	rh := verifyLlrb(t, h.right, h.key, high)
+	if lh != rh {
+		t.Fatalf("unbalanced black height at %s", h.key)
+	}
+	if h.black {
+		lh++
+	}
+	return lh
+}
+
+func llrbKeys(r *llrbRoot) []string {
+	var keys []string
+	r.Range(nil, func(key, val []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	return keys
+}
+
+func TestLlrbTree(t *testing.T) {
+	tree := newLlrbTree()
+	ref := make(map[string]bool)
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("key-%05d", rnd.Intn(2000)))
+		if rnd.Intn(3) == 0 {
+			val := tree.Delete(key)
+			if (val != nil) != ref[string(key)] {
+				t.Fatalf("unexpected delete result for %s", key)
+			}
+			delete(ref, string(key))
+		} else {
+			tree.Set(key, key)
+			ref[string(key)] = true
+		}
+		if i%1000 == 0 {
+			tree.Snapshot()
+			verifyLlrb(t, tree.root, nil, nil)
+		}
+	}
+	verifyLlrb(t, tree.root, nil, nil)
+
+	var expected []string
+	for key := range ref {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	keys := llrbKeys(tree.current())
+	if fmt.Sprint(keys) != fmt.Sprint(expected) || tree.count != uint64(len(ref)) {
+		t.Fatalf("tree content mismatch %v != %v", len(keys), len(expected))
+	}
+
+	high := expected[len(expected)/2]
+	var reversed []string
+	tree.current().RangeReverse([]byte(high), func(key, val []byte) bool {
+		reversed = append(reversed, string(key))
+		return true
+	})
+	for i, key := range reversed {
+		if key != expected[len(expected)/2-1-i] {
+			t.Fatalf("unexpected reverse range %v", reversed)
+		}
+	}
+	if len(reversed) != len(expected)/2 {
+		t.Fatalf("unexpected reverse range length %v", len(reversed))
+	}
+}
+
+func TestLlrbSnapshot(t *testing.T) {
+	tree := newLlrbTree()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		tree.Set(key, key)
+	}
+
+	snap := tree.Snapshot()
+	expected := llrbKeys(snap)
+	for i := 0; i < 100; i += 2 {
+		tree.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+	tree.Set([]byte("key-500"), []byte("key-500"))
+
+	if keys := llrbKeys(snap); fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("snapshot modified by writer")
+	}
+	if snap.Count() != 100 || tree.count != 51 {
+		t.Errorf("unexpected count %v, %v", snap.Count(), tree.count)
+	}
+
+	tree.Reset(snap)
+	if keys := llrbKeys(tree.current()); fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("tree not reset to snapshot")
+	}
+	verifyLlrb(t, tree.root, nil, nil)
+}
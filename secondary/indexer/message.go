@@ -35,6 +35,8 @@ const (
 	STREAM_READER_ERROR
 	STREAM_READER_SHUTDOWN
 	STREAM_READER_CONN_ERROR
+	STREAM_READER_QUEUE_OVERFLOW
+	STREAM_READER_KEY_SIZE_ERROR
 
 	//MUTATION_MANAGER
 	MUT_MGR_PERSIST_MUTATION_QUEUE
@@ -258,6 +260,44 @@ func (m *MsgStreamInfo) String() string {
 	return str
 }
 
+//STREAM_READER_KEY_SIZE_ERROR
+type MsgKeySizeError struct {
+	streamId common.StreamId
+	instId   common.IndexInstId
+	meta     *MutationMeta
+	docid    []byte
+}
+
+func (m *MsgKeySizeError) GetMsgType() MsgType {
+	return STREAM_READER_KEY_SIZE_ERROR
+}
+
+func (m *MsgKeySizeError) GetStreamId() common.StreamId {
+	return m.streamId
+}
+
+func (m *MsgKeySizeError) GetIndexInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgKeySizeError) GetMutationMeta() *MutationMeta {
+	return m.meta
+}
+
+func (m *MsgKeySizeError) GetDocid() []byte {
+	return m.docid
+}
+
+func (m *MsgKeySizeError) String() string {
+
+	str := "\n\tMessage: MsgKeySizeError"
+	str += fmt.Sprintf("\n\tStream: %v", m.streamId)
+	str += fmt.Sprintf("\n\tInstId: %v", m.instId)
+	str += fmt.Sprintf("\n\tMeta: %v", m.meta)
+	str += fmt.Sprintf("\n\tDocid: %s", m.docid)
+	return str
+}
+
 //STREAM_READER_UPDATE_QUEUE_MAP
 type MsgUpdateBucketQueue struct {
 	bucketQueueMap BucketQueueMap
@@ -789,9 +829,10 @@ func (m *MsgRecovery) GetRestartTs() *common.TsVbuuid {
 }
 
 type MsgRollback struct {
-	streamId   common.StreamId
-	bucket     string
-	rollbackTs *common.TsVbuuid
+	streamId    common.StreamId
+	bucket      string
+	rollbackTs  *common.TsVbuuid
+	rebuildList []common.IndexInstId //indexes which failed to rollback
 }
 
 func (m *MsgRollback) GetMsgType() MsgType {
@@ -810,6 +851,10 @@ func (m *MsgRollback) GetRollbackTs() *common.TsVbuuid {
 	return m.rollbackTs
 }
 
+func (m *MsgRollback) GetRebuildList() []common.IndexInstId {
+	return m.rebuildList
+}
+
 type MsgIndexSnapRequest struct {
 	ts        *common.TsVbuuid
 	idxInstId common.IndexInstId
@@ -998,6 +1043,10 @@ func (m MsgType) String() string {
 		return "STREAM_READER_SHUTDOWN"
 	case STREAM_READER_CONN_ERROR:
 		return "STREAM_READER_CONN_ERROR"
+	case STREAM_READER_QUEUE_OVERFLOW:
+		return "STREAM_READER_QUEUE_OVERFLOW"
+	case STREAM_READER_KEY_SIZE_ERROR:
+		return "STREAM_READER_KEY_SIZE_ERROR"
 
 	case MUT_MGR_PERSIST_MUTATION_QUEUE:
 		return "MUT_MGR_PERSIST_MUTATION_QUEUE"
@@ -0,0 +1,81 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//Metrics exported by indexer on its /metrics endpoint.
+var (
+	scanRequestsMetric = common.DefaultMetrics.NewCounter(
+		"indexer_scan_requests_total",
+		"Number of scan requests received for an index.",
+		"bucket", "index")
+
+	scanRowsMetric = common.DefaultMetrics.NewCounter(
+		"indexer_scan_rows_total",
+		"Number of rows returned by scans on an index.",
+		"bucket", "index")
+
+	scanBytesMetric = common.DefaultMetrics.NewCounter(
+		"indexer_scan_bytes_total",
+		"Number of bytes read by scans on an index.",
+		"bucket", "index")
+
+	scanDurationMetric = common.DefaultMetrics.NewHistogram(
+		"indexer_scan_duration_seconds",
+		"Latency of scans on an index, including wait for a consistent snapshot.",
+		common.LatencyBuckets, "bucket", "index")
+
+	scanPhaseDurationMetric = common.DefaultMetrics.NewHistogram(
+		"indexer_scan_phase_duration_seconds",
+		"Latency of a scan phase on an index, phase is one of "+
+			"snapshot_wait, storage_scan or network_send.",
+		common.LatencyBuckets, "bucket", "index", "phase")
+
+	flushDurationMetric = common.DefaultMetrics.NewHistogram(
+		"indexer_flush_duration_seconds",
+		"Latency of flushing a bucket's mutation queue to its indexes.",
+		common.LatencyBuckets, "stream", "bucket")
+
+	mutationQueueDepthMetric = common.DefaultMetrics.NewGauge(
+		"indexer_mutation_queue_depth",
+		"Number of mutations waiting in a vbucket's mutation queue.",
+		"stream", "bucket", "vbucket")
+
+	mutationQueueMemoryMetric = common.DefaultMetrics.NewGauge(
+		"indexer_mutation_queue_memory_bytes",
+		"Memory used by a bucket's mutation queue.",
+		"stream", "bucket")
+
+	queryportConnectionsMetric = common.DefaultMetrics.NewGauge(
+		"indexer_queryport_connections",
+		"Number of open connections to the queryport.")
+
+	queryportActiveRequestsMetric = common.DefaultMetrics.NewGauge(
+		"indexer_queryport_active_requests",
+		"Number of requests being served by the queryport.")
+)
+
+//phases of a scan, label values for scanPhaseDurationMetric.
+var scanPhases = []string{"snapshot_wait", "storage_scan", "network_send"}
+
+//deleteScanMetrics removes the scan metrics of an index that
+//is no longer hosted by the indexer.
+func deleteScanMetrics(bucket, index string) {
+	scanRequestsMetric.Delete(bucket, index)
+	scanRowsMetric.Delete(bucket, index)
+	scanBytesMetric.Delete(bucket, index)
+	scanDurationMetric.Delete(bucket, index)
+	for _, phase := range scanPhases {
+		scanPhaseDurationMetric.Delete(bucket, index, phase)
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 
 	"errors"
+	"strconv"
 	"sync"
+	"time"
 )
 
 //MutationManager handles messages from Indexer to manage Mutation Streams
@@ -49,6 +51,9 @@ type mutationMgr struct {
 
 	numVbuckets uint16 //number of vbuckets
 
+	queueMemory    int64 //memory shared by mutation queues of all buckets
+	minQueueMemory int64 //minimum memory for a bucket's mutation queue
+
 	flusherWaitGroup sync.WaitGroup
 
 	lock  sync.Mutex //lock to protect this structure
@@ -83,8 +88,15 @@ func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		supvCmdch:              supvCmdch,
 		supvRespch:             supvRespch,
 		numVbuckets:            uint16(config["numVbuckets"].Int()),
+		minQueueMemory:         int64(config["mutation_queue.min_memory"].Uint64()),
 	}
 
+	memQuota := config["settings.memory_quota"].Uint64()
+	memPercent := uint64(config["mutation_queue.memory_percent"].Int())
+	m.queueMemory = int64(memQuota * memPercent / 100)
+
+	common.DefaultMetrics.OnCollect(m.collectQueueMetrics)
+
 	//start Mutation Manager loop which listens to commands from its supervisor
 	go m.run()
 
@@ -276,7 +288,9 @@ func (m *mutationMgr) handleWorkerMessage(cmd Message) {
 		STREAM_READER_ERROR,
 		STREAM_READER_SYNC,
 		STREAM_READER_SNAPSHOT_MARKER,
-		STREAM_READER_CONN_ERROR:
+		STREAM_READER_CONN_ERROR,
+		STREAM_READER_QUEUE_OVERFLOW,
+		STREAM_READER_KEY_SIZE_ERROR:
 		//send message to supervisor to take decision
 		common.Tracef("MutationMgr::handleWorkerMessage \n\tReceived %v from worker", cmd)
 		m.supvRespch <- cmd
@@ -356,6 +370,7 @@ func (m *mutationMgr) handleOpenStream(cmd Message) {
 		m.streamIndexQueueMap[streamId] = indexQueueMap
 		m.streamReaderCmdChMap[streamId] = cmdCh
 		m.streamReaderExitChMap[streamId] = make(DoneChannel)
+		m.setMaxQueueMemory()
 
 		func() {
 			m.flock.Lock()
@@ -445,6 +460,7 @@ func (m *mutationMgr) addIndexListToExistingStream(streamId common.StreamId,
 			//update internal structures
 			m.streamBucketQueueMap[streamId] = bucketQueueMap
 			m.streamIndexQueueMap[streamId] = indexQueueMap
+			m.setMaxQueueMemory()
 		}
 		return respMsg
 	}
@@ -513,6 +529,7 @@ func (m *mutationMgr) handleRemoveIndexListFromStream(cmd Message) {
 			//update internal structures
 			m.streamBucketQueueMap[streamId] = bucketQueueMap
 			m.streamIndexQueueMap[streamId] = indexQueueMap
+			m.setMaxQueueMemory()
 		}
 
 		//send the message back on supv channel
@@ -579,6 +596,7 @@ func (m *mutationMgr) handleRemoveBucketFromStream(cmd Message) {
 			//update internal structures
 			m.streamBucketQueueMap[streamId] = bucketQueueMap
 			m.streamIndexQueueMap[streamId] = indexQueueMap
+			m.setMaxQueueMemory()
 		}
 
 		//send the message back on supv channel
@@ -768,12 +786,65 @@ func (m *mutationMgr) cleanupStream(streamId common.StreamId) {
 	delete(m.streamReaderCmdChMap, streamId)
 	delete(m.streamReaderExitChMap, streamId)
 
+	m.setMaxQueueMemory()
+
 	m.flock.Lock()
 	defer m.flock.Unlock()
 	delete(m.streamFlusherStopChMap, streamId)
 
 }
 
+//setMaxQueueMemory divides the memory reserved for mutation queues
+//equally among the bucket queues of all streams. Each queue gets at
+//least minQueueMemory. Caller must hold the lock.
+func (m *mutationMgr) setMaxQueueMemory() {
+
+	numQueues := 0
+	for _, bucketQueueMap := range m.streamBucketQueueMap {
+		numQueues += len(bucketQueueMap)
+	}
+	if numQueues == 0 {
+		return
+	}
+
+	maxMemory := m.queueMemory / int64(numQueues)
+	if maxMemory < m.minQueueMemory {
+		maxMemory = m.minQueueMemory
+	}
+
+	for streamId, bucketQueueMap := range m.streamBucketQueueMap {
+		for bucket, q := range bucketQueueMap {
+			common.Debugf("MutationMgr::setMaxQueueMemory Stream %v Bucket %v "+
+				"MaxMemory %v", streamId, bucket, maxMemory)
+			q.queue.SetMaxMemory(maxMemory)
+		}
+	}
+}
+
+//collectQueueMetrics samples the depth and memory usage of
+//the mutation queues of all streams.
+func (m *mutationMgr) collectQueueMetrics() {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mutationQueueDepthMetric.Reset()
+	mutationQueueMemoryMetric.Reset()
+
+	for streamId, bucketQueueMap := range m.streamBucketQueueMap {
+		stream := streamId.String()
+		for bucket, q := range bucketQueueMap {
+			mutationQueueMemoryMetric.With(stream, bucket).Set(
+				float64(q.queue.GetMemUsed()))
+			for vb := uint16(0); vb < q.queue.GetNumVbuckets(); vb++ {
+				vbno := strconv.Itoa(int(vb))
+				mutationQueueDepthMetric.With(stream, bucket, vbno).Set(
+					float64(q.queue.GetSize(Vbucket(vb))))
+			}
+		}
+	}
+}
+
 //handlePersistMutationQueue handles persist queue message from
 //Indexer. Success is sent on the supervisor Cmd channel
 //if the flush can be processed. Once the queue gets persisted,
@@ -811,10 +882,14 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 
 		flusher := NewFlusher()
 		sts := getStabilityTSFromTsVbuuid(ts)
+		start := time.Now()
 		msgch := flusher.PersistUptoTS(q.queue,
 			streamId, m.indexInstMap, m.indexPartnMap, sts, stopch)
 		//wait for flusher to finish
 		msg := <-msgch
+		if msg.GetMsgType() == MSG_SUCCESS {
+			flushDurationMetric.With(streamId.String(), bucket).ObserveSince(start)
+		}
 
 		//update map and free lock before blocking on the supv channel
 		func() {
@@ -40,6 +40,13 @@ type MutationQueue interface {
 
 	//returns the numbers of vbuckets for the queue
 	GetNumVbuckets() uint16
+
+	//return memory used by mutations in queue across all vbuckets
+	GetMemUsed() int64
+	//return memory limit for the queue, 0 means no limit
+	GetMaxMemory() int64
+	//set memory limit for the queue, 0 means no limit
+	SetMaxMemory(maxMemory int64)
 }
 
 //AtomicMutationQueue is a lock-free multi-queue with internal queue per
@@ -55,8 +62,15 @@ type MutationQueue interface {
 //for a single reader and writer per vbucket queue without using mutex locks.
 //
 //It provides safe concurrent read/write access across vbucket queues.
+//
+//Memory used by the queued mutations is accounted for across vbuckets.
+//Enqueue never blocks on it, writer is expected to throttle itself once
+//GetMemUsed() reaches GetMaxMemory().
 
 type atomicMutationQueue struct {
+	memUsed   int64 //memory used by mutations in queue
+	maxMemory int64 //memory limit for the queue, 0 means no limit
+
 	head        []unsafe.Pointer //head pointer per vbucket queue
 	tail        []unsafe.Pointer //tail pointer per vbucket queue
 	free        []*node          //free pointer per vbucket queue
@@ -89,6 +103,7 @@ func NewAtomicMutationQueue(numVbuckets uint16) *atomicMutationQueue {
 //Node represents a single element in the queue
 type node struct {
 	mutation *MutationKeys
+	size     int64 //memory accounted for the mutation
 	next     *node
 }
 
@@ -107,6 +122,7 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys, vbucket Vbucket) e
 	//create a new node
 	n := q.allocNode(vbucket)
 	n.mutation = mutation
+	n.size = mutation.Size()
 	n.next = nil
 
 	//point tail's next to new node
@@ -116,6 +132,7 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys, vbucket Vbucket) e
 	atomic.StorePointer(&q.tail[vbucket], unsafe.Pointer(tail.next))
 
 	atomic.AddInt64(&q.size[vbucket], 1)
+	atomic.AddInt64(&q.memUsed, n.size)
 
 	return nil
 
@@ -158,6 +175,7 @@ func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
 				//move head to next
 				atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 				atomic.AddInt64(&q.size[vbucket], -1)
+				atomic.AddInt64(&q.memUsed, -head.next.size)
 				//send mutation to caller
 				datach <- m
 				dequeueCount++
@@ -239,6 +257,7 @@ func (q *atomicMutationQueue) DequeueSingleElement(vbucket Vbucket) *MutationKey
 		//move head to next
 		atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 		atomic.AddInt64(&q.size[vbucket], -1)
+		atomic.AddInt64(&q.memUsed, -head.next.size)
 		return m
 	}
 	return nil
@@ -274,6 +293,21 @@ func (q *atomicMutationQueue) GetNumVbuckets() uint16 {
 	return q.numVbuckets
 }
 
+//GetMemUsed returns the memory used by mutations in queue
+func (q *atomicMutationQueue) GetMemUsed() int64 {
+	return atomic.LoadInt64(&q.memUsed)
+}
+
+//GetMaxMemory returns the memory limit for the queue
+func (q *atomicMutationQueue) GetMaxMemory() int64 {
+	return atomic.LoadInt64(&q.maxMemory)
+}
+
+//SetMaxMemory sets the memory limit for the queue
+func (q *atomicMutationQueue) SetMaxMemory(maxMemory int64) {
+	atomic.StoreInt64(&q.maxMemory, maxMemory)
+}
+
 //allocNode tries to get node from freelist, otherwise allocates a new node and returns
 func (q *atomicMutationQueue) allocNode(vbucket Vbucket) *node {
 
@@ -232,6 +232,50 @@ func TestMultipleVbucketsA(t *testing.T) {
 
 }
 
+func TestMemUsedA(t *testing.T) {
+
+	q := NewAtomicMutationQueue(2)
+	q.SetMaxMemory(1024)
+
+	mut := make([]*MutationKeys, 4)
+	for i := 0; i < 4; i++ {
+		mut[i] = &MutationKeys{meta: &MutationMeta{vbucket: Vbucket(i % 2),
+			seqno: Seqno(i)},
+			docid: []byte("docid"),
+			keys:  [][]byte{make([]byte, 200)}}
+		q.Enqueue(mut[i], Vbucket(i%2))
+	}
+	size := mut[0].Size()
+	if size < 205 {
+		t.Errorf("expected mutation size at least 205, got %v", size)
+	}
+	if r := q.GetMemUsed(); r != 4*size {
+		t.Errorf("expected memory used %v doesn't match returned %v", 4*size, r)
+	}
+	if r := q.GetMaxMemory(); r != 1024 {
+		t.Errorf("expected max memory 1024 doesn't match returned %v", r)
+	}
+	if !isQueueOverflow(q) {
+		t.Errorf("expected queue to overflow at %v", q.GetMemUsed())
+	}
+
+	q.DequeueSingleElement(0)
+	q.DequeueSingleElement(1)
+	if r := q.GetMemUsed(); r != 2*size {
+		t.Errorf("expected memory used %v doesn't match returned %v", 2*size, r)
+	}
+
+	ch, _ := q.DequeueUptoSeqno(0, 2)
+	for _ = range ch {
+	}
+	if r := q.GetMemUsed(); r != size {
+		t.Errorf("expected memory used %v doesn't match returned %v", size, r)
+	}
+	if isQueueOverflow(q) {
+		t.Errorf("expected queue not to overflow at %v", q.GetMemUsed())
+	}
+}
+
 func BenchmarkEnqueueA(b *testing.B) {
 
 	q := NewAtomicMutationQueue(1)
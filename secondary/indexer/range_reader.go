@@ -0,0 +1,305 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// kvIterable is a read-only version of forward index, that can be iterated
+// in sort order of encoded keys.
+type kvIterable interface {
+	// Iterate calls fn for every entry with key >= low, in sort order, till
+	// fn returns false. A nil low starts from the first entry. Key and value
+	// passed to fn can be retained by fn.
+	Iterate(low []byte, fn func(key, val []byte) bool) error
+
+	// IterateReverse is same as Iterate, except that fn is called for every
+	// entry with key < high, in reverse sort order. A nil high starts from
+	// the last entry.
+	IterateReverse(high []byte, fn func(key, val []byte) bool) error
+
+	// Len returns the number of entries.
+	Len() (uint64, error)
+}
+
+// rangeReader implements the IndexReader query methods on top of a
+// kvIterable, for storage engines that do not provide their own.
+type rangeReader struct {
+	store kvIterable
+	stats *statsCache
+}
+
+func newRangeReader(store kvIterable) rangeReader {
+	return rangeReader{store: store, stats: new(statsCache)}
+}
+
+//Counter interface
+func (r rangeReader) CountTotal(stopch StopChannel) (uint64, error) {
+	return r.store.Len()
+}
+
+//Exister interface
+func (r rangeReader) Exists(key Key, stopch StopChannel) (bool, error) {
+	count, err := r.CountRange(key, key, Both, stopch)
+	return count > 0, err
+}
+
+//Looker interface
+func (r rangeReader) Lookup(key Key, stopch StopChannel) (chan Value, chan error) {
+	chval := make(chan Value)
+	cherr := make(chan error)
+
+	common.Debugf("RangeReader: Received Lookup Query for Key %s", key.String())
+	go r.getValueSetForKeyRange(key, key, Both, chval, cherr, stopch)
+	return chval, cherr
+}
+
+func (r rangeReader) KeySet(stopch StopChannel) (chan Key, chan error) {
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	nilKey, _ := NewKeyFromEncodedBytes(nil)
+	go r.getKeySetForKeyRange(nilKey, nilKey, Both, false, false, chkey, cherr, stopch)
+	return chkey, cherr
+}
+
+func (r rangeReader) ValueSet(stopch StopChannel) (chan Value, chan error) {
+	chval := make(chan Value)
+	cherr := make(chan error)
+
+	nilKey, _ := NewKeyFromEncodedBytes(nil)
+	go r.getValueSetForKeyRange(nilKey, nilKey, Both, chval, cherr, stopch)
+	return chval, cherr
+}
+
+//Ranger
+func (r rangeReader) KeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go r.getKeySetForKeyRange(low, high, inclusion, false, false, chkey, cherr, stopch)
+	return chkey, cherr, Asc
+}
+
+func (r rangeReader) ReverseKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go r.getKeySetForKeyRange(low, high, inclusion, false, true, chkey, cherr, stopch)
+	return chkey, cherr, Desc
+}
+
+func (r rangeReader) DistinctKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go r.getKeySetForKeyRange(low, high, inclusion, true, false, chkey, cherr, stopch)
+	return chkey, cherr, Asc
+}
+
+func (r rangeReader) ReverseDistinctKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go r.getKeySetForKeyRange(low, high, inclusion, true, true, chkey, cherr, stopch)
+	return chkey, cherr, Desc
+}
+
+func (r rangeReader) ValueRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Value, chan error, SortOrder) {
+
+	chval := make(chan Value)
+	cherr := make(chan error)
+
+	go r.getValueSetForKeyRange(low, high, inclusion, chval, cherr, stopch)
+	return chval, cherr, Asc
+}
+
+//RangeCounter interface
+func (r rangeReader) CountRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (uint64, error) {
+
+	if low.Encoded() == nil && high.Encoded() == nil {
+		return r.store.Len()
+	}
+
+	var count uint64
+	err := r.scanRange(low, high, inclusion, func(key, val []byte) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+//Statistician interface
+func (r rangeReader) StatisticsRange(low, high Key, inclusion Inclusion,
+	nbins int, stopch StopChannel) (*RangeStatistics, error) {
+
+	return r.stats.statisticsRange(r, low, high, inclusion, nbins, stopch)
+}
+
+func (r rangeReader) getKeySetForKeyRange(low, high Key, inclusion Inclusion,
+	distinct, reverse bool, chkey chan Key, cherr chan error, stopch StopChannel) {
+
+	defer close(chkey)
+
+	common.Debugf("RangeReader Received Key Low - %s High - %s for Scan",
+		low.String(), high.String())
+
+	var filter *distinctFilter
+	if distinct {
+		filter = new(distinctFilter)
+	}
+
+	scan := r.scanRange
+	if reverse {
+		scan = r.scanRangeReverse
+	}
+	err := scan(low, high, inclusion, func(k, v []byte) bool {
+		key, _ := NewKeyFromEncodedBytes(k)
+		if filter.skip(key) {
+			return true
+		}
+
+		select {
+		case chkey <- key:
+			return true
+		case <-stopch:
+			return false
+		}
+	})
+	if err != nil {
+		sendScanError(err, cherr, stopch)
+	}
+}
+
+func (r rangeReader) getValueSetForKeyRange(low, high Key, inclusion Inclusion,
+	chval chan Value, cherr chan error, stopch StopChannel) {
+
+	defer close(chval)
+
+	common.Debugf("RangeReader Received Key Low - %s High - %s for Scan",
+		low.String(), high.String())
+
+	err := r.scanRange(low, high, inclusion, func(k, v []byte) bool {
+		val, err := NewValueFromEncodedBytes(v)
+		if err != nil {
+			common.Errorf("Error Converting from bytes %v to value %v, Skipping row",
+				v, err)
+			return true
+		}
+
+		select {
+		case chval <- val:
+			return true
+		case <-stopch:
+			return false
+		}
+	})
+	if err != nil {
+		sendScanError(err, cherr, stopch)
+	}
+}
+
+// scanRange calls fn for entries of the forward index between low and high
+// keys, in sort order, till fn returns false. Index entries are encoded as
+// [secKey..., docid] and scan keys as [secKey...], so an entry equals a scan
+// key if the encoded scan key, without array terminator, is a prefix of the
+// encoded entry. A nil low or high key leaves that end of the range open.
+func (r rangeReader) scanRange(low, high Key, inclusion Inclusion,
+	fn func(key, val []byte) bool) error {
+
+	var lowPrefix, highPrefix []byte
+	if lowkey := low.Encoded(); lowkey != nil {
+		lowPrefix = lowkey[:len(lowkey)-1]
+	}
+	if highkey := high.Encoded(); highkey != nil {
+		highPrefix = highkey[:len(highkey)-1]
+	}
+	lowIncl := inclusion == Low || inclusion == Both
+	highIncl := inclusion == High || inclusion == Both
+
+	return r.store.Iterate(lowPrefix, func(key, val []byte) bool {
+		if lowPrefix != nil && !lowIncl && bytes.HasPrefix(key, lowPrefix) {
+			return true
+		}
+
+		if highPrefix != nil {
+			if bytes.HasPrefix(key, highPrefix) {
+				if !highIncl {
+					return false
+				}
+			} else if bytes.Compare(key, highPrefix) > 0 {
+				return false
+			}
+		}
+
+		return fn(key, val)
+	})
+}
+
+// scanRangeReverse is same as scanRange, except that entries are visited in
+// reverse sort order.
+func (r rangeReader) scanRangeReverse(low, high Key, inclusion Inclusion,
+	fn func(key, val []byte) bool) error {
+
+	var lowPrefix, highLimit []byte
+	if lowkey := low.Encoded(); lowkey != nil {
+		lowPrefix = lowkey[:len(lowkey)-1]
+	}
+	if highkey := high.Encoded(); highkey != nil {
+		highLimit = highKeyLimit(highkey, inclusion == High || inclusion == Both)
+	}
+	lowIncl := inclusion == Low || inclusion == Both
+
+	return r.store.IterateReverse(highLimit, func(key, val []byte) bool {
+		if lowPrefix != nil {
+			if bytes.HasPrefix(key, lowPrefix) {
+				if !lowIncl {
+					return false
+				}
+			} else if bytes.Compare(key, lowPrefix) < 0 {
+				return false
+			}
+		}
+
+		return fn(key, val)
+	})
+}
+
+// highKeyLimit returns the key below which reverse scans start. Entries that
+// equal the high key have the encoded high key, without array terminator, as
+// prefix. They sort before the prefix followed by byte 0xff, as no encoded
+// item starts with 0xff.
+func highKeyLimit(highkey []byte, inclusive bool) []byte {
+	limit := append([]byte(nil), highkey[:len(highkey)-1]...)
+	if inclusive {
+		limit = append(limit, 0xff)
+	}
+	return limit
+}
+
+func sendScanError(err error, cherr chan error, stopch StopChannel) {
+	common.Errorf("RangeReader: Scan failed with error %v", err)
+	select {
+	case cherr <- err:
+	case <-stopch:
+	}
+}
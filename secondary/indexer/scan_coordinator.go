@@ -10,6 +10,7 @@
 package indexer
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,8 +25,7 @@ import (
 )
 
 // TODO:
-// 1. Add distinct unsupported error
-// 2. Add unique count unsupported error
+// 1. Add unique count unsupported error
 
 // Errors
 var (
@@ -36,6 +36,8 @@ var (
 	ErrInternal           = errors.New("Internal server error occured")
 	ErrSnapNotAvailable   = errors.New("No snapshot available for scan")
 	ErrScanTimedOut       = errors.New("Index scan timed out")
+	ErrInvalidConsistency = errors.New("Invalid scan consistency")
+	ErrInvalidCursor      = errors.New("Invalid scan cursor")
 )
 
 type scanType string
@@ -98,6 +100,26 @@ func (sd scanDescriptor) String() string {
 		str += fmt.Sprintf(" limit: %d", sd.p.limit)
 	}
 
+	if sd.p.distinct {
+		str += " distinct: true"
+	}
+
+	if sd.p.reverse {
+		str += " reverse: true"
+	}
+
+	if sd.p.offset > 0 {
+		str += fmt.Sprintf(" offset: %d", sd.p.offset)
+	}
+
+	if sd.p.cursor != nil {
+		str += fmt.Sprintf(" cursor: %s", string(sd.p.cursor.GetKey()))
+	}
+
+	if sd.p.consistency != 0 {
+		str += fmt.Sprintf(" consistency: %v", sd.p.consistency)
+	}
+
 	return str
 }
 
@@ -114,12 +136,50 @@ type scanParams struct {
 	incl      Inclusion
 	limit     int64
 	pageSize  int64
+	distinct  bool
+	reverse   bool
+	desc      []bool // descending index keys
+	collation *common.Collation
+	offset    int64
+	cursor    *protobuf.ScanCursor
+	resume    Key // resume scan after this entry, see setCursor
+
+	consistency common.Consistency
+	vector      *protobuf.TsConsistency
 }
 
 type statsResponse struct {
-	min, max Key
-	unique   uint64
-	count    uint64
+	min, max  Key
+	unique    uint64
+	estimated bool // whether unique is estimated
+	count     uint64
+	bins      []statsResponse
+}
+
+func newStatsResponse(stats *RangeStatistics) statsResponse {
+	resp := statsResponse{
+		min:       stats.Min,
+		max:       stats.Max,
+		unique:    stats.Unique,
+		estimated: stats.Estimated,
+		count:     stats.Count,
+	}
+	for _, bin := range stats.Bins {
+		resp.bins = append(resp.bins, newStatsResponse(bin))
+	}
+	return resp
+}
+
+// reverse returns statistics computed on an index with descending leading
+// key, with min, max and histogram in ascending order of the leading key.
+func (stats statsResponse) reverse() statsResponse {
+	stats.min, stats.max = stats.max, stats.min
+	bins := make([]statsResponse, len(stats.bins))
+	for i, bin := range stats.bins {
+		bins[len(bins)-1-i] = bin.reverse()
+	}
+	stats.bins = bins
+	return stats
 }
 
 type countResponse struct {
@@ -270,6 +330,17 @@ type indexScanStats struct {
 	BytesRead *uint64
 	ScanTime  *int64
 	WaitTime  *int64
+
+	WaitLatency *common.LatencyHistogram // snapshot-wait phase
+	ScanLatency *common.LatencyHistogram // storage-scan phase
+	SendLatency *common.LatencyHistogram // network-send phase
+}
+
+// scanLatency is the time spent by a scan in each of its phases.
+type scanLatency struct {
+	wait time.Duration // waiting for a snapshot that satisfies the scan
+	scan time.Duration // reading entries from storage
+	send time.Duration // sending responses to client
 }
 
 type scanCoordinator struct {
@@ -286,6 +357,7 @@ type scanCoordinator struct {
 	config common.Config
 
 	scanStatsMap map[common.IndexInstId]indexScanStats
+	kvSeqnos     *kvSeqnosBatcher
 }
 
 // NewScanCoordinator returns an instance of scanCoordinator or err message
@@ -304,6 +376,10 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		config:       config,
 		scanStatsMap: make(map[common.IndexInstId]indexScanStats),
 	}
+	s.kvSeqnos = newKVSeqnosBatcher(func(bucket string) (Timestamp, error) {
+		cluster := config["clusterAddr"].String()
+		return GetCurrentKVTs(cluster, bucket, config["numVbuckets"].Int())
+	})
 
 	addr := net.JoinHostPort("", config["scanPort"].String())
 	// TODO: Move queryport config to indexer.queryport base
@@ -319,6 +395,7 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		}
 		return nil, errMsg
 	}
+	common.DefaultMetrics.OnCollect(s.collectQueryportMetrics)
 
 	// main loop
 	go s.run()
@@ -327,6 +404,14 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 
 }
 
+//collectQueryportMetrics samples the connections and requests
+//being served by the queryport server.
+func (s *scanCoordinator) collectQueryportMetrics() {
+	st := s.serv.Statistics()
+	queryportConnectionsMetric.With().Set(float64(st.Connections))
+	queryportActiveRequestsMetric.With().Set(float64(st.ActiveRequests))
+}
+
 func (s *scanCoordinator) handleStats(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 
@@ -354,6 +439,11 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 		v = fmt.Sprint(*stat.WaitTime)
 		statsMap[k] = v
 
+		prefix := fmt.Sprintf("%s:%s:", inst.Defn.Bucket, inst.Defn.Name)
+		addLatencyStats(statsMap, prefix+"snapshot_wait_latency", stat.WaitLatency)
+		addLatencyStats(statsMap, prefix+"storage_scan_latency", stat.ScanLatency)
+		addLatencyStats(statsMap, prefix+"network_send_latency", stat.SendLatency)
+
 		st := s.serv.Statistics()
 		statsMap["num_connections"] = fmt.Sprint(st.Connections)
 
@@ -443,10 +533,23 @@ func (s *scanCoordinator) parseScanParams(
 			}
 			p.keys = append(p.keys, key)
 		}
+		// Results of point queries from every slice are merged in key order
+		sortKeys(p.keys)
 
 		return nil
 	}
 
+	fillCursor := func(cursor []byte) error {
+		if len(cursor) == 0 {
+			return nil
+		}
+		p.cursor = new(protobuf.ScanCursor)
+		if err := proto.Unmarshal(cursor, p.cursor); err != nil {
+			return ErrInvalidCursor
+		}
+		return nil
+	}
+
 	switch r := req.(type) {
 	case *protobuf.StatisticsRequest:
 		p.scanType = queryStats
@@ -474,11 +577,24 @@ func (s *scanCoordinator) parseScanParams(
 		p.limit = r.GetLimit()
 		p.defnID = r.GetDefnID()
 		p.pageSize = r.GetPageSize()
+		p.distinct = r.GetDistinct()
+		p.reverse = r.GetReverse()
+		p.offset = r.GetOffset()
+		p.consistency = common.Consistency(r.GetCons())
+		p.vector = r.GetVector()
+		if err == nil {
+			err = fillCursor(r.GetCursor())
+		}
 	case *protobuf.ScanAllRequest:
 		p.scanType = queryScanAll
 		p.limit = r.GetLimit()
 		p.defnID = r.GetDefnID()
 		p.pageSize = r.GetPageSize()
+		p.reverse = r.GetReverse()
+		p.offset = r.GetOffset()
+		p.consistency = common.Consistency(r.GetCons())
+		p.vector = r.GetVector()
+		err = fillCursor(r.GetCursor())
 	default:
 		err = ErrUnsupportedRequest
 	}
@@ -486,6 +602,113 @@ func (s *scanCoordinator) parseScanParams(
 	return
 }
 
+// setKeyOrder encodes scan keys as per the sort order and collation of
+// index keys. Ranges are scanned in the sort order of the index, low and
+// high keys are swapped if the leading index key is descending.
+func (p *scanParams) setKeyOrder(desc []bool, collation *common.Collation) error {
+	if desc == nil && collation == nil {
+		return nil
+	}
+
+	var err error
+	p.desc, p.collation = desc, collation
+	p.low, err = NewKeyWithCollation(p.low.Raw(), desc, collation, true)
+	if err != nil {
+		return err
+	}
+	p.high, err = NewKeyWithCollation(p.high.Raw(), desc, collation, true)
+	if err != nil {
+		return err
+	}
+	for i, key := range p.keys {
+		p.keys[i], err = NewKeyWithCollation(key.Raw(), desc, collation, true)
+		if err != nil {
+			return err
+		}
+	}
+	sortKeys(p.keys)
+
+	if len(desc) > 0 && desc[0] {
+		p.low, p.high = p.high, p.low
+		switch p.incl {
+		case Low:
+			p.incl = High
+		case High:
+			p.incl = Low
+		}
+	}
+	return nil
+}
+
+// setCursor sets the entry after which a resumed scan continues, in the
+// sort order of the scan. Range scans are narrowed to start after the
+// entry. Distinct scans resume after the secondary key of the entry, as
+// other entries of that key are duplicates of the returned one. Must be
+// called after setKeyOrder.
+func (p *scanParams) setCursor(isPrimary bool) error {
+	if p.cursor == nil {
+		return nil
+	}
+
+	var err error
+	raw := p.cursor.GetKey()
+	if p.distinct && !isPrimary {
+		var entry []json.RawMessage
+		if err = json.Unmarshal(raw, &entry); err != nil || len(entry) < 2 {
+			return ErrInvalidCursor
+		}
+		if raw, err = json.Marshal(entry[:len(entry)-1]); err != nil {
+			return ErrInvalidCursor
+		}
+	}
+
+	// cursor of a distinct scan is a secondary key, that is a prefix of
+	// the entries, otherwise it is an entry itself.
+	entry := !p.distinct || isPrimary
+	p.resume, err = NewKeyWithCollation(raw, p.desc, p.collation, !entry)
+	if err != nil {
+		return ErrInvalidCursor
+	} else if p.resume.Encoded() == nil {
+		return ErrInvalidCursor
+	}
+
+	// point queries are resumed by resumeRange
+	if len(p.keys) != 0 {
+		return nil
+	}
+	if p.reverse {
+		p.high = p.resume
+		p.incl = p.incl &^ High
+	} else {
+		p.low = p.resume
+		p.incl = p.incl &^ Low
+	}
+	return nil
+}
+
+// resumeRange returns the range of point query for key k, after the entry
+// at which the scan is resumed. ok is false if all entries of k were
+// returned before the scan was resumed.
+func (p *scanParams) resumeRange(k Key) (low, high Key, incl Inclusion, ok bool) {
+	resume, key := p.resume.Encoded(), k.Encoded()
+	if resume == nil || key == nil {
+		return k, k, Both, true
+	}
+
+	prefix := key[:len(key)-1]
+	cmp := bytes.Compare(resume, prefix)
+	switch {
+	case bytes.HasPrefix(resume, prefix) && p.reverse:
+		return k, p.resume, Low, true
+	case bytes.HasPrefix(resume, prefix):
+		return p.resume, k, High, true
+	case p.reverse:
+		return k, k, Both, cmp > 0
+	default:
+		return k, k, Both, cmp < 0
+	}
+}
+
 // Handle query requests arriving through queryport
 func (s *scanCoordinator) requestHandler(
 	req interface{},
@@ -516,13 +739,22 @@ func (s *scanCoordinator) requestHandler(
 	}
 
 	// Update statistics
-	s.mu.RLock()
-	(*s.scanStatsMap[indexInst.InstId].Requests)++
-	s.mu.RUnlock()
+	if err == nil {
+		s.mu.RLock()
+		(*s.scanStatsMap[indexInst.InstId].Requests)++
+		s.mu.RUnlock()
+		scanRequestsMetric.With(indexInst.Defn.Bucket, indexInst.Defn.Name).Inc()
+	}
 
 	if err == nil && indexInst.State != common.INDEX_STATE_ACTIVE {
 		err = ErrIndexNotReady
 	}
+	if err == nil {
+		err = p.setKeyOrder(indexInst.Defn.Descending(), indexInst.Defn.Collation)
+	}
+	if err == nil {
+		err = p.setCursor(indexInst.Defn.IsPrimary)
+	}
 	if err != nil {
 		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, err)
 		respch <- s.makeResponseMessage(sd, err)
@@ -535,6 +767,13 @@ func (s *scanCoordinator) requestHandler(
 	// Its a primary index scan
 	sd.isPrimary = indexInst.Defn.IsPrimary
 
+	if p.ts, err = s.getResumeTimestamp(p); err != nil {
+		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, err)
+		respch <- s.makeResponseMessage(sd, err)
+		close(respch)
+		return
+	}
+
 	common.Infof("%v: SCAN_REQ %v", s.logPrefix, sd)
 	// Before starting the index scan, we have to find out the snapshot timestamp
 	// that can fullfil this query by considering atleast-timestamp provided in
@@ -575,10 +814,14 @@ func (s *scanCoordinator) requestHandler(
 		respch <- s.makeResponseMessage(sd, err)
 		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, err)
 		close(respch)
+		lat := scanLatency{wait: time.Now().Sub(startTime)}
+		s.logSlowScan(sd, nil, startTime, lat, 0, "error occured "+err.Error())
 		return
 	}
 
 	waitDuration := time.Now().Sub(startTime)
+	lat := scanLatency{wait: waitDuration}
+	var status string
 
 	common.Infof("%v: SCAN_ID: %v scan timestamp: %v",
 		s.logPrefix, sd.scanId, ScanTStoString(ts))
@@ -594,26 +837,41 @@ func (s *scanCoordinator) requestHandler(
 	switch sd.p.scanType {
 	case queryStats:
 		var msg interface{}
+		t := time.Now()
 		stat, err := rdr.ReadStat()
+		lat.scan = time.Now().Sub(t)
 		if err != nil {
 			msg = s.makeResponseMessage(sd, err)
+			status = "error occured " + err.Error()
 		} else {
+			if len(p.desc) > 0 && p.desc[0] {
+				stat = stat.reverse()
+			}
 			msg = s.makeResponseMessage(sd, stat)
+			status = "successful"
 		}
 
+		t = time.Now()
 		respch <- msg
+		lat.send = time.Now().Sub(t)
 		close(respch)
 
 	case queryCount:
 		var msg interface{}
+		t := time.Now()
 		count, err := rdr.ReadCount()
+		lat.scan = time.Now().Sub(t)
 		if err != nil {
 			msg = s.makeResponseMessage(sd, err)
+			status = "error occured " + err.Error()
 		} else {
 			msg = s.makeResponseMessage(sd, count)
+			status = "successful"
 		}
 
+		t = time.Now()
 		respch <- msg
+		lat.send = time.Now().Sub(t)
 		close(respch)
 
 	case queryScan:
@@ -623,13 +881,17 @@ func (s *scanCoordinator) requestHandler(
 		var msg interface{}
 		var done bool
 		var reqquit bool = false
-		var status string
+		// Snapshot timestamp is sent with the first response, for clients
+		// to resume the scan from an atleast as recent snapshot.
+		snapTs := protoTsConsistency(ts)
 
 		// Read scan entries and send it to the client
 		// Closing respch indicates that we have no more messages to be sent
 	loop:
 		for {
+			t := time.Now()
 			keys, done, err = rdr.ReadKeyBatch()
+			lat.scan += time.Now().Sub(t)
 			// We have already finished reading from response stream
 			if done {
 				break loop
@@ -638,10 +900,13 @@ func (s *scanCoordinator) requestHandler(
 			if err != nil {
 				msg = s.makeResponseMessage(sd, err)
 			} else {
-				msg = s.makeResponseMessage(sd, keys)
+				resp := s.makeResponseMessage(sd, keys).(*protobuf.ResponseStream)
+				resp.Snapshot, snapTs = snapTs, nil
+				msg = resp
 			}
 
 			// Send protobuf message response to queryport
+			t = time.Now()
 			select {
 			case _, ok := <-quitch:
 				if !ok {
@@ -651,6 +916,7 @@ func (s *scanCoordinator) requestHandler(
 				}
 			case respch <- msg:
 			}
+			lat.send += time.Now().Sub(t)
 
 			if err != nil {
 				break loop
@@ -671,8 +937,66 @@ func (s *scanCoordinator) requestHandler(
 		(*s.scanStatsMap[indexInst.InstId].ScanTime) += time.Now().Sub(startTime).Nanoseconds()
 		(*s.scanStatsMap[indexInst.InstId].WaitTime) += waitDuration.Nanoseconds()
 		s.mu.RUnlock()
+
+		bucket, name := indexInst.Defn.Bucket, indexInst.Defn.Name
+		scanRowsMetric.With(bucket, name).Add(float64(rdr.ReturnedRows()))
+		scanBytesMetric.With(bucket, name).Add(float64(rdr.ReturnedBytes()))
+		scanDurationMetric.With(bucket, name).ObserveSince(startTime)
 		common.Infof("%v: SCAN_ID: %v finished scan (%s)", s.logPrefix, sd.scanId, status)
 	}
+
+	s.observeScanLatency(indexInst, lat)
+	s.logSlowScan(sd, ts, startTime, lat, rdr.ReturnedRows(), status)
+}
+
+// Record the time spent in each phase of a scan on index `inst`.
+func (s *scanCoordinator) observeScanLatency(
+	inst *common.IndexInst, lat scanLatency) {
+
+	s.mu.RLock()
+	stat, ok := s.scanStatsMap[inst.InstId]
+	s.mu.RUnlock()
+	if ok {
+		stat.WaitLatency.Add(lat.wait)
+		stat.ScanLatency.Add(lat.scan)
+		stat.SendLatency.Add(lat.send)
+	}
+
+	bucket, name := inst.Defn.Bucket, inst.Defn.Name
+	scanPhaseDurationMetric.With(bucket, name, "snapshot_wait").Observe(lat.wait.Seconds())
+	scanPhaseDurationMetric.With(bucket, name, "storage_scan").Observe(lat.scan.Seconds())
+	scanPhaseDurationMetric.With(bucket, name, "network_send").Observe(lat.send.Seconds())
+}
+
+// Log scans that took longer than slowScanThreshold, along with the
+// request parameters, requested timestamp and the snapshot timestamp
+// that served the scan.
+func (s *scanCoordinator) logSlowScan(sd *scanDescriptor,
+	snapTs *common.TsVbuuid, startTime time.Time, lat scanLatency,
+	rows uint64, status string) {
+
+	threshold := s.config["slowScanThreshold"].Int()
+	total := time.Now().Sub(startTime)
+	if threshold <= 0 || total < time.Duration(threshold)*time.Millisecond {
+		return
+	}
+	common.Warnf("%v: SLOW_SCAN %v, atleast ts: %v, snapshot ts: %v, "+
+		"rows: %v, wait: %v, scan: %v, send: %v, total: %v (%s)",
+		s.logPrefix, sd, AtleastTStoString(sd.p.ts), ScanTStoString(snapTs),
+		rows, lat.wait, lat.scan, lat.send, total, status)
+}
+
+// Add mean, percentiles and max of latency histogram `h`, in nanoseconds,
+// to statsMap with keys prefixed by `prefix`.
+func addLatencyStats(statsMap map[string]string, prefix string,
+	h *common.LatencyHistogram) {
+
+	statsMap[prefix+"_mean"] = fmt.Sprint(h.Mean().Nanoseconds())
+	statsMap[prefix+"_p50"] = fmt.Sprint(h.Percentile(50).Nanoseconds())
+	statsMap[prefix+"_p90"] = fmt.Sprint(h.Percentile(90).Nanoseconds())
+	statsMap[prefix+"_p99"] = fmt.Sprint(h.Percentile(99).Nanoseconds())
+	statsMap[prefix+"_p999"] = fmt.Sprint(h.Percentile(99.9).Nanoseconds())
+	statsMap[prefix+"_max"] = fmt.Sprint(h.Max().Nanoseconds())
 }
 
 func ProtoIndexEntryFromKey(k Key, isPrimary bool) *protobuf.IndexEntry {
@@ -711,6 +1035,37 @@ func ProtoIndexEntryFromKey(k Key, isPrimary bool) *protobuf.IndexEntry {
 	return entry
 }
 
+// ProtoIndexStatistics converts statistics computed by the index snapshot
+// to protobuf, min and max keys are returned as secondary keys.
+func ProtoIndexStatistics(stats statsResponse,
+	isPrimary bool) *protobuf.IndexStatistics {
+
+	// Entries of primary index are [docid], which is also the key
+	statsKey := func(k Key) []byte {
+		if stats.count == 0 {
+			return []byte{}
+		} else if isPrimary {
+			return k.Raw()
+		}
+		return ProtoIndexEntryFromKey(k, isPrimary).GetEntryKey()
+	}
+
+	pstats := &protobuf.IndexStatistics{
+		KeysCount:       proto.Uint64(stats.count),
+		UniqueKeysCount: proto.Uint64(stats.unique),
+		KeyMin:          statsKey(stats.min),
+		KeyMax:          statsKey(stats.max),
+	}
+	if stats.estimated {
+		pstats.UniqueEstimated = proto.Bool(true)
+	}
+	for _, bin := range stats.bins {
+		pstats.Histogram = append(pstats.Histogram,
+			ProtoIndexStatistics(bin, isPrimary))
+	}
+	return pstats
+}
+
 // Create a queryport response message
 // Response message can be StreamResponse or StatisticsResponse
 func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
@@ -753,12 +1108,7 @@ func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
 	case statsResponse:
 		stats := payload.(statsResponse)
 		r = &protobuf.StatisticsResponse{
-			Stats: &protobuf.IndexStatistics{
-				KeysCount:       proto.Uint64(stats.count),
-				UniqueKeysCount: proto.Uint64(stats.unique),
-				KeyMin:          stats.min.Raw(),
-				KeyMax:          stats.max.Raw(),
-			},
+			Stats: ProtoIndexStatistics(stats, sd.isPrimary),
 		}
 	case countResponse:
 		counts := payload.(countResponse)
@@ -767,6 +1117,154 @@ func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
 	return
 }
 
+// Compute the atleast-timestamp that a snapshot should satisfy for serving
+// the scan request, as per the consistency requested by the client.
+// - AnyConsistency, no timestamp, serve from the latest snapshot.
+// - SessionConsistency, current KV seqnos for the bucket, this gives
+//   read-your-own-write semantics for writes done before the request.
+// - QueryConsistency, timestamp vector supplied along with the request.
+func (s *scanCoordinator) getScanTimestamp(
+	p *scanParams) (*common.TsVbuuid, error) {
+
+	numVbuckets := s.config["numVbuckets"].Int()
+
+	switch p.consistency {
+	case 0, common.AnyConsistency:
+		return nil, nil
+
+	case common.SessionConsistency:
+		kvTs, err := s.kvSeqnos.get(p.bucket)
+		if err != nil {
+			return nil, err
+		}
+		ts := common.NewTsVbuuid(p.bucket, numVbuckets)
+		for i, seqno := range kvTs {
+			ts.Seqnos[i] = uint64(seqno)
+		}
+		return ts, nil
+
+	case common.QueryConsistency:
+		if p.vector == nil {
+			return nil, ErrInvalidConsistency
+		}
+		return p.vector.ToTsVbuuid(p.bucket, numVbuckets)
+	}
+	return nil, ErrInvalidConsistency
+}
+
+//kvSeqnosBatcher fetches current KV seqnos of buckets for session
+//consistent scans. Concurrent scans on a bucket are batched behind a
+//single in-flight fetch, so that request_plus load does not open a KV
+//connection per scan. A scan never shares a fetch that started before
+//it arrived, as that can miss its own writes, instead it waits for the
+//in-flight fetch and joins the next one.
+type kvSeqnosBatcher struct {
+	mu      sync.Mutex
+	buckets map[string]*kvSeqnosBatch
+	fetch   func(bucket string) (Timestamp, error)
+}
+
+type kvSeqnosBatch struct {
+	running *kvSeqnosFetch //fetch in flight
+	next    *kvSeqnosFetch //fetch to start once running is done
+}
+
+type kvSeqnosFetch struct {
+	donech chan bool
+	ts     Timestamp
+	err    error
+}
+
+func newKVSeqnosBatcher(
+	fetch func(bucket string) (Timestamp, error)) *kvSeqnosBatcher {
+
+	return &kvSeqnosBatcher{
+		buckets: make(map[string]*kvSeqnosBatch),
+		fetch:   fetch,
+	}
+}
+
+//get returns current KV seqnos of `bucket`, the timestamp is shared by
+//all scans of the batch and shall not be modified.
+func (kb *kvSeqnosBatcher) get(bucket string) (Timestamp, error) {
+	kb.mu.Lock()
+	batch, ok := kb.buckets[bucket]
+	if !ok {
+		batch = &kvSeqnosBatch{}
+		kb.buckets[bucket] = batch
+	}
+	if f := batch.next; f != nil {
+		kb.mu.Unlock()
+		<-f.donech
+		return f.ts, f.err
+	}
+
+	f := &kvSeqnosFetch{donech: make(chan bool)}
+	if running := batch.running; running != nil {
+		batch.next = f
+		kb.mu.Unlock()
+		<-running.donech
+		kb.mu.Lock()
+		batch.next = nil
+	}
+	batch.running = f
+	kb.mu.Unlock()
+
+	f.ts, f.err = kb.fetch(bucket)
+
+	kb.mu.Lock()
+	batch.running = nil
+	kb.mu.Unlock()
+	close(f.donech)
+	return f.ts, f.err
+}
+
+// Compute the atleast-timestamp for the scan request, resumed scans are
+// served from a snapshot atleast as recent as the snapshot of the cursor,
+// in addition to the requested consistency.
+func (s *scanCoordinator) getResumeTimestamp(
+	p *scanParams) (*common.TsVbuuid, error) {
+
+	ts, err := s.getScanTimestamp(p)
+	if err != nil || p.cursor.GetSnapshot() == nil {
+		return ts, err
+	}
+
+	numVbuckets := s.config["numVbuckets"].Int()
+	cursorTs, err := p.cursor.GetSnapshot().ToTsVbuuid(p.bucket, numVbuckets)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	} else if ts == nil {
+		return cursorTs, nil
+	}
+	for i, seqno := range cursorTs.Seqnos {
+		if seqno > ts.Seqnos[i] {
+			ts.Seqnos[i] = seqno
+			ts.Vbuuids[i] = cursorTs.Vbuuids[i]
+		}
+	}
+	return ts, nil
+}
+
+// protoTsConsistency returns timestamp vector for vbuckets of `ts` with
+// non-zero seqnos.
+func protoTsConsistency(ts *common.TsVbuuid) *protobuf.TsConsistency {
+	if ts == nil {
+		return nil
+	}
+
+	var vbnos []uint16
+	var seqnos, vbuuids []uint64
+	for i, seqno := range ts.Seqnos {
+		if seqno != 0 {
+			vbnos = append(vbnos, uint16(i))
+			seqnos = append(seqnos, seqno)
+			vbuuids = append(vbuuids, ts.Vbuuids[i])
+		}
+	}
+	return protobuf.NewTsConsistency(vbnos, seqnos, vbuuids)
+}
+
 // Find and return data structures for the specified index
 func (s *scanCoordinator) findIndexInstance(
 	defnID uint64) (*common.IndexInst, error) {
@@ -787,24 +1285,66 @@ func (s *scanCoordinator) findIndexInstance(
 }
 
 // Scan entries from the target partitions from index snapshot
-// Scan entries/errors are written back into sd.respch channel
+// Every slice of every partition is scanned by a separate worker and their
+// results are merged (for scans) or reduced (for counts and statistics)
+// before writing back into sd.respch channel.
 func (s *scanCoordinator) scanIndexSnapshot(sd *scanDescriptor, snap IndexSnapshot) {
-	// TODO: Multiple partition scanner needs a stream merger/stats reducer to
-	// work with multiple partitions and slices.
 	common.Debugf("%v: scanIndexSnapshot: SCAN_ID: %v instance_id: %v",
 		s.logPrefix, sd.scanId, snap.IndexInstId())
 
 	var wg sync.WaitGroup
 	var workerStopChannels []StopChannel
+	var workerChannels []chan interface{}
 
 	for _, ps := range snap.Partitions() {
-		wg.Add(1)
-		stopch := make(StopChannel)
-		workerStopChannels = append(workerStopChannels, stopch)
-		go s.scanPartitionSnapshot(sd, ps, stopch, &wg)
+		common.Debugf("%v: scanIndexSnapshot: SCAN_ID: %v partition: %v",
+			s.logPrefix, sd.scanId, ps.PartitionId())
+
+		for _, ss := range ps.Slices() {
+			wg.Add(1)
+			stopch := make(StopChannel)
+			workerch := make(chan interface{}, scanWorkerBufSize)
+			workerStopChannels = append(workerStopChannels, stopch)
+			workerChannels = append(workerChannels, workerch)
+			go s.scanSliceSnapshot(sd, ss, workerch, stopch, &wg)
+		}
 	}
 
-	s.monitorWorkers(&wg, sd.stopch, workerStopChannels, "scanPartitions")
+	// Workers are stopped either when the client stops reading results or
+	// when merger has no use of further results (eg. limit is reached).
+	stopch := make(StopChannel)
+	mergeDonech := make(DoneChannel)
+	go func(upstream StopChannel) {
+		select {
+		case <-upstream:
+		case <-mergeDonech:
+		}
+		close(stopch)
+	}(sd.stopch)
+
+	monitorDonech := make(DoneChannel)
+	go func() {
+		s.monitorWorkers(&wg, stopch, workerStopChannels, "scanIndexSnapshot")
+		close(monitorDonech)
+	}()
+
+	switch sd.p.scanType {
+	case queryStats:
+		s.reduceStats(sd, workerChannels)
+	case queryCount:
+		s.reduceCounts(sd, workerChannels)
+	case queryScan, queryScanAll:
+		s.mergeKeys(sd, workerChannels)
+	}
+	close(mergeDonech)
+
+	// Drain leftover results to unblock the workers
+	for _, workerch := range workerChannels {
+		for _ = range workerch {
+		}
+	}
+	<-monitorDonech
+
 	// We have no more responses to be sent
 	close(sd.respch)
 }
@@ -846,60 +1386,60 @@ func (s *scanCoordinator) monitorWorkers(wg *sync.WaitGroup,
 
 }
 
-func (s *scanCoordinator) scanPartitionSnapshot(sd *scanDescriptor,
-	snap PartitionSnapshot, stopch StopChannel, wg *sync.WaitGroup) {
-
-	defer wg.Done()
-	common.Debugf("%v: scanPartitionSnapshot: SCAN_ID: %v partition: %v",
-		s.logPrefix, sd.scanId, snap.PartitionId())
-
-	var workerWg sync.WaitGroup
-	var workerStopChannels []StopChannel
-
-	for _, sliceSnap := range snap.Slices() {
-		workerWg.Add(1)
-		workerStopCh := make(StopChannel)
-		workerStopChannels = append(workerStopChannels, workerStopCh)
-		go s.scanSliceSnapshot(sd, sliceSnap, workerStopCh, &workerWg)
-	}
-
-	s.monitorWorkers(&workerWg, stopch, workerStopChannels, "scanPartitionSnapshot")
-}
-
 func (s *scanCoordinator) scanSliceSnapshot(sd *scanDescriptor,
-	ss SliceSnapshot, stopch StopChannel, wg *sync.WaitGroup) {
+	ss SliceSnapshot, respch chan<- interface{}, stopch StopChannel,
+	wg *sync.WaitGroup) {
 
 	defer wg.Done()
+	defer close(respch)
 	common.Debugf("%v: scanLocalSlice: SCAN_ID: %v Slice : %v",
 		s.logPrefix, sd.scanId, ss.SliceId())
 
 	switch sd.p.scanType {
 	case queryStats:
-		s.queryStats(sd, ss.Snapshot(), stopch)
+		s.queryStats(sd, ss.Snapshot(), respch, stopch)
 	case queryCount:
-		s.queryCount(sd, ss.Snapshot(), stopch)
+		s.queryCount(sd, ss.Snapshot(), respch, stopch)
 	case queryScan:
-		s.queryScan(sd, ss.Snapshot(), stopch)
+		s.queryScan(sd, ss.Snapshot(), respch, stopch)
 	case queryScanAll:
-		s.queryScanAll(sd, ss.Snapshot(), stopch)
+		s.queryScanAll(sd, ss.Snapshot(), respch, stopch)
 	}
 
 	ss.Snapshot().Close()
 }
 
-func (s *scanCoordinator) queryStats(sd *scanDescriptor, snap Snapshot, stopch StopChannel) {
-	totalRows, err := snap.CountRange(sd.p.low, sd.p.high, sd.p.incl, stopch)
-	// TODO: Implement min, max, unique (maybe)
-	if err != nil {
-		sd.respch <- err
+func (s *scanCoordinator) queryStats(sd *scanDescriptor, snap Snapshot,
+	respch chan<- interface{}, stopch StopChannel) {
+
+	nbins := s.config["statisticsBins"].Int()
+
+	if len(sd.p.keys) != 0 { // handle lookup statistics
+		var all []*RangeStatistics
+		for _, k := range sd.p.keys {
+			stats, err := snap.StatisticsRange(k, k, Both, nbins, stopch)
+			if err != nil {
+				respch <- err
+				return
+			}
+			all = append(all, stats)
+		}
+		respch <- mergeStatistics(all, nbins)
+
 	} else {
-		min, _ := NewKey([]byte("min"))
-		max, _ := NewKey([]byte("max"))
-		sd.respch <- statsResponse{count: totalRows, min: min, max: max}
+		stats, err := snap.StatisticsRange(sd.p.low, sd.p.high, sd.p.incl,
+			nbins, stopch)
+		if err != nil {
+			respch <- err
+		} else {
+			respch <- stats
+		}
 	}
 }
 
-func (s *scanCoordinator) queryCount(sd *scanDescriptor, snap Snapshot, stopch StopChannel) {
+func (s *scanCoordinator) queryCount(sd *scanDescriptor, snap Snapshot,
+	respch chan<- interface{}, stopch StopChannel) {
+
 	p := sd.p
 	lowkey, highkey := p.low.Encoded(), p.high.Encoded()
 	if p.keys != nil && len(p.keys) > 0 { // handle lookup counts
@@ -907,52 +1447,87 @@ func (s *scanCoordinator) queryCount(sd *scanDescriptor, snap Snapshot, stopch S
 		for _, key := range p.keys {
 			count, err := snap.CountRange(key, key, Both, stopch)
 			if err != nil {
-				sd.respch <- err
-				break
+				respch <- err
+				return
 			}
 			allCounts += count
 		}
-		sd.respch <- countResponse{count: int64(allCounts)}
+		respch <- countResponse{count: int64(allCounts)}
 
 	} else if lowkey != nil || highkey != nil { // handle range counts
 		count, err := snap.CountRange(p.low, p.high, p.incl, stopch)
 		if err != nil {
-			sd.respch <- err
+			respch <- err
+		} else {
+			respch <- countResponse{count: int64(count)}
 		}
-		sd.respch <- countResponse{count: int64(count)}
 
 	} else { // handle full total
 		count, err := snap.CountTotal(stopch)
 		if err != nil {
-			sd.respch <- err
+			respch <- err
 		} else {
-			sd.respch <- countResponse{count: int64(count)}
+			respch <- countResponse{count: int64(count)}
 		}
 	}
 }
 
-func (s *scanCoordinator) queryScan(sd *scanDescriptor, snap Snapshot, stopch StopChannel) {
+func (s *scanCoordinator) queryScan(sd *scanDescriptor, snap Snapshot,
+	respch chan<- interface{}, stopch StopChannel) {
+
+	// Entries of primary index are unique by themselves
+	distinct := sd.p.distinct && !sd.isPrimary
+	keyRange := snap.KeyRange
+	if sd.p.reverse && distinct {
+		keyRange = snap.ReverseDistinctKeyRange
+	} else if sd.p.reverse {
+		keyRange = snap.ReverseKeyRange
+	} else if distinct {
+		keyRange = snap.DistinctKeyRange
+	}
+
 	// TODO: Decide whether a missing response should be provided point query for keys
 	if len(sd.p.keys) != 0 {
-		for _, k := range sd.p.keys {
-			ch, cherr, _ := snap.KeyRange(k, k, Both, stopch)
-			s.receiveKeys(sd, ch, cherr)
+		for i := range sd.p.keys {
+			k := sd.p.keys[i]
+			if sd.p.reverse {
+				k = sd.p.keys[len(sd.p.keys)-1-i]
+			}
+			if low, high, incl, ok := sd.p.resumeRange(k); ok {
+				ch, cherr, _ := keyRange(low, high, incl, stopch)
+				s.receiveKeys(sd, ch, cherr, respch)
+			}
 		}
 	} else {
-		ch, cherr, _ := snap.KeyRange(sd.p.low, sd.p.high, sd.p.incl, stopch)
-		s.receiveKeys(sd, ch, cherr)
+		ch, cherr, _ := keyRange(sd.p.low, sd.p.high, sd.p.incl, stopch)
+		s.receiveKeys(sd, ch, cherr, respch)
 	}
 
 }
 
-func (s *scanCoordinator) queryScanAll(sd *scanDescriptor, snap Snapshot, stopch StopChannel) {
+func (s *scanCoordinator) queryScanAll(sd *scanDescriptor, snap Snapshot,
+	respch chan<- interface{}, stopch StopChannel) {
+
+	// Resumed scans start after the cursor, see setCursor
+	if sd.p.reverse {
+		ch, cherr, _ := snap.ReverseKeyRange(sd.p.low, sd.p.high, sd.p.incl, stopch)
+		s.receiveKeys(sd, ch, cherr, respch)
+		return
+	} else if sd.p.cursor != nil {
+		ch, cherr, _ := snap.KeyRange(sd.p.low, sd.p.high, sd.p.incl, stopch)
+		s.receiveKeys(sd, ch, cherr, respch)
+		return
+	}
+
 	ch, cherr := snap.KeySet(stopch)
-	s.receiveKeys(sd, ch, cherr)
+	s.receiveKeys(sd, ch, cherr, respch)
 }
 
 // receiveKeys receives results/errors from snapshot reader and forwards it to
 // the caller till the result channel is closed by the snapshot reader
-func (s *scanCoordinator) receiveKeys(sd *scanDescriptor, chkey chan Key, cherr chan error) {
+func (s *scanCoordinator) receiveKeys(sd *scanDescriptor, chkey chan Key,
+	cherr chan error, respch chan<- interface{}) {
+
 	ok := true
 	var key Key
 	var err error
@@ -963,11 +1538,11 @@ func (s *scanCoordinator) receiveKeys(sd *scanDescriptor, chkey chan Key, cherr
 			if ok {
 				common.Tracef("%v: SCAN_ID: %v Received key: %v)",
 					s.logPrefix, sd.scanId, string(key.Raw()))
-				sd.respch <- key
+				respch <- key
 			}
 		case err, _ = <-cherr:
 			if err != nil {
-				sd.respch <- err
+				respch <- err
 			}
 		}
 	}
@@ -979,12 +1554,16 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 
 	common.Infof("ScanCoordinator::handleUpdateIndexInstMap %v", cmd)
 	indexInstMap := cmd.(*MsgUpdateInstMap).GetIndexInstMap()
+	oldInstMap := s.indexInstMap
 	s.indexInstMap = common.CopyIndexInstMap(indexInstMap)
 
 	// Remove invalid indexes
 	for instId, _ := range s.scanStatsMap {
 		if _, ok := s.indexInstMap[instId]; !ok {
 			delete(s.scanStatsMap, instId)
+			if inst, ok := oldInstMap[instId]; ok {
+				deleteScanMetrics(inst.Defn.Bucket, inst.Defn.Name)
+			}
 		}
 	}
 
@@ -997,6 +1576,10 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 				BytesRead: new(uint64),
 				ScanTime:  new(int64),
 				WaitTime:  new(int64),
+
+				WaitLatency: common.NewLatencyHistogram(),
+				ScanLatency: common.NewLatencyHistogram(),
+				SendLatency: common.NewLatencyHistogram(),
 			}
 		}
 	}
@@ -1072,3 +1655,25 @@ func ScanTStoString(ts *common.TsVbuuid) string {
 
 	return seqsStr
 }
+
+// Helper method to pretty print atleast-timestamp of a scan request,
+// only vbuckets with non-zero seqnos are printed.
+func AtleastTStoString(ts *common.TsVbuuid) string {
+	if ts == nil {
+		return "none"
+	}
+
+	var seqsStr string = "["
+	for i, seqno := range ts.Seqnos {
+		if seqno == 0 {
+			continue
+		}
+		if len(seqsStr) > 1 {
+			seqsStr += ","
+		}
+		seqsStr += fmt.Sprintf("%d=%d", i, seqno)
+	}
+	seqsStr += "]"
+
+	return seqsStr
+}
@@ -160,6 +160,10 @@ func (s *mockSlice) Insert(k Key, v Value) error {
 	return s.err
 }
 
+func (s *mockSlice) InsertArray(k []Key, v Value) error {
+	return s.err
+}
+
 func (s *mockSlice) Delete(d []byte) error {
 	return s.err
 }
@@ -262,6 +266,24 @@ func (s *mockSnapshot) KeyRange(low, high Key, inclusion Inclusion,
 	return s.keych, s.errch, s.order
 }
 
+func (s *mockSnapshot) DistinctKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	return s.KeyRange(low, high, inclusion, stopch)
+}
+
+func (s *mockSnapshot) ReverseKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	return s.KeyRange(low, high, inclusion, stopch)
+}
+
+func (s *mockSnapshot) ReverseDistinctKeyRange(low, high Key, inclusion Inclusion,
+	stopch StopChannel) (chan Key, chan error, SortOrder) {
+
+	return s.KeyRange(low, high, inclusion, stopch)
+}
+
 func (s *mockSnapshot) ValueRange(low, high Key, inclusion Inclusion,
 	stopch StopChannel) (chan Value, chan error, SortOrder) {
 	s.valch = make(chan Value)
@@ -271,7 +293,8 @@ func (s *mockSnapshot) ValueRange(low, high Key, inclusion Inclusion,
 }
 
 func (s *mockSnapshot) GetKeySetForKeyRange(low Key, high Key,
-	inclusion Inclusion, chkey chan Key, cherr chan error, stopch StopChannel) {
+	inclusion Inclusion, distinct bool, chkey chan Key, cherr chan error,
+	stopch StopChannel) {
 	panic("not implemented")
 }
 
@@ -303,6 +326,39 @@ loop:
 	return s.count, s.err
 }
 
+func (s *mockSnapshot) StatisticsRange(low, high Key, inclusion Inclusion,
+	nbins int, stopch StopChannel) (*RangeStatistics, error) {
+
+	var depth uint64
+	if nbins > 0 {
+		count, err := s.CountRange(low, high, inclusion, stopch)
+		if err != nil {
+			return nil, err
+		}
+		depth = histogramDepth(count, nbins)
+	}
+
+	s.keych = make(chan Key)
+	s.errch = make(chan error)
+	go s.feeder(s.keych, nil, s.errch)
+
+	builder := newStatsBuilder(depth)
+loop:
+	for {
+		select {
+		case s.err, _ = <-s.errch:
+			break loop
+		case key, ok := <-s.keych:
+			if !ok {
+				break loop
+			}
+			builder.add(key)
+		}
+	}
+
+	return builder.statistics(), s.err
+}
+
 func (s *mockSnapshot) Open() error {
 	return nil
 }
@@ -8,7 +8,9 @@ import (
 	queryclient "github.com/couchbase/indexing/secondary/queryport/client"
 	"github.com/couchbaselabs/goprotobuf/proto"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const QUERY_PORT_ADDR = ":7000"
@@ -114,7 +116,7 @@ func TestInvalidIndexScan(t *testing.T) {
 	if err != nil {
 		t.Errorf("cannot create GsiClient %v", err)
 	}
-	client.ScanAll(uint64(defnId), 40, verifyInvalidIndex)
+	client.ScanAll(uint64(defnId), 40, c.AnyConsistency, nil, verifyInvalidIndex)
 	client.Close()
 
 }
@@ -139,7 +141,7 @@ func TestIndexScan(t *testing.T) {
 	}
 	low := c.SecondaryKey{"low"}
 	high := c.SecondaryKey{"high"}
-	client.Range(uint64(defnId), low, high, queryclient.Inclusion(Both), false, 0, verifyIndexScanAll)
+	client.Range(uint64(defnId), low, high, queryclient.Inclusion(Both), false, 0, c.AnyConsistency, nil, verifyIndexScanAll)
 	client.Close()
 	if count != nkeys {
 		t.Error("Scan result entries count mismatch", count, "!=", nkeys)
@@ -163,7 +165,7 @@ func TestIndexScanAll(t *testing.T) {
 	if err != nil {
 		t.Errorf("cannot create GsiClient %v", err)
 	}
-	client.ScanAll(uint64(defnId), 0, verifyIndexScanAll)
+	client.ScanAll(uint64(defnId), 0, c.AnyConsistency, nil, verifyIndexScanAll)
 	client.Close()
 	if count != nkeys {
 		t.Error("Scan result entries count mismatch", count, "!=", nkeys)
@@ -187,7 +189,7 @@ func TestIndexScanAllLimit(t *testing.T) {
 	if err != nil {
 		t.Errorf("cannot create GsiClient %v", err)
 	}
-	client.ScanAll(uint64(defnId), 100, verifyIndexScanAll)
+	client.ScanAll(uint64(defnId), 100, c.AnyConsistency, nil, verifyIndexScanAll)
 	client.Close()
 	if count != 100 {
 		t.Error("Scan result entries count mismatch", count, "!=", 100)
@@ -211,7 +213,7 @@ func TestScanEmptyIndex(t *testing.T) {
 	if err != nil {
 		t.Errorf("cannot create GsiClient %v", err)
 	}
-	client.ScanAll(uint64(defnId), 0, verifyIndexScanAll)
+	client.ScanAll(uint64(defnId), 0, c.AnyConsistency, nil, verifyIndexScanAll)
 	client.Close()
 	if count != 0 {
 		t.Error("Scan result entries count mismatch", count, "!=", nkeys)
@@ -236,7 +238,7 @@ func TestIndexScanErrors(t *testing.T) {
 	if err != nil {
 		t.Errorf("cannot create GsiClient %v", err)
 	}
-	client.ScanAll(uint64(defnId), 0, verifyIndexScanAll)
+	client.ScanAll(uint64(defnId), 0, c.AnyConsistency, nil, verifyIndexScanAll)
 
 	if count != 100 {
 		t.Error("Scan result entries count mismatch", count, "!=", 100)
@@ -250,7 +252,7 @@ func TestIndexScanErrors(t *testing.T) {
 	nerrors = 0
 	nkeys = 0
 
-	client.ScanAll(uint64(defnId), 0, verifyIndexScanAll)
+	client.ScanAll(uint64(defnId), 0, c.AnyConsistency, nil, verifyIndexScanAll)
 	if count != 0 {
 		t.Error("Scan result entries count mismatch", count, "!=", 0)
 	}
@@ -303,14 +305,17 @@ func TestStatistics(t *testing.T) {
 	tst = t
 	nkeys = 10000
 
+	minKey, _ := json.Marshal(testSK(0))
+	maxKey, _ := json.Marshal(testSK(nkeys - 1))
 	testStatisticsResponse := &protobuf.StatisticsResponse{
 		Stats: &protobuf.IndexStatistics{
 			KeysCount:       proto.Uint64(uint64(nkeys)),
-			UniqueKeysCount: proto.Uint64(0),
-			KeyMin:          []byte("min"),
-			KeyMax:          []byte("max"),
+			UniqueKeysCount: proto.Uint64(uint64(nkeys)),
+			KeyMin:          minKey,
+			KeyMax:          maxKey,
 		},
 	}
+	nbins := c.SystemConfig["indexer.statisticsBins"].Int()
 
 	defnId := h.createIndex("idx", "default", simpleKeyFeeder)
 	config := c.SystemConfig.SectionConfig("queryport.client.", true)
@@ -321,11 +326,28 @@ func TestStatistics(t *testing.T) {
 	low := c.SecondaryKey{"low"}
 	high := c.SecondaryKey{"high"}
 	out, err := client.RangeStatistics(uint64(defnId), low, high, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	if reflect.DeepEqual(out, testStatisticsResponse.GetStats()) == false {
+	stats := out.(*protobuf.IndexStatistics)
+	bins := stats.GetHistogram()
+	stats.Histogram = nil
+	if reflect.DeepEqual(stats, testStatisticsResponse.GetStats()) == false {
 		t.Errorf("Unexpected stats response %v", out)
 	}
 
+	if len(bins) != nbins {
+		t.Errorf("Expected %v histogram bins, got %v", nbins, len(bins))
+	}
+	binCount := uint64(0)
+	for _, bin := range bins {
+		binCount += bin.GetKeysCount()
+	}
+	if binCount != uint64(nkeys) {
+		t.Errorf("Histogram bins count mismatch %v != %v", binCount, nkeys)
+	}
+
 	client.Close()
 }
 
@@ -355,3 +377,95 @@ func TestStatisticsError(t *testing.T) {
 
 	client.Close()
 }
+
+func TestScanTimestamp(t *testing.T) {
+	s := &scanCoordinator{config: c.SystemConfig.SectionConfig("indexer.", true)}
+	numVbuckets := s.config["numVbuckets"].Int()
+
+	p := &scanParams{bucket: "default", consistency: c.AnyConsistency}
+	if ts, err := s.getScanTimestamp(p); ts != nil || err != nil {
+		t.Fatalf("expected no timestamp for any consistency, got %v %v", ts, err)
+	}
+	// requests from clients that do not send consistency.
+	p.consistency = 0
+	if ts, err := s.getScanTimestamp(p); ts != nil || err != nil {
+		t.Fatalf("expected no timestamp without consistency, got %v %v", ts, err)
+	}
+
+	p.consistency = c.QueryConsistency
+	if _, err := s.getScanTimestamp(p); err != ErrInvalidConsistency {
+		t.Fatalf("expected %v without vector, got %v", ErrInvalidConsistency, err)
+	}
+	p.vector = protobuf.NewTsConsistency(
+		[]uint16{1, 7}, []uint64{100, 700}, []uint64{11, 77})
+	ts, err := s.getScanTimestamp(p)
+	if err != nil {
+		t.Fatal(err)
+	} else if ts.Bucket != "default" || len(ts.Seqnos) != numVbuckets {
+		t.Fatalf("unexpected timestamp %v", ts)
+	} else if ts.Seqnos[1] != 100 || ts.Vbuuids[7] != 77 || ts.Seqnos[0] != 0 {
+		t.Fatalf("unexpected timestamp %v", ts)
+	}
+
+	p.vector = protobuf.NewTsConsistency([]uint16{uint16(numVbuckets)},
+		[]uint64{1}, []uint64{1})
+	if _, err := s.getScanTimestamp(p); err != protobuf.ErrorInvalidVector {
+		t.Fatalf("expected %v, got %v", protobuf.ErrorInvalidVector, err)
+	}
+
+	p.consistency = c.Consistency(100)
+	if _, err := s.getScanTimestamp(p); err != ErrInvalidConsistency {
+		t.Fatalf("expected %v, got %v", ErrInvalidConsistency, err)
+	}
+}
+
+func TestKVSeqnosBatch(t *testing.T) {
+	var fetches int32
+	releasech := make(chan bool)
+	kb := newKVSeqnosBatcher(func(bucket string) (Timestamp, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		<-releasech
+		return Timestamp{Seqno(n)}, nil
+	})
+	get := func(tsch chan Timestamp) {
+		ts, err := kb.get("default")
+		if err != nil {
+			t.Error(err)
+		}
+		tsch <- ts
+	}
+
+	first := make(chan Timestamp, 1)
+	go get(first)
+	for atomic.LoadInt32(&fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// scans arriving during a fetch wait for it and share the next one.
+	batched := make(chan Timestamp, 3)
+	for i := 0; i < 3; i++ {
+		go get(batched)
+	}
+	for next := false; !next; {
+		time.Sleep(10 * time.Millisecond)
+		kb.mu.Lock()
+		next = kb.buckets["default"].next != nil
+		kb.mu.Unlock()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	releasech <- true
+	if ts := <-first; ts[0] != 1 {
+		t.Fatalf("expected seqnos of fetch 1, got %v", ts)
+	}
+	close(releasech)
+	for i := 0; i < 3; i++ {
+		if ts := <-batched; ts[0] != 2 {
+			t.Fatalf("expected seqnos of fetch 2, got %v", ts)
+		}
+	}
+
+	// a scan after the batch starts a new fetch.
+	if ts, err := kb.get("default"); err != nil || ts[0] != 3 {
+		t.Fatalf("expected seqnos of fetch 3, got %v %v", ts, err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Number of responses buffered by every scan worker, so that workers can
+// make progress while merger is waiting on other workers.
+const scanWorkerBufSize = 256
+
+// mergeKeys performs a k-way merge of sorted key streams, one from every scan
+// worker, and forwards keys into sd.respch in collation order, or reverse
+// collation order for reverse scans. First offset number of keys are
+// skipped. It returns when all the streams are exhausted, when a worker
+// reports an error or when limit number of keys are forwarded.
+func (s *scanCoordinator) mergeKeys(sd *scanDescriptor,
+	workerChannels []chan interface{}) {
+
+	items := make(keyHeap, 0, len(workerChannels))
+	var h heap.Interface = &items
+	if sd.p.reverse {
+		h = &reverseKeyHeap{items}
+	}
+
+	// Read next key from the i-th worker into the heap
+	next := func(i int) error {
+		resp, ok := <-workerChannels[i]
+		if !ok {
+			return nil
+		}
+
+		switch val := resp.(type) {
+		case Key:
+			heap.Push(h, keyHeapItem{key: val, src: i})
+		case error:
+			return val
+		}
+		return nil
+	}
+
+	for i := range workerChannels {
+		if err := next(i); err != nil {
+			sd.respch <- err
+			return
+		}
+	}
+
+	// Same secondary key can be present in more than one slice
+	var filter *distinctFilter
+	if sd.p.distinct && !sd.isPrimary {
+		filter = new(distinctFilter)
+	}
+
+	var count, skipped int64
+	for h.Len() > 0 {
+		item := heap.Pop(h).(keyHeapItem)
+		if filter.skip(item.key) {
+			// duplicate of a previous key
+		} else if skipped < sd.p.offset {
+			skipped++
+		} else {
+			sd.respch <- item.key
+			count++
+			if sd.p.limit > 0 && count >= sd.p.limit {
+				return
+			}
+		}
+
+		if err := next(item.src); err != nil {
+			sd.respch <- err
+			return
+		}
+	}
+}
+
+// reduceCounts adds up counts from all the scan workers into a single count
+// response.
+func (s *scanCoordinator) reduceCounts(sd *scanDescriptor,
+	workerChannels []chan interface{}) {
+
+	var total int64
+	for _, workerch := range workerChannels {
+		for resp := range workerch {
+			switch val := resp.(type) {
+			case countResponse:
+				total += val.count
+			case error:
+				sd.respch <- val
+				return
+			}
+		}
+	}
+	sd.respch <- countResponse{count: total}
+}
+
+// reduceStats merges statistics from all the scan workers into a single
+// statistics response.
+func (s *scanCoordinator) reduceStats(sd *scanDescriptor,
+	workerChannels []chan interface{}) {
+
+	var all []*RangeStatistics
+	for _, workerch := range workerChannels {
+		for resp := range workerch {
+			switch val := resp.(type) {
+			case *RangeStatistics:
+				all = append(all, val)
+			case error:
+				sd.respch <- val
+				return
+			}
+		}
+	}
+	nbins := s.config["statisticsBins"].Int()
+	sd.respch <- newStatsResponse(mergeStatistics(all, nbins))
+}
+
+// sortKeys sorts keys in collation order.
+func sortKeys(keys []Key) {
+	sort.Sort(keySorter(keys))
+}
+
+type keySorter []Key
+
+func (ks keySorter) Len() int {
+	return len(ks)
+}
+
+func (ks keySorter) Less(i, j int) bool {
+	return ks[i].Compare(ks[j]) < 0
+}
+
+func (ks keySorter) Swap(i, j int) {
+	ks[i], ks[j] = ks[j], ks[i]
+}
+
+// keyHeap implements heap.Interface to order head keys of worker streams.
+type keyHeapItem struct {
+	key Key
+	src int // index of the worker stream
+}
+
+type keyHeap []keyHeapItem
+
+func (h keyHeap) Len() int {
+	return len(h)
+}
+
+func (h keyHeap) Less(i, j int) bool {
+	if cmp := h[i].key.Compare(h[j].key); cmp != 0 {
+		return cmp < 0
+	}
+	// keep the order stable for equal keys
+	return h[i].src < h[j].src
+}
+
+func (h keyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *keyHeap) Push(x interface{}) {
+	*h = append(*h, x.(keyHeapItem))
+}
+
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// reverseKeyHeap orders head keys of worker streams for reverse scans.
+type reverseKeyHeap struct {
+	keyHeap
+}
+
+func (h reverseKeyHeap) Less(i, j int) bool {
+	if cmp := h.keyHeap[i].key.Compare(h.keyHeap[j].key); cmp != 0 {
+		return cmp > 0
+	}
+	// keep the order stable for equal keys
+	return h.keyHeap[i].src < h.keyHeap[j].src
+}
@@ -0,0 +1,87 @@
+package indexer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mergerTestKeys(t *testing.T, docids ...string) []Key {
+	var keys []Key
+	for _, docid := range docids {
+		b, _ := json.Marshal([]interface{}{"SecKey", docid})
+		k, err := NewKey(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func runMergeKeys(p *scanParams, streams ...[]Key) []Key {
+	sd := &scanDescriptor{p: p, respch: make(chan interface{})}
+	var workerChannels []chan interface{}
+	for _, stream := range streams {
+		workerch := make(chan interface{}, len(stream))
+		for _, k := range stream {
+			workerch <- k
+		}
+		close(workerch)
+		workerChannels = append(workerChannels, workerch)
+	}
+
+	s := &scanCoordinator{}
+	go func() {
+		s.mergeKeys(sd, workerChannels)
+		close(sd.respch)
+	}()
+
+	var keys []Key
+	for resp := range sd.respch {
+		keys = append(keys, resp.(Key))
+	}
+	return keys
+}
+
+func TestMergeKeys(t *testing.T) {
+	s1 := mergerTestKeys(t, "a", "d", "e")
+	s2 := mergerTestKeys(t, "b", "c", "f")
+	expected := mergerTestKeys(t, "a", "b", "c", "d", "e", "f")
+
+	keys := runMergeKeys(&scanParams{}, s1, s2, nil)
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected %v keys, got %v", len(expected), len(keys))
+	}
+	for i, k := range keys {
+		if k.Compare(expected[i]) != 0 {
+			t.Errorf("Unexpected key at %v, %s", i, k.Raw())
+		}
+	}
+
+	keys = runMergeKeys(&scanParams{limit: 4}, s1, s2)
+	if len(keys) != 4 {
+		t.Errorf("Expected 4 keys with limit, got %v", len(keys))
+	}
+
+	keys = runMergeKeys(&scanParams{offset: 2, limit: 3}, s1, s2)
+	if len(keys) != 3 || keys[0].Compare(expected[2]) != 0 {
+		t.Errorf("Expected 3 keys from offset 2, got %v", len(keys))
+	}
+
+	keys = runMergeKeys(&scanParams{distinct: true}, s1, s2)
+	if len(keys) != 1 {
+		t.Errorf("Expected 1 distinct key, got %v", len(keys))
+	}
+
+	r1 := mergerTestKeys(t, "e", "d", "a")
+	r2 := mergerTestKeys(t, "f", "c", "b")
+	keys = runMergeKeys(&scanParams{reverse: true}, r1, r2)
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected %v keys in reverse, got %v", len(expected), len(keys))
+	}
+	for i, k := range keys {
+		if k.Compare(expected[len(expected)-1-i]) != 0 {
+			t.Errorf("Unexpected key in reverse at %v, %s", i, k.Raw())
+		}
+	}
+}
@@ -40,3 +40,19 @@ type Slice interface {
 
 	IndexWriter
 }
+
+//NewSlice initializes a new slice with the storage engine specified
+//in the index definition.
+func NewSlice(path string, sliceId SliceId, indexInst common.IndexInst,
+	sysconf common.Config) (Slice, error) {
+
+	defn := indexInst.Defn
+	switch defn.Using {
+	case common.Llrb:
+		return NewLlrbSlice(path, sliceId, defn.DefnId, indexInst.InstId, sysconf)
+	case common.LevelDB:
+		return NewLeveldbSlice(path, sliceId, defn.DefnId, indexInst.InstId, sysconf)
+	default:
+		return NewForestDBSlice(path, sliceId, defn.DefnId, indexInst.InstId, sysconf)
+	}
+}
@@ -76,7 +76,9 @@ func (sc *snapshotInfoContainer) RemoveOldest() error {
 //being removed are closed as well.
 func (sc *snapshotInfoContainer) RemoveRecentThanTS(tsVbuuid *common.TsVbuuid) error {
 	ts := getStabilityTSFromTsVbuuid(tsVbuuid)
-	for e := sc.snapshotList.Front(); e != nil; e = e.Next() {
+	var next *list.Element
+	for e := sc.snapshotList.Front(); e != nil; e = next {
+		next = e.Next() // e.Next() is nil once e is removed
 		snapshot := e.Value.(SnapshotInfo)
 		snapTsVbuuid := snapshot.Timestamp()
 		snapTs := getStabilityTSFromTsVbuuid(snapTsVbuuid)
@@ -131,7 +133,7 @@ func (sc *snapshotInfoContainer) GetEqualToTS(tsVbuuid *common.TsVbuuid) Snapsho
 	return nil
 }
 
-//GetSnapshotOlderThanTS returns a snapshot which is older than the
+//GetSnapshotOlderThanTS returns the latest snapshot which is older than the
 //given TS or atleast equal. Returns nil if its not able to find any match
 func (sc *snapshotInfoContainer) GetOlderThanTS(tsVbuuid *common.TsVbuuid) SnapshotInfo {
 	ts := getStabilityTSFromTsVbuuid(tsVbuuid)
@@ -141,8 +143,6 @@ func (sc *snapshotInfoContainer) GetOlderThanTS(tsVbuuid *common.TsVbuuid) Snaps
 		snapTs := getStabilityTSFromTsVbuuid(snapTsVbuuid)
 		if ts.GreaterThanEqual(snapTs) {
 			return snapshot
-		} else {
-			break
 		}
 	}
 
@@ -30,6 +30,7 @@ func NewStatsManager(supvCmdch MsgChannel,
 
 	http.HandleFunc("/stats", s.handleStatsReq)
 	http.HandleFunc("/stats/mem", s.handleMemStatsReq)
+	http.Handle("/metrics", common.DefaultMetrics)
 	return s, &MsgSuccess{}
 }
 
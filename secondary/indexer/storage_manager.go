@@ -303,16 +303,7 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 				s.indexSnapMap[idxInstId] = is
 
 				// Also notify any waiters for snapshots creation
-				var newWaiters []*snapshotWaiter
-				for _, w := range s.waitersMap[idxInstId] {
-					if w.ts == nil || tsVbuuid.AsRecent(w.ts) {
-						snap := CloneIndexSnapshot(is)
-						w.Notify(snap)
-					} else {
-						newWaiters = append(newWaiters, w)
-					}
-				}
-				s.waitersMap[idxInstId] = newWaiters
+				s.notifySnapshotWaiters(idxInstId, is)
 			} else {
 				DestroyIndexSnapshot(is)
 			}
@@ -323,7 +314,40 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 
 }
 
-//handleRollback will rollback to given timestamp
+//notifySnapshotWaiters notifies waiters of index instance whose
+//atleast-timestamp is satisfied by the newly created snapshot. Other
+//waiters continue to wait for a more recent snapshot.
+func (s *storageMgr) notifySnapshotWaiters(idxInstId common.IndexInstId,
+	is IndexSnapshot) {
+
+	var newWaiters []*snapshotWaiter
+	for _, w := range s.waitersMap[idxInstId] {
+		if w.ts == nil || is.Timestamp().SeqnosAsRecent(w.ts) {
+			snap := CloneIndexSnapshot(is)
+			w.Notify(snap)
+		} else {
+			newWaiters = append(newWaiters, w)
+		}
+	}
+	s.waitersMap[idxInstId] = newWaiters
+}
+
+//sliceRollback is a slice of an index being rolled back along
+//with the snapshot it is going to be rolled back to
+type sliceRollback struct {
+	idxInstId common.IndexInstId
+	partnId   common.PartitionId
+	slice     Slice
+	snapInfos SnapshotInfoContainer
+	snapInfo  SnapshotInfo //nil means rollback to zero
+}
+
+//handleRollback will rollback to given timestamp. Rollback happens in two
+//phases. First a snapshot timestamp, which every slice of the bucket in stream
+//can rollback to, is picked. Then all the slices are rolled back to it.
+//Indexes whose slices fail to rollback are reset to zero and reported back
+//to be rebuilt, so that the other indexes remain consistent at the common
+//timestamp.
 func (sm *storageMgr) handleRollback(cmd Message) {
 
 	streamId := cmd.(*MsgRollback).GetStreamId()
@@ -332,7 +356,8 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 
 	numVbuckets := sm.config["numVbuckets"].Int()
 
-	var respTs *common.TsVbuuid
+	var rollbacks []*sliceRollback
+	rebuildMap := make(map[common.IndexInstId]bool)
 
 	//for every index managed by this indexer
 	for idxInstId, partnMap := range sm.indexPartnMap {
@@ -345,52 +370,72 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 			for partnId, partnInst := range partnMap {
 				sc := partnInst.Sc
 
-				//rollback all slices
 				for _, slice := range sc.GetAllSlices() {
+					r := &sliceRollback{
+						idxInstId: idxInstId,
+						partnId:   partnId,
+						slice:     slice,
+					}
+					rollbacks = append(rollbacks, r)
+
 					infos, err := slice.GetSnapshots()
-					// TODO: Proper error handling if possible
 					if err != nil {
-						panic("Unable read snapinfo -" + err.Error())
+						common.Errorf("StorageMgr::handleRollback \n\tError Reading "+
+							"Snapshots Index: %v PartitionId: %v SliceId: %v. Error %v",
+							idxInstId, partnId, slice.Id(), err)
+						rebuildMap[idxInstId] = true
+						continue
 					}
-					s := NewSnapshotInfoContainer(infos)
-					snapInfo := s.GetOlderThanTS(rollbackTs)
-					if snapInfo != nil {
-						err := slice.Rollback(snapInfo)
-						if err == nil {
-							common.Debugf("StorageMgr::handleRollback \n\t Rollback Index: %v "+
-								"PartitionId: %v SliceId: %v To Snapshot %v ", idxInstId, partnId,
-								slice.Id(), snapInfo)
-							respTs = snapInfo.Timestamp()
-						} else {
-							//send error response back
-							//TODO handle the case where some of the slices fail to rollback
-							sm.supvCmdch <- &MsgError{err: Error{code: ERROR_STORAGE_MGR_ROLLBACK_FAIL,
-								severity: FATAL,
-								category: STORAGE_MGR,
-								cause:    err}}
-							return
-						}
+					r.snapInfos = NewSnapshotInfoContainer(infos)
+				}
+			}
+		}
+	}
 
-					} else {
-						//if there is no snapshot available, rollback to zero
-						err := slice.RollbackToZero()
-						if err == nil {
-							common.Debugf("StorageMgr::handleRollback \n\t Rollback Index: %v "+
-								"PartitionId: %v SliceId: %v To Zero ", idxInstId, partnId,
-								slice.Id())
-							respTs = common.NewTsVbuuid(bucket, numVbuckets)
-						} else {
-							//send error response back
-							//TODO handle the case where some of the slices fail to rollback
-							sm.supvCmdch <- &MsgError{err: Error{code: ERROR_STORAGE_MGR_ROLLBACK_FAIL,
-								severity: FATAL,
-								category: STORAGE_MGR,
-								cause:    err}}
-							return
-						}
-					}
+	//phase 1: pick the common timestamp
+	respTs := sm.getCommonRollbackTs(rollbacks, rebuildMap, rollbackTs)
+	if respTs == nil {
+		respTs = common.NewTsVbuuid(bucket, numVbuckets)
+	}
 
-				}
+	//phase 2: rollback all slices to the common timestamp
+	for _, r := range rollbacks {
+		if rebuildMap[r.idxInstId] {
+			continue
+		}
+
+		var err error
+		if r.snapInfo != nil {
+			err = r.slice.Rollback(r.snapInfo)
+		} else {
+			err = r.slice.RollbackToZero()
+		}
+
+		if err != nil {
+			common.Errorf("StorageMgr::handleRollback \n\tError Rollback Index: %v "+
+				"PartitionId: %v SliceId: %v To Snapshot %v. Index needs to be rebuilt. "+
+				"Error %v", r.idxInstId, r.partnId, r.slice.Id(), r.snapInfo, err)
+			rebuildMap[r.idxInstId] = true
+			continue
+		}
+
+		common.Debugf("StorageMgr::handleRollback \n\t Rollback Index: %v "+
+			"PartitionId: %v SliceId: %v To Snapshot %v ", r.idxInstId, r.partnId,
+			r.slice.Id(), r.snapInfo)
+	}
+
+	//indexes to be rebuilt are reset to zero, irrespective
+	//of where their slices have been rolled back to
+	var rebuildList []common.IndexInstId
+	for idxInstId := range rebuildMap {
+		rebuildList = append(rebuildList, idxInstId)
+	}
+	for _, r := range rollbacks {
+		if rebuildMap[r.idxInstId] {
+			if err := r.slice.RollbackToZero(); err != nil {
+				common.Errorf("StorageMgr::handleRollback \n\tError Rollback Index: %v "+
+					"PartitionId: %v SliceId: %v To Zero. Error %v", r.idxInstId,
+					r.partnId, r.slice.Id(), err)
 			}
 		}
 	}
@@ -410,8 +455,57 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 	sm.updateIndexSnapMap(sm.indexPartnMap, streamId, bucket)
 
 	sm.supvCmdch <- &MsgRollback{streamId: streamId,
-		bucket:     bucket,
-		rollbackTs: respTs}
+		bucket:      bucket,
+		rollbackTs:  respTs,
+		rebuildList: rebuildList}
+}
+
+//getCommonRollbackTs returns the most recent timestamp, not more recent than
+//rollbackTs, at which every slice has a snapshot, after setting the snapshot
+//each slice needs to rollback to. Starting from the latest snapshot of a
+//slice, the timestamp is lowered to the snapshot of any slice that has none
+//at it, till all the slices agree. If there is no such timestamp, all the
+//slices are to be rolled back to zero and nil is returned.
+//Slices of indexes which need to be rebuilt are not considered.
+func (sm *storageMgr) getCommonRollbackTs(rollbacks []*sliceRollback,
+	rebuildMap map[common.IndexInstId]bool,
+	rollbackTs *common.TsVbuuid) *common.TsVbuuid {
+
+	var commonTs *common.TsVbuuid
+	for changed := true; changed; {
+		changed = false
+		for _, r := range rollbacks {
+			if rebuildMap[r.idxInstId] {
+				continue
+			}
+
+			ts := commonTs
+			if ts == nil {
+				ts = rollbackTs
+			}
+			if r.snapInfo = r.snapInfos.GetOlderThanTS(ts); r.snapInfo == nil {
+				common.Debugf("StorageMgr::getCommonRollbackTs \n\tIndex: %v "+
+					"PartitionId: %v SliceId: %v Cannot Rollback To %v", r.idxInstId,
+					r.partnId, r.slice.Id(), ts)
+				for _, rr := range rollbacks {
+					rr.snapInfo = nil
+				}
+				return nil
+			}
+
+			//snapshot is older than the common timestamp, lower it
+			snapTs := getStabilityTSFromTsVbuuid(r.snapInfo.Timestamp())
+			if commonTs == nil ||
+				!snapTs.Equals(getStabilityTSFromTsVbuuid(commonTs)) {
+				commonTs, changed = r.snapInfo.Timestamp(), true
+			}
+		}
+	}
+
+	if commonTs == nil {
+		return nil
+	}
+	return commonTs.Copy()
 }
 
 func (s *storageMgr) handleUpdateIndexInstMap(cmd Message) {
@@ -506,7 +600,8 @@ func (s *storageMgr) handleGetIndexSnapshot(cmd Message) {
 	// - If atleast-ts is nil and no snapshot is available, send nil ts
 	// - If atleast-ts is not-nil and no snapshot is available, wait until
 	// it is available.
-	if req.GetTS() == nil || is.Timestamp().AsRecent(req.GetTS()) {
+	if req.GetTS() == nil ||
+		(is != nil && is.Timestamp().SeqnosAsRecent(req.GetTS())) {
 		snap := CloneIndexSnapshot(is)
 		req.respch <- snap
 	} else {
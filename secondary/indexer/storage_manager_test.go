@@ -0,0 +1,318 @@
+package indexer
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newTestStorageMgr(instIds ...common.IndexInstId) *storageMgr {
+	s := &storageMgr{
+		supvCmdch:     make(MsgChannel, 1),
+		indexInstMap:  make(common.IndexInstMap),
+		indexPartnMap: make(IndexPartnMap),
+		indexSnapMap:  make(map[common.IndexInstId]IndexSnapshot),
+		waitersMap:    make(map[common.IndexInstId][]*snapshotWaiter),
+		config:        common.SystemConfig.SectionConfig("indexer.", true),
+	}
+	for _, instId := range instIds {
+		s.indexInstMap[instId] = common.IndexInst{InstId: instId}
+	}
+	return s
+}
+
+func testSnapTs(seqnos ...uint64) *common.TsVbuuid {
+	ts := common.NewTsVbuuid("default", len(seqnos))
+	for i, seqno := range seqnos {
+		ts.Seqnos[i], ts.Vbuuids[i] = seqno, 1234
+	}
+	return ts
+}
+
+// testStabilityTs returns a timestamp whose snapshot markers end at seqnos.
+func testStabilityTs(seqnos ...uint64) *common.TsVbuuid {
+	ts := testSnapTs(seqnos...)
+	for i, seqno := range seqnos {
+		ts.Snapshots[i] = [2]uint64{seqno, seqno}
+	}
+	return ts
+}
+
+type testSnapInfo struct {
+	ts *common.TsVbuuid
+}
+
+func (info *testSnapInfo) Timestamp() *common.TsVbuuid {
+	return info.ts
+}
+
+func (info *testSnapInfo) IsCommitted() bool {
+	return true
+}
+
+type testSnapshot struct {
+	Snapshot
+}
+
+func (s *testSnapshot) Close() error {
+	return nil
+}
+
+// testSlice records rollbacks, all other methods of Slice are unimplemented.
+type testSlice struct {
+	Slice
+	infos       []SnapshotInfo // latest first
+	snapErr     error
+	rollbackErr error
+	rolledBack  SnapshotInfo
+	toZero      bool
+}
+
+// newTestSlice returns a slice with a snapshot at every timestamp of
+// snapshots, each a list of seqnos.
+func newTestSlice(snapshots ...[]uint64) *testSlice {
+	slice := &testSlice{}
+	for _, seqnos := range snapshots {
+		info := &testSnapInfo{ts: testStabilityTs(seqnos...)}
+		slice.infos = append(slice.infos, info)
+	}
+	return slice
+}
+
+func (s *testSlice) Id() SliceId {
+	return 0
+}
+
+func (s *testSlice) GetSnapshots() ([]SnapshotInfo, error) {
+	return s.infos, s.snapErr
+}
+
+func (s *testSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	return &testSnapshot{}, nil
+}
+
+func (s *testSlice) Rollback(info SnapshotInfo) error {
+	if s.rollbackErr != nil {
+		return s.rollbackErr
+	}
+	for i, si := range s.infos {
+		if si == info {
+			s.infos = s.infos[i:]
+		}
+	}
+	s.rolledBack = info
+	return nil
+}
+
+// RollbackToZero resets the slice, it has no snapshots after that.
+func (s *testSlice) RollbackToZero() error {
+	s.infos, s.snapErr = nil, nil
+	s.rolledBack, s.toZero = nil, true
+	return nil
+}
+
+func getIndexSnapshot(s *storageMgr, instId common.IndexInstId,
+	ts *common.TsVbuuid) chan interface{} {
+
+	respch := make(chan interface{}, 1)
+	s.handleGetIndexSnapshot(&MsgIndexSnapRequest{
+		ts: ts, idxInstId: instId, respch: respch,
+	})
+	<-s.supvCmdch
+	return respch
+}
+
+func TestSnapshotWaitAtleastTs(t *testing.T) {
+	instId := common.IndexInstId(1)
+	s := newTestStorageMgr(instId)
+	s.indexSnapMap[instId] = &indexSnapshot{instId: instId, ts: testSnapTs(10, 10)}
+
+	// any consistency is served from the latest snapshot.
+	if is := (<-getIndexSnapshot(s, instId, nil)).(IndexSnapshot); is == nil {
+		t.Fatalf("expected latest snapshot")
+	}
+	// snapshot already satisfies the timestamp.
+	respch := getIndexSnapshot(s, instId, testSnapTs(10, 5))
+	if is := (<-respch).(IndexSnapshot); is.Timestamp().Seqnos[0] != 10 {
+		t.Fatalf("expected snapshot at 10, got %v", is.Timestamp())
+	}
+
+	// request_plus / at_plus waits for a more recent snapshot.
+	respch = getIndexSnapshot(s, instId, testSnapTs(20, 5))
+	if len(respch) != 0 || len(s.waitersMap[instId]) != 1 {
+		t.Fatalf("expected scan to wait for snapshot")
+	}
+	s.notifySnapshotWaiters(instId, &indexSnapshot{ts: testSnapTs(15, 15)})
+	if len(respch) != 0 || len(s.waitersMap[instId]) != 1 {
+		t.Fatalf("expected scan to wait for snapshot at 20")
+	}
+	s.notifySnapshotWaiters(instId, &indexSnapshot{ts: testSnapTs(20, 5)})
+	if len(s.waitersMap[instId]) != 0 {
+		t.Fatalf("expected waiter to be notified")
+	}
+	if is := (<-respch).(IndexSnapshot); is.Timestamp().Seqnos[0] != 20 {
+		t.Fatalf("expected snapshot at 20, got %v", is.Timestamp())
+	}
+
+	// snapshot from a different branch of vbucket history does not qualify.
+	ts := testSnapTs(20, 5)
+	ts.Vbuuids[0] = 5678
+	respch = getIndexSnapshot(s, instId, ts)
+	s.notifySnapshotWaiters(instId, &indexSnapshot{ts: testSnapTs(30, 30)})
+	if len(respch) != 0 || len(s.waitersMap[instId]) != 1 {
+		t.Fatalf("expected scan to wait for snapshot with vbuuid 5678")
+	}
+}
+
+func TestSnapshotWaitNoSnapshot(t *testing.T) {
+	instId := common.IndexInstId(1)
+	s := newTestStorageMgr(instId)
+
+	if is := <-getIndexSnapshot(s, instId, nil); is != nil {
+		t.Fatalf("expected nil snapshot, got %v", is)
+	}
+	respch := getIndexSnapshot(s, instId, testSnapTs(1, 0))
+	if len(respch) != 0 {
+		t.Fatalf("expected scan to wait for first snapshot")
+	}
+	if err := <-getIndexSnapshot(s, 2, nil); err != ErrIndexNotFound {
+		t.Fatalf("expected %v, got %v", ErrIndexNotFound, err)
+	}
+}
+
+func TestCommonRollbackTs(t *testing.T) {
+	testcases := []struct {
+		name       string
+		rollbackTs []uint64
+		slices     [][][]uint64
+		commonTs   []uint64 // nil if slices are rolled back to zero
+	}{
+		{"latest", []uint64{40, 40},
+			[][][]uint64{{{30, 30}, {20, 20}}, {{30, 30}, {10, 10}}},
+			[]uint64{30, 30}},
+		{"mismatched", []uint64{40, 40},
+			[][][]uint64{
+				{{30, 30}, {20, 20}, {10, 10}},
+				{{25, 25}, {20, 20}, {10, 10}},
+				{{35, 35}, {25, 25}, {20, 20}},
+			},
+			[]uint64{20, 20}},
+		{"older than rollback", []uint64{22, 22},
+			[][][]uint64{{{30, 30}, {20, 20}}, {{20, 20}, {10, 10}}},
+			[]uint64{20, 20}},
+		// neither snapshot is older than the other.
+		{"not comparable", []uint64{40, 40},
+			[][][]uint64{{{30, 10}, {10, 10}}, {{10, 30}, {10, 10}}},
+			[]uint64{10, 10}},
+		{"no common", []uint64{40, 40},
+			[][][]uint64{{{30, 30}, {20, 20}}, {{25, 25}, {15, 15}}}, nil},
+		{"no snapshot", []uint64{40, 40},
+			[][][]uint64{{{30, 30}}, {}}, nil},
+	}
+
+	s := newTestStorageMgr()
+	for _, tc := range testcases {
+		var rollbacks []*sliceRollback
+		for i, snapshots := range tc.slices {
+			slice := newTestSlice(snapshots...)
+			rollbacks = append(rollbacks, &sliceRollback{
+				idxInstId: common.IndexInstId(i),
+				slice:     slice,
+				snapInfos: NewSnapshotInfoContainer(slice.infos),
+			})
+		}
+		// index to be rebuilt does not hold back the others.
+		rollbacks = append(rollbacks, &sliceRollback{
+			idxInstId: common.IndexInstId(100),
+			slice:     newTestSlice(),
+		})
+		rebuildMap := map[common.IndexInstId]bool{100: true}
+
+		commonTs := s.getCommonRollbackTs(
+			rollbacks, rebuildMap, testStabilityTs(tc.rollbackTs...))
+		if tc.commonTs == nil {
+			if commonTs != nil {
+				t.Errorf("%v: expected no common timestamp, got %v", tc.name, commonTs)
+			}
+			for _, r := range rollbacks {
+				if r.snapInfo != nil {
+					t.Errorf("%v: expected rollback to zero, got %v", tc.name, r.snapInfo)
+				}
+			}
+			continue
+		}
+
+		ref := getStabilityTSFromTsVbuuid(testStabilityTs(tc.commonTs...))
+		if commonTs == nil || !getStabilityTSFromTsVbuuid(commonTs).Equals(ref) {
+			t.Errorf("%v: expected common timestamp %v, got %v", tc.name, ref, commonTs)
+			continue
+		}
+		for _, r := range rollbacks[:len(tc.slices)] {
+			if r.snapInfo == nil ||
+				!getStabilityTSFromTsVbuuid(r.snapInfo.Timestamp()).Equals(ref) {
+				t.Errorf("%v: index %v expected rollback to %v, got %v",
+					tc.name, r.idxInstId, ref, r.snapInfo)
+			}
+		}
+	}
+}
+
+func TestRollbackRebuildsFailedIndexes(t *testing.T) {
+	s := newTestStorageMgr(1, 2, 3)
+	slices := map[common.IndexInstId]*testSlice{
+		1: newTestSlice([]uint64{30, 30}, []uint64{20, 20}),
+		2: newTestSlice([]uint64{20, 20}, []uint64{10, 10}),
+		3: newTestSlice([]uint64{20, 20}),
+	}
+	slices[2].rollbackErr = errors.New("rollback failed")
+	slices[3].snapErr = errors.New("snapshots unreadable")
+	for instId, slice := range slices {
+		inst := s.indexInstMap[instId]
+		inst.Defn.Bucket, inst.Stream = "default", common.MAINT_STREAM
+		s.indexInstMap[instId] = inst
+
+		sc := NewHashedSliceContainer()
+		sc.AddSlice(0, slice)
+		s.indexPartnMap[instId] = PartitionInstMap{0: PartitionInst{Sc: sc}}
+	}
+
+	s.handleRollback(&MsgRollback{
+		streamId:   common.MAINT_STREAM,
+		bucket:     "default",
+		rollbackTs: testStabilityTs(40, 40),
+	})
+	resp := (<-s.supvCmdch).(*MsgRollback)
+
+	ref := getStabilityTSFromTsVbuuid(testStabilityTs(20, 20))
+	if ts := resp.GetRollbackTs(); !getStabilityTSFromTsVbuuid(ts).Equals(ref) {
+		t.Errorf("expected rollback to %v, got %v", ref, ts)
+	}
+	rebuildList := resp.GetRebuildList()
+	sort.Sort(indexInstIds(rebuildList))
+	if !reflect.DeepEqual(rebuildList, []common.IndexInstId{2, 3}) {
+		t.Errorf("expected indexes 2 and 3 to be rebuilt, got %v", rebuildList)
+	}
+
+	// only the failing indexes are reset to zero.
+	if slices[1].toZero || slices[1].rolledBack != slices[1].infos[0] {
+		t.Errorf("expected index 1 to rollback to its snapshot at 20")
+	}
+	if !slices[2].toZero || !slices[3].toZero {
+		t.Errorf("expected indexes 2 and 3 to rollback to zero")
+	}
+	if is := s.indexSnapMap[1]; is == nil ||
+		!getStabilityTSFromTsVbuuid(is.Timestamp()).Equals(ref) {
+		t.Errorf("expected snapshot of index 1 at %v, got %v", ref, is)
+	}
+	if s.indexSnapMap[2] != nil || s.indexSnapMap[3] != nil {
+		t.Errorf("expected no snapshot for indexes 2 and 3")
+	}
+}
+
+type indexInstIds []common.IndexInstId
+
+func (ids indexInstIds) Len() int           { return len(ids) }
+func (ids indexInstIds) Less(i, j int) bool { return ids[i] < ids[j] }
+func (ids indexInstIds) Swap(i, j int)      { ids[i], ids[j] = ids[j], ids[i] }
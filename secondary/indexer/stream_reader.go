@@ -11,6 +11,7 @@ package indexer
 
 import (
 	"errors"
+	"time"
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/dataport"
@@ -42,6 +43,8 @@ type mutationStreamReader struct {
 	bucketQueueMap BucketQueueMap //indexId to mutation queue map
 
 	bucketFilterMap map[string]*common.TsVbuuid
+
+	stopped bool //set once stream reader has been shutdown
 }
 
 //CreateMutationStreamReader creates a new mutation stream and starts
@@ -150,27 +153,39 @@ func (r *mutationStreamReader) run() {
 			}
 
 		case cmd, ok := <-r.supvCmdch:
-			if ok {
-				//handle commands from supervisor
-				if cmd.GetMsgType() == STREAM_READER_SHUTDOWN {
-					//shutdown and exit the stream reader loop
-					r.Shutdown()
-					r.supvCmdch <- &MsgSuccess{}
-					return
-				}
-				msg := r.handleSupervisorCommands(cmd)
-				r.supvCmdch <- msg
-			} else {
-				//supervisor channel closed. Shutdown stream reader.
-				r.Shutdown()
-				return
+			r.handleSupervisorCmd(cmd, ok)
+		}
 
-			}
+		if r.stopped {
+			//exit the stream reader loop
+			return
 		}
 	}
 
 }
 
+//handleSupervisorCmd handles a command received on supervisor channel.
+//On shutdown, the reader is marked stopped.
+func (r *mutationStreamReader) handleSupervisorCmd(cmd Message, ok bool) {
+
+	if ok {
+		//handle commands from supervisor
+		if cmd.GetMsgType() == STREAM_READER_SHUTDOWN {
+			//shutdown the stream reader
+			r.Shutdown()
+			r.stopped = true
+			r.supvCmdch <- &MsgSuccess{}
+			return
+		}
+		msg := r.handleSupervisorCommands(cmd)
+		r.supvCmdch <- msg
+	} else {
+		//supervisor channel closed. Shutdown stream reader.
+		r.Shutdown()
+		r.stopped = true
+	}
+}
+
 func (r *mutationStreamReader) handleVbKeyVersions(vbKeyVers []*protobuf.VbKeyVersions) {
 
 	for _, vb := range vbKeyVers {
@@ -187,6 +202,10 @@ func (r *mutationStreamReader) handleKeyVersions(bucket string, vbucket Vbucket,
 
 	for _, kv := range kvs {
 
+		//stream reader may get shutdown while waiting on a throttled worker
+		if r.stopped {
+			return
+		}
 		r.handleSingleKeyVersion(bucket, vbucket, vbuuid, kv)
 	}
 
@@ -215,7 +234,8 @@ func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbu
 		switch byte(cmd) {
 
 		//case protobuf.Command_Upsert, protobuf.Command_Deletion, protobuf.Command_UpsertDeletion:
-		case common.Upsert, common.Deletion, common.UpsertDeletion:
+		case common.Upsert, common.Deletion, common.UpsertDeletion,
+			common.Expiration:
 
 			//As there can multiple keys in a KeyVersion for a mutation,
 			//filter needs to be evaluated and set only once.
@@ -282,6 +302,15 @@ func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbu
 				meta:     meta}
 			r.supvRespch <- msg
 
+		case common.KeySizeError:
+			//projector did not index the document's oversized keys,
+			//send message to supervisor to report it
+			msg := &MsgKeySizeError{streamId: r.streamId,
+				instId: common.IndexInstId(kv.GetUuids()[i]),
+				meta:   meta,
+				docid:  kv.GetDocid()}
+			r.supvRespch <- msg
+
 		case common.Snapshot:
 			//get snapshot information from message
 			typ, start, end := kv.Snapshot()
@@ -303,11 +332,30 @@ func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbu
 
 	//place secKey in the right worker's queue
 	if mut != nil {
-		r.workerch[int(vbucket)%r.numWorkers] <- mut
+		r.sendToWorker(mut, int(vbucket)%r.numWorkers)
 	}
 
 }
 
+//sendToWorker places the mutation in the worker's queue. While the worker
+//is throttled on mutation queue memory, supervisor commands are still
+//served so that the stream can be updated or shutdown.
+func (r *mutationStreamReader) sendToWorker(mut *MutationKeys, workerId int) {
+
+	for {
+		select {
+		case r.workerch[workerId] <- mut:
+			return
+
+		case cmd, ok := <-r.supvCmdch:
+			r.handleSupervisorCmd(cmd, ok)
+			if r.stopped {
+				return
+			}
+		}
+	}
+}
+
 //startMutationStreamWorker is the worker which processes mutation in a worker queue
 func (r *mutationStreamReader) startMutationStreamWorker(workerId int, stopch StopChannel) {
 
@@ -317,7 +365,11 @@ func (r *mutationStreamReader) startMutationStreamWorker(workerId int, stopch St
 	for {
 		select {
 		case mut := <-r.workerch[workerId]:
-			r.handleSingleMutation(mut)
+			if stopped := r.handleSingleMutation(mut, stopch); stopped {
+				common.Infof("MutationStreamReader::startMutationStreamWorker Stream Worker %v "+
+					"Stopped while Throttled for Stream %v", workerId, r.streamId)
+				return
+			}
 		case <-stopch:
 			common.Infof("MutationStreamReader::startMutationStreamWorker Stream Worker %v "+
 				"Stopped for Stream %v", workerId, r.streamId)
@@ -327,13 +379,19 @@ func (r *mutationStreamReader) startMutationStreamWorker(workerId int, stopch St
 
 }
 
-//handleSingleMutation enqueues mutation in the mutation queue
-func (r *mutationStreamReader) handleSingleMutation(mut *MutationKeys) {
+//handleSingleMutation enqueues mutation in the mutation queue. If the
+//queue is over its memory limit, the worker is throttled first. Returns
+//true if the worker got stopped while being throttled.
+func (r *mutationStreamReader) handleSingleMutation(mut *MutationKeys,
+	stopch StopChannel) bool {
 
 	common.Tracef("MutationStreamReader::handleSingleMutation received mutation %v", mut)
 
+	stopped := false
+
 	//based on the index, enqueue the mutation in the right queue
 	if q, ok := r.bucketQueueMap[mut.meta.bucket]; ok {
+		stopped = r.waitForQueueMemory(q.queue, mut.meta.bucket, stopch)
 		q.queue.Enqueue(mut, mut.meta.vbucket)
 
 	} else {
@@ -341,6 +399,62 @@ func (r *mutationStreamReader) handleSingleMutation(mut *MutationKeys) {
 			"unknown bucket. Skipped  %v", mut)
 	}
 
+	return stopped
+}
+
+//waitForQueueMemory blocks while the mutation queue is over its memory
+//limit. Blocked workers fill up their buffers and stop the reader from
+//consuming the Dataport, which in turn stops reading the connection and
+//slows down the projector's endpoint. Supervisor is asked to drain the
+//queue meanwhile. If the worker is stopped, it returns true without
+//waiting so that the mutation in hand still gets enqueued.
+func (r *mutationStreamReader) waitForQueueMemory(q MutationQueue,
+	bucket string, stopch StopChannel) bool {
+
+	if !isQueueOverflow(q) {
+		return false
+	}
+
+	common.Infof("MutationStreamReader::waitForQueueMemory Throttling Stream %v "+
+		"Bucket %v. MemUsed %v MaxMemory %v", r.streamId, bucket,
+		q.GetMemUsed(), q.GetMaxMemory())
+
+	ticker := time.NewTicker(time.Millisecond * QUEUE_MEMORY_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	msg := &MsgStream{mType: STREAM_READER_QUEUE_OVERFLOW,
+		streamId: r.streamId,
+		meta:     &MutationMeta{bucket: bucket}}
+	var notify <-chan time.Time
+
+	for isQueueOverflow(q) {
+		if notify == nil {
+			select {
+			case r.supvRespch <- msg:
+				notify = time.After(time.Millisecond * QUEUE_OVERFLOW_NOTIFY_INTERVAL)
+			case <-stopch:
+				return true
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-notify:
+			notify = nil
+		case <-stopch:
+			return true
+		}
+	}
+
+	common.Infof("MutationStreamReader::waitForQueueMemory Resumed Stream %v "+
+		"Bucket %v. MemUsed %v MaxMemory %v", r.streamId, bucket,
+		q.GetMemUsed(), q.GetMaxMemory())
+	return false
+}
+
+//isQueueOverflow returns true if the queue has reached its memory limit
+func isQueueOverflow(q MutationQueue) bool {
+	maxMemory := q.GetMaxMemory()
+	return maxMemory > 0 && q.GetMemUsed() >= maxMemory
 }
 
 //handleStreamInfoMsg handles the error messages from Dataport
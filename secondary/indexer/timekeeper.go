@@ -141,6 +141,9 @@ func (tk *timekeeper) handleSupervisorCommands(cmd Message) {
 	case STREAM_READER_CONN_ERROR:
 		tk.handleStreamConnError(cmd)
 
+	case STREAM_READER_QUEUE_OVERFLOW:
+		tk.handleQueueOverflow(cmd)
+
 	case TK_ENABLE_FLUSH:
 		tk.handleFlushStateChange(cmd)
 
@@ -441,6 +444,31 @@ func (tk *timekeeper) handleSync(cmd Message) {
 
 }
 
+//handleQueueOverflow handles the notification from stream reader that
+//it is throttling the stream as the bucket's mutation queue is over
+//its memory limit.
+func (tk *timekeeper) handleQueueOverflow(cmd Message) {
+
+	common.Tracef("Timekeeper::handleQueueOverflow %v", cmd)
+
+	streamId := cmd.(*MsgStream).GetStreamId()
+	meta := cmd.(*MsgStream).GetMutationMeta()
+
+	tk.lock.Lock()
+	defer tk.lock.Unlock()
+
+	//check if bucket is active in stream
+	if tk.checkBucketActiveInStream(streamId, meta.bucket) == false {
+		common.Warnf("Timekeeper::handleQueueOverflow \n\tReceived Overflow for "+
+			"Inactive Bucket %v Stream %v. Ignored.", meta.bucket, streamId)
+		return
+	}
+
+	tk.drainQueueIfOverflow(streamId, meta.bucket)
+
+	tk.supvCmdch <- &MsgSuccess{}
+}
+
 func (tk *timekeeper) handleFlushDone(cmd Message) {
 
 	streamId := cmd.(*MsgMutMgrFlushDone).GetStreamId()
@@ -1343,7 +1371,6 @@ func (tk *timekeeper) generateNewStabilityTS(streamId common.StreamId,
 				"List for Bucket: %v Stream: %v.", tsVbuuid, bucket, streamId)
 			tsList := tk.ss.streamBucketTsListMap[streamId][bucket]
 			tsList.PushBack(tsVbuuid)
-			tk.drainQueueIfOverflow(streamId, bucket)
 		}
 	}
 }
@@ -1488,27 +1515,31 @@ func getTSFromTsVbuuid(tsVbuuid *common.TsVbuuid) Timestamp {
 	return ts
 }
 
-//if there are more mutations in this queue than configured,
-//drain the queue
+//if the mutation queue of this bucket is over its memory limit,
+//drain the queue by flushing a new stability TS from the current HWT
 func (tk *timekeeper) drainQueueIfOverflow(streamId common.StreamId, bucket string) {
 
 	if !tk.ss.streamBucketDrainEnabledMap[streamId][bucket] {
 		return
 	}
 
-	switch streamId {
-
-	case common.MAINT_STREAM:
-		//TODO
-
-		//if the number of mutation are more than configured
-
-		//if stream is in PREPARE_RECOVERY, nothing to do
-
-		//if RECOVERY, drain on TS from queue
+	//if a flush is in progress or there are pending TS, the queue
+	//will get drained once those are flushed
+	if !tk.ss.canFlushNewTS(streamId, bucket) {
+		return
+	}
 
-		//if stream is in ACTIVE state, flush the queue
+	//nothing to drain if no mutations have been received since last TS
+	if !tk.ss.streamBucketNewTsReqdMap[streamId][bucket] ||
+		!tk.ss.checkAllStreamBeginsReceived(streamId, bucket) {
+		return
 	}
+
+	tsVbuuid := tk.ss.getNextStabilityTS(streamId, bucket)
+	common.Debugf("Timekeeper::drainQueueIfOverflow \n\tFlushing new "+
+		"TS: %v Bucket: %v Stream: %v.", tsVbuuid, bucket, streamId)
+	tk.ss.streamBucketFlushInProgressTsMap[streamId][bucket] = tsVbuuid
+	go tk.sendNewStabilityTS(tsVbuuid, bucket, streamId)
 }
 
 func (tk *timekeeper) initiateRecovery(streamId common.StreamId,
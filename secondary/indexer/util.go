@@ -12,6 +12,7 @@ package indexer
 import (
 	"errors"
 	"fmt"
+	"github.com/couchbase/indexing/secondary/collatejson"
 	"github.com/couchbase/indexing/secondary/common"
 	"net"
 	"strconv"
@@ -64,10 +65,31 @@ func GetLocalIP() (net.IP, error) {
 	return nil, errors.New("cannot find local IP address")
 }
 
-func IndexPath(inst *common.IndexInst, sliceId SliceId) string {
+func IndexPath(inst *common.IndexInst, partnId common.PartitionId, sliceId SliceId) string {
+	if inst.Defn.IsPartitioned() {
+		return fmt.Sprintf("%s_%s_%d_%d_%d.index", inst.Defn.Bucket, inst.Defn.Name,
+			inst.InstId, partnId, sliceId)
+	}
 	return fmt.Sprintf("%s_%s_%d_%d.index", inst.Defn.Bucket, inst.Defn.Name, inst.InstId, sliceId)
 }
 
+//EncodePartitionBounds encodes JSON upper bounds of RANGE partitions
+//using collatejson, for comparison with the partition key of documents.
+func EncodePartitionBounds(bounds []string) ([][]byte, error) {
+
+	var err error
+	codes := make([][]byte, 0, len(bounds))
+	jsoncodec := collatejson.NewCodec(16)
+	for _, bound := range bounds {
+		code := make([]byte, 0, len(bound)*3)
+		if code, err = jsoncodec.Encode([]byte(bound), code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
 func GetCurrentKVTs(cluster, bucket string, numVbs int) (Timestamp, error) {
 	ts := NewTimestamp(numVbs)
 	start := time.Now()
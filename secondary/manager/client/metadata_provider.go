@@ -10,14 +10,18 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/couchbase/gometa/common"
 	"github.com/couchbase/gometa/log"
 	"github.com/couchbase/gometa/message"
 	"github.com/couchbase/gometa/protocol"
+	"github.com/couchbase/indexing/secondary/collatejson"
 	c "github.com/couchbase/indexing/secondary/common"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -38,7 +42,7 @@ type MetadataProvider struct {
 
 type metadataRepo struct {
 	definitions map[c.IndexDefnId]*c.IndexDefn
-	instances   map[c.IndexDefnId]*IndexInstDistribution
+	instances   map[c.IndexDefnId]map[uint64]*IndexInstDistribution // partId -> instance
 	indices     map[c.IndexDefnId]*IndexMetadata
 	mutex       sync.Mutex
 }
@@ -69,6 +73,9 @@ type InstanceDefn struct {
 	State  c.IndexState
 	Error  string
 	Endpts []c.Endpoint
+	// partition of a partitioned index hosted at Endpts[i], for all
+	// partitions of the index.
+	Partitions []c.PartitionId
 }
 
 var REQUEST_CHANNEL_COUNT = 1000
@@ -141,20 +148,47 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 	}
 
 	ns, ok := plan["nodes"].([]interface{})
-	if !ok || len(ns) != 1 {
-		return c.IndexDefnId(0), errors.New("Create Index is allowed for one and only one node")
+	if !ok || len(ns) == 0 {
+		return c.IndexDefnId(0), errors.New("Create Index requires atleast one node")
+	}
+	nodes := make([]string, 0, len(ns))
+	for _, n := range ns {
+		node, ok := n.(string)
+		if !ok {
+			return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to create index.  Invalid node %v", n))
+		}
+		for _, other := range nodes {
+			if other == node {
+				return c.IndexDefnId(0),
+					errors.New(fmt.Sprintf("Fails to create index.  Node %s is repeated", node))
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	scheme, bounds, err := partitionPlan(plan, partnExpr, len(nodes))
+	if err != nil {
+		return c.IndexDefnId(0), err
+	}
+
+	collation, err := collationPlan(plan)
+	if err != nil {
+		return c.IndexDefnId(0), err
 	}
-	nodes := []string{ns[0].(string)}
 
 	deferred, ok := plan["defer_build"].(bool)
 	if !ok {
 		deferred = false
 	}
 
-	watcher := o.findMatchingWatcher(nodes[0])
-	if watcher == nil {
-		return c.IndexDefnId(0),
-			errors.New(fmt.Sprintf("Fails to create index.  Node %s does not exist or is not running", nodes[0]))
+	watchers := make([]*watcher, 0, len(nodes))
+	for _, node := range nodes {
+		watcher := o.findMatchingWatcher(node)
+		if watcher == nil {
+			return c.IndexDefnId(0),
+				errors.New(fmt.Sprintf("Fails to create index.  Node %s does not exist or is not running", node))
+		}
+		watchers = append(watchers, watcher)
 	}
 
 	defnID, err := c.NewIndexDefnId()
@@ -165,26 +199,142 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 	idxDefn := &c.IndexDefn{
 		DefnId:          defnID,
 		Name:            name,
-		Using:           c.IndexType(using),
+		Using:           c.IndexTypeFromString(using),
 		Bucket:          bucket,
 		IsPrimary:       isPrimary,
 		SecExprs:        secExprs,
 		ExprType:        c.ExprType(exprType),
-		PartitionScheme: c.SINGLE,
+		PartitionScheme: scheme,
 		PartitionKey:    partnExpr,
 		WhereExpr:       whereExpr,
 		Deferred:        deferred,
-		Nodes:           nodes}
+		Nodes:           nodes,
+		PartitionBounds: bounds,
+		Collation:       collation}
 
-	content, err := c.MarshallIndexDefn(idxDefn)
-	if err != nil {
-		return 0, err
+	// partition `i` of the index is created on nodes[i], undo the
+	// partitions already created if one of the nodes fails.
+	key := fmt.Sprintf("%d", defnID)
+	for i, watcher := range watchers {
+		if idxDefn.IsPartitioned() {
+			idxDefn.Partitions = []c.PartitionId{c.PartitionId(i)}
+		}
+
+		content, err := c.MarshallIndexDefn(idxDefn)
+		if err != nil {
+			return 0, err
+		}
+
+		if err = watcher.makeRequest(OPCODE_CREATE_INDEX, key, content); err != nil {
+			// partitions that fail to drop are left orphaned on their nodes.
+			var orphans []string
+			for j, created := range watchers[:i] {
+				if derr := created.makeRequest(OPCODE_DROP_INDEX, key, []byte("")); derr != nil {
+					c.Errorf("MetadataProvider.CreateIndexWithPlan(): Fails to drop partition %d of index %s on node %s.  Error = %v",
+						j, name, nodes[j], derr)
+					orphans = append(orphans, fmt.Sprintf("%s (%v)", nodes[j], derr))
+				}
+			}
+			if len(orphans) > 0 {
+				err = errors.New(fmt.Sprintf("%v.  Fails to drop partitions of index %s created on nodes %s",
+					err, name, strings.Join(orphans, ", ")))
+			}
+			return defnID, err
+		}
 	}
 
-	key := fmt.Sprintf("%d", defnID)
-	err = watcher.makeRequest(OPCODE_CREATE_INDEX, key, content)
+	return defnID, nil
+}
+
+// partitionPlan returns the partition scheme for an index deployed on
+// `numNodes` nodes, and the upper bounds of RANGE partitions. The plan
+// may specify "partition_scheme" as one of KEY, HASH or RANGE, where
+// RANGE partitions also need "partition_bounds", a sorted list of
+// numNodes-1 values. Index deployed on more than one node defaults to KEY
+// partitioning if partition expression is given, and HASH partitioning
+// on document id otherwise.
+func partitionPlan(plan map[string]interface{},
+	partnExpr string, numNodes int) (c.PartitionScheme, []string, error) {
+
+	var scheme c.PartitionScheme
+	if s, ok := plan["partition_scheme"].(string); ok {
+		scheme = c.PartitionScheme(strings.ToUpper(s))
+	} else if numNodes == 1 {
+		scheme = c.SINGLE
+	} else if partnExpr != "" {
+		scheme = c.KEY
+	} else {
+		scheme = c.HASH
+	}
 
-	return defnID, err
+	switch scheme {
+	case c.SINGLE:
+		if numNodes != 1 {
+			return "", nil, errors.New("Create Index is allowed for one and only one node")
+		}
+		return scheme, nil, nil
+
+	case c.KEY:
+		if partnExpr == "" {
+			return "", nil, errors.New("Fails to create index.  KEY partitioning requires a partition key")
+		}
+		return scheme, nil, nil
+
+	case c.HASH:
+		return scheme, nil, nil
+
+	case c.RANGE:
+		if partnExpr == "" {
+			return "", nil, errors.New("Fails to create index.  RANGE partitioning requires a partition key")
+		}
+		values, _ := plan["partition_bounds"].([]interface{})
+		if len(values) != numNodes-1 {
+			return "", nil, errors.New(fmt.Sprintf("Fails to create index.  "+
+				"RANGE partitioning on %d nodes requires %d partition_bounds", numNodes, numNodes-1))
+		}
+		bounds := make([]string, 0, len(values))
+		codec := collatejson.NewCodec(16)
+		var prev []byte
+		for _, value := range values {
+			bound, err := json.Marshal(value)
+			if err != nil {
+				return "", nil, err
+			}
+			code, err := codec.StreamEncode(bound, nil)
+			if err != nil {
+				return "", nil, err
+			} else if prev != nil && bytes.Compare(prev, code) >= 0 {
+				return "", nil, errors.New("Fails to create index.  partition_bounds are not sorted")
+			}
+			prev = code
+			bounds = append(bounds, string(bound))
+		}
+		return scheme, bounds, nil
+	}
+	return "", nil, errors.New(fmt.Sprintf("Fails to create index.  Unknown partition scheme %v", scheme))
+}
+
+// collationPlan returns the collation of string values in index keys, nil
+// if the plan does not specify one. The plan may specify "collation" as a
+// BCP 47 language tag, like "de" or "ja", and "case_insensitive" and
+// "accent_insensitive" as booleans.
+func collationPlan(plan map[string]interface{}) (*c.Collation, error) {
+	lang, hasLang := plan["collation"].(string)
+	caseInsensitive, hasCase := plan["case_insensitive"].(bool)
+	accentInsensitive, hasAccent := plan["accent_insensitive"].(bool)
+	if !hasLang && !hasCase && !hasAccent {
+		return nil, nil
+	}
+
+	collation := &c.Collation{
+		Language:          lang,
+		CaseInsensitive:   caseInsensitive,
+		AccentInsensitive: accentInsensitive,
+	}
+	if err := collation.Apply(collatejson.NewCodec(16)); err != nil {
+		return nil, errors.New(fmt.Sprintf("Fails to create index.  Invalid collation %v", lang))
+	}
+	return collation, nil
 }
 
 func (o *MetadataProvider) CreateIndex(
@@ -203,7 +353,7 @@ func (o *MetadataProvider) CreateIndex(
 	idxDefn := &c.IndexDefn{
 		DefnId:          defnID,
 		Name:            name,
-		Using:           c.IndexType(using),
+		Using:           c.IndexTypeFromString(using),
 		Bucket:          bucket,
 		IsPrimary:       isPrimary,
 		SecExprs:        secExprs,
@@ -424,7 +574,7 @@ func newMetadataRepo() *metadataRepo {
 
 	return &metadataRepo{
 		definitions: make(map[c.IndexDefnId]*c.IndexDefn),
-		instances:   make(map[c.IndexDefnId]*IndexInstDistribution),
+		instances:   make(map[c.IndexDefnId]map[uint64]*IndexInstDistribution),
 		indices:     make(map[c.IndexDefnId]*IndexMetadata)}
 }
 
@@ -436,9 +586,9 @@ func (r *metadataRepo) addDefn(defn *c.IndexDefn) {
 	r.definitions[defn.DefnId] = defn
 	r.indices[defn.DefnId] = r.makeIndexMetadata(defn)
 
-	inst, ok := r.instances[defn.DefnId]
+	parts, ok := r.instances[defn.DefnId]
 	if ok {
-		r.updateIndexMetadata(defn.DefnId, inst)
+		r.updateIndexMetadata(defn.DefnId, parts)
 	}
 }
 
@@ -457,11 +607,21 @@ func (r *metadataRepo) updateTopology(topology *IndexTopology) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	// partitions of an index are reported by topology of the indexer
+	// node hosting them.
 	for _, defnRef := range topology.Definitions {
 		defnId := c.IndexDefnId(defnRef.DefnId)
-		for _, instRef := range defnRef.Instances {
-			r.instances[defnId] = &instRef
-			r.updateIndexMetadata(defnId, &instRef)
+		for i := range defnRef.Instances {
+			instRef := &defnRef.Instances[i]
+			parts, ok := r.instances[defnId]
+			if !ok {
+				parts = make(map[uint64]*IndexInstDistribution)
+				r.instances[defnId] = parts
+			}
+			for _, partition := range instRef.Partitions {
+				parts[partition.PartId] = instRef
+			}
+			r.updateIndexMetadata(defnId, parts)
 		}
 	}
 }
@@ -492,18 +652,38 @@ func (r *metadataRepo) makeIndexMetadata(defn *c.IndexDefn) *IndexMetadata {
 		Instances: nil}
 }
 
-func (r *metadataRepo) updateIndexMetadata(defnId c.IndexDefnId, inst *IndexInstDistribution) {
+func (r *metadataRepo) updateIndexMetadata(defnId c.IndexDefnId,
+	parts map[uint64]*IndexInstDistribution) {
 
 	meta, ok := r.indices[defnId]
 	if ok {
+		partIds := make([]int, 0, len(parts))
+		for partId, _ := range parts {
+			partIds = append(partIds, int(partId))
+		}
+		sort.Ints(partIds)
+
+		// state of a partitioned index is the least advanced state of
+		// its partitions.
 		idxInst := new(InstanceDefn)
-		idxInst.InstId = c.IndexInstId(inst.InstId)
-		idxInst.State = c.IndexState(inst.State)
-		idxInst.Error = inst.Error
+		for i, partId := range partIds {
+			inst := parts[uint64(partId)]
+			idxInst.InstId = c.IndexInstId(inst.InstId)
+			if i == 0 || c.IndexState(inst.State) < idxInst.State {
+				idxInst.State = c.IndexState(inst.State)
+			}
+			if idxInst.Error == "" {
+				idxInst.Error = inst.Error
+			}
 
-		for _, partition := range inst.Partitions {
-			for _, slice := range partition.SinglePartition.Slices {
-				idxInst.Endpts = append(idxInst.Endpts, c.Endpoint(slice.Host))
+			for _, partition := range inst.Partitions {
+				if partition.PartId != uint64(partId) {
+					continue
+				}
+				for _, slice := range partition.SinglePartition.Slices {
+					idxInst.Endpts = append(idxInst.Endpts, c.Endpoint(slice.Host))
+					idxInst.Partitions = append(idxInst.Partitions, c.PartitionId(partId))
+				}
 			}
 		}
 		meta.Instances = []*InstanceDefn{idxInst}
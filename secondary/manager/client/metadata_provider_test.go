@@ -0,0 +1,131 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	c "github.com/couchbase/indexing/secondary/common"
+	"reflect"
+	"testing"
+)
+
+func TestPartitionPlan(t *testing.T) {
+	testcases := []struct {
+		plan      string
+		partnExpr string
+		numNodes  int
+		scheme    c.PartitionScheme
+		bounds    []string
+		fails     bool
+	}{
+		{`{}`, "", 1, c.SINGLE, nil, false},
+		{`{}`, "", 3, c.HASH, nil, false},
+		{`{}`, "city", 3, c.KEY, nil, false},
+		{`{"partition_scheme": "single"}`, "", 2, "", nil, true},
+		{`{"partition_scheme": "key"}`, "", 2, "", nil, true},
+		{`{"partition_scheme": "hash"}`, "city", 2, c.HASH, nil, false},
+		{`{"partition_scheme": "list"}`, "city", 2, "", nil, true},
+
+		{`{"partition_scheme": "range", "partition_bounds": [10, 20]}`,
+			"age", 3, c.RANGE, []string{`10`, `20`}, false},
+		{`{"partition_scheme": "range", "partition_bounds": ["m"]}`,
+			"city", 2, c.RANGE, []string{`"m"`}, false},
+		{`{"partition_scheme": "range", "partition_bounds": [5, "a", [1]]}`,
+			"city", 4, c.RANGE, []string{`5`, `"a"`, `[1]`}, false},
+		// missing partition key
+		{`{"partition_scheme": "range", "partition_bounds": [10]}`,
+			"", 2, "", nil, true},
+		// wrong count of bounds
+		{`{"partition_scheme": "range"}`, "age", 2, "", nil, true},
+		{`{"partition_scheme": "range", "partition_bounds": [10]}`,
+			"age", 3, "", nil, true},
+		{`{"partition_scheme": "range", "partition_bounds": [10, 20]}`,
+			"age", 2, "", nil, true},
+		// unsorted or repeated bounds
+		{`{"partition_scheme": "range", "partition_bounds": [20, 10]}`,
+			"age", 3, "", nil, true},
+		{`{"partition_scheme": "range", "partition_bounds": [10, 10]}`,
+			"age", 3, "", nil, true},
+		{`{"partition_scheme": "range", "partition_bounds": ["a", 10]}`,
+			"age", 3, "", nil, true},
+	}
+
+	for _, tc := range testcases {
+		var plan map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.plan), &plan); err != nil {
+			t.Fatal(err)
+		}
+		scheme, bounds, err := partitionPlan(plan, tc.partnExpr, tc.numNodes)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%v on %v nodes: expected error", tc.plan, tc.numNodes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v on %v nodes: unexpected error %v", tc.plan, tc.numNodes, err)
+		} else if scheme != tc.scheme || !reflect.DeepEqual(bounds, tc.bounds) {
+			t.Errorf("%v on %v nodes: expected %v %v, got %v %v",
+				tc.plan, tc.numNodes, tc.scheme, tc.bounds, scheme, bounds)
+		}
+	}
+}
+
+func TestUpdatePartitionedIndexMetadata(t *testing.T) {
+	defnId := c.IndexDefnId(100)
+	repo := &metadataRepo{
+		indices: map[c.IndexDefnId]*IndexMetadata{
+			defnId: &IndexMetadata{Definition: &c.IndexDefn{DefnId: defnId}},
+		},
+	}
+	partition := func(partId uint64, state c.IndexState,
+		host, err string) *IndexInstDistribution {
+
+		return &IndexInstDistribution{
+			InstId: 200,
+			State:  uint32(state),
+			Error:  err,
+			Partitions: []IndexPartDistribution{{
+				PartId: partId,
+				SinglePartition: IndexSinglePartDistribution{
+					Slices: []IndexSliceLocator{{Host: host}},
+				},
+			}},
+		}
+	}
+	parts := map[uint64]*IndexInstDistribution{
+		2: partition(2, c.INDEX_STATE_ACTIVE, "node3:9101", ""),
+		0: partition(0, c.INDEX_STATE_ACTIVE, "node1:9101", ""),
+		1: partition(1, c.INDEX_STATE_INITIAL, "node2:9101", "build failed"),
+	}
+	repo.updateIndexMetadata(defnId, parts)
+
+	insts := repo.indices[defnId].Instances
+	if len(insts) != 1 {
+		t.Fatalf("expected single instance, got %v", len(insts))
+	}
+	inst := insts[0]
+	ref := &InstanceDefn{
+		InstId:     200,
+		State:      c.INDEX_STATE_INITIAL, // least advanced partition
+		Error:      "build failed",
+		Endpts:     []c.Endpoint{"node1:9101", "node2:9101", "node3:9101"},
+		Partitions: []c.PartitionId{0, 1, 2},
+	}
+	if !reflect.DeepEqual(inst, ref) {
+		t.Fatalf("expected %+v, got %+v", ref, inst)
+	}
+
+	// metadata of unknown index is ignored.
+	repo.updateIndexMetadata(c.IndexDefnId(101), parts)
+	if len(repo.indices) != 1 {
+		t.Fatalf("unexpected metadata for unknown index")
+	}
+}
@@ -773,8 +773,13 @@ func (m *MetadataRepo) addIndexToTopology(defn *common.IndexDefn, id common.Inde
 		topology.Version = 0
 	}
 
+	var partIds []uint64
+	for _, partnId := range defn.Partitions {
+		partIds = append(partIds, uint64(partnId))
+	}
+
 	topology.AddIndexDefinition(defn.Bucket, defn.Name, uint64(defn.DefnId),
-		uint64(id), uint32(common.INDEX_STATE_CREATED), host)
+		uint64(id), uint32(common.INDEX_STATE_CREATED), host, partIds)
 
 	// Add a reference of the bucket-level topology to the global topology.
 	// If it fails later to create bucket-level topology, it will have
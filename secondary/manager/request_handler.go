@@ -171,7 +171,7 @@ func (m *httpHandler) createIndexRequest(w http.ResponseWriter, r *http.Request)
 	idxDefn := &common.IndexDefn{
 		DefnId:          defnID,
 		Name:            indexinfo.Name,
-		Using:           common.ForestDB,
+		Using:           common.IndexTypeFromString(indexinfo.Using),
 		Bucket:          indexinfo.Bucket,
 		IsPrimary:       indexinfo.IsPrimary,
 		SecExprs:        indexinfo.SecExprs,
@@ -173,7 +173,7 @@ func (s *Stream) handleSingleKeyVersion(bucket string,
 		switch byte(cmd) {
 		case common.Upsert:
 			s.handler.HandleUpsert(s.id, bucket, vbucket, vbuuid, kv, i)
-		case common.Deletion:
+		case common.Deletion, common.Expiration:
 			s.handler.HandleDeletion(s.id, bucket, vbucket, vbuuid, kv, i)
 		case common.UpsertDeletion:
 			s.handler.HandleUpsertDeletion(s.id, bucket, vbucket, vbuuid, kv, i)
@@ -28,6 +28,7 @@ import (
 //	Upsert         		- data command
 //	Deletion            - data command
 //	UpsertDeletion      - data command
+//	Expiration          - data command, handled as Deletion
 //	Sync                - control command
 //	DropData            - control command
 //	StreamBegin         - control command
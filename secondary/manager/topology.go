@@ -111,23 +111,33 @@ func (g *GlobalTopology) RemoveTopologyKey(key string) {
 //
 // Add an index definition to Topology.
 //
-func (t *IndexTopology) AddIndexDefinition(bucket string, name string, defnId uint64, instId uint64, state uint32, host string) {
+// For partitioned index, partIds are the partitions hosted by `host`.
+//
+func (t *IndexTopology) AddIndexDefinition(bucket string, name string, defnId uint64, instId uint64, state uint32, host string,
+	partIds []uint64) {
 
 	t.RemoveIndexDefinition(bucket, name)
 
-	slice := new(IndexSliceLocator)
-	slice.SliceId = 0
-	slice.Host = host
-	slice.State = state
-
-	part := new(IndexPartDistribution)
-	part.PartId = 0
-	part.SinglePartition.Slices = append(part.SinglePartition.Slices, *slice)
+	if len(partIds) == 0 {
+		partIds = []uint64{0}
+	}
 
 	inst := new(IndexInstDistribution)
 	inst.InstId = instId
 	inst.State = state
-	inst.Partitions = append(inst.Partitions, *part)
+
+	for _, partId := range partIds {
+		slice := new(IndexSliceLocator)
+		slice.SliceId = 0
+		slice.Host = host
+		slice.State = state
+
+		part := new(IndexPartDistribution)
+		part.PartId = partId
+		part.SinglePartition.Slices = append(part.SinglePartition.Slices, *slice)
+
+		inst.Partitions = append(inst.Partitions, *part)
+	}
 
 	defn := new(IndexDefnDistribution)
 	defn.Bucket = bucket
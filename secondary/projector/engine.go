@@ -60,6 +60,11 @@ func (engine *Engine) StreamEndData(
 	return engine.evaluator.StreamEndData(vbno, vbuuid, seqno)
 }
 
+// GetStatistics from this engine.
+func (engine *Engine) GetStatistics() map[string]interface{} {
+	return engine.evaluator.GetStatistics()
+}
+
 // TransformRoute data to endpoints.
 func (engine *Engine) TransformRoute(
 	vbuuid uint64, m *mc.UprEvent, data map[string]interface{}) error {
@@ -59,6 +59,12 @@ type Feed struct {
 //    mutationChanSize: channel size of projector's data path routine
 //    vbucketSyncTimeout: timeout, in ms, for sending periodic Sync messages
 //    routerEndpointFactory: endpoint factory
+//    maxKeySize: maximum size, in bytes, of secondary-key for an index
+//    jsEvaluationTimeout: timeout, in ms, for evaluating a document with
+//        JavaScript expressions
+//    maxWhereFilterDocs: maximum number of documents tracked for a partial
+//        index
+//    sendExpirations: send expirations as Expiration, otherwise as Deletion
 func NewFeed(topic string, config c.Config) (*Feed, error) {
 	epf := config["routerEndpointFactory"].Value.(c.RouterEndpointFactory)
 	chsize := config["feedChanSize"].Int()
@@ -822,6 +828,13 @@ func (feed *Feed) getStatistics() c.Statistics {
 	stats, _ := c.NewStatistics(nil)
 	stats.Set("topic", feed.topic)
 	stats.Set("engines", feed.engineNames())
+	engStats, _ := c.NewStatistics(nil)
+	for _, engines := range feed.engines {
+		for uuid, engine := range engines {
+			engStats.Set(fmt.Sprintf("%v", uuid), engine.GetStatistics())
+		}
+	}
+	stats.Set("engineStats", engStats)
 	for bucketn, kvdata := range feed.kvdata {
 		stats.Set("bucket-"+bucketn, kvdata.GetStatistics())
 	}
@@ -1116,7 +1129,7 @@ func (feed *Feed) getEndpoint(raddr string) (string, c.RouterEndpoint, error) {
 func (feed *Feed) subscribers(
 	req Subscriber) (map[uint64]c.Evaluator, map[uint64]c.Router, error) {
 
-	evaluators, err := req.GetEvaluators()
+	evaluators, err := req.GetEvaluators(feed.config)
 	if err != nil {
 		return nil, nil, projC.ErrorInconsistentFeed
 	}
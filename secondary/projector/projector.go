@@ -201,6 +201,10 @@ func (p *Projector) doMutationTopic(
 	config.Set("mutationChanSize", p.config["mutationChanSize"])
 	config.Set("vbucketSyncTimeout", p.config["vbucketSyncTimeout"])
 	config.Set("routerEndpointFactory", p.config["routerEndpointFactory"])
+	config.Set("maxKeySize", p.config["maxKeySize"])
+	config.Set("jsEvaluationTimeout", p.config["jsEvaluationTimeout"])
+	config.Set("maxWhereFilterDocs", p.config["maxWhereFilterDocs"])
+	config.Set("sendExpirations", p.config["sendExpirations"])
 
 	var err error
 
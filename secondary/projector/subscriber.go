@@ -7,9 +7,10 @@ import c "github.com/couchbase/indexing/secondary/common"
 // custom-messages, and `routers`, to supply distribution topology
 // for custom-messages.
 type Subscriber interface {
-	// GetEvaluators will return a map of uuid to Evaluator interface.
+	// GetEvaluators will return a map of uuid to Evaluator interface,
+	// `config` is feed's configuration.
 	// - return ErrorInconsistentFeed for malformed tables.
-	GetEvaluators() (map[uint64]c.Evaluator, error)
+	GetEvaluators(config c.Config) (map[uint64]c.Evaluator, error)
 
 	// GetRouters will return a map of uuid to Router interface.
 	// - return ErrorInconsistentFeed for malformed tables.
@@ -23,8 +23,14 @@ import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
 import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
 import c "github.com/couchbase/indexing/secondary/common"
 
+var dcpBacklogMetric = c.DefaultMetrics.NewGauge(
+	"projector_dcp_backlog",
+	"Number of mutations in the current DCP snapshot yet to be received.",
+	"topic", "bucket", "vbucket")
+
 // VbucketRoutine is immutable structure defined for each vbucket.
 type VbucketRoutine struct {
+	topic     string // immutable
 	bucket    string // immutable
 	vbno      uint16 // immutable
 	vbuuid    uint64 // immutable
@@ -47,6 +53,7 @@ func NewVbucketRoutine(
 	mutChanSize := config["mutationChanSize"].Int()
 
 	vr := &VbucketRoutine{
+		topic:     topic,
 		bucket:    bucket,
 		vbno:      vbno,
 		vbuuid:    vbuuid,
@@ -110,6 +117,10 @@ func (vr *VbucketRoutine) GetStatistics() map[string]interface{} {
 
 // routine handles data path for a single vbucket.
 func (vr *VbucketRoutine) run(reqch chan []interface{}, seqno uint64) {
+	vbno := fmt.Sprint(vr.vbno)
+	backlog := dcpBacklogMetric.With(vr.topic, vr.bucket, vbno)
+	snapEnd := seqno
+
 	defer func() { // panic safe
 		if r := recover(); r != nil {
 			c.Errorf("%v run() crashed: %v\n", vr.logPrefix, r)
@@ -124,6 +135,8 @@ func (vr *VbucketRoutine) run(reqch chan []interface{}, seqno uint64) {
 			vr.broadcast2Endpoints(data)
 		}
 
+		// forget the vbucket's backlog, topic might not be restarted.
+		dcpBacklogMetric.Delete(vr.topic, vr.bucket, vbno)
 		close(vr.finch)
 		c.Infof("%v ... stopped\n", vr.logPrefix)
 	}()
@@ -199,8 +212,11 @@ loop:
 				switch m.Opcode {
 				case mcd.UPR_SNAPSHOT:
 					sshotCount++
+					snapEnd = m.SnapendSeq
+					backlog.Set(dcpBacklog(snapEnd, seqno))
 				case mcd.UPR_MUTATION, mcd.UPR_DELETION, mcd.UPR_EXPIRATION:
 					mutationCount++
+					backlog.Set(dcpBacklog(snapEnd, seqno))
 				case mcd.UPR_STREAMEND:
 					break loop
 				}
@@ -292,6 +308,14 @@ func (vr *VbucketRoutine) handleEvent(m *mc.UprEvent, seqno uint64) uint64 {
 	return seqno
 }
 
+// number of mutations yet to be received in the current snapshot.
+func dcpBacklog(snapEnd, seqno uint64) float64 {
+	if snapEnd > seqno {
+		return float64(snapEnd - seqno)
+	}
+	return 0
+}
+
 // send to all endpoints.
 func (vr *VbucketRoutine) broadcast2Endpoints(data interface{}) {
 	for raddr, endpoint := range vr.endpoints {
@@ -319,14 +343,16 @@ func (vr *VbucketRoutine) makeStreamBeginData(seqno uint64) interface{} {
 	if len(vr.engines) == 0 {
 		return nil
 	}
-	// using the first engine that is capable of it.
+	// every engine is told about stream-begin, using the first engine
+	// that is capable of it.
+	var data interface{}
 	for _, engine := range vr.engines {
-		data := engine.StreamBeginData(vr.vbno, vr.vbuuid, seqno)
-		if data != nil {
-			return data
+		d := engine.StreamBeginData(vr.vbno, vr.vbuuid, seqno)
+		if data == nil {
+			data = d
 		}
 	}
-	return nil
+	return data
 }
 
 func (vr *VbucketRoutine) makeSyncData(seqno uint64) (data interface{}) {
@@ -34,16 +34,22 @@ const (
 	Command_DropData       Command = 5
 	Command_StreamBegin    Command = 6
 	Command_StreamEnd      Command = 7
+	Command_Snapshot       Command = 8
+	Command_Expiration     Command = 9
+	Command_KeySizeError   Command = 10
 )
 
 var Command_name = map[int32]string{
-	1: "Upsert",
-	2: "Deletion",
-	3: "UpsertDeletion",
-	4: "Sync",
-	5: "DropData",
-	6: "StreamBegin",
-	7: "StreamEnd",
+	1:  "Upsert",
+	2:  "Deletion",
+	3:  "UpsertDeletion",
+	4:  "Sync",
+	5:  "DropData",
+	6:  "StreamBegin",
+	7:  "StreamEnd",
+	8:  "Snapshot",
+	9:  "Expiration",
+	10: "KeySizeError",
 }
 var Command_value = map[string]int32{
 	"Upsert":         1,
@@ -53,6 +59,9 @@ var Command_value = map[string]int32{
 	"DropData":       5,
 	"StreamBegin":    6,
 	"StreamEnd":      7,
+	"Snapshot":       8,
+	"Expiration":     9,
+	"KeySizeError":   10,
 }
 
 func (x Command) Enum() *Command {
@@ -185,8 +194,8 @@ func (m *VbKeyVersions) GetKvs() []*KeyVersions {
 // is based on the commands.
 //
 // Interpreting seq.no:
-// 1. For Upsert, Deletion, UpsertDeletion messages, sequence number corresponds
-//    to kv mutation.
+// 1. For Upsert, Deletion, UpsertDeletion, Expiration messages, sequence
+//    number corresponds to kv mutation.
 // 2. For Sync message, it is the latest kv mutation sequence-no. received for
 //    a vbucket.
 // 3. For DropData message, it is the first kv mutation that was dropped due
@@ -204,7 +213,7 @@ func (m *VbKeyVersions) GetKvs() []*KeyVersions {
 //      oldkey - end-seqno (8 byte)
 //
 // fields `docid`, `uuids`, `keys`, `oldkeys` are valid only for
-// Upsert, Deletion, UpsertDeletion messages.
+// Upsert, Deletion, UpsertDeletion, Expiration messages.
 type KeyVersions struct {
 	Seqno            *uint64  `protobuf:"varint,1,req,name=seqno" json:"seqno,omitempty"`
 	Docid            []byte   `protobuf:"bytes,2,opt,name=docid" json:"docid,omitempty"`
@@ -1,6 +1,11 @@
 package protobuf
 
+import "bytes"
+import "encoding/json"
 import "fmt"
+import "sync"
+import "sync/atomic"
+import "time"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
@@ -78,12 +83,10 @@ func (instance *IndexInst) GetPartitionObject() Partition {
 		return instance.GetTp()
 	case PartitionScheme_SINGLE:
 		return instance.GetSinglePartn()
-	case PartitionScheme_KEY:
-		// return instance.GetKeyPartn()
-	case PartitionScheme_HASH:
-		// return instance.GetHashPartn()
+	case PartitionScheme_KEY, PartitionScheme_HASH:
+		return instance.GetHashPartn()
 	case PartitionScheme_RANGE:
-		// return instance.GetRangePartn()
+		return instance.GetRangePartn()
 	}
 	return nil
 }
@@ -91,48 +94,103 @@ func (instance *IndexInst) GetPartitionObject() Partition {
 // IndexEvaluator implements `Evaluator` interface for protobuf
 // definition of an index instance.
 type IndexEvaluator struct {
+	// statistics, 64-bit aligned and updated atomically by vbucket
+	// routines.
+	upserts         int64 // no. of documents upserted
+	upsertDeletions int64 // no. of documents not qualifying WHERE anymore
+	deletions       int64 // no. of documents deleted
+	expirations     int64 // no. of documents deleted on expiry of TTL
+	keySizeErrors   int64 // no. of secondary-keys rejected for their size
+
 	skExprs  []interface{} // compiled expression
 	pkExpr   interface{}   // compiled expression
 	whExpr   interface{}   // compiled expression
+	isArray  bool          // skExprs has an array expression
 	instance *IndexInst
+	// secondary-keys larger than maxKeySize are rejected, 0 for no limit.
+	maxKeySize int
+	// send expirations as Expiration command, otherwise as Deletion.
+	sendExpirations bool
+
+	mu      sync.Mutex
+	filters map[uint16]*whereFilter // vbno -> filter, for partial index
+	// documents tracked by filters, limited to maxFilterDocs.
+	filterDocs    int64
+	maxFilterDocs int64
 }
 
 // NewIndexEvaluator returns a reference to a new instance
-// of IndexEvaluator.
-func NewIndexEvaluator(instance *IndexInst) (*IndexEvaluator, error) {
+// of IndexEvaluator. `config` is the projector's feed configuration,
+// from which "maxKeySize", "jsEvaluationTimeout", "maxWhereFilterDocs"
+// and "sendExpirations" are picked if supplied.
+func NewIndexEvaluator(
+	instance *IndexInst, config c.Config) (*IndexEvaluator, error) {
+
 	var err error
 
-	ie := &IndexEvaluator{instance: instance}
+	ie := &IndexEvaluator{
+		instance: instance,
+		filters:  make(map[uint16]*whereFilter),
+	}
+	if cv, ok := config["maxKeySize"]; ok {
+		ie.maxKeySize = cv.Int()
+	}
+	if cv, ok := config["maxWhereFilterDocs"]; ok {
+		ie.maxFilterDocs = int64(cv.Int())
+	}
+	if cv, ok := config["sendExpirations"]; ok {
+		ie.sendExpirations = cv.Bool()
+	}
+	var jsTimeout time.Duration
+	if cv, ok := config["jsEvaluationTimeout"]; ok {
+		jsTimeout = time.Duration(cv.Int()) * time.Millisecond
+	}
 	// compile expressions once and reuse it many times.
 	defn := ie.instance.GetDefinition()
+	var compile func([]string) ([]interface{}, error)
 	switch defn.GetExprType() {
 	case ExprType_JavaScript:
+		compile = func(exprs []string) ([]interface{}, error) {
+			return CompileJSExpression(exprs, jsTimeout)
+		}
 	case ExprType_N1QL:
-		// expressions to evaluate secondary-key
-		exprs := defn.GetSecExpressions()
-		ie.skExprs, err = CompileN1QLExpression(exprs)
+		compile = CompileN1QLExpression
+	default:
+		return ie, nil
+	}
+
+	// expressions to evaluate secondary-key
+	exprs := defn.GetSecExpressions()
+	ie.skExprs, err = compile(exprs)
+	if err != nil {
+		return nil, err
+	}
+	for _, cExpr := range ie.skExprs {
+		switch cExpr := cExpr.(type) {
+		case *arrayExpr:
+			ie.isArray = true
+		case *jsExpr:
+			ie.isArray = ie.isArray || cExpr.isArray
+		}
+	}
+	// expression to evaluate partition key
+	expr := defn.GetPartnExpression()
+	if len(expr) > 0 {
+		cExprs, err := compile([]string{expr})
 		if err != nil {
 			return nil, err
+		} else if len(cExprs) > 0 {
+			ie.pkExpr = cExprs[0]
 		}
-		// expression to evaluate partition key
-		expr := defn.GetPartnExpression()
-		if len(expr) > 0 {
-			cExprs, err := CompileN1QLExpression([]string{expr})
-			if err != nil {
-				return nil, err
-			} else if len(cExprs) > 0 {
-				ie.pkExpr = cExprs[0]
-			}
-		}
-		// expression to evaluate where clause
-		expr = defn.GetWhereExpression()
-		if len(expr) > 0 {
-			cExprs, err := CompileN1QLExpression([]string{expr})
-			if err != nil {
-				return nil, err
-			} else if len(cExprs) > 0 {
-				ie.whExpr = cExprs[0]
-			}
+	}
+	// expression to evaluate where clause
+	expr = defn.GetWhereExpression()
+	if len(expr) > 0 {
+		cExprs, err := compile([]string{expr})
+		if err != nil {
+			return nil, err
+		} else if len(cExprs) > 0 {
+			ie.whExpr = cExprs[0]
 		}
 	}
 	return ie, nil
@@ -147,6 +205,7 @@ func (ie *IndexEvaluator) Bucket() string {
 func (ie *IndexEvaluator) StreamBeginData(
 	vbno uint16, vbuuid, seqno uint64) (data interface{}) {
 
+	ie.resetFilter(vbno, seqno)
 	bucket := ie.Bucket()
 	kv := c.NewKeyVersions(seqno, nil, 1)
 	kv.AddStreamBegin()
@@ -183,6 +242,17 @@ func (ie *IndexEvaluator) StreamEndData(
 	return &c.DataportKeyVersions{bucket, vbno, vbuuid, kv}
 }
 
+// GetStatistics implement Evaluator{} interface.
+func (ie *IndexEvaluator) GetStatistics() map[string]interface{} {
+	return map[string]interface{}{
+		"upserts":         float64(atomic.LoadInt64(&ie.upserts)),
+		"upsertDeletions": float64(atomic.LoadInt64(&ie.upsertDeletions)),
+		"deletions":       float64(atomic.LoadInt64(&ie.deletions)),
+		"expirations":     float64(atomic.LoadInt64(&ie.expirations)),
+		"keySizeErrors":   float64(atomic.LoadInt64(&ie.keySizeErrors)),
+	}
+}
+
 // TransformRoute implement Evaluator{} interface.
 func (ie *IndexEvaluator) TransformRoute(
 	vbuuid uint64, m *mc.UprEvent, data map[string]interface{}) (err error) {
@@ -194,6 +264,7 @@ func (ie *IndexEvaluator) TransformRoute(
 	}()
 
 	var npkey /*new-partition*/, opkey /*old-partition*/, nkey, okey []byte
+	var errEndpoints []string // indexers to report oversized keys
 	instn := ie.instance
 
 	where, err := ie.wherePredicate(m.Value)
@@ -208,14 +279,40 @@ func (ie *IndexEvaluator) TransformRoute(
 		if nkey, err = ie.evaluate(m.Key, m.Value); err != nil {
 			return err
 		}
+		// oversized secondary-key, or array entries, are not indexed
+		// and are reported to the indexer hosting them. Document left
+		// without keys is deleted from the index.
+		key, rejected, err := ie.limitKeySize(nkey)
+		if err != nil {
+			return err
+		} else if rejected > 0 {
+			atomic.AddInt64(&ie.keySizeErrors, int64(rejected))
+			c.Errorf("inst: %v rejected %v keys larger than %v for docid %q\n",
+				instn.GetInstId(), rejected, ie.maxKeySize, string(m.Key))
+			errEndpoints = instn.UpsertEndpoints(m, npkey, nkey, nil)
+			if nkey = key; nkey == nil {
+				where, npkey = false, nil
+			}
+		}
 	}
+	oldWhere := false
 	if len(m.OldValue) > 0 { // project old secondary key
+		if oldWhere, err = ie.wherePredicate(m.OldValue); err != nil {
+			return err
+		}
 		if opkey, err = ie.partitionKey(m.OldValue); err != nil {
 			return err
 		}
 		if okey, err = ie.evaluate(m.Key, m.OldValue); err != nil {
 			return err
 		}
+		// oversized old-keys were never indexed.
+		key, rejected, err := ie.limitKeySize(okey)
+		if err != nil {
+			return err
+		} else if okey = key; rejected > 0 && okey == nil {
+			oldWhere = false
+		}
 	}
 
 	vbno, seqno := m.VBucket, m.Seqno
@@ -223,17 +320,54 @@ func (ie *IndexEvaluator) TransformRoute(
 
 	bucket := ie.Bucket()
 
+	for _, raddr := range errEndpoints {
+		dkv, ok := data[raddr].(*c.DataportKeyVersions)
+		if !ok {
+			kv := c.NewKeyVersions(seqno, m.Key, 4)
+			kv.AddKeySizeError(uuid)
+			dkv = &c.DataportKeyVersions{bucket, vbno, vbuuid, kv}
+		} else {
+			dkv.Kv.AddKeySizeError(uuid)
+		}
+		data[raddr] = dkv
+	}
+
+	// documents whose old version was not indexed, by a partial index,
+	// need not be deleted from indexers.
+	qualified, known := ie.qualified(m, oldWhere)
+	skip := known && !qualified
+
 	c.Tracef("inst: %v where: %v (pkey: %v) key: %v\n",
 		uuid, where, string(npkey), string(nkey))
 	switch m.Opcode {
 	case mcd.UPR_MUTATION:
-		// FIXME: TODO: where clause is not used to for optimizing out messages
-		// not passing the where clause. For this we need a gaurantee that
-		// where clause will be defined only on immutable fields.
-		//if where { // WHERE predicate
+		defer ie.track(m, where)
+
+		// false mutation, indexed fields of the document are not
+		// modified, indexer already has the entry.
+		if len(m.OldValue) > 0 && where && oldWhere &&
+			bytes.Equal(npkey, opkey) && bytes.Equal(nkey, okey) {
+
+			c.Tracef("inst: %v skipped unchanged key for %v\n",
+				uuid, string(m.Key))
+			return nil
+
+		} else if !where && skip {
+			c.Tracef("inst: %v skipped unqualified document %v\n",
+				uuid, string(m.Key))
+			return nil
+		}
+
 		// NOTE: Upsert shall be targeted to indexer node hosting the
-		// key.
-		raddrs := instn.UpsertEndpoints(m, npkey, nkey, okey)
+		// key, documents not qualifying the WHERE predicate are only
+		// deleted from the index.
+		var raddrs []string
+		if where {
+			raddrs = instn.UpsertEndpoints(m, npkey, nkey, okey)
+			atomic.AddInt64(&ie.upserts, 1)
+		} else {
+			atomic.AddInt64(&ie.upsertDeletions, 1)
+		}
 		for _, raddr := range raddrs {
 			dkv, ok := data[raddr].(*c.DataportKeyVersions)
 			if !ok {
@@ -245,11 +379,17 @@ func (ie *IndexEvaluator) TransformRoute(
 			}
 			data[raddr] = dkv
 		}
-		//}
 		// NOTE: UpsertDeletion shall be broadcasted if old-key is not
-		// available.
+		// available, and is implied for endpoints receiving Upsert.
+		upserts := raddrs
+		if skip {
+			break
+		}
 		raddrs = instn.UpsertDeletionEndpoints(m, opkey, nkey, okey)
 		for _, raddr := range raddrs {
+			if c.HasString(raddr, upserts) {
+				continue
+			}
 			dkv, ok := data[raddr].(*c.DataportKeyVersions)
 			if !ok {
 				kv := c.NewKeyVersions(seqno, m.Key, 4)
@@ -262,14 +402,36 @@ func (ie *IndexEvaluator) TransformRoute(
 		}
 
 	case mcd.UPR_DELETION, mcd.UPR_EXPIRATION:
+		defer ie.track(m, false)
+		if skip {
+			c.Tracef("inst: %v skipped unqualified document %v\n",
+				uuid, string(m.Key))
+			return nil
+		}
+		// expired documents are deleted from index same as deleted
+		// documents, but accounted separately. Unless enabled,
+		// expirations are sent as Deletion, since indexers of older
+		// versions drop the Expiration command.
+		command := c.Deletion
+		if m.Opcode == mcd.UPR_EXPIRATION {
+			atomic.AddInt64(&ie.expirations, 1)
+			if ie.sendExpirations {
+				command = c.Expiration
+			}
+		} else {
+			atomic.AddInt64(&ie.deletions, 1)
+		}
+
 		// Delete shall be broadcasted if old-key is not available.
 		raddrs := instn.DeletionEndpoints(m, opkey, okey)
 		for _, raddr := range raddrs {
 			dkv, ok := data[raddr].(*c.DataportKeyVersions)
 			if !ok {
 				kv := c.NewKeyVersions(seqno, m.Key, 4)
-				kv.AddDeletion(uuid, okey)
 				dkv = &c.DataportKeyVersions{bucket, vbno, vbuuid, kv}
+			}
+			if command == c.Expiration {
+				dkv.Kv.AddExpiration(uuid, okey)
 			} else {
 				dkv.Kv.AddDeletion(uuid, okey)
 			}
@@ -279,6 +441,35 @@ func (ie *IndexEvaluator) TransformRoute(
 	return nil
 }
 
+// limitKeySize drops secondary-key `key` if it is larger than the maximum
+// key size, or for array indexes drops its oversized entries, and returns
+// the remaining key along with the number of keys dropped. Returns nil if
+// no key remains.
+func (ie *IndexEvaluator) limitKeySize(key []byte) ([]byte, int, error) {
+	if ie.maxKeySize <= 0 || len(key) <= ie.maxKeySize {
+		return key, 0, nil
+	} else if !ie.isArray {
+		return nil, 1, nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(key, &entries); err != nil {
+		return nil, 0, err
+	}
+	keys := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry) <= ie.maxKeySize {
+			keys = append(keys, entry)
+		}
+	}
+	rejected := len(entries) - len(keys)
+	if len(keys) == 0 {
+		return nil, rejected, nil
+	}
+	key, err := json.Marshal(keys)
+	return key, rejected, err
+}
+
 func (ie *IndexEvaluator) evaluate(docid, doc []byte) ([]byte, error) {
 	defn := ie.instance.GetDefinition()
 	if defn.GetIsPrimary() { // primary index supported !!
@@ -288,7 +479,14 @@ func (ie *IndexEvaluator) evaluate(docid, doc []byte) ([]byte, error) {
 	exprType := defn.GetExprType()
 	switch exprType {
 	case ExprType_JavaScript:
+		if ie.isArray {
+			return JSArrayTransform(docid, doc, ie.skExprs)
+		}
+		return JSTransform(docid, doc, ie.skExprs)
 	case ExprType_N1QL:
+		if ie.isArray {
+			return N1QLArrayTransform(docid, doc, ie.skExprs)
+		}
 		return N1QLTransform(docid, doc, ie.skExprs)
 	}
 	return nil, nil
@@ -305,6 +503,7 @@ func (ie *IndexEvaluator) partitionKey(doc []byte) ([]byte, error) {
 	exprType := defn.GetExprType()
 	switch exprType {
 	case ExprType_JavaScript:
+		return JSTransform(nil, doc, []interface{}{ie.pkExpr})
 	case ExprType_N1QL:
 		return N1QLTransform(nil, doc, []interface{}{ie.pkExpr})
 	}
@@ -318,19 +517,23 @@ func (ie *IndexEvaluator) wherePredicate(doc []byte) (bool, error) {
 	}
 	defn := ie.instance.GetDefinition()
 	exprType := defn.GetExprType()
+	var out []byte
+	var err error
 	switch exprType {
 	case ExprType_JavaScript:
+		out, err = JSTransform(nil, doc, []interface{}{ie.whExpr})
 	case ExprType_N1QL:
 		// TODO: can be optimized by using a custom N1QL-evaluator.
-		out, err := N1QLTransform(nil, doc, []interface{}{ie.whExpr})
-		if out == nil { // missing is treated as false
-			return false, err
-		} else if err != nil { // errors are treated as false
-			return false, err
-		} else if string(out) == "true" {
-			return true, nil
-		}
-		return false, nil // predicate is false
+		out, err = N1QLTransform(nil, doc, []interface{}{ie.whExpr})
+	default:
+		return true, nil
+	}
+	if out == nil { // missing is treated as false
+		return false, err
+	} else if err != nil { // errors are treated as false
+		return false, err
+	} else if string(out) == "true" {
+		return true, nil
 	}
-	return true, nil
+	return false, nil // predicate is false
 }
@@ -188,6 +188,8 @@ type IndexInst struct {
 	Definition       *IndexDefn       `protobuf:"bytes,3,req,name=definition" json:"definition,omitempty"`
 	Tp               *TestPartition   `protobuf:"bytes,4,opt,name=tp" json:"tp,omitempty"`
 	SinglePartn      *SinglePartition `protobuf:"bytes,5,opt,name=singlePartn" json:"singlePartn,omitempty"`
+	HashPartn        *HashPartition   `protobuf:"bytes,7,opt,name=hashPartn" json:"hashPartn,omitempty"`
+	RangePartn       *RangePartition  `protobuf:"bytes,8,opt,name=rangePartn" json:"rangePartn,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -230,6 +232,20 @@ func (m *IndexInst) GetSinglePartn() *SinglePartition {
 	return nil
 }
 
+func (m *IndexInst) GetHashPartn() *HashPartition {
+	if m != nil {
+		return m.HashPartn
+	}
+	return nil
+}
+
+func (m *IndexInst) GetRangePartn() *RangePartition {
+	if m != nil {
+		return m.RangePartn
+	}
+	return nil
+}
+
 // Index DDL from create index statement.
 type IndexDefn struct {
 	DefnID           *uint64          `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -0,0 +1,169 @@
+package protobuf
+
+import "reflect"
+import "strings"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+// newKeySizeEvaluator returns an evaluator for JavaScript expressions
+// `exprs`, with secondary-keys limited to `maxKeySize`, routing keys to
+// single endpoint "host1".
+func newKeySizeEvaluator(
+	t *testing.T, exprs []string, maxKeySize int) *IndexEvaluator {
+
+	instance := &IndexInst{
+		InstId: proto.Uint64(1),
+		Definition: &IndexDefn{
+			DefnID:          proto.Uint64(1),
+			Bucket:          proto.String("default"),
+			ExprType:        ExprType_JavaScript.Enum(),
+			SecExpressions:  exprs,
+			PartitionScheme: PartitionScheme_SINGLE.Enum(),
+		},
+		SinglePartn: NewSinglePartition([]string{"host1"}),
+	}
+	config := c.Config{"maxKeySize": c.ConfigValue{Value: maxKeySize}}
+	ie, err := NewIndexEvaluator(instance, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ie
+}
+
+// transformKeySize returns commands and keys sent to "host1" for the
+// mutation of document `doc`.
+func transformKeySize(
+	t *testing.T, ie *IndexEvaluator, doc string) ([]byte, []string) {
+
+	m := &mc.UprEvent{
+		Opcode: mcd.UPR_MUTATION, VBucket: 1, Seqno: 10,
+		Key: []byte("doc1"), Value: []byte(doc),
+	}
+	data := make(map[string]interface{})
+	if err := ie.TransformRoute(1234, m, data); err != nil {
+		t.Fatal(err)
+	}
+	dkv, ok := data["host1"].(*c.DataportKeyVersions)
+	if !ok {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(dkv.Kv.Keys))
+	for _, key := range dkv.Kv.Keys {
+		keys = append(keys, string(key))
+	}
+	return dkv.Kv.Commands, keys
+}
+
+func TestKeySize(t *testing.T) {
+	// keys are `["<name>","doc1"]`, 11 bytes longer than the name.
+	ie := newKeySizeEvaluator(t, []string{"doc.name"}, 20)
+	testcases := []struct {
+		name     string
+		commands []byte
+		keys     []string
+		errors   int64
+	}{
+		{strings.Repeat("a", 8), []byte{c.Upsert},
+			[]string{`["aaaaaaaa","doc1"]`}, 0},
+		{strings.Repeat("b", 9), []byte{c.Upsert},
+			[]string{`["bbbbbbbbb","doc1"]`}, 0},
+		// oversized key is reported and document is only deleted.
+		{strings.Repeat("c", 10), []byte{c.KeySizeError}, []string{""}, 1},
+	}
+	for _, tc := range testcases {
+		commands, keys := transformKeySize(t, ie, `{"name": "`+tc.name+`"}`)
+		if !reflect.DeepEqual(commands, tc.commands) {
+			t.Errorf("%v: expected commands %v, got %v", tc.name, tc.commands, commands)
+		} else if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("%v: expected keys %v, got %v", tc.name, tc.keys, keys)
+		} else if ie.keySizeErrors != tc.errors {
+			t.Errorf("%v: expected %v key size errors, got %v",
+				tc.name, tc.errors, ie.keySizeErrors)
+		}
+	}
+}
+
+func TestArrayKeySize(t *testing.T) {
+	// array entries are `["<tag>","doc1"]`, 11 bytes longer than the tag.
+	ie := newKeySizeEvaluator(t, []string{"ALL doc.tags"}, 20)
+	testcases := []struct {
+		doc      string
+		commands []byte
+		keys     []string
+		errors   int64
+	}{
+		// array key larger than the limit, but all entries within it.
+		{`{"tags": ["aaaaaaaa", "bbbbbbbbb"]}`, []byte{c.Upsert},
+			[]string{`[["aaaaaaaa","doc1"],["bbbbbbbbb","doc1"]]`}, 0},
+		// only oversized entries are dropped.
+		{`{"tags": ["aaaaaaaa", "bbbbbbbbb", "cccccccccc"]}`,
+			[]byte{c.KeySizeError, c.Upsert},
+			[]string{"", `[["aaaaaaaa","doc1"],["bbbbbbbbb","doc1"]]`}, 1},
+		{`{"tags": ["cccccccccc", "dddddddddd"]}`,
+			[]byte{c.KeySizeError}, []string{""}, 3},
+	}
+	for _, tc := range testcases {
+		commands, keys := transformKeySize(t, ie, tc.doc)
+		if !reflect.DeepEqual(commands, tc.commands) {
+			t.Errorf("%v: expected commands %v, got %v", tc.doc, tc.commands, commands)
+		} else if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("%v: expected keys %v, got %v", tc.doc, tc.keys, keys)
+		} else if ie.keySizeErrors != tc.errors {
+			t.Errorf("%v: expected %v key size errors, got %v",
+				tc.doc, tc.errors, ie.keySizeErrors)
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	instance := &IndexInst{
+		InstId: proto.Uint64(1),
+		Definition: &IndexDefn{
+			DefnID:          proto.Uint64(1),
+			Bucket:          proto.String("default"),
+			ExprType:        ExprType_JavaScript.Enum(),
+			SecExpressions:  []string{"doc.name"},
+			PartitionScheme: PartitionScheme_SINGLE.Enum(),
+		},
+		SinglePartn: NewSinglePartition([]string{"host1"}),
+	}
+	testcases := []struct {
+		sendExpirations bool
+		opcode          mcd.CommandCode
+		command         byte
+	}{
+		{false, mcd.UPR_DELETION, c.Deletion},
+		{false, mcd.UPR_EXPIRATION, c.Deletion},
+		{true, mcd.UPR_DELETION, c.Deletion},
+		{true, mcd.UPR_EXPIRATION, c.Expiration},
+	}
+	for _, tc := range testcases {
+		config := c.Config{
+			"sendExpirations": c.ConfigValue{Value: tc.sendExpirations},
+		}
+		ie, err := NewIndexEvaluator(instance, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &mc.UprEvent{
+			Opcode: tc.opcode, VBucket: 1, Seqno: 10, Key: []byte("doc1"),
+		}
+		data := make(map[string]interface{})
+		if err := ie.TransformRoute(1234, m, data); err != nil {
+			t.Fatal(err)
+		}
+		dkv, ok := data["host1"].(*c.DataportKeyVersions)
+		if !ok {
+			t.Fatalf("%v %v: expected mutation for host1", tc.sendExpirations, tc.opcode)
+		}
+		commands := dkv.Kv.Commands
+		if !reflect.DeepEqual(commands, []byte{tc.command}) {
+			t.Errorf("%v %v: expected commands %v, got %v",
+				tc.sendExpirations, tc.opcode, []byte{tc.command}, commands)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package protobuf
+
+import "encoding/json"
+import "errors"
+import "fmt"
+import "sync"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "github.com/robertkrimen/otto"
+
+// ErrorJSTimeout is returned when evaluating a document with JavaScript
+// expressions does not complete within the configured timeout.
+var ErrorJSTimeout = errors.New("protobuf.errorJSTimeout")
+
+// jsVM is a JavaScript interpreter, with the expressions of an index
+// compiled in it, that can evaluate only one document at a time.
+type jsVM struct {
+	vm      *otto.Otto
+	parse   otto.Value   // JSON.parse()
+	fns     []otto.Value // compiled expressions, by position
+	emitted []otto.Value // keys emitted by map function being evaluated
+}
+
+// jsPool is a pool of interpreters copied from the interpreter that
+// compiled the expressions, so that documents from several vbucket
+// routines are evaluated concurrently.
+type jsPool struct {
+	mu      sync.Mutex
+	proto   *otto.Otto // compiled expressions, never evaluated
+	nfns    int
+	free    []*jsVM
+	timeout time.Duration // 0 for no timeout
+}
+
+// jsExpr is a compiled JavaScript expression, a function of the document
+// and its meta-data.
+type jsExpr struct {
+	pool    *jsPool
+	pos     int  // position of the function in jsVM.fns
+	isArray bool // array expression or map function
+}
+
+// CompileJSExpression will take JavaScript expressions defined for an
+// index and compile them for evaluation. An expression is either a view's
+// map function, `function (doc, meta) { emit(doc.name); }`, or an
+// expression on `doc` and `meta`, like `doc.name.toLowerCase()`. Map
+// functions and array expressions, `DISTINCT <expr>` or `ALL <expr>`, are
+// compiled as array expressions and at most one of them is allowed. ASC and
+// DESC suffixes, that only affect the sort order of the index, are ignored.
+// Evaluating a document fails with ErrorJSTimeout if it takes longer than
+// `timeout`, 0 for no timeout.
+func CompileJSExpression(
+	expressions []string, timeout time.Duration) ([]interface{}, error) {
+
+	pool := &jsPool{proto: otto.New(), timeout: timeout}
+	cExprs := make([]interface{}, 0, len(expressions))
+	arrays := 0
+	for i, expr := range expressions {
+		expr, _ = c.KeyOrder(expr)
+		arrExpr, _, isArray := c.ArrayExpr(expr)
+		if isArray {
+			expr = arrExpr
+		}
+		src := expr
+		if c.IsJSMapFunction(expr) {
+			isArray = true
+		} else {
+			src = "function (doc, meta) { return (" + expr + "); }"
+		}
+		if isArray {
+			arrays++
+			if arrays > 1 {
+				err := fmt.Errorf("multiple array expressions %v", expressions)
+				c.Errorf("CompileJSExpression() %v\n", err)
+				return nil, err
+			}
+		}
+
+		fn, err := pool.proto.Run("(" + src + ")")
+		if err == nil && !fn.IsFunction() {
+			err = fmt.Errorf("not a function")
+		}
+		if err == nil {
+			err = pool.proto.Set(jsFunctionName(i), fn)
+		}
+		if err != nil {
+			c.Errorf("CompileJSExpression() %v: %v\n", expr, err)
+			return nil, err
+		}
+		cExprs = append(cExprs, &jsExpr{pool: pool, pos: i, isArray: isArray})
+	}
+	pool.nfns = len(cExprs)
+	// an interpreter is kept, and validated, for the first evaluation.
+	jsvm, err := pool.newVM()
+	if err != nil {
+		c.Errorf("CompileJSExpression() %v\n", err)
+		return nil, err
+	}
+	pool.free = append(pool.free, jsvm)
+	return cExprs, nil
+}
+
+// jsFunctionName returns the global name of compiled expression at `pos`.
+func jsFunctionName(pos int) string {
+	return fmt.Sprintf("__indexExpr%d", pos)
+}
+
+// newVM returns a new interpreter, copied from the interpreter that
+// compiled the expressions.
+func (pool *jsPool) newVM() (*jsVM, error) {
+	jsvm := &jsVM{vm: pool.proto.Copy(), fns: make([]otto.Value, 0, pool.nfns)}
+	jsvm.vm.Interrupt = make(chan func(), 1)
+	emit := func(call otto.FunctionCall) otto.Value {
+		jsvm.emitted = append(jsvm.emitted, call.Argument(0))
+		return otto.UndefinedValue()
+	}
+	if err := jsvm.vm.Set("emit", emit); err != nil {
+		return nil, err
+	}
+	parse, err := jsvm.vm.Run("JSON.parse")
+	if err != nil {
+		return nil, err
+	}
+	jsvm.parse = parse
+	for i := 0; i < pool.nfns; i++ {
+		fn, err := jsvm.vm.Get(jsFunctionName(i))
+		if err != nil {
+			return nil, err
+		}
+		jsvm.fns = append(jsvm.fns, fn)
+	}
+	return jsvm, nil
+}
+
+// evaluate calls `eval` with an interpreter from the pool, interrupting
+// it if the evaluation takes longer than the pool's timeout.
+func (pool *jsPool) evaluate(
+	eval func(*jsVM) ([]byte, error)) (out []byte, err error) {
+
+	pool.mu.Lock()
+	var jsvm *jsVM
+	if n := len(pool.free); n > 0 {
+		jsvm, pool.free = pool.free[n-1], pool.free[:n-1]
+	} else if jsvm, err = pool.newVM(); err != nil {
+		pool.mu.Unlock()
+		return nil, err
+	}
+	pool.mu.Unlock()
+
+	if pool.timeout <= 0 {
+		out, err = eval(jsvm)
+		pool.put(jsvm)
+		return out, err
+	}
+
+	timer := time.AfterFunc(pool.timeout, func() {
+		jsvm.vm.Interrupt <- func() { panic(ErrorJSTimeout) }
+	})
+	defer func() {
+		// interpreter that was, or is about to be, interrupted is
+		// discarded, as its state is unknown.
+		interrupted := !timer.Stop()
+		if r := recover(); r == ErrorJSTimeout {
+			out, err = nil, ErrorJSTimeout
+		} else if r != nil {
+			panic(r)
+		} else if !interrupted {
+			pool.put(jsvm)
+		}
+	}()
+	return eval(jsvm)
+}
+
+func (pool *jsPool) put(jsvm *jsVM) {
+	pool.mu.Lock()
+	pool.free = append(pool.free, jsvm)
+	pool.mu.Unlock()
+}
+
+var jsMissing, _ = json.Marshal(string(collatejson.MissingLiteral))
+
+// JSTransform will use compiled list of JavaScript expressions and
+// evaluate a document using them to return a secondary key as JSON
+// object. Map functions shall return the key instead of emitting it.
+func JSTransform(docid, doc []byte, cExprs []interface{}) ([]byte, error) {
+	if len(cExprs) == 0 {
+		return nil, nil
+	}
+	pool := cExprs[0].(*jsExpr).pool
+	return pool.evaluate(func(jsvm *jsVM) ([]byte, error) {
+		docval, metaval, ok := jsvm.document(docid, doc)
+		if !ok {
+			return nil, nil
+		}
+		return jsTransform(jsvm, docid, docval, metaval, cExprs, -1, nil)
+	})
+}
+
+// JSArrayTransform will evaluate a document using compiled list of
+// JavaScript expressions, one of which is an array expression or a map
+// function, and return a JSON array of secondary keys, one for each unique
+// element of the array or key emitted by the map function. Returns nil if
+// the document does not have elements to index.
+func JSArrayTransform(docid, doc []byte, cExprs []interface{}) ([]byte, error) {
+	if len(cExprs) == 0 {
+		return nil, nil
+	}
+	pool := cExprs[0].(*jsExpr).pool
+	return pool.evaluate(func(jsvm *jsVM) ([]byte, error) {
+		return jsArrayTransform(jsvm, docid, doc, cExprs)
+	})
+}
+
+// jsArrayTransform evaluates a document, for JSArrayTransform(), with an
+// interpreter from the pool.
+func jsArrayTransform(
+	jsvm *jsVM, docid, doc []byte, cExprs []interface{}) ([]byte, error) {
+
+	docval, metaval, ok := jsvm.document(docid, doc)
+	if !ok {
+		return nil, nil
+	}
+
+	var elements []json.RawMessage
+	var err error
+	pos := -1
+	for i, cExpr := range cExprs {
+		if expr := cExpr.(*jsExpr); expr.isArray {
+			elements, err = jsvm.elements(expr, docval, metaval)
+			if err != nil {
+				return nil, err
+			}
+			pos = i
+		}
+	}
+	if pos < 0 || len(elements) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]json.RawMessage, 0, len(elements))
+	for _, element := range elements {
+		key, err := jsTransform(
+			jsvm, docid, docval, metaval, cExprs, pos, element)
+		if err != nil {
+			return nil, err
+		} else if key != nil {
+			keys = append(keys, json.RawMessage(key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(keys)
+}
+
+// document returns the JavaScript values of a document and of its
+// meta-data, ok is false if document is not a JSON object.
+func (jsvm *jsVM) document(
+	docid, doc []byte) (docval, metaval otto.Value, ok bool) {
+
+	var err error
+	docval, err = jsvm.parse.Call(otto.NullValue(), string(doc))
+	if err != nil || !docval.IsObject() {
+		return docval, metaval, false
+	}
+	meta := map[string]interface{}{}
+	if docid != nil {
+		meta["id"] = string(docid)
+	}
+	if metaval, err = jsvm.vm.ToValue(meta); err != nil {
+		return docval, metaval, false
+	}
+	return docval, metaval, true
+}
+
+// jsTransform evaluates expressions on a document, using `element` for
+// the array expression at `pos`, and return the secondary key.
+func jsTransform(
+	jsvm *jsVM, docid []byte, docval, metaval otto.Value,
+	cExprs []interface{}, pos int, element json.RawMessage) ([]byte, error) {
+
+	keys := make([]json.RawMessage, 0, len(cExprs)+1)
+	skip := true
+	for i, cExpr := range cExprs {
+		key := element
+		if i != pos {
+			fn := jsvm.fns[cExpr.(*jsExpr).pos]
+			value, err := fn.Call(otto.NullValue(), docval, metaval)
+			if err != nil {
+				return nil, err
+			} else if key, err = jsonValue(value); err != nil {
+				return nil, err
+			}
+		}
+
+		if key == nil && skip { // undefined is treated as missing
+			return nil, nil
+
+		} else if key == nil {
+			keys = append(keys, json.RawMessage(jsMissing))
+			continue
+		}
+		skip = false
+		keys = append(keys, key)
+	}
+
+	if len(cExprs) == 1 && len(keys) == 1 && docid == nil {
+		// used for partition-key evaluation and where predicate.
+		return keys[0], nil
+
+	} else if len(keys) > 0 {
+		// make secondary keys unique by appending the docid, refer to
+		// N1QLTransform().
+		if docid != nil {
+			id, err := json.Marshal(string(docid))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, json.RawMessage(id))
+		}
+		return json.Marshal(keys)
+	}
+	return nil, nil
+}
+
+// elements evaluates array expression, or map function, on a document and
+// return the unique keys emitted by it, or else the unique elements of the
+// array returned by it.
+func (jsvm *jsVM) elements(
+	expr *jsExpr, docval, metaval otto.Value) ([]json.RawMessage, error) {
+
+	jsvm.emitted = nil
+	value, err := jsvm.fns[expr.pos].Call(otto.NullValue(), docval, metaval)
+	emitted := jsvm.emitted
+	jsvm.emitted = nil
+	if err != nil {
+		return nil, err
+	}
+
+	var items []json.RawMessage
+	if len(emitted) > 0 {
+		items = make([]json.RawMessage, 0, len(emitted))
+		for _, value := range emitted {
+			item, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+
+	} else if value.Class() == "Array" {
+		data, err := jsonValue(value)
+		if err != nil {
+			return nil, err
+		} else if err = json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+	elements := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		if item == nil || seen[string(item)] {
+			continue
+		}
+		seen[string(item)] = true
+		elements = append(elements, item)
+	}
+	return elements, nil
+}
+
+// jsonValue returns JSON encoded value, nil if value is undefined.
+func jsonValue(value otto.Value) (json.RawMessage, error) {
+	if value.IsUndefined() {
+		return nil, nil
+	}
+	data, err := value.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
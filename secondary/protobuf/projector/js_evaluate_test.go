@@ -0,0 +1,116 @@
+package protobuf
+
+import "fmt"
+import "sync"
+import "testing"
+import "time"
+
+var jsdoc = []byte(`{"name": "Fred", "age": 32, "tags": ["a", "b", "a"]}`)
+
+func TestJSTransform(t *testing.T) {
+	cExprs, err := CompileJSExpression(
+		[]string{"doc.name.toLowerCase()", "doc.age + 1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := JSTransform([]byte("doc1"), jsdoc, cExprs)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(key) != `["fred",33,"doc1"]` {
+		t.Errorf("unexpected key %v", string(key))
+	}
+
+	// leading undefined value skips the document.
+	cExprs, _ = CompileJSExpression([]string{"doc.city", "doc.age"}, 0)
+	if key, err = JSTransform([]byte("doc1"), jsdoc, cExprs); err != nil {
+		t.Fatal(err)
+	} else if key != nil {
+		t.Errorf("expected nil key, got %v", string(key))
+	}
+
+	// where predicate
+	cExprs, _ = CompileJSExpression([]string{"doc.age > 30"}, 0)
+	if key, _ = JSTransform(nil, jsdoc, cExprs); string(key) != "true" {
+		t.Errorf("unexpected predicate %v", string(key))
+	}
+}
+
+func TestJSArrayTransform(t *testing.T) {
+	mapfn := `function (doc, meta) {
+		for (var i = 0; i < doc.tags.length; i++) {
+			emit(doc.tags[i] + meta.id);
+		}
+	}`
+	cExprs, err := CompileJSExpression([]string{mapfn, "doc.age"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if !cExprs[0].(*jsExpr).isArray {
+		t.Fatalf("expected map function to be an array expression")
+	}
+	keys, err := JSArrayTransform([]byte("doc1"), jsdoc, cExprs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := `[["adoc1",32,"doc1"],["bdoc1",32,"doc1"]]`
+	if string(keys) != ref {
+		t.Errorf("expected %v, got %v", ref, string(keys))
+	}
+
+	cExprs, _ = CompileJSExpression([]string{"ALL doc.tags"}, 0)
+	keys, _ = JSArrayTransform([]byte("doc1"), jsdoc, cExprs)
+	if ref = `[["a","doc1"],["b","doc1"]]`; string(keys) != ref {
+		t.Errorf("expected %v, got %v", ref, string(keys))
+	}
+
+	_, err = CompileJSExpression([]string{mapfn, "DISTINCT doc.tags"}, 0)
+	if err == nil {
+		t.Errorf("expected error for multiple array expressions")
+	}
+}
+
+func TestJSTimeout(t *testing.T) {
+	mapfn := `function (doc, meta) {
+		if (meta.id == "loop") { while (true) {} }
+		emit(doc.name);
+	}`
+	cExprs, err := CompileJSExpression([]string{mapfn}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := JSArrayTransform([]byte("loop"), jsdoc, cExprs)
+	if err != ErrorJSTimeout {
+		t.Fatalf("expected %v, got %v %v", ErrorJSTimeout, string(keys), err)
+	}
+	// interrupted interpreter is not reused.
+	keys, err = JSArrayTransform([]byte("doc1"), jsdoc, cExprs)
+	if err != nil {
+		t.Fatal(err)
+	} else if ref := `[["Fred","doc1"]]`; string(keys) != ref {
+		t.Errorf("expected %v, got %v", ref, string(keys))
+	}
+}
+
+func TestJSConcurrent(t *testing.T) {
+	mapfn := `function (doc, meta) { emit(doc.name + meta.id); }`
+	cExprs, err := CompileJSExpression([]string{mapfn, "doc.age"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				docid := fmt.Sprintf("doc-%d-%d", i, j)
+				keys, err := JSArrayTransform([]byte(docid), jsdoc, cExprs)
+				ref := fmt.Sprintf(`[["Fred%s",32,"%s"]]`, docid, docid)
+				if err != nil || string(keys) != ref {
+					t.Errorf("expected %v, got %v %v", ref, string(keys), err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
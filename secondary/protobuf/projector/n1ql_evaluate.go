@@ -1,22 +1,52 @@
 package protobuf
 
+import "encoding/json"
+import "fmt"
+
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/couchbase/indexing/secondary/collatejson"
 import qexpr "github.com/couchbaselabs/query/expression"
 import qparser "github.com/couchbaselabs/query/expression/parser"
 import qvalue "github.com/couchbaselabs/query/value"
 
+// arrayExpr is a compiled array expression, every element of the array
+// is indexed as a separate entry.
+type arrayExpr struct {
+	expr     qexpr.Expression
+	distinct bool
+}
+
 // CompileN1QLExpression will take expressions defined in N1QL's DDL statement
-// and compile them for evaluation.
+// and compile them for evaluation. Array expressions, `DISTINCT <expr>` or
+// `ALL <expr>`, are compiled into *arrayExpr and at most one of them is
+// allowed. ASC and DESC suffixes, that only affect the sort order of the
+// index, are ignored.
 func CompileN1QLExpression(expressions []string) ([]interface{}, error) {
 	cExprs := make([]interface{}, 0, len(expressions))
+	arrays := 0
 	for _, expr := range expressions {
+		expr, _ = c.KeyOrder(expr)
+		arrExpr, distinct, isArray := c.ArrayExpr(expr)
+		if isArray {
+			arrays++
+			if arrays > 1 {
+				err := fmt.Errorf("multiple array expressions %v", expressions)
+				c.Errorf("CompileN1QLExpression() %v\n", err)
+				return nil, err
+			}
+			expr = arrExpr
+		}
+
 		cExpr, err := qparser.Parse(expr)
 		if err != nil {
 			c.Errorf("CompileN1QLExpression() %v: %v\n", expr, err)
 			return nil, err
 		}
-		cExprs = append(cExprs, cExpr)
+		if isArray {
+			cExprs = append(cExprs, &arrayExpr{expr: cExpr, distinct: distinct})
+		} else {
+			cExprs = append(cExprs, cExpr)
+		}
 	}
 	return cExprs, nil
 }
@@ -70,3 +100,62 @@ func N1QLTransform(docid, doc []byte, cExprs []interface{}) ([]byte, error) {
 	}
 	return nil, nil
 }
+
+// N1QLArrayTransform will evaluate a document using compiled list of
+// expressions, one of which is an array expression, and return a JSON array
+// of secondary keys, one for each unique element of the array. Returns nil if
+// the document does not have elements to index.
+func N1QLArrayTransform(docid, doc []byte, cExprs []interface{}) ([]byte, error) {
+	var elements []qvalue.Value
+	pos := -1
+	context := qexpr.NewIndexContext()
+	docval := qvalue.NewValue(doc)
+	for i, cExpr := range cExprs {
+		arrExpr, ok := cExpr.(*arrayExpr)
+		if !ok {
+			continue
+		}
+		value, err := arrExpr.expr.Evaluate(docval, context)
+		if err != nil {
+			return nil, err
+		} else if value.Type() != qvalue.ARRAY {
+			return nil, nil
+		}
+		// DISTINCT and ALL array expressions index the same entries, as
+		// entries of an element repeated within a document are identical.
+		seen := make(map[string]bool)
+		for _, item := range value.Actual().([]interface{}) {
+			element := qvalue.NewValue(item)
+			data, err := element.MarshalJSON()
+			if err != nil {
+				return nil, err
+			} else if element.Type() == qvalue.MISSING || seen[string(data)] {
+				continue
+			}
+			seen[string(data)] = true
+			elements = append(elements, element)
+		}
+		pos = i
+	}
+	if pos < 0 || len(elements) == 0 {
+		return nil, nil
+	}
+
+	// evaluate the array expression to each of its element.
+	keys := make([]json.RawMessage, 0, len(elements))
+	exprs := make([]interface{}, len(cExprs))
+	copy(exprs, cExprs)
+	for _, element := range elements {
+		exprs[pos] = qexpr.NewConstant(element)
+		key, err := N1QLTransform(docid, doc, exprs)
+		if err != nil {
+			return nil, err
+		} else if key != nil {
+			keys = append(keys, json.RawMessage(key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(keys)
+}
@@ -94,6 +94,28 @@ func TestN1QLTransform2000(t *testing.T) {
 	}
 }
 
+func TestN1QLArrayTransform(t *testing.T) {
+	doc := []byte(`{"city": "Kathmandu", "tags": ["b", "a", "b"]}`)
+	cExprs, err := CompileN1QLExpression(
+		[]string{`city`, `DISTINCT ARRAY t FOR t IN tags END`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secKeys, err := N1QLArrayTransform([]byte("docid"), doc, cExprs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := `[["Kathmandu","b","docid"],["Kathmandu","a","docid"]]`
+	if string(secKeys) != ref {
+		t.Fatalf("evaluation failed %v", string(secKeys))
+	}
+
+	_, err = CompileN1QLExpression([]string{`ALL tags`, `DISTINCT tags`})
+	if err == nil {
+		t.Fatalf("expected error for multiple array expressions")
+	}
+}
+
 func BenchmarkCompileN1QLExpression(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		CompileN1QLExpression([]string{`age`})
@@ -0,0 +1,101 @@
+package protobuf
+
+import "hash/crc32"
+
+import "github.com/couchbaselabs/goprotobuf/proto"
+import c "github.com/couchbase/indexing/secondary/common"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+// NewHashPartition return a new partition instance, initialized with a
+// list of endpoint hosts, that receive mutations for `partitions` out of
+// `numPartitions`.
+func NewHashPartition(
+	endpoints []string, numPartitions uint32, partitions []uint32) *HashPartition {
+
+	return &HashPartition{
+		Endpoints:     endpoints,
+		NumPartitions: proto.Uint32(numPartitions),
+		Partitions:    partitions,
+	}
+}
+
+// SetCoordinatorEndpoint will set coordinator endpoint, that is different
+// from other endpoints.
+func (p *HashPartition) SetCoordinatorEndpoint(endpoint string) *HashPartition {
+	p.CoordEndpoint = proto.String(endpoint)
+	return p
+}
+
+// Hosts implements Partition{} interface.
+func (p *HashPartition) Hosts(inst *IndexInst) []string {
+	endpoints := make([]string, 0)
+	for _, endpoint := range p.GetEndpoints() {
+		endpoints = append(endpoints, endpoint)
+	}
+	if p.GetCoordEndpoint() != "" {
+		endpoints = append(endpoints, p.GetCoordEndpoint())
+	}
+	return endpoints
+}
+
+// UpsertEndpoints implements Partition{} interface.
+// - not sent to coordinator-endpoint.
+// - UpsertDeletionEndpoint is implied for every UpsertEndpoint.
+// - if `key` is empty, document is no more indexed and only
+//   UpsertDeletionEndpoint apply.
+func (p *HashPartition) UpsertEndpoints(
+	inst *IndexInst, m *mc.UprEvent, partKey, key, oldKey []byte) []string {
+
+	if len(key) == 0 {
+		return nil
+	}
+	return p.partitionEndpoints(p.routingKey(inst, m, partKey))
+}
+
+// UpsertDeletionEndpoints implements Partition{} interface.
+// - for HASH partitioning document never moves across partitions.
+// - for KEY partitioning, if `oldPartKey` is not available, shall be
+//   broadcasted to all endpoints.
+func (p *HashPartition) UpsertDeletionEndpoints(
+	inst *IndexInst, m *mc.UprEvent, oldPartKey, key, oldKey []byte) []string {
+
+	return p.DeletionEndpoints(inst, m, oldPartKey, oldKey)
+}
+
+// DeletionEndpoints implements Partition{} interface.
+// - not sent to coordinator-endpoint
+// - for KEY partitioning, if `oldPartKey` is not available, shall be
+//   broadcasted to all endpoints.
+func (p *HashPartition) DeletionEndpoints(
+	inst *IndexInst, m *mc.UprEvent, oldPartKey, oldKey []byte) []string {
+
+	if key := p.routingKey(inst, m, oldPartKey); len(key) > 0 {
+		return p.partitionEndpoints(key)
+	}
+	return p.GetEndpoints()
+}
+
+// routingKey returns the key to locate the partition of a document, the
+// document id for HASH partitioning and partition key for KEY
+// partitioning.
+func (p *HashPartition) routingKey(
+	inst *IndexInst, m *mc.UprEvent, partKey []byte) []byte {
+
+	if inst.GetDefinition().GetPartitionScheme() == PartitionScheme_HASH {
+		return m.Key
+	}
+	return partKey
+}
+
+// partitionEndpoints return endpoints if partition for `key` is hosted by
+// them.
+func (p *HashPartition) partitionEndpoints(key []byte) []string {
+	n := p.GetNumPartitions()
+	if n == 0 {
+		n = 1
+	}
+	if c.HasUint32(crc32.ChecksumIEEE(key)%n, p.GetPartitions()) {
+		return p.GetEndpoints()
+	}
+	return nil
+}
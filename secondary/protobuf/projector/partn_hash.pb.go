@@ -0,0 +1,59 @@
+// Code generated by protoc-gen-go.
+// source: partn_hash.proto
+// DO NOT EDIT!
+
+package protobuf
+
+import proto "github.com/couchbaselabs/goprotobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// HashPartition routes a document to partition `hash(key) % numPartitions`,
+// where key is the evaluated partition expression for KEY partitioning and
+// the document id for HASH partitioning. Each indexer node receives
+// mutations for the partitions it hosts, on its own set of endpoints.
+type HashPartition struct {
+	Endpoints        []string `protobuf:"bytes,1,rep,name=endpoints" json:"endpoints,omitempty"`
+	NumPartitions    *uint32  `protobuf:"varint,2,req,name=numPartitions" json:"numPartitions,omitempty"`
+	Partitions       []uint32 `protobuf:"varint,3,rep,name=partitions" json:"partitions,omitempty"`
+	CoordEndpoint    *string  `protobuf:"bytes,4,opt,name=coordEndpoint" json:"coordEndpoint,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *HashPartition) Reset()         { *m = HashPartition{} }
+func (m *HashPartition) String() string { return proto.CompactTextString(m) }
+func (*HashPartition) ProtoMessage()    {}
+
+func (m *HashPartition) GetEndpoints() []string {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+func (m *HashPartition) GetNumPartitions() uint32 {
+	if m != nil && m.NumPartitions != nil {
+		return *m.NumPartitions
+	}
+	return 0
+}
+
+func (m *HashPartition) GetPartitions() []uint32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func (m *HashPartition) GetCoordEndpoint() string {
+	if m != nil && m.CoordEndpoint != nil {
+		return *m.CoordEndpoint
+	}
+	return ""
+}
+
+func init() {
+}
@@ -0,0 +1,98 @@
+package protobuf
+
+import "bytes"
+import "sort"
+
+import "github.com/couchbaselabs/goprotobuf/proto"
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/collatejson"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+// NewRangePartition return a new partition instance, initialized with a
+// list of endpoint hosts, that receive mutations for `partitions`. `bounds`
+// are collatejson encoded upper bounds of partitions.
+func NewRangePartition(
+	endpoints []string, bounds [][]byte, partitions []uint32) *RangePartition {
+
+	return &RangePartition{
+		Endpoints:  endpoints,
+		Bounds:     bounds,
+		Partitions: partitions,
+	}
+}
+
+// SetCoordinatorEndpoint will set coordinator endpoint, that is different
+// from other endpoints.
+func (p *RangePartition) SetCoordinatorEndpoint(endpoint string) *RangePartition {
+	p.CoordEndpoint = proto.String(endpoint)
+	return p
+}
+
+// Hosts implements Partition{} interface.
+func (p *RangePartition) Hosts(inst *IndexInst) []string {
+	endpoints := make([]string, 0)
+	for _, endpoint := range p.GetEndpoints() {
+		endpoints = append(endpoints, endpoint)
+	}
+	if p.GetCoordEndpoint() != "" {
+		endpoints = append(endpoints, p.GetCoordEndpoint())
+	}
+	return endpoints
+}
+
+// UpsertEndpoints implements Partition{} interface.
+// - not sent to coordinator-endpoint.
+// - UpsertDeletionEndpoint is implied for every UpsertEndpoint.
+// - if `key` is empty, document is no more indexed and only
+//   UpsertDeletionEndpoint apply.
+func (p *RangePartition) UpsertEndpoints(
+	inst *IndexInst, m *mc.UprEvent, partKey, key, oldKey []byte) []string {
+
+	if len(key) == 0 {
+		return nil
+	}
+	return p.partitionEndpoints(partKey)
+}
+
+// UpsertDeletionEndpoints implements Partition{} interface.
+// - if `oldPartKey` is not available, shall be broadcasted to all
+//   endpoints.
+func (p *RangePartition) UpsertDeletionEndpoints(
+	inst *IndexInst, m *mc.UprEvent, oldPartKey, key, oldKey []byte) []string {
+
+	return p.DeletionEndpoints(inst, m, oldPartKey, oldKey)
+}
+
+// DeletionEndpoints implements Partition{} interface.
+// - not sent to coordinator-endpoint
+// - if `oldPartKey` is not available, shall be broadcasted to all
+//   endpoints.
+func (p *RangePartition) DeletionEndpoints(
+	inst *IndexInst, m *mc.UprEvent, oldPartKey, oldKey []byte) []string {
+
+	if len(oldPartKey) > 0 {
+		return p.partitionEndpoints(oldPartKey)
+	}
+	return p.GetEndpoints()
+}
+
+// partitionEndpoints return endpoints if partition for `partKey` is
+// hosted by them. Partition key that cannot be collated, or is missing,
+// belongs to the first partition.
+func (p *RangePartition) partitionEndpoints(partKey []byte) []string {
+	var id uint32
+	if len(partKey) > 0 {
+		codec := collatejson.NewCodec(16)
+		code, err := codec.StreamEncode(partKey, nil)
+		if err == nil {
+			bounds := p.GetBounds()
+			id = uint32(sort.Search(len(bounds), func(i int) bool {
+				return bytes.Compare(code, bounds[i]) < 0
+			}))
+		}
+	}
+	if c.HasUint32(id, p.GetPartitions()) {
+		return p.GetEndpoints()
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Code generated by protoc-gen-go.
+// source: partn_range.proto
+// DO NOT EDIT!
+
+package protobuf
+
+import proto "github.com/couchbaselabs/goprotobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// RangePartition routes a document to the partition whose range contains
+// the evaluated partition expression. Partition `i` holds keys less than
+// bounds[i] and not less than bounds[i-1], the last partition holds the
+// rest of the keys. Each indexer node receives mutations for the
+// partitions it hosts, on its own set of endpoints.
+type RangePartition struct {
+	Endpoints        []string `protobuf:"bytes,1,rep,name=endpoints" json:"endpoints,omitempty"`
+	Bounds           [][]byte `protobuf:"bytes,2,rep,name=bounds" json:"bounds,omitempty"`
+	Partitions       []uint32 `protobuf:"varint,3,rep,name=partitions" json:"partitions,omitempty"`
+	CoordEndpoint    *string  `protobuf:"bytes,4,opt,name=coordEndpoint" json:"coordEndpoint,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *RangePartition) Reset()         { *m = RangePartition{} }
+func (m *RangePartition) String() string { return proto.CompactTextString(m) }
+func (*RangePartition) ProtoMessage()    {}
+
+func (m *RangePartition) GetEndpoints() []string {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+func (m *RangePartition) GetBounds() [][]byte {
+	if m != nil {
+		return m.Bounds
+	}
+	return nil
+}
+
+func (m *RangePartition) GetPartitions() []uint32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func (m *RangePartition) GetCoordEndpoint() string {
+	if m != nil && m.CoordEndpoint != nil {
+		return *m.CoordEndpoint
+	}
+	return ""
+}
+
+func init() {
+}
@@ -0,0 +1,136 @@
+package protobuf
+
+import "fmt"
+import "reflect"
+import "testing"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+var testPartnKey = []byte(`["key"]`)
+
+type testPartition interface {
+	UpsertEndpoints(inst *IndexInst, m *mc.UprEvent, partKey, key, oldKey []byte) []string
+	DeletionEndpoints(inst *IndexInst, m *mc.UprEvent, oldPartKey, oldKey []byte) []string
+}
+
+// routedPartitions returns the partitions whose endpoints receive the
+// upsert and the deletion of document `docid`.
+func routedPartitions(
+	inst *IndexInst, partns []testPartition,
+	docid, partKey string) (upserts, deletions []int) {
+
+	m := &mc.UprEvent{Key: []byte(docid)}
+	for i, p := range partns {
+		host := []string{fmt.Sprintf("host-%v", i)}
+		endpoints := p.UpsertEndpoints(inst, m, []byte(partKey), testPartnKey, nil)
+		if reflect.DeepEqual(endpoints, host) {
+			upserts = append(upserts, i)
+		}
+		endpoints = p.DeletionEndpoints(inst, m, []byte(partKey), nil)
+		if reflect.DeepEqual(endpoints, host) {
+			deletions = append(deletions, i)
+		}
+	}
+	return upserts, deletions
+}
+
+func TestHashPartition(t *testing.T) {
+	partns := make([]testPartition, 0, 4)
+	for i := 0; i < 4; i++ {
+		host := []string{fmt.Sprintf("host-%v", i)}
+		partns = append(partns, NewHashPartition(host, 4, []uint32{uint32(i)}))
+	}
+
+	testcases := []struct {
+		scheme         PartitionScheme
+		docid, partKey string
+		upserts        []int // crc32 of routing key % 4
+		deletions      []int
+	}{
+		{PartitionScheme_HASH, "doc1", "", []int{3}, []int{3}},
+		{PartitionScheme_HASH, "doc4", "", []int{0}, []int{0}},
+		{PartitionScheme_HASH, "user::11", "", []int{2}, []int{2}},
+		// partition key is ignored by HASH partitioning.
+		{PartitionScheme_HASH, "doc2", `"tokyo"`, []int{1}, []int{1}},
+		{PartitionScheme_KEY, "doc1", `"tokyo"`, []int{1}, []int{1}},
+		{PartitionScheme_KEY, "doc2", `"paris"`, []int{2}, []int{2}},
+		{PartitionScheme_KEY, "doc3", `10`, []int{1}, []int{1}},
+		{PartitionScheme_KEY, "doc4", `[1,2]`, []int{3}, []int{3}},
+		// missing partition key, deletion is broadcasted.
+		{PartitionScheme_KEY, "doc1", "", []int{0}, []int{0, 1, 2, 3}},
+	}
+	for _, tc := range testcases {
+		inst := &IndexInst{
+			Definition: &IndexDefn{PartitionScheme: tc.scheme.Enum()},
+		}
+		upserts, deletions := routedPartitions(inst, partns, tc.docid, tc.partKey)
+		if !reflect.DeepEqual(upserts, tc.upserts) {
+			t.Errorf("%v %v %v: expected upsert on %v, got %v",
+				tc.scheme, tc.docid, tc.partKey, tc.upserts, upserts)
+		}
+		if !reflect.DeepEqual(deletions, tc.deletions) {
+			t.Errorf("%v %v %v: expected deletion on %v, got %v",
+				tc.scheme, tc.docid, tc.partKey, tc.deletions, deletions)
+		}
+	}
+
+	// document no more indexed is only deleted.
+	inst := &IndexInst{
+		Definition: &IndexDefn{PartitionScheme: PartitionScheme_HASH.Enum()},
+	}
+	m := &mc.UprEvent{Key: []byte("doc1")}
+	if endpoints := partns[3].UpsertEndpoints(inst, m, nil, nil, nil); endpoints != nil {
+		t.Errorf("expected no upsert for empty key, got %v", endpoints)
+	}
+}
+
+func TestRangePartition(t *testing.T) {
+	// partitions [.., 10), [10, 20), [20, ..)
+	codec := collatejson.NewCodec(16)
+	bounds := make([][]byte, 0, 2)
+	for _, bound := range []string{`10`, `20`} {
+		code, err := codec.Encode([]byte(bound), make([]byte, 0, 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds = append(bounds, code)
+	}
+	partns := make([]testPartition, 0, 3)
+	for i := 0; i < 3; i++ {
+		host := []string{fmt.Sprintf("host-%v", i)}
+		partns = append(partns, NewRangePartition(host, bounds, []uint32{uint32(i)}))
+	}
+	inst := &IndexInst{
+		Definition: &IndexDefn{PartitionScheme: PartitionScheme_RANGE.Enum()},
+	}
+
+	testcases := []struct {
+		partKey   string
+		upserts   []int
+		deletions []int
+	}{
+		{`5`, []int{0}, []int{0}},
+		{`9.999`, []int{0}, []int{0}},
+		{`10`, []int{1}, []int{1}}, // bounds are exclusive
+		{`15`, []int{1}, []int{1}},
+		{`20`, []int{2}, []int{2}},
+		{`1000`, []int{2}, []int{2}},
+		{`null`, []int{0}, []int{0}},  // sorts before numbers
+		{`"abc"`, []int{2}, []int{2}}, // sorts after numbers
+		{`{`, []int{0}, []int{0}},     // cannot be collated
+		// missing partition key, deletion is broadcasted.
+		{``, []int{0}, []int{0, 1, 2}},
+	}
+	for _, tc := range testcases {
+		upserts, deletions := routedPartitions(inst, partns, "doc1", tc.partKey)
+		if !reflect.DeepEqual(upserts, tc.upserts) {
+			t.Errorf("%v: expected upsert on %v, got %v",
+				tc.partKey, tc.upserts, upserts)
+		}
+		if !reflect.DeepEqual(deletions, tc.deletions) {
+			t.Errorf("%v: expected deletion on %v, got %v",
+				tc.partKey, tc.deletions, deletions)
+		}
+	}
+}
@@ -276,8 +276,10 @@ func (req *MutationTopicRequest) Append(reqTs *TsVbuuid) *MutationTopicRequest {
 //}
 
 // GetEvaluators impelement Subscriber{} interface
-func (req *MutationTopicRequest) GetEvaluators() (map[uint64]c.Evaluator, error) {
-	return getEvaluators(req.GetInstances())
+func (req *MutationTopicRequest) GetEvaluators(
+	config c.Config) (map[uint64]c.Evaluator, error) {
+
+	return getEvaluators(req.GetInstances(), config)
 }
 
 // GetRouters impelement Subscriber{} interface
@@ -548,8 +550,10 @@ func (req *AddBucketsRequest) Decode(data []byte) (err error) {
 }
 
 // GetEvaluators impelement Subscriber{} interface
-func (req *AddBucketsRequest) GetEvaluators() (map[uint64]c.Evaluator, error) {
-	return getEvaluators(req.GetInstances())
+func (req *AddBucketsRequest) GetEvaluators(
+	config c.Config) (map[uint64]c.Evaluator, error) {
+
+	return getEvaluators(req.GetInstances(), config)
 }
 
 // GetRouters impelement Subscriber{} interface
@@ -628,8 +632,10 @@ func (req *AddInstancesRequest) Decode(data []byte) (err error) {
 }
 
 // GetEvaluators impelement Subscriber{} interface
-func (req *AddInstancesRequest) GetEvaluators() (map[uint64]c.Evaluator, error) {
-	return getEvaluators(req.GetInstances())
+func (req *AddInstancesRequest) GetEvaluators(
+	config c.Config) (map[uint64]c.Evaluator, error) {
+
+	return getEvaluators(req.GetInstances(), config)
 }
 
 // GetRouters impelement Subscriber{} interface
@@ -738,12 +744,14 @@ func (req *ShutdownTopicRequest) Decode(data []byte) (err error) {
 //-- local functions
 
 // TODO: add other types of engines
-func getEvaluators(instances []*Instance) (map[uint64]c.Evaluator, error) {
+func getEvaluators(
+	instances []*Instance, config c.Config) (map[uint64]c.Evaluator, error) {
+
 	engines := make(map[uint64]c.Evaluator)
 	for _, instance := range instances {
 		uuid := instance.GetUuid()
 		if val := instance.GetIndexInstance(); val != nil {
-			ie, err := NewIndexEvaluator(val)
+			ie, err := NewIndexEvaluator(val, config)
 			if err != nil {
 				return nil, err
 			}
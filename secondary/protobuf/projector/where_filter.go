@@ -0,0 +1,86 @@
+package protobuf
+
+import "sync/atomic"
+
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+// whereFilter tracks the documents of a vbucket that qualify the WHERE
+// clause of a partial index, so that mutations of documents that were never
+// indexed can be dropped by the projector. Tracking is complete only if the
+// vbucket stream began from seqno 0, otherwise qualification of documents
+// not mutated since the stream began is unknown.
+//
+// Documents tracked by all filters of an index are limited to the
+// evaluator's maxFilterDocs, a filter adding documents beyond that limit
+// gives up tracking. A filter is accessed only by the routine handling its
+// vbucket.
+type whereFilter struct {
+	complete bool
+	docids   map[string]bool
+}
+
+// resetFilter starts tracking qualifying documents of vbucket `vbno`
+// afresh, for the vbucket stream beginning from `seqno`.
+func (ie *IndexEvaluator) resetFilter(vbno uint16, seqno uint64) {
+	if ie.whExpr == nil { // not a partial index
+		return
+	}
+	f := &whereFilter{complete: seqno == 0 && ie.maxFilterDocs > 0}
+	if f.complete {
+		f.docids = make(map[string]bool)
+	}
+	ie.mu.Lock()
+	old := ie.filters[vbno]
+	ie.filters[vbno] = f
+	ie.mu.Unlock()
+	if old != nil {
+		atomic.AddInt64(&ie.filterDocs, -int64(len(old.docids)))
+	}
+}
+
+// qualified returns whether the previous version of the document, mutated
+// or deleted by `m`, was indexed. known is false if that is not known, in
+// which case the mutation shall be passed on to indexers.
+func (ie *IndexEvaluator) qualified(
+	m *mc.UprEvent, oldWhere bool) (qualified, known bool) {
+
+	if ie.whExpr == nil { // not a partial index
+		return true, true
+	} else if len(m.OldValue) > 0 {
+		return oldWhere, true
+	}
+	ie.mu.Lock()
+	f := ie.filters[m.VBucket]
+	ie.mu.Unlock()
+	if f == nil || !f.complete {
+		return false, false
+	}
+	return f.docids[string(m.Key)], true
+}
+
+// track whether the latest version of the document, mutated or deleted by
+// `m`, is indexed.
+func (ie *IndexEvaluator) track(m *mc.UprEvent, where bool) {
+	if ie.whExpr == nil { // not a partial index
+		return
+	}
+	ie.mu.Lock()
+	f := ie.filters[m.VBucket]
+	ie.mu.Unlock()
+	if f == nil || !f.complete {
+		return
+	}
+
+	key := string(m.Key)
+	if _, ok := f.docids[key]; ok == where {
+		return
+	} else if !where {
+		delete(f.docids, key)
+		atomic.AddInt64(&ie.filterDocs, -1)
+	} else if atomic.AddInt64(&ie.filterDocs, 1) <= ie.maxFilterDocs {
+		f.docids[key] = true
+	} else {
+		atomic.AddInt64(&ie.filterDocs, -int64(len(f.docids)+1))
+		f.complete, f.docids = false, nil
+	}
+}
@@ -0,0 +1,94 @@
+package protobuf
+
+import "fmt"
+import "testing"
+
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+func TestWhereFilter(t *testing.T) {
+	ie := &IndexEvaluator{
+		whExpr:        "doc.age > 30",
+		filters:       make(map[uint16]*whereFilter),
+		maxFilterDocs: 10,
+	}
+	m := &mc.UprEvent{VBucket: 1, Key: []byte("doc1")}
+
+	// qualification is not known before stream begins from seqno 0.
+	if _, known := ie.qualified(m, false); known {
+		t.Fatalf("expected unknown qualification")
+	}
+	ie.resetFilter(1, 10)
+	ie.track(m, true)
+	if _, known := ie.qualified(m, false); known {
+		t.Fatalf("expected unknown qualification")
+	}
+
+	ie.resetFilter(1, 0)
+	if qualified, known := ie.qualified(m, false); !known || qualified {
+		t.Fatalf("expected document to not qualify")
+	}
+	ie.track(m, true)
+	if qualified, known := ie.qualified(m, false); !known || !qualified {
+		t.Fatalf("expected document to qualify")
+	}
+	ie.track(m, false)
+	if qualified, _ := ie.qualified(m, false); qualified {
+		t.Fatalf("expected document to not qualify")
+	}
+
+	// old value, when available, decides the qualification.
+	m.OldValue = []byte(`{"age": 40}`)
+	if qualified, known := ie.qualified(m, true); !known || !qualified {
+		t.Fatalf("expected document to qualify")
+	}
+}
+
+func TestWhereFilterLimit(t *testing.T) {
+	ie := &IndexEvaluator{
+		whExpr:        "doc.age > 30",
+		filters:       make(map[uint16]*whereFilter),
+		maxFilterDocs: 4,
+	}
+	ie.resetFilter(1, 0)
+	ie.resetFilter(2, 0)
+	track := func(vbno uint16, docid string, where bool) *mc.UprEvent {
+		m := &mc.UprEvent{VBucket: vbno, Key: []byte(docid)}
+		ie.track(m, where)
+		return m
+	}
+	for i := 0; i < 2; i++ {
+		track(1, fmt.Sprintf("doc1-%d", i), true)
+		track(2, fmt.Sprintf("doc2-%d", i), true)
+	}
+	// tracking same document again, or untracked document, is not counted.
+	track(1, "doc1-0", true)
+	track(2, "doc2-9", false)
+	if ie.filterDocs != 4 {
+		t.Fatalf("expected 4 tracked documents, got %v", ie.filterDocs)
+	}
+
+	// limit is shared by all vbuckets of the index.
+	m := track(2, "doc2-2", true)
+	if _, known := ie.qualified(m, false); known {
+		t.Fatalf("expected vbucket 2 to give up tracking")
+	} else if ie.filterDocs != 2 {
+		t.Fatalf("expected 2 tracked documents, got %v", ie.filterDocs)
+	}
+	m = track(1, "doc1-2", true)
+	if qualified, known := ie.qualified(m, false); !known || !qualified {
+		t.Fatalf("expected document to qualify")
+	}
+
+	// restarted stream releases its documents.
+	ie.resetFilter(1, 0)
+	if ie.filterDocs != 0 {
+		t.Fatalf("expected no tracked documents, got %v", ie.filterDocs)
+	}
+
+	// tracking is disabled without a limit.
+	ie.maxFilterDocs = 0
+	ie.resetFilter(1, 0)
+	if _, known := ie.qualified(m, false); known {
+		t.Fatalf("expected unknown qualification")
+	}
+}
@@ -13,11 +13,26 @@ var ErrorTransportVersion = errors.New("dataport.transportVersion")
 // ErrorMissingPayload
 var ErrorMissingPayload = errors.New("dataport.missingPlayload")
 
+// ErrorInvalidVector
+var ErrorInvalidVector = errors.New("queryport.invalidVector")
+
+// Tagged associates a request or response message with the request-id of
+// its stream, to multiplex several requests on a single connection.
+type Tagged struct {
+	RequestId uint64
+	Message   interface{}
+}
+
 // ProtobufEncode encode payload message into protobuf array of bytes. Return
 // `data` can be transported to the other end and decoded back to Payload
 // message.
 func ProtobufEncode(payload interface{}) (data []byte, err error) {
 	pl := &QueryPayload{Version: proto.Uint32(uint32(ProtobufVersion()))}
+	if tagged, ok := payload.(*Tagged); ok {
+		pl.RequestId = proto.Uint64(tagged.RequestId)
+		payload = tagged.Message
+	}
+
 	switch val := payload.(type) {
 	// request
 	case *StatisticsRequest:
@@ -35,6 +50,9 @@ func ProtobufEncode(payload interface{}) (data []byte, err error) {
 	case *EndStreamRequest:
 		pl.EndStream = val
 
+	case *StreamWindow:
+		pl.StreamWindow = val
+
 	// response
 	case *StatisticsResponse:
 		pl.Statistics = val
@@ -56,7 +74,8 @@ func ProtobufEncode(payload interface{}) (data []byte, err error) {
 }
 
 // ProtobufDecode complements ProtobufEncode() API. `data` returned by encode
-// is converted back to protobuf message structure.
+// is converted back to protobuf message structure. Messages tagged with a
+// request-id are returned as *Tagged.
 func ProtobufDecode(data []byte) (value interface{}, err error) {
 	pl := &QueryPayload{}
 	if err = proto.Unmarshal(data, pl); err != nil {
@@ -71,6 +90,13 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		pl = protoMsgConvertor[ver](pl)
 	}
 
+	if value, err = decodeMessage(pl); err != nil || pl.RequestId == nil {
+		return value, err
+	}
+	return &Tagged{RequestId: pl.GetRequestId(), Message: value}, nil
+}
+
+func decodeMessage(pl *QueryPayload) (interface{}, error) {
 	// request
 	if val := pl.GetStatisticsRequest(); val != nil {
 		return val, nil
@@ -82,6 +108,8 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetEndStream(); val != nil {
 		return val, nil
+	} else if val := pl.GetStreamWindow(); val != nil {
+		return val, nil
 		// response
 	} else if val := pl.GetStatistics(); val != nil {
 		return val, nil
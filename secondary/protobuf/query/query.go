@@ -4,6 +4,67 @@ import "errors"
 import "encoding/json"
 
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+// NewTsConsistency returns a new instance of timestamp vector, to be used
+// with index scans requesting query consistency.
+func NewTsConsistency(
+	vbnos []uint16, seqnos []uint64, vbuuids []uint64) *TsConsistency {
+
+	vbnos32 := make([]uint32, len(vbnos))
+	for i, vbno := range vbnos {
+		vbnos32[i] = uint32(vbno)
+	}
+	return &TsConsistency{Vbnos: vbnos32, Seqnos: seqnos, Vbuuids: vbuuids}
+}
+
+// ToTsVbuuid converts timestamp vector to common.TsVbuuid for `bucket`,
+// vbuckets not present in the vector will have zero seqno and vbuuid.
+func (ts *TsConsistency) ToTsVbuuid(
+	bucket string, numVbuckets int) (*c.TsVbuuid, error) {
+
+	vbnos, seqnos, vbuuids := ts.GetVbnos(), ts.GetSeqnos(), ts.GetVbuuids()
+	if len(vbnos) != len(seqnos) || len(vbnos) != len(vbuuids) {
+		return nil, ErrorInvalidVector
+	}
+	tsVbuuid := c.NewTsVbuuid(bucket, numVbuckets)
+	for i, vbno := range vbnos {
+		if int(vbno) >= numVbuckets {
+			return nil, ErrorInvalidVector
+		}
+		tsVbuuid.Seqnos[vbno] = seqnos[i]
+		tsVbuuid.Vbuuids[vbno] = vbuuids[i]
+	}
+	return tsVbuuid, nil
+}
+
+// NewScanCursor returns an opaque cursor to resume a scan after index
+// `entry`, returned from the snapshot at timestamp `snapshot`.
+func NewScanCursor(entry *IndexEntry, snapshot *TsConsistency) ([]byte, error) {
+	key, err := entry.IndexKey()
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&ScanCursor{Key: key, Snapshot: snapshot})
+}
+
+// IndexKey returns the key of index entry as stored in the index, a JSON
+// array of [secKey..., docid].
+func (entry *IndexEntry) IndexKey() ([]byte, error) {
+	docid, err := json.Marshal(string(entry.GetPrimaryKey()))
+	if err != nil {
+		return nil, err
+	}
+	// secKey is empty for primary index.
+	key := []byte{'['}
+	if secKey := entry.GetEntryKey(); len(secKey) > 2 {
+		key = append(key, secKey[1:len(secKey)-1]...)
+		key = append(key, ',')
+	}
+	key = append(key, docid...)
+	key = append(key, ']')
+	return key, nil
+}
 
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
@@ -81,5 +142,13 @@ func (s *IndexStatistics) DistinctCount() (int64, error) {
 
 // Bins implements common.IndexStatistics{} method.
 func (s *IndexStatistics) Bins() ([]c.IndexStatistics, error) {
-	return nil, nil
+	histogram := s.GetHistogram()
+	if len(histogram) == 0 {
+		return nil, nil
+	}
+	bins := make([]c.IndexStatistics, 0, len(histogram))
+	for _, bin := range histogram {
+		bins = append(bins, bin)
+	}
+	return bins, nil
 }
@@ -16,12 +16,15 @@ It has these top-level messages:
 	ScanRequest
 	ScanAllRequest
 	EndStreamRequest
+	StreamWindow
 	ResponseStream
 	StreamEndResponse
 	CountRequest
 	CountResponse
 	Span
 	Range
+	TsConsistency
+	ScanCursor
 	IndexEntry
 	IndexStatistics
 */
@@ -64,7 +67,11 @@ type QueryPayload struct {
 	CountResponse     *CountResponse      `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
 	EndStream         *EndStreamRequest   `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
 	StreamEnd         *StreamEndResponse  `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
-	XXX_unrecognized  []byte              `json:"-"`
+	StreamWindow      *StreamWindow       `protobuf:"bytes,11,opt,name=streamWindow" json:"streamWindow,omitempty"`
+	// requests multiplexed on a connection are tagged with a request-id,
+	// that is unique for the connection, responses carry the same id.
+	RequestId        *uint64 `protobuf:"varint,12,opt,name=requestId" json:"requestId,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *QueryPayload) Reset()         { *m = QueryPayload{} }
@@ -141,6 +148,20 @@ func (m *QueryPayload) GetStreamEnd() *StreamEndResponse {
 	return nil
 }
 
+func (m *QueryPayload) GetStreamWindow() *StreamWindow {
+	if m != nil {
+		return m.StreamWindow
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetRequestId() uint64 {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return 0
+}
+
 // Get Index statistics. StatisticsResponse is returned back from indexer.
 type StatisticsRequest struct {
 	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -192,18 +213,25 @@ func (m *StatisticsResponse) GetErr() *Error {
 
 // Scan request to indexer.
 type ScanRequest struct {
-	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
-	Span             *Span   `protobuf:"bytes,2,req,name=span" json:"span,omitempty"`
-	Distinct         *bool   `protobuf:"varint,3,req,name=distinct" json:"distinct,omitempty"`
-	Limit            *int64  `protobuf:"varint,4,req,name=limit" json:"limit,omitempty"`
-	PageSize         *int64  `protobuf:"varint,5,req,name=pageSize" json:"pageSize,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Span             *Span          `protobuf:"bytes,2,req,name=span" json:"span,omitempty"`
+	Distinct         *bool          `protobuf:"varint,3,req,name=distinct" json:"distinct,omitempty"`
+	Limit            *int64         `protobuf:"varint,4,req,name=limit" json:"limit,omitempty"`
+	PageSize         *int64         `protobuf:"varint,5,req,name=pageSize" json:"pageSize,omitempty"`
+	Cons             *uint32        `protobuf:"varint,6,opt,name=cons,def=1" json:"cons,omitempty"`
+	Vector           *TsConsistency `protobuf:"bytes,7,opt,name=vector" json:"vector,omitempty"`
+	Reverse          *bool          `protobuf:"varint,8,opt,name=reverse" json:"reverse,omitempty"`
+	Offset           *int64         `protobuf:"varint,9,opt,name=offset" json:"offset,omitempty"`
+	Cursor           []byte         `protobuf:"bytes,10,opt,name=cursor" json:"cursor,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
 }
 
 func (m *ScanRequest) Reset()         { *m = ScanRequest{} }
 func (m *ScanRequest) String() string { return proto.CompactTextString(m) }
 func (*ScanRequest) ProtoMessage()    {}
 
+const Default_ScanRequest_Cons uint32 = 1
+
 func (m *ScanRequest) GetDefnID() uint64 {
 	if m != nil && m.DefnID != nil {
 		return *m.DefnID
@@ -239,18 +267,60 @@ func (m *ScanRequest) GetPageSize() int64 {
 	return 0
 }
 
+func (m *ScanRequest) GetCons() uint32 {
+	if m != nil && m.Cons != nil {
+		return *m.Cons
+	}
+	return Default_ScanRequest_Cons
+}
+
+func (m *ScanRequest) GetVector() *TsConsistency {
+	if m != nil {
+		return m.Vector
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetReverse() bool {
+	if m != nil && m.Reverse != nil {
+		return *m.Reverse
+	}
+	return false
+}
+
+func (m *ScanRequest) GetOffset() int64 {
+	if m != nil && m.Offset != nil {
+		return *m.Offset
+	}
+	return 0
+}
+
+func (m *ScanRequest) GetCursor() []byte {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
 // Full table scan request from indexer.
 type ScanAllRequest struct {
-	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
-	PageSize         *int64  `protobuf:"varint,2,req,name=pageSize" json:"pageSize,omitempty"`
-	Limit            *int64  `protobuf:"varint,3,req,name=limit" json:"limit,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	PageSize         *int64         `protobuf:"varint,2,req,name=pageSize" json:"pageSize,omitempty"`
+	Limit            *int64         `protobuf:"varint,3,req,name=limit" json:"limit,omitempty"`
+	Cons             *uint32        `protobuf:"varint,4,opt,name=cons,def=1" json:"cons,omitempty"`
+	Vector           *TsConsistency `protobuf:"bytes,5,opt,name=vector" json:"vector,omitempty"`
+	Reverse          *bool          `protobuf:"varint,6,opt,name=reverse" json:"reverse,omitempty"`
+	Offset           *int64         `protobuf:"varint,7,opt,name=offset" json:"offset,omitempty"`
+	Cursor           []byte         `protobuf:"bytes,8,opt,name=cursor" json:"cursor,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
 }
 
 func (m *ScanAllRequest) Reset()         { *m = ScanAllRequest{} }
 func (m *ScanAllRequest) String() string { return proto.CompactTextString(m) }
 func (*ScanAllRequest) ProtoMessage()    {}
 
+const Default_ScanAllRequest_Cons uint32 = 1
+
 func (m *ScanAllRequest) GetDefnID() uint64 {
 	if m != nil && m.DefnID != nil {
 		return *m.DefnID
@@ -272,6 +342,41 @@ func (m *ScanAllRequest) GetLimit() int64 {
 	return 0
 }
 
+func (m *ScanAllRequest) GetCons() uint32 {
+	if m != nil && m.Cons != nil {
+		return *m.Cons
+	}
+	return Default_ScanAllRequest_Cons
+}
+
+func (m *ScanAllRequest) GetVector() *TsConsistency {
+	if m != nil {
+		return m.Vector
+	}
+	return nil
+}
+
+func (m *ScanAllRequest) GetReverse() bool {
+	if m != nil && m.Reverse != nil {
+		return *m.Reverse
+	}
+	return false
+}
+
+func (m *ScanAllRequest) GetOffset() int64 {
+	if m != nil && m.Offset != nil {
+		return *m.Offset
+	}
+	return 0
+}
+
+func (m *ScanAllRequest) GetCursor() []byte {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
 // Request by client to stop streaming the query results.
 type EndStreamRequest struct {
 	XXX_unrecognized []byte `json:"-"`
@@ -281,10 +386,29 @@ func (m *EndStreamRequest) Reset()         { *m = EndStreamRequest{} }
 func (m *EndStreamRequest) String() string { return proto.CompactTextString(m) }
 func (*EndStreamRequest) ProtoMessage()    {}
 
+// Credits granted by client for a multiplexed request, indexer shall not
+// send more responses on the request stream than granted credits.
+type StreamWindow struct {
+	Credits          *uint32 `protobuf:"varint,1,req,name=credits" json:"credits,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *StreamWindow) Reset()         { *m = StreamWindow{} }
+func (m *StreamWindow) String() string { return proto.CompactTextString(m) }
+func (*StreamWindow) ProtoMessage()    {}
+
+func (m *StreamWindow) GetCredits() uint32 {
+	if m != nil && m.Credits != nil {
+		return *m.Credits
+	}
+	return 0
+}
+
 type ResponseStream struct {
-	IndexEntries     []*IndexEntry `protobuf:"bytes,1,rep,name=indexEntries" json:"indexEntries,omitempty"`
-	Err              *Error        `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
-	XXX_unrecognized []byte        `json:"-"`
+	IndexEntries     []*IndexEntry  `protobuf:"bytes,1,rep,name=indexEntries" json:"indexEntries,omitempty"`
+	Err              *Error         `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
+	Snapshot         *TsConsistency `protobuf:"bytes,3,opt,name=snapshot" json:"snapshot,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
 }
 
 func (m *ResponseStream) Reset()         { *m = ResponseStream{} }
@@ -305,6 +429,13 @@ func (m *ResponseStream) GetErr() *Error {
 	return nil
 }
 
+func (m *ResponseStream) GetSnapshot() *TsConsistency {
+	if m != nil {
+		return m.Snapshot
+	}
+	return nil
+}
+
 // Last response packet sent by server to end query results.
 type StreamEndResponse struct {
 	Err              *Error `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
@@ -428,6 +559,67 @@ func (m *Range) GetInclusion() uint32 {
 	return 0
 }
 
+// Timestamp vector for a subset of vbuckets, scans requesting query
+// consistency shall be served from a snapshot atleast as recent as this
+// vector.
+type TsConsistency struct {
+	Vbnos            []uint32 `protobuf:"varint,1,rep,name=vbnos" json:"vbnos,omitempty"`
+	Seqnos           []uint64 `protobuf:"varint,2,rep,name=seqnos" json:"seqnos,omitempty"`
+	Vbuuids          []uint64 `protobuf:"varint,3,rep,name=vbuuids" json:"vbuuids,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *TsConsistency) Reset()         { *m = TsConsistency{} }
+func (m *TsConsistency) String() string { return proto.CompactTextString(m) }
+func (*TsConsistency) ProtoMessage()    {}
+
+func (m *TsConsistency) GetVbnos() []uint32 {
+	if m != nil {
+		return m.Vbnos
+	}
+	return nil
+}
+
+func (m *TsConsistency) GetSeqnos() []uint64 {
+	if m != nil {
+		return m.Seqnos
+	}
+	return nil
+}
+
+func (m *TsConsistency) GetVbuuids() []uint64 {
+	if m != nil {
+		return m.Vbuuids
+	}
+	return nil
+}
+
+// Opaque cursor to resume a scan after an index entry, from a snapshot
+// atleast as recent as the snapshot that returned the entry.
+type ScanCursor struct {
+	Key              []byte         `protobuf:"bytes,1,req,name=key" json:"key,omitempty"`
+	Snapshot         *TsConsistency `protobuf:"bytes,2,opt,name=snapshot" json:"snapshot,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *ScanCursor) Reset()         { *m = ScanCursor{} }
+func (m *ScanCursor) String() string { return proto.CompactTextString(m) }
+func (*ScanCursor) ProtoMessage()    {}
+
+func (m *ScanCursor) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *ScanCursor) GetSnapshot() *TsConsistency {
+	if m != nil {
+		return m.Snapshot
+	}
+	return nil
+}
+
 type IndexEntry struct {
 	EntryKey         []byte `protobuf:"bytes,1,req,name=entryKey" json:"entryKey,omitempty"`
 	PrimaryKey       []byte `protobuf:"bytes,2,req,name=primaryKey" json:"primaryKey,omitempty"`
@@ -454,11 +646,13 @@ func (m *IndexEntry) GetPrimaryKey() []byte {
 
 // Statistics of a given index.
 type IndexStatistics struct {
-	KeysCount        *uint64 `protobuf:"varint,1,req,name=keysCount" json:"keysCount,omitempty"`
-	UniqueKeysCount  *uint64 `protobuf:"varint,2,req,name=uniqueKeysCount" json:"uniqueKeysCount,omitempty"`
-	KeyMin           []byte  `protobuf:"bytes,3,req,name=keyMin" json:"keyMin,omitempty"`
-	KeyMax           []byte  `protobuf:"bytes,4,req,name=keyMax" json:"keyMax,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	KeysCount        *uint64            `protobuf:"varint,1,req,name=keysCount" json:"keysCount,omitempty"`
+	UniqueKeysCount  *uint64            `protobuf:"varint,2,req,name=uniqueKeysCount" json:"uniqueKeysCount,omitempty"`
+	KeyMin           []byte             `protobuf:"bytes,3,req,name=keyMin" json:"keyMin,omitempty"`
+	KeyMax           []byte             `protobuf:"bytes,4,req,name=keyMax" json:"keyMax,omitempty"`
+	Histogram        []*IndexStatistics `protobuf:"bytes,5,rep,name=histogram" json:"histogram,omitempty"`
+	UniqueEstimated  *bool              `protobuf:"varint,6,opt,name=uniqueEstimated" json:"uniqueEstimated,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
 }
 
 func (m *IndexStatistics) Reset()         { *m = IndexStatistics{} }
@@ -493,5 +687,19 @@ func (m *IndexStatistics) GetKeyMax() []byte {
 	return nil
 }
 
+func (m *IndexStatistics) GetHistogram() []*IndexStatistics {
+	if m != nil {
+		return m.Histogram
+	}
+	return nil
+}
+
+func (m *IndexStatistics) GetUniqueEstimated() bool {
+	if m != nil && m.UniqueEstimated != nil {
+		return *m.UniqueEstimated
+	}
+	return false
+}
+
 func init() {
 }
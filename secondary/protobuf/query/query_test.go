@@ -0,0 +1,94 @@
+package protobuf
+
+import "reflect"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+func TestTsConsistencyRoundTrip(t *testing.T) {
+	vector := NewTsConsistency(
+		[]uint16{0, 5, 1023}, []uint64{10, 50, 1023}, []uint64{1, 5, 9})
+	req := &ScanRequest{
+		DefnID:   proto.Uint64(1),
+		Span:     &Span{Equals: [][]byte{[]byte(`"a"`)}},
+		Distinct: proto.Bool(false),
+		Limit:    proto.Int64(10),
+		PageSize: proto.Int64(1),
+		Cons:     proto.Uint32(uint32(c.QueryConsistency)),
+		Vector:   vector,
+	}
+	data, err := ProtobufEncode(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ProtobufDecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := msg.(*ScanRequest)
+	if c.Consistency(r.GetCons()) != c.QueryConsistency {
+		t.Fatalf("expected query consistency, got %v", r.GetCons())
+	}
+
+	ts, err := r.GetVector().ToTsVbuuid("default", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := c.NewTsVbuuid("default", 1024)
+	ref.Seqnos[0], ref.Seqnos[5], ref.Seqnos[1023] = 10, 50, 1023
+	ref.Vbuuids[0], ref.Vbuuids[5], ref.Vbuuids[1023] = 1, 5, 9
+	if !reflect.DeepEqual(ts.Seqnos, ref.Seqnos) ||
+		!reflect.DeepEqual(ts.Vbuuids, ref.Vbuuids) {
+		t.Fatalf("expected %v, got %v", ref, ts)
+	}
+}
+
+func TestTsConsistencyInvalid(t *testing.T) {
+	vector := NewTsConsistency([]uint16{1024}, []uint64{1}, []uint64{1})
+	if _, err := vector.ToTsVbuuid("default", 1024); err != ErrorInvalidVector {
+		t.Fatalf("expected %v, got %v", ErrorInvalidVector, err)
+	}
+	vector = &TsConsistency{Vbnos: []uint32{1}, Seqnos: []uint64{1}}
+	if _, err := vector.ToTsVbuuid("default", 1024); err != ErrorInvalidVector {
+		t.Fatalf("expected %v, got %v", ErrorInvalidVector, err)
+	}
+}
+
+// requests from clients that predate scan consistency do not carry cons.
+func TestScanRequestDefaultCons(t *testing.T) {
+	reqs := []interface{}{
+		&ScanRequest{
+			DefnID:   proto.Uint64(1),
+			Span:     &Span{Equals: [][]byte{[]byte(`"a"`)}},
+			Distinct: proto.Bool(false),
+			Limit:    proto.Int64(10),
+			PageSize: proto.Int64(1),
+		},
+		&ScanAllRequest{
+			DefnID:   proto.Uint64(1),
+			PageSize: proto.Int64(1),
+			Limit:    proto.Int64(10),
+		},
+	}
+	for _, req := range reqs {
+		data, err := ProtobufEncode(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := ProtobufDecode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cons uint32
+		switch r := msg.(type) {
+		case *ScanRequest:
+			cons = r.GetCons()
+		case *ScanAllRequest:
+			cons = r.GetCons()
+		}
+		if c.Consistency(cons) != c.AnyConsistency {
+			t.Errorf("expected any consistency for %T, got %v", msg, cons)
+		}
+	}
+}
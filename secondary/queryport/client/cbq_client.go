@@ -201,6 +201,19 @@ func (b *cbqClient) GetScanport(
 	return b.queryport, true
 }
 
+// GetIndexDefn implement BridgeAccessor{} interface.
+func (b *cbqClient) GetIndexDefn(defnID common.IndexDefnId) *common.IndexDefn {
+	return nil
+}
+
+// GetPartitionScanports implement BridgeAccessor{} interface, partitioned
+// indexes are not supported by cbq-agent.
+func (b *cbqClient) GetPartitionScanports(
+	defnID common.IndexDefnId) (queryports []string, ok bool) {
+
+	return nil, false
+}
+
 // Timeit implement BridgeAccessor{} interface.
 func (b *cbqClient) Timeit(defnID uint64, value float64) {
 	// TODO: do nothing ?
@@ -32,6 +32,15 @@ var ErrorInstanceNotFound = errors.New("queryport.instanceNotFound")
 // ErrorIndexNotReady
 var ErrorIndexNotReady = errors.New("queryport.indexNotReady")
 
+// ErrorInvalidConsistency
+var ErrorInvalidConsistency = errors.New("queryport.client.invalidConsistency")
+
+// ErrorExpectedTimestamp
+var ErrorExpectedTimestamp = errors.New("queryport.client.expectedTimestamp")
+
+// ErrorInvalidTimestamp
+var ErrorInvalidTimestamp = errors.New("queryport.client.invalidTimestamp")
+
 // ResponseHandler shall interpret response packets from server
 // and handle them. If handler is not interested in receiving any
 // more response it shall return false, else it shall continue
@@ -67,6 +76,22 @@ const (
 	Both
 )
 
+// TsConsistency specifies a subset of vbuckets, along with their seqnos
+// and vbuuids, to be used as timestamp vector for scans requesting
+// common.QueryConsistency.
+type TsConsistency struct {
+	Vbnos   []uint16
+	Seqnos  []uint64
+	Vbuuids []uint64
+}
+
+// NewTsConsistency returns a new consistency vector object.
+func NewTsConsistency(
+	vbnos []uint16, seqnos []uint64, vbuuids []uint64) *TsConsistency {
+
+	return &TsConsistency{Vbnos: vbnos, Seqnos: seqnos, Vbuuids: vbuuids}
+}
+
 // BridgeAccessor for Create,Drop,List,Refresh operations.
 type BridgeAccessor interface {
 	// Refresh shall refresh to latest set of index managed by GSI
@@ -121,6 +146,16 @@ type BridgeAccessor interface {
 	// load, hosting index `defnID` or an equivalent of `defnID`
 	GetScanport(defnID common.IndexDefnId) (queryport string, ok bool)
 
+	// GetIndexDefn shall return the definition of index `defnID`, nil if
+	// not available.
+	GetIndexDefn(defnID common.IndexDefnId) *common.IndexDefn
+
+	// GetPartitionScanports shall fetch queryport addresses of indexers
+	// hosting partitions of a partitioned index `defnID`, in partition
+	// order. ok is false if a partition is not hosted by any indexer.
+	GetPartitionScanports(
+		defnID common.IndexDefnId) (queryports []string, ok bool)
+
 	// IndexState returns the current state of index `defnID` and error.
 	IndexState(defnID uint64) (common.IndexState, error)
 
@@ -144,19 +179,47 @@ type GsiAccessor interface {
 		defnID uint64, low, high common.SecondaryKey,
 		inclusion Inclusion) (common.IndexStatistics, error)
 
-	// Lookup scan index between low and high.
+	// Lookup scan index between low and high. `cons` specifies the
+	// consistency criteria for the scan, `vector` is mandatory for
+	// common.QueryConsistency and ignored otherwise.
 	Lookup(
 		defnID uint64, values []common.SecondaryKey,
-		distinct bool, limit int64, callb ResponseHandler) error
+		distinct bool, limit int64,
+		cons common.Consistency, vector *TsConsistency,
+		callb ResponseHandler) error
 
 	// Range scan index between low and high.
 	Range(
 		defnID uint64, low, high common.SecondaryKey,
 		inclusion Inclusion, distinct bool, limit int64,
+		cons common.Consistency, vector *TsConsistency,
 		callb ResponseHandler) error
 
 	// ScanAll for full table scan.
-	ScanAll(defnID uint64, limit int64, callb ResponseHandler) error
+	ScanAll(
+		defnID uint64, limit int64,
+		cons common.Consistency, vector *TsConsistency,
+		callb ResponseHandler) error
+
+	// RangeWithCursor scan index between low and high, for paginated
+	// scans. First `offset` entries are skipped, and if `cursor` is not
+	// nil, the scan resumes after the entry at which the cursor was
+	// returned, from a snapshot atleast as recent as that of the
+	// previous scan. Returns an opaque cursor to resume the scan after
+	// the last entry passed to `callb`, nil if there were no entries.
+	RangeWithCursor(
+		defnID uint64, low, high common.SecondaryKey,
+		inclusion Inclusion, distinct bool, offset, limit int64,
+		cursor []byte,
+		cons common.Consistency, vector *TsConsistency,
+		callb ResponseHandler) ([]byte, error)
+
+	// ScanAllWithCursor for paginated full table scan, refer to
+	// RangeWithCursor for `offset` and `cursor`.
+	ScanAllWithCursor(
+		defnID uint64, offset, limit int64, cursor []byte,
+		cons common.Consistency, vector *TsConsistency,
+		callb ResponseHandler) ([]byte, error)
 
 	// CountLookup of all entries in index.
 	CountLookup(defnID uint64) (int64, error)
@@ -277,7 +340,9 @@ func (c *GsiClient) RangeStatistics(
 // Lookup scan index between low and high.
 func (c *GsiClient) Lookup(
 	defnID uint64, values []common.SecondaryKey,
-	distinct bool, limit int64, callb ResponseHandler) error {
+	distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
 
 	// check whether the index is present and available.
 	if _, err := c.bridge.IndexState(defnID); err != nil {
@@ -287,6 +352,20 @@ func (c *GsiClient) Lookup(
 		callb(protoResp)
 		return nil
 	}
+	defn, queryports, err := c.partitions(defnID)
+	if err != nil {
+		return err
+	} else if queryports != nil {
+		scan := func(qc *gsiScanClient, callb ResponseHandler) error {
+			return qc.Lookup(
+				defnID, values, distinct, limit, cons, vector, callb)
+		}
+		begin := time.Now().UnixNano()
+		_, err = c.scatter(
+			defn, queryports, distinct, false, 0, limit, scan, callb)
+		c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+		return err
+	}
 	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
 	if !ok {
 		return ErrorNoHost
@@ -294,7 +373,7 @@ func (c *GsiClient) Lookup(
 	qc := c.queryClients[queryport]
 	// time Lookup()
 	begin := time.Now().UnixNano()
-	err := qc.Lookup(defnID, values, distinct, limit, callb)
+	err = qc.Lookup(defnID, values, distinct, limit, cons, vector, callb)
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return err
 }
@@ -303,31 +382,124 @@ func (c *GsiClient) Lookup(
 func (c *GsiClient) Range(
 	defnID uint64, low, high common.SecondaryKey,
 	inclusion Inclusion, distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	_, err := c.rangeScan(
+		defnID, low, high, inclusion, distinct, false, 0, limit, nil,
+		cons, vector, callb)
+	return err
+}
+
+// ReverseRange scan index between high and low, in reverse order of the
+// index, to serve descending order of an ascending key and vice versa.
+func (c *GsiClient) ReverseRange(
+	defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler) error {
 
+	_, err := c.rangeScan(
+		defnID, low, high, inclusion, distinct, true, 0, limit, nil,
+		cons, vector, callb)
+	return err
+}
+
+// RangeWithCursor scan index between low and high, skipping `offset`
+// entries, and resuming after `cursor` if not nil.
+func (c *GsiClient) RangeWithCursor(
+	defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, offset, limit int64,
+	cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
+
+	return c.rangeScan(
+		defnID, low, high, inclusion, distinct, false, offset, limit, cursor,
+		cons, vector, callb)
+}
+
+func (c *GsiClient) rangeScan(
+	defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct, reverse bool, offset, limit int64,
+	cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
+
 	// check whether the index is present and available.
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		protoResp := &protobuf.ResponseStream{
 			Err: &protobuf.Error{Error: proto.String(err.Error())},
 		}
 		callb(protoResp)
-		return nil
+		return nil, nil
+	}
+	defn, queryports, err := c.partitions(defnID)
+	if err != nil {
+		return nil, err
+	} else if queryports != nil {
+		scan := func(qc *gsiScanClient, callb ResponseHandler) error {
+			_, err := qc.rangeScan(
+				defnID, low, high, inclusion, distinct, reverse,
+				0, partitionLimit(offset, limit), cursor,
+				cons, vector, callb)
+			return err
+		}
+		begin := time.Now().UnixNano()
+		next, err := c.scatter(
+			defn, queryports, distinct, reverse, offset, limit, scan, callb)
+		c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+		return next, err
 	}
 	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
 	if !ok {
-		return ErrorNoHost
+		return nil, ErrorNoHost
 	}
 	qc := c.queryClients[queryport]
 	// time Range()
 	begin := time.Now().UnixNano()
-	err := qc.Range(defnID, low, high, inclusion, distinct, limit, callb)
+	next, err := qc.rangeScan(
+		defnID, low, high, inclusion, distinct, reverse, offset, limit, cursor,
+		cons, vector, callb)
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
-	return err
+	return next, err
 }
 
 // ScanAll for full table scan.
 func (c *GsiClient) ScanAll(
-	defnID uint64, limit int64, callb ResponseHandler) error {
+	defnID uint64, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	_, err := c.scanAll(defnID, false, 0, limit, nil, cons, vector, callb)
+	return err
+}
+
+// ReverseScanAll for full table scan, in reverse order of the index.
+func (c *GsiClient) ReverseScanAll(
+	defnID uint64, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	_, err := c.scanAll(defnID, true, 0, limit, nil, cons, vector, callb)
+	return err
+}
+
+// ScanAllWithCursor for full table scan, skipping `offset` entries, and
+// resuming after `cursor` if not nil.
+func (c *GsiClient) ScanAllWithCursor(
+	defnID uint64, offset, limit int64, cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
+
+	return c.scanAll(
+		defnID, false, offset, limit, cursor, cons, vector, callb)
+}
+
+func (c *GsiClient) scanAll(
+	defnID uint64, reverse bool, offset, limit int64, cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
 
 	// check whether the index is present and available.
 	if _, err := c.bridge.IndexState(defnID); err != nil {
@@ -335,18 +507,35 @@ func (c *GsiClient) ScanAll(
 			Err: &protobuf.Error{Error: proto.String(err.Error())},
 		}
 		callb(protoResp)
-		return nil
+		return nil, nil
+	}
+	defn, queryports, err := c.partitions(defnID)
+	if err != nil {
+		return nil, err
+	} else if queryports != nil {
+		scan := func(qc *gsiScanClient, callb ResponseHandler) error {
+			_, err := qc.scanAll(
+				defnID, reverse, 0, partitionLimit(offset, limit), cursor,
+				cons, vector, callb)
+			return err
+		}
+		begin := time.Now().UnixNano()
+		next, err := c.scatter(
+			defn, queryports, false, reverse, offset, limit, scan, callb)
+		c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+		return next, err
 	}
 	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
 	if !ok {
-		return ErrorNoHost
+		return nil, ErrorNoHost
 	}
 	qc := c.queryClients[queryport]
 	// time ScanAll()
 	begin := time.Now().UnixNano()
-	err := qc.ScanAll(defnID, limit, callb)
+	next, err := qc.scanAll(
+		defnID, reverse, offset, limit, cursor, cons, vector, callb)
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
-	return err
+	return next, err
 }
 
 // CountLookup to count number entries for given set of keys.
@@ -357,6 +546,14 @@ func (c *GsiClient) CountLookup(
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		return 0, err
 	}
+	if _, queryports, err := c.partitions(defnID); err != nil {
+		return 0, err
+	} else if queryports != nil {
+		return c.countPartitions(defnID, queryports,
+			func(qc *gsiScanClient) (int64, error) {
+				return qc.CountLookup(defnID, values)
+			})
+	}
 	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
 	if !ok {
 		return 0, ErrorNoHost
@@ -378,6 +575,14 @@ func (c *GsiClient) CountRange(
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		return 0, err
 	}
+	if _, queryports, err := c.partitions(defnID); err != nil {
+		return 0, err
+	} else if queryports != nil {
+		return c.countPartitions(defnID, queryports,
+			func(qc *gsiScanClient) (int64, error) {
+				return qc.CountRange(defnID, low, high, inclusion)
+			})
+	}
 	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
 	if !ok {
 		return 0, ErrorNoHost
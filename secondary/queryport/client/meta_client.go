@@ -143,16 +143,19 @@ func (b *metadataClient) BuildIndexes(defnIDs []common.IndexDefnId) error {
 		return ErrorIndexNotFound
 	}
 
+	// partitioned index is built on every node hosting its partitions.
 	dispatch := make(map[string][]common.IndexDefnId) // adminport -> []indexes
 	for _, defnID := range defnIDs {
-		adminport, ok := b.getNode(defnID)
+		adminports, ok := b.getIndexNodes(defnID)
 		if !ok {
 			return ErrorIndexNotFound
 		}
-		if _, ok := dispatch[adminport]; !ok {
-			dispatch[adminport] = make([]common.IndexDefnId, 0)
+		for _, adminport := range adminports {
+			if _, ok := dispatch[adminport]; !ok {
+				dispatch[adminport] = make([]common.IndexDefnId, 0)
+			}
+			dispatch[adminport] = append(dispatch[adminport], defnID)
 		}
-		dispatch[adminport] = append(dispatch[adminport], defnID)
 	}
 
 	errMessages := make([]string, 0)
@@ -171,11 +174,24 @@ func (b *metadataClient) BuildIndexes(defnIDs []common.IndexDefnId) error {
 
 // DropIndex implements BridgeAccessor{} interface.
 func (b *metadataClient) DropIndex(defnID common.IndexDefnId) error {
-	adminport, ok := b.getNode(defnID)
+	adminports, ok := b.getIndexNodes(defnID)
 	if !ok {
 		return ErrorIndexNotFound
 	}
-	return b.mdClient.DropIndex(defnID, adminport)
+
+	// partitioned index is dropped from every node hosting its partitions.
+	errMessages := make([]string, 0)
+	for _, adminport := range adminports {
+		err := b.mdClient.DropIndex(defnID, adminport)
+		if err != nil {
+			msg := fmt.Sprintf("drop error with %q indexer: %v", adminport, err)
+			errMessages = append(errMessages, msg)
+		}
+	}
+	if len(errMessages) > 0 {
+		return fmt.Errorf(strings.Join(errMessages, "\n"))
+	}
+	return nil
 }
 
 // GetScanports implements BridgeAccessor{} interface.
@@ -209,6 +225,41 @@ func (b *metadataClient) GetScanport(
 	return queryport, ok
 }
 
+// GetIndexDefn implement BridgeAccessor{} interface.
+func (b *metadataClient) GetIndexDefn(
+	defnID common.IndexDefnId) *common.IndexDefn {
+
+	if index := b.getIndex(defnID); index != nil {
+		return index.Definition
+	}
+	return nil
+}
+
+// GetPartitionScanports implement BridgeAccessor{} interface.
+func (b *metadataClient) GetPartitionScanports(
+	defnID common.IndexDefnId) (queryports []string, ok bool) {
+
+	index := b.getIndex(defnID)
+	if index == nil || len(index.Instances) == 0 {
+		return nil, false
+	}
+
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+
+	// every partition shall be hosted by an indexer.
+	instance := index.Instances[0]
+	if len(instance.Endpts) != index.Definition.NumPartitions() {
+		return nil, false
+	}
+	queryports = make([]string, 0, len(instance.Endpts))
+	for _, queryport := range instance.Endpts {
+		adminport := b.queryport2adminport(string(queryport))
+		queryports = append(queryports, b.queryports[adminport])
+	}
+	return queryports, true
+}
+
 // Timeit implement BridgeAccessor{} interface.
 func (b *metadataClient) Timeit(defnID uint64, value float64) {
 	b.rw.Lock()
@@ -387,6 +438,42 @@ func (b *metadataClient) getNode(
 	return "", false
 }
 
+// getIndexNodes return all nodes hosting index with `defnID`, that is,
+// nodes hosting partitions of a partitioned index.
+func (b *metadataClient) getIndexNodes(
+	defnID common.IndexDefnId) (adminports []string, ok bool) {
+
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+
+	for addr, indexes := range b.topology {
+		for _, index := range indexes {
+			if defnID == index.Definition.DefnId {
+				adminports = append(adminports, addr)
+				break
+			}
+		}
+	}
+	return adminports, len(adminports) > 0
+}
+
+// getIndex return meta-data of index with `defnID`.
+func (b *metadataClient) getIndex(
+	defnID common.IndexDefnId) *mclient.IndexMetadata {
+
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+
+	for _, indexes := range b.topology {
+		for _, index := range indexes {
+			if defnID == index.Definition.DefnId {
+				return index
+			}
+		}
+	}
+	return nil
+}
+
 // given queryport fetch the corresponding adminport for the indexer node.
 func (b *metadataClient) queryport2adminport(queryport string) string {
 	queryports := make([]string, 0, len(b.queryports))
@@ -0,0 +1,259 @@
+package client
+
+import "errors"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/transport"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+// ErrorStreamTimeout
+var ErrorStreamTimeout = errors.New("queryport.client.streamTimeout")
+
+// ErrorStreamFailed
+var ErrorStreamFailed = errors.New("queryport.client.streamFailed")
+
+// muxConnection multiplexes concurrent request streams on a single
+// connection to queryport. Requests and responses are tagged with the
+// request-id of their stream.
+//
+// Responses of all streams are received by a single routine, that shall
+// never block on a slow stream, otherwise a stream waiting for another
+// stream on the same connection, like gathering partitions of an index,
+// would deadlock. Hence indexer sends only as many responses on a stream
+// as credits granted by client, and every stream buffers responses for
+// all its outstanding credits.
+type muxConnection struct {
+	conn net.Conn
+	wmu  sync.Mutex                 // serialize transmissions
+	tpkt *transport.TransportPacket // guarded by wmu
+	rpkt *transport.TransportPacket // used only by receive routine
+
+	mu      sync.Mutex
+	streams map[uint64]chan interface{} // request-id -> response channel
+	nextId  uint64
+	err     error // once set connection is not usable
+
+	// config params
+	readDeadline  time.Duration
+	writeDeadline time.Duration
+	window        uint32 // credits granted for every stream
+	logPrefix     string
+}
+
+func newMuxConnection(
+	host string, maxPayload int,
+	readDeadline, writeDeadline time.Duration,
+	window uint32) (*muxConnection, error) {
+
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	flags := transport.TransportFlag(0).SetProtobuf()
+	tpkt := transport.NewTransportPacket(maxPayload, flags)
+	tpkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	rpkt := transport.NewTransportPacket(maxPayload, flags)
+	rpkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+
+	mc := &muxConnection{
+		conn:          conn,
+		tpkt:          tpkt,
+		rpkt:          rpkt,
+		streams:       make(map[uint64]chan interface{}),
+		readDeadline:  readDeadline,
+		writeDeadline: writeDeadline,
+		window:        window,
+		logPrefix:     fmt.Sprintf("[Queryport-mux:%v]", conn.LocalAddr()),
+	}
+	go mc.doReceive()
+	c.Infof("%v connected to %v ...\n", mc.logPrefix, host)
+	return mc, nil
+}
+
+// newStream opens a new request stream on the connection.
+func (mc *muxConnection) newStream() (*muxStream, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.err != nil {
+		return nil, mc.err
+	}
+	mc.nextId++
+	// StreamEndResponse is sent without credits.
+	respch := make(chan interface{}, mc.window+1)
+	mc.streams[mc.nextId] = respch
+	return &muxStream{mc: mc, id: mc.nextId, respch: respch}, nil
+}
+
+// closeStream forgets stream `id`, responses that are still in flight
+// for the stream are dropped.
+func (mc *muxConnection) closeStream(id uint64) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.streams, id)
+}
+
+// broken returns whether connection can no more be used.
+func (mc *muxConnection) broken() bool {
+	return mc.error() != nil
+}
+
+func (mc *muxConnection) error() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.err
+}
+
+// fail the connection with `err`, closing the connection terminates the
+// receive routine which will in turn fail all active streams.
+func (mc *muxConnection) fail(err error) {
+	mc.mu.Lock()
+	if mc.err == nil {
+		mc.err = err
+	}
+	mc.mu.Unlock()
+	mc.conn.Close()
+}
+
+// Close the connection.
+func (mc *muxConnection) Close() error {
+	mc.fail(ErrorClosedPool)
+	return nil
+}
+
+// transmit message `msg` tagged with request-id `id`.
+func (mc *muxConnection) transmit(id uint64, msg interface{}) error {
+	mc.wmu.Lock()
+	defer mc.wmu.Unlock()
+
+	timeoutMs := mc.writeDeadline * time.Millisecond
+	mc.conn.SetWriteDeadline(time.Now().Add(timeoutMs))
+	err := mc.tpkt.Send(mc.conn, &protobuf.Tagged{RequestId: id, Message: msg})
+	if err != nil {
+		format := "%v request transport failed `%v`\n"
+		c.Errorf(format, mc.logPrefix, err)
+		mc.fail(err)
+	}
+	return err
+}
+
+// receive routine, routes responses to their streams.
+func (mc *muxConnection) doReceive() {
+	var err error
+
+loop:
+	for {
+		var resp interface{}
+		if resp, err = mc.rpkt.Receive(mc.conn); err != nil {
+			break loop
+		}
+		tagged, ok := resp.(*protobuf.Tagged)
+		if !ok {
+			format := "%v untagged response %T, indexer does not support " +
+				"multiplexed requests\n"
+			c.Errorf(format, mc.logPrefix, resp)
+			err = ErrorProtocol
+			break loop
+		}
+
+		mc.mu.Lock()
+		respch, ok := mc.streams[tagged.RequestId]
+		mc.mu.Unlock()
+		if !ok {
+			format := "%v dropped response for closed stream %v\n"
+			c.Tracef(format, mc.logPrefix, tagged.RequestId)
+			continue
+		}
+		select {
+		case respch <- tagged.Message:
+		default: // indexer exceeded the granted credits
+			format := "%v stream %v exceeded window of %v responses\n"
+			c.Errorf(format, mc.logPrefix, tagged.RequestId, mc.window)
+			err = ErrorProtocol
+			break loop
+		}
+	}
+
+	mc.fail(err)
+	mc.mu.Lock()
+	for id, respch := range mc.streams {
+		close(respch)
+		delete(mc.streams, id)
+	}
+	mc.mu.Unlock()
+	c.Infof("%v ... stopped (%v)\n", mc.logPrefix, mc.error())
+}
+
+// muxStream is a request stream multiplexed on muxConnection.
+type muxStream struct {
+	mc       *muxConnection
+	id       uint64
+	respch   chan interface{}
+	opened   bool   // whether credits were granted
+	ended    bool   // whether StreamEndResponse was received
+	consumed uint32 // responses received since credits were last granted
+}
+
+func (s *muxStream) send(req interface{}) error {
+	if err := s.mc.transmit(s.id, req); err != nil {
+		return err
+	}
+	if !s.opened {
+		s.opened = true
+		window := &protobuf.StreamWindow{Credits: proto.Uint32(s.mc.window)}
+		return s.mc.transmit(s.id, window)
+	}
+	return nil
+}
+
+func (s *muxStream) receive() (interface{}, error) {
+	timeout := time.NewTimer(s.mc.readDeadline * time.Millisecond)
+	defer timeout.Stop()
+
+	select {
+	case resp, ok := <-s.respch:
+		if !ok {
+			return nil, s.mc.error()
+		}
+		if _, ok := resp.(*protobuf.StreamEndResponse); ok {
+			s.ended = true
+			return resp, nil
+		}
+		// grant credits once half of the window is consumed.
+		if s.consumed++; s.consumed >= (s.mc.window+1)/2 {
+			credits := &protobuf.StreamWindow{Credits: proto.Uint32(s.consumed)}
+			s.consumed = 0
+			if err := s.mc.transmit(s.id, credits); err != nil {
+				return nil, err
+			}
+		}
+		return resp, nil
+
+	case <-timeout.C:
+		return nil, ErrorStreamTimeout
+	}
+}
+
+func (s *muxStream) release(healthy bool) {
+	s.mc.closeStream(s.id)
+	if !healthy {
+		// stream failed midway, like on a timeout, connection is in an
+		// unknown state and is not to be used by other streams.
+		s.mc.fail(ErrorStreamFailed)
+		return
+	}
+	// request indexer to end an abandoned stream, so that it does not
+	// wait for credits forever.
+	if s.opened && !s.ended && !s.mc.broken() {
+		s.mc.transmit(s.id, &protobuf.EndStreamRequest{})
+	}
+}
+
+func (s *muxStream) laddr() net.Addr {
+	return s.mc.conn.LocalAddr()
+}
@@ -0,0 +1,180 @@
+package client
+
+import "net"
+import "reflect"
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/transport"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+// testIndexer accepts a single connection and passes every request it
+// receives to `callb`, that returns the responses to be sent back.
+type testIndexer struct {
+	lis  net.Listener
+	reqs chan interface{} // requests received by indexer
+}
+
+func startTestIndexer(
+	t *testing.T, callb func(req interface{}) []interface{}) *testIndexer {
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := &testIndexer{lis: lis, reqs: make(chan interface{}, 100)}
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		maxPayload := c.SystemConfig["queryport.client.maxPayload"].Int()
+		flags := transport.TransportFlag(0).SetProtobuf()
+		tpkt := transport.NewTransportPacket(maxPayload, flags)
+		tpkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+		rpkt := transport.NewTransportPacket(maxPayload, flags)
+		rpkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+		for {
+			req, err := rpkt.Receive(conn)
+			if err != nil {
+				return
+			}
+			ti.reqs <- req
+			for _, resp := range callb(req) {
+				if err := tpkt.Send(conn, resp); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return ti
+}
+
+func (ti *testIndexer) connect(
+	t *testing.T, readDeadline time.Duration) *muxConnection {
+
+	maxPayload := c.SystemConfig["queryport.client.maxPayload"].Int()
+	mc, err := newMuxConnection(
+		ti.lis.Addr().String(), maxPayload, readDeadline, 5000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mc
+}
+
+// expect indexer to receive a request of same type as `ref` on stream `id`.
+func (ti *testIndexer) expect(t *testing.T, id uint64, ref interface{}) {
+	select {
+	case req := <-ti.reqs:
+		tagged, ok := req.(*protobuf.Tagged)
+		if !ok || tagged.RequestId != id {
+			t.Fatalf("expected request on stream %v, got %v", id, req)
+		} else if reflect.TypeOf(tagged.Message) != reflect.TypeOf(ref) {
+			t.Fatalf("expected %T, got %T", ref, tagged.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %T on stream %v", ref, id)
+	}
+}
+
+func TestMuxUntaggedResponse(t *testing.T) {
+	c.LogIgnore()
+	// indexer does not support request streams.
+	ti := startTestIndexer(t, func(req interface{}) []interface{} {
+		if _, ok := req.(*protobuf.Tagged).Message.(*protobuf.StreamWindow); ok {
+			return []interface{}{&protobuf.StatisticsResponse{}}
+		}
+		return nil
+	})
+	defer ti.lis.Close()
+	mc := ti.connect(t, 5000)
+	defer mc.Close()
+
+	s, err := mc.newStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send(&protobuf.StatisticsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := s.receive(); err != ErrorProtocol {
+		t.Fatalf("expected %v, got %v %v", ErrorProtocol, resp, err)
+	}
+	s.release(true)
+	if !mc.broken() {
+		t.Fatalf("expected connection to be broken")
+	} else if _, err := mc.newStream(); err != ErrorProtocol {
+		t.Fatalf("expected %v, got %v", ErrorProtocol, err)
+	}
+}
+
+func TestMuxEarlyRelease(t *testing.T) {
+	c.LogIgnore()
+	// indexer sends a single response for the window, and waits for more.
+	ti := startTestIndexer(t, func(req interface{}) []interface{} {
+		tagged := req.(*protobuf.Tagged)
+		if _, ok := tagged.Message.(*protobuf.StreamWindow); ok {
+			resp := &protobuf.StatisticsResponse{}
+			return []interface{}{
+				&protobuf.Tagged{RequestId: tagged.RequestId, Message: resp},
+			}
+		}
+		return nil
+	})
+	defer ti.lis.Close()
+	mc := ti.connect(t, 5000)
+	defer mc.Close()
+
+	s, err := mc.newStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send(&protobuf.StatisticsRequest{}); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.receive(); err != nil {
+		t.Fatal(err)
+	}
+	ti.expect(t, 1, &protobuf.StatisticsRequest{})
+	ti.expect(t, 1, &protobuf.StreamWindow{})
+
+	// consumer stops before end of stream, indexer is asked to end it.
+	s.release(true)
+	ti.expect(t, 1, &protobuf.EndStreamRequest{})
+	if mc.broken() {
+		t.Fatalf("unexpected error %v", mc.error())
+	} else if s, err = mc.newStream(); err != nil {
+		t.Fatal(err)
+	} else if s.id != 2 {
+		t.Fatalf("expected stream 2, got %v", s.id)
+	}
+}
+
+func TestMuxUnhealthyRelease(t *testing.T) {
+	c.LogIgnore()
+	// indexer never responds.
+	ti := startTestIndexer(t, func(req interface{}) []interface{} {
+		return nil
+	})
+	defer ti.lis.Close()
+	mc := ti.connect(t, 100)
+	defer mc.Close()
+
+	s, err := mc.newStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send(&protobuf.StatisticsRequest{}); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.receive(); err != ErrorStreamTimeout {
+		t.Fatalf("expected %v, got %v", ErrorStreamTimeout, err)
+	}
+
+	// connection is not to be reused after a failed stream.
+	s.release(false)
+	if _, err := mc.newStream(); err != ErrorStreamFailed {
+		t.Fatalf("expected %v, got %v", ErrorStreamFailed, err)
+	}
+}
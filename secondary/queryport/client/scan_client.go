@@ -7,6 +7,18 @@
 //      ...                     ---> EndStreamRequest
 //      <--- StreamEndResponse       <--- Response (residue)
 //                                   <--- StreamEndResponse
+//
+// When multiplexed, every request and its responses are tagged with a
+// request-id, and the client grants credits for the responses it can
+// buffer:
+//
+// ---> Request(id)
+// ---> StreamWindow(id, credits)
+//      <--- Response(id)
+//      ...
+// ---> StreamWindow(id, credits)
+//      ...
+//      <--- StreamEndResponse(id)
 
 package client
 
@@ -14,6 +26,7 @@ import "errors"
 import "fmt"
 import "io"
 import "net"
+import "sync"
 import "time"
 import "encoding/json"
 
@@ -26,6 +39,8 @@ import "github.com/couchbaselabs/goprotobuf/proto"
 type gsiScanClient struct {
 	queryport string
 	pool      *connectionPool
+	mu        sync.Mutex
+	mux       *muxConnection // shared by requests, if multiplexed
 	// config params
 	maxPayload         int // TODO: what if it exceeds ?
 	readDeadline       time.Duration
@@ -34,9 +49,21 @@ type gsiScanClient struct {
 	poolOverflow       int
 	cpTimeout          time.Duration
 	cpAvailWaitTimeout time.Duration
+	multiplex          bool
+	streamWindow       uint32
 	logPrefix          string
 }
 
+// scanStream is a request and its responses, on a connection acquired
+// from the pool or multiplexed on a shared connection.
+type scanStream interface {
+	send(req interface{}) error
+	receive() (interface{}, error)
+	// release the stream, `healthy` is false if stream failed.
+	release(healthy bool)
+	laddr() net.Addr
+}
+
 func newGsiScanClient(queryport string, config common.Config) *gsiScanClient {
 	t := time.Duration(config["connPoolAvailWaitTimeout"].Int())
 	c := &gsiScanClient{
@@ -48,6 +75,8 @@ func newGsiScanClient(queryport string, config common.Config) *gsiScanClient {
 		poolOverflow:       config["poolOverflow"].Int(),
 		cpTimeout:          time.Duration(config["connPoolTimeout"].Int()),
 		cpAvailWaitTimeout: t,
+		multiplex:          config["multiplex"].Bool(),
+		streamWindow:       uint32(config["streamWindow"].Int()),
 		logPrefix:          fmt.Sprintf("[GsiScanClient:%q]", queryport),
 	}
 	c.pool = newConnectionPool(
@@ -120,7 +149,9 @@ func (c *gsiScanClient) RangeStatistics(
 // Lookup scan index between low and high.
 func (c *gsiScanClient) Lookup(
 	defnID uint64, values []common.SecondaryKey,
-	distinct bool, limit int64, callb ResponseHandler) error {
+	distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
 
 	// serialize lookup value.
 	equals := make([][]byte, 0, len(values))
@@ -131,15 +162,17 @@ func (c *gsiScanClient) Lookup(
 		}
 		equals = append(equals, val)
 	}
+	protoVector, err := consistencyVector(cons, vector)
+	if err != nil {
+		return err
+	}
 
-	connectn, err := c.pool.Get()
+	stream, err := c.newStream()
 	if err != nil {
 		return err
 	}
 	healthy := true
-	defer c.pool.Return(connectn, healthy)
-
-	conn, pkt := connectn.conn, connectn.pkt
+	defer func() { stream.release(healthy) }()
 
 	req := &protobuf.ScanRequest{
 		DefnID:   proto.Uint64(defnID),
@@ -147,9 +180,11 @@ func (c *gsiScanClient) Lookup(
 		Distinct: proto.Bool(distinct),
 		PageSize: proto.Int64(1),
 		Limit:    proto.Int64(limit),
+		Cons:     proto.Uint32(uint32(cons)),
+		Vector:   protoVector,
 	}
 	// ---> protobuf.ScanRequest
-	if err := c.sendRequest(conn, pkt, req); err != nil {
+	if err := stream.send(req); err != nil {
 		msg := "%v Scan() request transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, err)
 		healthy = false
@@ -159,7 +194,7 @@ func (c *gsiScanClient) Lookup(
 	cont := true
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb)
+		cont, healthy, err = c.streamResponse(stream, callb)
 		if err != nil {
 			msg := "%v Scan() response failed `%v`\n"
 			common.Errorf(msg, c.logPrefix, err)
@@ -171,26 +206,70 @@ func (c *gsiScanClient) Lookup(
 // Range scan index between low and high.
 func (c *gsiScanClient) Range(
 	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
-	distinct bool, limit int64, callb ResponseHandler) error {
+	distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	_, err := c.rangeScan(
+		defnID, low, high, inclusion, distinct, false, 0, limit, nil,
+		cons, vector, callb)
+	return err
+}
+
+// ReverseRange scan index between high and low, in reverse order of the
+// index.
+func (c *gsiScanClient) ReverseRange(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct bool, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	_, err := c.rangeScan(
+		defnID, low, high, inclusion, distinct, true, 0, limit, nil,
+		cons, vector, callb)
+	return err
+}
+
+// RangeWithCursor scan index between low and high, skipping `offset`
+// entries, and resuming after `cursor` if not nil. Returns cursor to
+// resume the scan after the last entry passed to callb.
+func (c *gsiScanClient) RangeWithCursor(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct bool, offset, limit int64, cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
+
+	return c.rangeScan(
+		defnID, low, high, inclusion, distinct, false, offset, limit, cursor,
+		cons, vector, callb)
+}
+
+func (c *gsiScanClient) rangeScan(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct, reverse bool, offset, limit int64, cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
 
 	// serialize low and high values.
 	l, err := json.Marshal(low)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	h, err := json.Marshal(high)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	protoVector, err := consistencyVector(cons, vector)
+	if err != nil {
+		return nil, err
 	}
 
-	connectn, err := c.pool.Get()
+	stream, err := c.newStream()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	healthy := true
-	defer c.pool.Return(connectn, healthy)
-
-	conn, pkt := connectn.conn, connectn.pkt
+	defer func() { stream.release(healthy) }()
 
 	req := &protobuf.ScanRequest{
 		DefnID: proto.Uint64(defnID),
@@ -202,62 +281,112 @@ func (c *gsiScanClient) Range(
 		Distinct: proto.Bool(distinct),
 		PageSize: proto.Int64(1),
 		Limit:    proto.Int64(limit),
+		Cons:     proto.Uint32(uint32(cons)),
+		Vector:   protoVector,
+		Reverse:  proto.Bool(reverse),
+		Offset:   proto.Int64(offset),
+		Cursor:   cursor,
 	}
 	// ---> protobuf.ScanRequest
-	if err := c.sendRequest(conn, pkt, req); err != nil {
+	if err := stream.send(req); err != nil {
 		msg := "%v Scan() request transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, err)
 		healthy = false
-		return err
+		return nil, err
 	}
 
+	tracker := &scanCursor{}
+	callb = tracker.handler(callb)
 	cont := true
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb)
+		cont, healthy, err = c.streamResponse(stream, callb)
 		if err != nil {
 			msg := "%v Scan() response failed `%v`\n"
 			common.Errorf(msg, c.logPrefix, err)
 		}
 	}
-	return nil
+	return tracker.cursor()
 }
 
 // ScanAll for full table scan.
 func (c *gsiScanClient) ScanAll(
-	defnID uint64, limit int64, callb ResponseHandler) error {
+	defnID uint64, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	_, err := c.scanAll(defnID, false, 0, limit, nil, cons, vector, callb)
+	return err
+}
+
+// ReverseScanAll for full table scan, in reverse order of the index.
+func (c *gsiScanClient) ReverseScanAll(
+	defnID uint64, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
 
-	connectn, err := c.pool.Get()
+	_, err := c.scanAll(defnID, true, 0, limit, nil, cons, vector, callb)
+	return err
+}
+
+// ScanAllWithCursor for full table scan, skipping `offset` entries, and
+// resuming after `cursor` if not nil. Returns cursor to resume the scan
+// after the last entry passed to callb.
+func (c *gsiScanClient) ScanAllWithCursor(
+	defnID uint64, offset, limit int64, cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
+
+	return c.scanAll(
+		defnID, false, offset, limit, cursor, cons, vector, callb)
+}
+
+func (c *gsiScanClient) scanAll(
+	defnID uint64, reverse bool, offset, limit int64, cursor []byte,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) ([]byte, error) {
+
+	protoVector, err := consistencyVector(cons, vector)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	healthy := true
-	defer c.pool.Return(connectn, healthy)
 
-	conn, pkt := connectn.conn, connectn.pkt
+	stream, err := c.newStream()
+	if err != nil {
+		return nil, err
+	}
+	healthy := true
+	defer func() { stream.release(healthy) }()
 
 	req := &protobuf.ScanAllRequest{
 		DefnID:   proto.Uint64(defnID),
 		PageSize: proto.Int64(1),
 		Limit:    proto.Int64(limit),
+		Cons:     proto.Uint32(uint32(cons)),
+		Vector:   protoVector,
+		Reverse:  proto.Bool(reverse),
+		Offset:   proto.Int64(offset),
+		Cursor:   cursor,
 	}
-	if err := c.sendRequest(conn, pkt, req); err != nil {
+	if err := stream.send(req); err != nil {
 		common.Errorf(
 			"%v ScanAll() request transport failed `%v`\n",
 			c.logPrefix, err)
 		healthy = false
-		return err
+		return nil, err
 	}
 
+	tracker := &scanCursor{}
+	callb = tracker.handler(callb)
 	cont := true
 	for cont {
-		cont, healthy, err = c.streamResponse(conn, pkt, callb)
+		cont, healthy, err = c.streamResponse(stream, callb)
 		if err != nil {
 			msg := "%v ScanAll() response failed `%v`\n"
 			common.Errorf(msg, c.logPrefix, err)
 		}
 	}
-	return nil
+	return tracker.cursor()
 }
 
 // CountLookup to count number entries for given set of keys.
@@ -325,32 +454,88 @@ func (c *gsiScanClient) CountRange(
 	return countResp.GetCount(), nil
 }
 
+// consistencyVector validates the consistency criteria for a scan request
+// and returns the timestamp vector to be sent along with the request.
+func consistencyVector(
+	cons common.Consistency,
+	vector *TsConsistency) (*protobuf.TsConsistency, error) {
+
+	switch cons {
+	case common.AnyConsistency, common.SessionConsistency:
+		return nil, nil
+
+	case common.QueryConsistency:
+		if vector == nil {
+			return nil, ErrorExpectedTimestamp
+		}
+		if len(vector.Vbnos) != len(vector.Seqnos) ||
+			len(vector.Vbnos) != len(vector.Vbuuids) {
+			return nil, ErrorInvalidTimestamp
+		}
+		protoVector := protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids)
+		return protoVector, nil
+	}
+	return nil, ErrorInvalidConsistency
+}
+
+// scanCursor tracks the last index entry received by a scan, to resume the
+// scan after that entry.
+type scanCursor struct {
+	snapshot *protobuf.TsConsistency
+	entry    *protobuf.IndexEntry
+}
+
+// handler wraps `callb` to track entries passed to it.
+func (sc *scanCursor) handler(callb ResponseHandler) ResponseHandler {
+	return func(resp ResponseReader) bool {
+		if stream, ok := resp.(*protobuf.ResponseStream); ok {
+			if snapshot := stream.GetSnapshot(); snapshot != nil {
+				sc.snapshot = snapshot
+			}
+			if entries := stream.GetIndexEntries(); len(entries) > 0 {
+				sc.entry = entries[len(entries)-1]
+			}
+		}
+		return callb(resp)
+	}
+}
+
+// cursor returns the opaque cursor, nil if no entries were received.
+func (sc *scanCursor) cursor() ([]byte, error) {
+	if sc.entry == nil {
+		return nil, nil
+	}
+	return protobuf.NewScanCursor(sc.entry, sc.snapshot)
+}
+
 func (c *gsiScanClient) Close() error {
+	c.mu.Lock()
+	if c.mux != nil {
+		c.mux.Close()
+	}
+	c.mu.Unlock()
 	return c.pool.Close()
 }
 
 func (c *gsiScanClient) doRequestResponse(req interface{}) (interface{}, error) {
-	connectn, err := c.pool.Get()
+	stream, err := c.newStream()
 	if err != nil {
 		return nil, err
 	}
 	healthy := true
-	defer c.pool.Return(connectn, healthy)
-
-	conn, pkt := connectn.conn, connectn.pkt
+	defer func() { stream.release(healthy) }()
 
 	// ---> protobuf.*Request
-	if err := c.sendRequest(conn, pkt, req); err != nil {
+	if err := stream.send(req); err != nil {
 		msg := "%v %T request transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, req, err)
 		healthy = false
 		return nil, err
 	}
 
-	timeoutMs := c.readDeadline * time.Millisecond
-	conn.SetReadDeadline(time.Now().Add(timeoutMs))
 	// <--- protobuf.*Response
-	resp, err := pkt.Receive(conn)
+	resp, err := stream.receive()
 	if err != nil {
 		msg := "%v %T response transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, req, err)
@@ -358,15 +543,40 @@ func (c *gsiScanClient) doRequestResponse(req interface{}) (interface{}, error)
 		return nil, err
 	}
 
-	conn.SetReadDeadline(time.Now().Add(timeoutMs))
 	// <--- protobuf.StreamEndResponse (skipped) TODO: knock this off.
-	endResp, err := pkt.Receive(conn)
+	endResp, err := stream.receive()
 	if _, ok := endResp.(*protobuf.StreamEndResponse); !ok {
+		healthy = false
 		return nil, ErrorProtocol
 	}
 	return resp, nil
 }
 
+// newStream for a request, multiplexed on the shared connection if
+// enabled, otherwise on a connection acquired from the pool.
+func (c *gsiScanClient) newStream() (scanStream, error) {
+	if !c.multiplex {
+		connectn, err := c.pool.Get()
+		if err != nil {
+			return nil, err
+		}
+		return &pooledStream{client: c, connectn: connectn}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mux == nil || c.mux.broken() {
+		mux, err := newMuxConnection(
+			c.queryport, c.maxPayload, c.readDeadline, c.writeDeadline,
+			c.streamWindow)
+		if err != nil {
+			return nil, err
+		}
+		c.mux = mux
+	}
+	return c.mux.newStream()
+}
+
 func (c *gsiScanClient) sendRequest(
 	conn net.Conn, pkt *transport.TransportPacket, req interface{}) (err error) {
 
@@ -376,18 +586,15 @@ func (c *gsiScanClient) sendRequest(
 }
 
 func (c *gsiScanClient) streamResponse(
-	conn net.Conn,
-	pkt *transport.TransportPacket,
+	stream scanStream,
 	callb ResponseHandler) (cont bool, healthy bool, err error) {
 
 	var resp interface{}
 	var endResp *protobuf.StreamEndResponse
 	var finish bool
 
-	laddr := conn.LocalAddr()
-	timeoutMs := c.readDeadline * time.Millisecond
-	conn.SetReadDeadline(time.Now().Add(timeoutMs))
-	if resp, err = pkt.Receive(conn); err != nil {
+	laddr := stream.laddr()
+	if resp, err = stream.receive(); err != nil {
 		resp := &protobuf.ResponseStream{
 			Err: &protobuf.Error{Error: proto.String(err.Error())},
 		}
@@ -411,18 +618,17 @@ func (c *gsiScanClient) streamResponse(
 	}
 
 	if cont == false && healthy == true && finish == false {
-		err = c.closeStream(conn, pkt)
+		err = c.closeStream(stream)
 	}
 	return
 }
 
-func (c *gsiScanClient) closeStream(
-	conn net.Conn, pkt *transport.TransportPacket) (err error) {
+func (c *gsiScanClient) closeStream(stream scanStream) (err error) {
 
 	var resp interface{}
-	laddr := conn.LocalAddr()
+	laddr := stream.laddr()
 	// request server to end the stream.
-	err = c.sendRequest(conn, pkt, &protobuf.EndStreamRequest{})
+	err = stream.send(&protobuf.EndStreamRequest{})
 	if err != nil {
 		msg := "%v closeStream() request transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, err)
@@ -431,11 +637,9 @@ func (c *gsiScanClient) closeStream(
 	msg := "%v connection %q transmitted protobuf.EndStreamRequest"
 	common.Tracef(msg, c.logPrefix, laddr)
 
-	timeoutMs := c.readDeadline * time.Millisecond
 	// flush the connection until stream has ended.
 	for true {
-		conn.SetReadDeadline(time.Now().Add(timeoutMs))
-		resp, err = pkt.Receive(conn)
+		resp, err = stream.receive()
 		if err == io.EOF {
 			common.Errorf("%v connection %q closed \n", c.logPrefix, laddr)
 			return
@@ -451,3 +655,28 @@ func (c *gsiScanClient) closeStream(
 	}
 	return
 }
+
+// pooledStream is a request stream on a connection acquired from the pool.
+type pooledStream struct {
+	client   *gsiScanClient
+	connectn *connection
+}
+
+func (s *pooledStream) send(req interface{}) error {
+	return s.client.sendRequest(s.connectn.conn, s.connectn.pkt, req)
+}
+
+func (s *pooledStream) receive() (interface{}, error) {
+	conn, pkt := s.connectn.conn, s.connectn.pkt
+	timeoutMs := s.client.readDeadline * time.Millisecond
+	conn.SetReadDeadline(time.Now().Add(timeoutMs))
+	return pkt.Receive(conn)
+}
+
+func (s *pooledStream) release(healthy bool) {
+	s.client.pool.Return(s.connectn, healthy)
+}
+
+func (s *pooledStream) laddr() net.Addr {
+	return s.connectn.conn.LocalAddr()
+}
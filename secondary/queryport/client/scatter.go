@@ -0,0 +1,289 @@
+package client
+
+import "bytes"
+import "sync"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+// gatherBatchSize is the number of gathered entries passed to application
+// callback in a single response.
+const gatherBatchSize = 64
+
+// partitionScan shall scan a single partition of an index hosted by
+// queryport client `qc`, and pass responses to `callb`.
+type partitionScan func(qc *gsiScanClient, callb ResponseHandler) error
+
+// partitionStream is the sorted stream of index entries received from a
+// partition of an index.
+type partitionStream struct {
+	entries  chan *protobuf.IndexEntry
+	snapshot *protobuf.TsConsistency
+	err      error
+	// head of the stream, and its collatejson encoded index key.
+	head *protobuf.IndexEntry
+	key  []byte
+}
+
+// partitions return definition and queryports hosting the partitions of
+// index `defnID`, queryports is nil if index is not partitioned.
+func (c *GsiClient) partitions(
+	defnID uint64) (*common.IndexDefn, []string, error) {
+
+	defn := c.bridge.GetIndexDefn(common.IndexDefnId(defnID))
+	if defn == nil || !defn.IsPartitioned() {
+		return defn, nil, nil
+	}
+	queryports, ok := c.bridge.GetPartitionScanports(common.IndexDefnId(defnID))
+	if !ok {
+		return defn, nil, ErrorNoHost
+	}
+	return defn, queryports, nil
+}
+
+// scatter `scan` to all partitions of index `defn` hosted by `queryports`
+// and gather their sorted results into a single sorted stream of entries
+// passed to `callb`. Partitions shall be scanned without offset and with
+// limit of `offset+limit`, entries are skipped and limited while
+// gathering. Returns the cursor to resume the scan after the last entry
+// passed to `callb`.
+func (c *GsiClient) scatter(
+	defn *common.IndexDefn, queryports []string,
+	distinct, reverse bool, offset, limit int64,
+	scan partitionScan, callb ResponseHandler) ([]byte, error) {
+
+	qcs := make([]*gsiScanClient, 0, len(queryports))
+	for _, queryport := range queryports {
+		qc, ok := c.queryClients[queryport]
+		if !ok {
+			return nil, ErrorNoHost
+		}
+		qcs = append(qcs, qc)
+	}
+
+	quitch := make(chan bool)
+	var wg sync.WaitGroup
+
+	streams := make([]*partitionStream, 0, len(qcs))
+	for _, qc := range qcs {
+		s := &partitionStream{
+			entries: make(chan *protobuf.IndexEntry, gatherBatchSize),
+		}
+		streams = append(streams, s)
+
+		wg.Add(1)
+		go func(qc *gsiScanClient, s *partitionStream) {
+			defer wg.Done()
+			defer close(s.entries)
+
+			err := scan(qc, func(resp ResponseReader) bool {
+				if err := resp.Error(); err != nil {
+					s.err = err
+					return false
+				}
+				stream, ok := resp.(*protobuf.ResponseStream)
+				if !ok {
+					return true
+				}
+				if snapshot := stream.GetSnapshot(); snapshot != nil {
+					s.snapshot = snapshot
+				}
+				for _, entry := range stream.GetIndexEntries() {
+					select {
+					case s.entries <- entry:
+					case <-quitch:
+						return false
+					}
+				}
+				return true
+			})
+			if err != nil && s.err == nil {
+				s.err = err
+			}
+		}(qc, s)
+	}
+
+	last, err := gather(defn, streams, distinct, reverse, offset, limit, callb)
+	close(quitch)
+	wg.Wait()
+
+	if err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: &protobuf.Error{Error: proto.String(err.Error())},
+		}
+		callb(protoResp)
+		return nil, nil
+	}
+	if last == nil {
+		return nil, nil
+	}
+	return protobuf.NewScanCursor(last, gatherSnapshots(streams))
+}
+
+// gather merges sorted streams of partitions, in reverse order if
+// `reverse` is true, and returns the last entry passed to `callb`.
+func gather(
+	defn *common.IndexDefn, streams []*partitionStream,
+	distinct, reverse bool, offset, limit int64,
+	callb ResponseHandler) (*protobuf.IndexEntry, error) {
+
+	codec := collatejson.NewCodec(16)
+	codec.SortbyDescending(defn.Descending())
+	if err := defn.Collation.Apply(codec); err != nil {
+		return nil, err
+	}
+
+	next := func(s *partitionStream) error {
+		entry, ok := <-s.entries
+		if !ok {
+			s.head, s.key = nil, nil
+			return s.err
+		}
+		key, err := entry.IndexKey()
+		if err != nil {
+			return err
+		}
+		s.head = entry
+		s.key, err = codec.StreamEncode(key, s.key)
+		return err
+	}
+	for _, s := range streams {
+		if err := next(s); err != nil {
+			return nil, err
+		}
+	}
+
+	var last *protobuf.IndexEntry
+	var lastSecKey []byte
+	skipped, count := int64(0), int64(0)
+	batch := make([]*protobuf.IndexEntry, 0, gatherBatchSize)
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		cont := callb(&protobuf.ResponseStream{IndexEntries: batch})
+		batch = make([]*protobuf.IndexEntry, 0, gatherBatchSize)
+		return cont
+	}
+
+	for limit <= 0 || count < limit {
+		var min *partitionStream
+		for _, s := range streams {
+			if s.head == nil {
+				continue
+			} else if min == nil {
+				min = s
+			} else if cmp := bytes.Compare(s.key, min.key); reverse && cmp > 0 {
+				min = s
+			} else if !reverse && cmp < 0 {
+				min = s
+			}
+		}
+		if min == nil {
+			break
+		}
+
+		entry := min.head
+		include := true
+		if distinct {
+			// same secondary key can be indexed by several partitions.
+			secKey, err := collatejson.TrimLastItem(min.key)
+			if err != nil {
+				return nil, err
+			}
+			include = lastSecKey == nil || !bytes.Equal(secKey, lastSecKey)
+			lastSecKey = append(lastSecKey[:0], secKey...)
+		}
+		if include && skipped < offset {
+			skipped++
+		} else if include {
+			batch = append(batch, entry)
+			last = entry
+			count++
+			if len(batch) == gatherBatchSize && !flush() {
+				return last, nil
+			}
+		}
+		if err := next(min); err != nil {
+			return nil, err
+		}
+	}
+	if flush() {
+		callb(&protobuf.StreamEndResponse{})
+	}
+	return last, nil
+}
+
+// countPartitions adds up `count` of all partitions of index `defnID`
+// hosted by `queryports`.
+func (c *GsiClient) countPartitions(
+	defnID uint64, queryports []string,
+	count func(qc *gsiScanClient) (int64, error)) (int64, error) {
+
+	var wg sync.WaitGroup
+	counts := make([]int64, len(queryports))
+	errs := make([]error, len(queryports))
+	begin := time.Now().UnixNano()
+	for i, queryport := range queryports {
+		qc, ok := c.queryClients[queryport]
+		if !ok {
+			return 0, ErrorNoHost
+		}
+		wg.Add(1)
+		go func(i int, qc *gsiScanClient) {
+			defer wg.Done()
+			counts[i], errs[i] = count(qc)
+		}(i, qc)
+	}
+	wg.Wait()
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+
+	total := int64(0)
+	for i, n := range counts {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// partitionLimit is the limit for scanning a partition, every partition
+// may contribute all the entries skipped by `offset` and limited by
+// `limit`.
+func partitionLimit(offset, limit int64) int64 {
+	if limit <= 0 {
+		return limit
+	}
+	return offset + limit
+}
+
+// gatherSnapshots return the timestamp of snapshots scanned across all
+// partitions, the most recent seqno of each vbucket.
+func gatherSnapshots(streams []*partitionStream) *protobuf.TsConsistency {
+	var ts *protobuf.TsConsistency
+	vbs := make(map[uint32]int) // vbno -> offset in ts
+	for _, s := range streams {
+		snapshot := s.snapshot
+		if snapshot == nil {
+			continue
+		} else if ts == nil {
+			ts = &protobuf.TsConsistency{}
+		}
+		seqnos, vbuuids := snapshot.GetSeqnos(), snapshot.GetVbuuids()
+		for i, vbno := range snapshot.GetVbnos() {
+			if j, ok := vbs[vbno]; !ok {
+				vbs[vbno] = len(ts.Vbnos)
+				ts.Vbnos = append(ts.Vbnos, vbno)
+				ts.Seqnos = append(ts.Seqnos, seqnos[i])
+				ts.Vbuuids = append(ts.Vbuuids, vbuuids[i])
+			} else if seqnos[i] > ts.Seqnos[j] {
+				ts.Seqnos[j], ts.Vbuuids[j] = seqnos[i], vbuuids[i]
+			}
+		}
+	}
+	return ts
+}
@@ -0,0 +1,139 @@
+package client
+
+import "fmt"
+import "reflect"
+import "testing"
+
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+// partition entries are listed as "secKey/docid".
+var testPartitions = [][]string{
+	{"a/d1", "b/d3", "d/d6"},
+	{"a/d2", "c/d4", "d/d5"},
+	{"a/d0", "c/d7"},
+}
+
+func newTestStreams(partitions [][]string, reverse bool) []*partitionStream {
+	streams := make([]*partitionStream, 0, len(partitions))
+	for _, entries := range partitions {
+		s := &partitionStream{
+			entries: make(chan *protobuf.IndexEntry, len(entries)),
+		}
+		for i := range entries {
+			entry := entries[i]
+			if reverse {
+				entry = entries[len(entries)-1-i]
+			}
+			s.entries <- &protobuf.IndexEntry{
+				EntryKey:   []byte(fmt.Sprintf(`["%s"]`, entry[:1])),
+				PrimaryKey: []byte(entry[2:]),
+			}
+		}
+		close(s.entries)
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+func TestGather(t *testing.T) {
+	testcases := []struct {
+		desc, distinct, reverse bool
+		offset, limit           int64
+		ref                     []string
+	}{
+		{false, false, false, 0, 0,
+			[]string{"a/d0", "a/d1", "a/d2", "b/d3", "c/d4", "c/d7", "d/d5", "d/d6"}},
+		{false, false, false, 2, 3, []string{"a/d2", "b/d3", "c/d4"}},
+		{false, false, false, 7, 5, []string{"d/d6"}},
+		{false, false, false, 10, 0, nil},
+		{false, true, false, 0, 0, []string{"a/d0", "b/d3", "c/d4", "d/d5"}},
+		{false, true, false, 1, 2, []string{"b/d3", "c/d4"}},
+		{false, true, false, 3, 0, []string{"d/d5"}},
+		// reverse scan of ascending index.
+		{false, false, true, 0, 0,
+			[]string{"d/d6", "d/d5", "c/d7", "c/d4", "b/d3", "a/d2", "a/d1", "a/d0"}},
+		{false, false, true, 1, 2, []string{"d/d5", "c/d7"}},
+		{false, true, true, 0, 0, []string{"d/d6", "c/d7", "b/d3", "a/d2"}},
+		{false, true, true, 1, 2, []string{"c/d7", "b/d3"}},
+		// forward scan of descending index, docids remain ascending.
+		{true, false, false, 0, 0,
+			[]string{"d/d5", "d/d6", "c/d4", "c/d7", "b/d3", "a/d0", "a/d1", "a/d2"}},
+		{true, true, false, 1, 2, []string{"c/d4", "b/d3"}},
+	}
+
+	for _, tc := range testcases {
+		defn := &common.IndexDefn{SecExprs: []string{"name"}}
+		if tc.desc {
+			defn.SecExprs = []string{"name DESC"}
+		}
+		// partitions are sorted in descending order of secondary keys.
+		streams := newTestStreams(testPartitions, tc.desc || tc.reverse)
+
+		var out []string
+		ended := false
+		last, err := gather(
+			defn, streams, tc.distinct, tc.reverse, tc.offset, tc.limit,
+			func(resp ResponseReader) bool {
+				if _, ok := resp.(*protobuf.StreamEndResponse); ok {
+					ended = true
+				}
+				secKeys, docids, err := resp.GetEntries()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, secKey := range secKeys {
+					out = append(out, fmt.Sprintf("%v/%s", secKey[0], docids[i]))
+				}
+				return true
+			})
+		if err != nil {
+			t.Fatal(err)
+		} else if !ended {
+			t.Errorf("%+v: expected end of stream", tc)
+		}
+		if !reflect.DeepEqual(out, tc.ref) {
+			t.Errorf("%+v: expected %v, got %v", tc, tc.ref, out)
+		}
+		if n := len(tc.ref); n > 0 && string(last.GetPrimaryKey()) != tc.ref[n-1][2:] {
+			t.Errorf("%+v: unexpected last entry %v", tc, last)
+		} else if n == 0 && last != nil {
+			t.Errorf("%+v: unexpected last entry %v", tc, last)
+		}
+	}
+}
+
+func TestGatherStop(t *testing.T) {
+	defn := &common.IndexDefn{SecExprs: []string{"name"}}
+	entries := make([]string, 0, 2*gatherBatchSize)
+	for i := 0; i < cap(entries); i++ {
+		entries = append(entries, fmt.Sprintf("a/%03d", i))
+	}
+	streams := newTestStreams([][]string{entries}, false)
+
+	calls := 0
+	last, err := gather(defn, streams, false, false, 0, 0,
+		func(resp ResponseReader) bool {
+			calls++
+			return false
+		})
+	if err != nil {
+		t.Fatal(err)
+	} else if calls != 1 {
+		t.Fatalf("expected callback to be stopped, got %v calls", calls)
+	} else if string(last.GetPrimaryKey()) != entries[gatherBatchSize-1][2:] {
+		t.Fatalf("unexpected last entry %v", last)
+	}
+}
+
+func TestGatherError(t *testing.T) {
+	defn := &common.IndexDefn{SecExprs: []string{"name"}}
+	streams := newTestStreams(testPartitions, false)
+	streams[1].err = ErrorNoHost
+
+	_, err := gather(defn, streams, false, false, 0, 0,
+		func(resp ResponseReader) bool { return true })
+	if err != ErrorNoHost {
+		t.Fatalf("expected %v, got %v", ErrorNoHost, err)
+	}
+}
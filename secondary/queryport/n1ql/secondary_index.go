@@ -33,6 +33,9 @@ var ErrorIndexEmpty = errors.NewError(nil, "gsi.empty")
 // ErrorEmptyHost is no valid node hosting an index.
 var ErrorEmptyHost = errors.NewError(nil, "gsi.emptyHost")
 
+// ErrorExpectedVector is at_plus scan without timestamp vector.
+var ErrorExpectedVector = errors.NewError(nil, "gsi.expectedVector")
+
 var n1ql2GsiInclusion = map[datastore.Inclusion]qclient.Inclusion{
 	datastore.NEITHER: qclient.Neither,
 	datastore.LOW:     qclient.Low,
@@ -462,6 +465,11 @@ func (si *secondaryIndex) RangeKey() expression.Expressions {
 	if si != nil && si.secExprs != nil {
 		exprs := make(expression.Expressions, 0, len(si.secExprs))
 		for _, exprS := range si.secExprs {
+			exprS, _ = c.KeyOrder(exprS)
+			// array index keys are elements of the array expression
+			if arrExpr, _, ok := c.ArrayExpr(exprS); ok {
+				exprS = arrExpr
+			}
 			expr, _ := parser.Parse(exprS)
 			exprs = append(exprs, expr)
 		}
@@ -558,19 +566,25 @@ func (si *secondaryIndex) Scan(
 	entryChannel := conn.EntryChannel()
 	defer close(entryChannel)
 
+	gsicons, tsvector, err := n1ql2GsiConsistency(cons, vector)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+
 	client := si.gsi.gsiClient
 	if span.Seek != nil {
 		seek := values2SKey(span.Seek)
 		client.Lookup(
 			si.defnID, []c.SecondaryKey{seek}, distinct, limit,
-			makeResponsehandler(conn))
+			gsicons, tsvector, makeResponsehandler(conn))
 
 	} else {
 		low, high := values2SKey(span.Range.Low), values2SKey(span.Range.High)
 		incl := n1ql2GsiInclusion[span.Range.Inclusion]
 		client.Range(
 			si.defnID, low, high, incl, distinct, limit,
-			makeResponsehandler(conn))
+			gsicons, tsvector, makeResponsehandler(conn))
 	}
 }
 
@@ -582,8 +596,15 @@ func (si *secondaryIndex) ScanEntries(
 	entryChannel := conn.EntryChannel()
 	defer close(entryChannel)
 
+	gsicons, tsvector, err := n1ql2GsiConsistency(cons, vector)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+
 	client := si.gsi.gsiClient
-	client.ScanAll(si.defnID, limit, makeResponsehandler(conn))
+	client.ScanAll(
+		si.defnID, limit, gsicons, tsvector, makeResponsehandler(conn))
 }
 
 //-------------------------------------
@@ -633,6 +654,7 @@ type statistics struct {
 	uniqueKeys int64
 	min        value.Values
 	max        value.Values
+	bins       []datastore.Statistics
 }
 
 // return an
@@ -645,6 +667,10 @@ func newStatistics(pstats c.IndexStatistics) datastore.Statistics {
 	stats.min = skey2Values(min)
 	max, _ := pstats.MaxKey()
 	stats.max = skey2Values(max)
+	bins, _ := pstats.Bins()
+	for _, bin := range bins {
+		stats.bins = append(stats.bins, newStatistics(bin))
+	}
 	return stats
 }
 
@@ -670,7 +696,7 @@ func (stats *statistics) Max() (value.Values, errors.Error) {
 
 // Bins implement Statistics{} interface.
 func (stats *statistics) Bins() ([]datastore.Statistics, errors.Error) {
-	return nil, nil
+	return stats.bins, nil
 }
 
 //------------------
@@ -705,6 +731,45 @@ func skey2Values(skey c.SecondaryKey) []value.Value {
 	return vals
 }
 
+// map n1ql scan consistency and timestamp vector to gsi consistency.
+//   unbounded  - AnyConsistency
+//   scan_plus  - SessionConsistency, indexer shall wait for KV's seqnos.
+//   at_plus    - QueryConsistency, indexer shall wait for `vector`.
+func n1ql2GsiConsistency(
+	cons datastore.ScanConsistency,
+	vector timestamp.Vector) (c.Consistency, *qclient.TsConsistency, errors.Error) {
+
+	switch cons {
+	case datastore.UNBOUNDED:
+		return c.AnyConsistency, nil, nil
+
+	case datastore.SCAN_PLUS:
+		return c.SessionConsistency, nil, nil
+
+	case datastore.AT_PLUS:
+		if vector == nil {
+			return 0, nil, ErrorExpectedVector
+		}
+		entries := vector.Entries()
+		vbnos := make([]uint16, 0, len(entries))
+		seqnos := make([]uint64, 0, len(entries))
+		vbuuids := make([]uint64, 0, len(entries))
+		for _, entry := range entries {
+			vbuuid, err := strconv.ParseUint(entry.Guard(), 10, 64)
+			if err != nil {
+				return 0, nil, errors.NewError(err, "GSI invalid vbuuid")
+			}
+			vbnos = append(vbnos, uint16(entry.Position()))
+			seqnos = append(seqnos, entry.Value())
+			vbuuids = append(vbuuids, vbuuid)
+		}
+		tsvector := qclient.NewTsConsistency(vbnos, seqnos, vbuuids)
+		return c.QueryConsistency, tsvector, nil
+	}
+	msg := fmt.Sprintf("GSI unsupported scan consistency %v", cons)
+	return 0, nil, errors.NewError(nil, msg)
+}
+
 // get cluster info and refresh ns-server data.
 func getClusterInfo(
 	cluster string, pooln string) (*c.ClusterInfoCache, errors.Error) {
@@ -32,13 +32,17 @@ type Server struct {
 	readDeadline   time.Duration
 	writeDeadline  time.Duration
 	streamChanSize int
+	compression    transport.TransportFlag
+	threshold      int // compression threshold
 	logPrefix      string
 
 	nConnections int64
+	nRequests    int64 // requests being served
 }
 
 type ServerStats struct {
-	Connections int64
+	Connections    int64
+	ActiveRequests int64
 }
 
 // NewServer creates a new queryport daemon.
@@ -54,8 +58,15 @@ func NewServer(
 		readDeadline:   time.Duration(config["readDeadline"].Int()),
 		writeDeadline:  time.Duration(config["writeDeadline"].Int()),
 		streamChanSize: config["streamChanSize"].Int(),
+		threshold:      config["compressionThreshold"].Int(),
 		logPrefix:      fmt.Sprintf("[Queryport %q]", laddr),
 	}
+	flags := transport.TransportFlag(0).SetProtobuf()
+	compression := config["compression"].String()
+	if s.compression, err = flags.SetCompression(compression); err != nil {
+		c.Errorf("%v invalid compression %q\n", s.logPrefix, compression)
+		return nil, err
+	}
 	if s.lis, err = net.Listen("tcp", laddr); err != nil {
 		c.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
 		return nil, err
@@ -68,7 +79,8 @@ func NewServer(
 
 func (s *Server) Statistics() ServerStats {
 	return ServerStats{
-		Connections: atomic.LoadInt64(&s.nConnections),
+		Connections:    atomic.LoadInt64(&s.nConnections),
+		ActiveRequests: atomic.LoadInt64(&s.nRequests),
 	}
 }
 
@@ -116,8 +128,40 @@ func (s *Server) listener() {
 	}
 }
 
+// queryStream is a request being served on a connection, requests tagged
+// with a request-id are multiplexed on the connection and served
+// concurrently.
+type queryStream struct {
+	id     uint64
+	tagged bool
+	endch  chan bool // closed when client requests to end the stream
+	// credits granted by client for a tagged stream, windowch is
+	// notified whenever credits are added.
+	credits  int64
+	windowch chan bool
+}
+
+func newQueryStream(id uint64, tagged bool) *queryStream {
+	return &queryStream{
+		id:       id,
+		tagged:   tagged,
+		endch:    make(chan bool),
+		windowch: make(chan bool, 1),
+	}
+}
+
+// addCredits granted by client, never blocks.
+func (st *queryStream) addCredits(credits uint32) {
+	atomic.AddInt64(&st.credits, int64(credits))
+	select {
+	case st.windowch <- true:
+	default:
+	}
+}
+
 // handle connection request. connection might be kept open in client's
-// connection pool.
+// connection pool. Untagged requests are served one after the other, as
+// the client waits for StreamEndResponse before posting the next request.
 func (s *Server) handleConnection(conn net.Conn) {
 	atomic.AddInt64(&s.nConnections, 1)
 	defer func() {
@@ -125,35 +169,68 @@ func (s *Server) handleConnection(conn net.Conn) {
 	}()
 
 	raddr := conn.RemoteAddr()
+	closech := make(chan bool)
 	defer func() {
+		close(closech)
 		conn.Close()
 		c.Debugf("%v connection %v closed\n", s.logPrefix, raddr)
 	}()
 
 	// start a receive routine.
 	rcvch := make(chan interface{}, s.streamChanSize)
-	go s.doReceive(conn, rcvch)
+	go s.doReceive(conn, rcvch, closech)
 
-	// transport buffer for transmission
-	flags := transport.TransportFlag(0).SetProtobuf()
-	tpkt := transport.NewTransportPacket(s.maxPayload, flags)
-	tpkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	// start a transmit routine, shared by all streams on this connection.
+	sendch := make(chan interface{}, s.streamChanSize)
+	go s.doTransmit(conn, sendch, closech)
+
+	streams := make(map[uint64]*queryStream)
+	donech := make(chan *queryStream, s.streamChanSize)
 
 loop:
 	for {
 		select {
 		case req, ok := <-rcvch:
-			if _, yes := req.(*protobuf.EndStreamRequest); yes { // skip
-				format := "%v connection %q skip protobuf.EndStreamRequest\n"
-				c.Debugf(format, s.logPrefix, raddr)
-				break
-			} else if !ok {
+			if !ok {
 				break loop
 			}
-			respch := make(chan interface{}, s.streamChanSize)
-			quitch := make(chan interface{}, s.streamChanSize)
-			go s.handleRequest(conn, tpkt, respch, rcvch, quitch)
-			s.callb(req, respch, quitch) // blocking call
+			var id uint64
+			var isTagged bool
+			if tagged, yes := req.(*protobuf.Tagged); yes {
+				id, req, isTagged = tagged.RequestId, tagged.Message, true
+			}
+			st, active := streams[id]
+
+			switch val := req.(type) {
+			case *protobuf.EndStreamRequest:
+				if !active { // skip
+					format := "%v connection %q skip protobuf.EndStreamRequest\n"
+					c.Debugf(format, s.logPrefix, raddr)
+					break
+				}
+				delete(streams, id)
+				close(st.endch)
+
+			case *protobuf.StreamWindow:
+				if active {
+					st.addCredits(val.GetCredits())
+				}
+
+			default:
+				if active {
+					format := "%v connection %q duplicate request-id %v\n"
+					c.Errorf(format, s.logPrefix, raddr, id)
+					break
+				}
+				st = newQueryStream(id, isTagged)
+				streams[id] = st
+				go s.handleRequest(conn, st, req, sendch, donech, closech)
+			}
+
+		case st := <-donech:
+			if streams[st.id] == st {
+				delete(streams, st.id)
+			}
 
 		case <-s.killch:
 			break loop
@@ -161,62 +238,132 @@ loop:
 	}
 }
 
+// serve request `req` by calling back the application and stream its
+// responses to the transmit routine.
 func (s *Server) handleRequest(
-	conn net.Conn,
-	tpkt *transport.TransportPacket,
-	respch, rcvch <-chan interface{}, quitch chan<- interface{}) {
+	conn net.Conn, st *queryStream, req interface{},
+	sendch chan<- interface{}, donech chan<- *queryStream,
+	closech <-chan bool) {
+
+	atomic.AddInt64(&s.nRequests, 1)
+	defer atomic.AddInt64(&s.nRequests, -1)
 
 	raddr := conn.RemoteAddr()
 
-	timeoutMs := s.writeDeadline * time.Millisecond
-	transmit := func(resp interface{}) error {
-		conn.SetWriteDeadline(time.Now().Add(timeoutMs))
-		err := tpkt.Send(conn, resp)
-		if err != nil {
-			format := "%v connection %v response transport failed `%v`\n"
-			c.Debugf(format, s.logPrefix, raddr, err)
+	respch := make(chan interface{}, s.streamChanSize)
+	quitch := make(chan interface{}, s.streamChanSize)
+	go s.callb(req, respch, quitch)
+
+	transmit := func(resp interface{}) bool {
+		if st.tagged {
+			resp = &protobuf.Tagged{RequestId: st.id, Message: resp}
+		}
+		select {
+		case sendch <- resp:
+			return true
+		case <-closech:
+			return false
+		case <-s.killch:
+			return false
+		}
+	}
+	endStream := func() {
+		// stream is done, a new request may reuse its request-id once
+		// the client receives StreamEndResponse.
+		select {
+		case donech <- st:
+		case <-closech:
+		}
+		if transmit(&protobuf.StreamEndResponse{}) {
+			format := "%v protobuf.StreamEndResponse -> %q\n"
+			c.Debugf(format, s.logPrefix, raddr)
 		}
-		return err
 	}
 
 	defer close(quitch)
 
+	// a response of a tagged stream is read ahead and held, with respch
+	// disabled, while the stream waits for credits. End of stream is
+	// detected, and sent, without credits.
+	var pending interface{}
+	rch, windowch := respch, chan bool(nil)
+
 loop:
 	for { // response loop to stream query results back to client
 		select {
-		case resp, ok := <-respch:
+		case resp, ok := <-rch:
 			if !ok {
-				if err := transmit(&protobuf.StreamEndResponse{}); err == nil {
-					format := "%v protobuf.StreamEndResponse -> %q\n"
-					c.Debugf(format, s.logPrefix, raddr)
-				}
+				endStream()
 				break loop
 			}
-			if err := transmit(resp); err != nil {
+			if st.tagged && atomic.LoadInt64(&st.credits) <= 0 {
+				pending, rch, windowch = resp, nil, st.windowch
+				continue
+			}
+			if !transmit(resp) {
 				break loop
 			}
+			if st.tagged {
+				atomic.AddInt64(&st.credits, -1)
+			}
 
-		case req, ok := <-rcvch:
-			if _, yes := req.(*protobuf.EndStreamRequest); ok && yes {
-				if err := transmit(&protobuf.StreamEndResponse{}); err == nil {
-					format := "%v protobuf.StreamEndResponse -> %q\n"
-					c.Debugf(format, s.logPrefix, raddr)
+		case <-windowch:
+			if atomic.LoadInt64(&st.credits) > 0 {
+				if !transmit(pending) {
+					break loop
 				}
-				break loop
-
-			} else if !ok {
-				break loop
+				atomic.AddInt64(&st.credits, -1)
+				pending, rch, windowch = nil, respch, nil
 			}
 
+		case <-st.endch:
+			endStream()
+			break loop
+
+		case <-closech:
+			break loop // connection closed
+
 		case <-s.killch:
 			break loop // close connection
 		}
 	}
 }
 
+// transmit responses of all streams on the connection, when this function
+// returns the connection is expected to be closed.
+func (s *Server) doTransmit(
+	conn net.Conn, sendch <-chan interface{}, closech <-chan bool) {
+
+	raddr := conn.RemoteAddr()
+
+	// transport buffer for transmission
+	tpkt := transport.NewTransportPacket(s.maxPayload, s.compression)
+	tpkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	tpkt.SetCompressionThreshold(s.threshold)
+
+	timeoutMs := s.writeDeadline * time.Millisecond
+	for {
+		select {
+		case resp := <-sendch:
+			conn.SetWriteDeadline(time.Now().Add(timeoutMs))
+			if err := tpkt.Send(conn, resp); err != nil {
+				format := "%v connection %v response transport failed `%v`\n"
+				c.Debugf(format, s.logPrefix, raddr, err)
+				conn.Close() // unblock receive routine
+				return
+			}
+
+		case <-closech:
+			return
+		}
+	}
+}
+
 // receive requests from remote, when this function returns
 // the connection is expected to be closed.
-func (s *Server) doReceive(conn net.Conn, rcvch chan<- interface{}) {
+func (s *Server) doReceive(
+	conn net.Conn, rcvch chan<- interface{}, closech <-chan bool) {
+
 	raddr := conn.RemoteAddr()
 
 	// transport buffer for receiving
@@ -244,6 +391,8 @@ loop:
 		}
 		select {
 		case rcvch <- req:
+		case <-closech:
+			break loop
 		case <-s.killch:
 			break loop
 		}
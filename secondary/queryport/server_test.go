@@ -1,25 +1,15 @@
-// +build ignore
-
 package queryport
 
-import "reflect"
+import "fmt"
+import "net"
 import "testing"
 import "time"
 
 import c "github.com/couchbase/indexing/secondary/common"
-import "github.com/couchbase/indexing/secondary/queryport/client"
+import "github.com/couchbase/indexing/secondary/transport"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 import "github.com/couchbaselabs/goprotobuf/proto"
 
-var testStatisticsResponse = &protobuf.StatisticsResponse{
-	Stats: &protobuf.IndexStatistics{
-		KeysCount:       proto.Uint64(100),
-		UniqueKeysCount: proto.Uint64(100),
-		KeyMin:          []byte("aaaaa"),
-		KeyMax:          []byte("zzzzz"),
-	},
-}
-
 var testResponseStream = &protobuf.ResponseStream{
 	IndexEntries: []*protobuf.IndexEntry{
 		&protobuf.IndexEntry{
@@ -31,325 +21,204 @@ var testResponseStream = &protobuf.ResponseStream{
 	},
 }
 
-func TestStatistics(t *testing.T) {
-	c.LogIgnore()
-	//c.SetLogLevel(c.LogLevelDebug)
-
-	addr := "localhost:9101"
-	serverCallb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
-
-		switch req.(type) {
-		case *protobuf.StatisticsRequest:
-			resp := testStatisticsResponse
-			select {
-			case respch <- resp:
-				close(respch)
-			case <-quitch:
-				t.Fatal("unexpected quit", req)
-			}
-
-		default:
-			t.Fatal("unknown request", req)
-		}
-	}
-
-	s := startServer(t, addr, serverCallb)
-	time.Sleep(100 * time.Millisecond)
+// testMuxClient talks to queryport on a single connection, requests are
+// tagged with a request-id, or untagged if request-id is 0.
+type testMuxClient struct {
+	conn net.Conn
+	tpkt *transport.TransportPacket
+	rpkt *transport.TransportPacket
+}
 
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	client, err := client.NewGsiClient("localhost:9000", config)
+func newTestMuxClient(t *testing.T, s *Server) *testMuxClient {
+	conn, err := net.Dial("tcp", s.lis.Addr().String())
 	if err != nil {
 		t.Fatal(err)
 	}
+	maxPayload := c.SystemConfig["queryport.indexer.maxPayload"].Int()
+	flags := transport.TransportFlag(0).SetProtobuf()
+	tc := &testMuxClient{
+		conn: conn,
+		tpkt: transport.NewTransportPacket(maxPayload, flags),
+		rpkt: transport.NewTransportPacket(maxPayload, flags),
+	}
+	tc.tpkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	tc.rpkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	return tc
+}
 
-	l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	out, err := client.RangeStatistics(0x0 /*defnID*/, l, h, 0)
-	if err != nil {
-		t.Fatal(err)
+func (tc *testMuxClient) send(t *testing.T, id uint64, msg interface{}) {
+	if id > 0 {
+		msg = &protobuf.Tagged{RequestId: id, Message: msg}
 	}
-	if reflect.DeepEqual(out, testStatisticsResponse.GetStats()) == false {
-		t.Fatal("failed on client.Statistics()")
+	if err := tc.tpkt.Send(tc.conn, msg); err != nil {
+		t.Fatal(err)
 	}
-	client.Close()
-	s.Close()
 }
 
-func TestRange(t *testing.T) {
-	c.LogIgnore()
-	addr := "localhost:9101"
-	serverCallb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
-
-		switch req.(type) {
-		case *protobuf.ScanRequest:
-		default:
-			t.Fatal("unknown request", req)
-		}
-		sendResponse(t, 10000, respch, quitch)
+// scan requests `count` responses on stream `id`, granting `credits`.
+func (tc *testMuxClient) scan(t *testing.T, id uint64, count, credits uint32) {
+	tc.send(t, id, &protobuf.ScanRequest{Limit: proto.Int64(int64(count))})
+	if id > 0 {
+		tc.grant(t, id, credits)
 	}
-	s := startServer(t, addr, serverCallb)
-	time.Sleep(100 * time.Millisecond)
+}
+
+func (tc *testMuxClient) grant(t *testing.T, id uint64, credits uint32) {
+	tc.send(t, id, &protobuf.StreamWindow{Credits: proto.Uint32(credits)})
+}
 
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
+// receive a response and the request-id of its stream.
+func (tc *testMuxClient) receive(t *testing.T) (uint64, interface{}) {
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := tc.rpkt.Receive(tc.conn)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	count := 0
-	l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	qc.Range(
-		0x0 /*defnID*/, l, h, 100, true, 1000,
-		func(val client.ResponseReader) bool {
-			if err := val.Error(); err != nil {
-				t.Fatal(err)
-			} else if skeys, _, err := val.GetEntries(); err != nil {
-				t.Fatal(err)
-
-			} else if len(skeys) > 0 {
-				count++
-				if count == 10000 {
-					return false
-				}
-			}
-			return true
-		})
-
-	count = 0
-	l, h = c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	qc.Range(
-		0x0 /*defnID*/, l, h, 100, true, 1000,
-		func(val client.ResponseReader) bool {
-			count++
-			if count == 2 {
-				return false
-			}
-			return true
-		})
-
-	qc.Close()
-	s.Close()
+	if tagged, ok := resp.(*protobuf.Tagged); ok {
+		return tagged.RequestId, tagged.Message
+	}
+	return 0, resp
 }
 
-func TestScanAll(t *testing.T) {
-	c.LogIgnore()
-	addr := "localhost:9101"
-	callb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
-
-		switch req.(type) {
-		case *protobuf.ScanAllRequest:
+// receiveN receives `n` responses and counts them by request-id, end of a
+// stream is counted under the key "<id>/end".
+func (tc *testMuxClient) receiveN(t *testing.T, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		id, resp := tc.receive(t)
+		switch resp.(type) {
+		case *protobuf.ResponseStream:
+			counts[fmt.Sprint(id)]++
+		case *protobuf.StreamEndResponse:
+			counts[fmt.Sprintf("%v/end", id)]++
 		default:
-			t.Fatal("unknown request", req)
+			t.Fatalf("unexpected response %T", resp)
 		}
-		sendResponse(t, 10000, respch, quitch)
 	}
-	s := startServer(t, addr, callb)
-	time.Sleep(100 * time.Millisecond)
+	return counts
+}
 
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
-	if err != nil {
-		t.Fatal(err)
+// expectNone fails if a response arrives within `timeout`.
+func (tc *testMuxClient) expectNone(t *testing.T, timeout time.Duration) {
+	tc.conn.SetReadDeadline(time.Now().Add(timeout))
+	if resp, err := tc.rpkt.Receive(tc.conn); err == nil {
+		t.Fatalf("unexpected response %v", resp)
 	}
-
-	count := 0
-	qc.ScanAll(
-		0x0 /*defnID*/, 1000,
-		func(val client.ResponseReader) bool {
-			if err := val.Error(); err != nil {
-				t.Fatal(err)
-			} else if skeys, _, err := val.GetEntries(); err != nil {
-				t.Fatal(err)
-			} else if len(skeys) > 0 {
-				count++
-				if count == 10000 {
-					return false
-				}
-			}
-			return true
-		})
-
-	count = 0
-	qc.ScanAll(
-		0x0 /*defnID*/, 1000,
-		func(val client.ResponseReader) bool {
-			count++
-			if count == 2 {
-				return false
-			}
-			return true
-		})
-
-	qc.Close()
-	s.Close()
 }
 
-func BenchmarkStatistics(b *testing.B) {
-	c.LogIgnore()
-	addr := "localhost:9101"
+// startScanServer starts a queryport that responds to a scan with as many
+// responses as its limit.
+func startScanServer(t *testing.T) *Server {
 	callb := func(
 		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
 
-		respch <- testStatisticsResponse
-		close(respch)
-	}
-	s := startServer(b, addr, callb)
-	time.Sleep(100 * time.Millisecond)
-
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	b.ResetTimer()
-	l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	for i := 0; i < b.N; i++ {
-		qc.RangeStatistics(0x0 /*defnID*/, l, h, 0)
+		scan, ok := req.(*protobuf.ScanRequest)
+		if !ok {
+			t.Errorf("unknown request %v", req)
+			close(respch)
+			return
+		}
+		sendResponse(t, int(scan.GetLimit()), respch, quitch)
 	}
-	b.StopTimer()
-	s.Close()
-	qc.Close()
-	time.Sleep(100 * time.Millisecond)
+	return startServer(t, "localhost:0", callb)
 }
 
-func BenchmarkRange1(b *testing.B) {
+func TestMuxInterleaved(t *testing.T) {
 	c.LogIgnore()
-	addr := "localhost:9101"
-	callb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+	s := startScanServer(t)
+	defer s.Close()
+	tc := newTestMuxClient(t, s)
+	defer tc.conn.Close()
 
-		respch <- testResponseStream
-		close(respch)
+	tc.scan(t, 1, 5, 100)
+	tc.scan(t, 2, 7, 100)
+	tc.scan(t, 3, 3, 100)
+	counts := tc.receiveN(t, 5+7+3+3)
+	ref := map[string]int{
+		"1": 5, "1/end": 1, "2": 7, "2/end": 1, "3": 3, "3/end": 1,
 	}
-	s := startServer(b, addr, callb)
-	time.Sleep(100 * time.Millisecond)
-
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
-	if err != nil {
-		b.Fatal(err)
+	if fmt.Sprint(counts) != fmt.Sprint(ref) {
+		t.Fatalf("expected %v, got %v", ref, counts)
 	}
 
-	b.ResetTimer()
-	l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	for i := 0; i < b.N; i++ {
-		qc.Range(
-			0x0 /*defnID*/, l, h, 100, true, 1000,
-			func(val client.ResponseReader) bool {
-				return true
-			})
+	// request-id of an ended stream can be reused.
+	tc.scan(t, 1, 2, 100)
+	if counts := tc.receiveN(t, 3); counts["1"] != 2 || counts["1/end"] != 1 {
+		t.Fatalf("unexpected responses %v", counts)
 	}
-	b.StopTimer()
-
-	s.Close()
-	qc.Close()
-	time.Sleep(100 * time.Millisecond)
 }
 
-func BenchmarkRange100(b *testing.B) {
+func TestMuxWindow(t *testing.T) {
 	c.LogIgnore()
-	addr := "localhost:9101"
-	callb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+	s := startScanServer(t)
+	defer s.Close()
+	tc := newTestMuxClient(t, s)
+	defer tc.conn.Close()
 
-		for i := 0; i < 100; i++ {
-			respch <- testResponseStream
-		}
-		close(respch)
+	// window is smaller than the count of responses.
+	tc.scan(t, 1, 10, 3)
+	if counts := tc.receiveN(t, 3); counts["1"] != 3 {
+		t.Fatalf("unexpected responses %v", counts)
 	}
-	s := startServer(b, addr, callb)
-	time.Sleep(100 * time.Millisecond)
+	tc.expectNone(t, 100*time.Millisecond)
 
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
-	if err != nil {
-		b.Fatal(err)
+	tc.grant(t, 1, 4)
+	if counts := tc.receiveN(t, 4); counts["1"] != 4 {
+		t.Fatalf("unexpected responses %v", counts)
 	}
+	tc.expectNone(t, 100*time.Millisecond)
 
-	b.ResetTimer()
-	l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	for i := 0; i < b.N; i++ {
-		qc.Range(
-			0x0 /*defnID*/, l, h, 100, true, 1000,
-			func(val client.ResponseReader) bool {
-				return true
-			})
+	// StreamEndResponse is sent without credits.
+	tc.grant(t, 1, 3)
+	if counts := tc.receiveN(t, 4); counts["1"] != 3 || counts["1/end"] != 1 {
+		t.Fatalf("unexpected responses %v", counts)
 	}
-	b.StopTimer()
-
-	s.Close()
-	qc.Close()
-	time.Sleep(100 * time.Millisecond)
 }
 
-func BenchmarkRangeParallel10(b *testing.B) {
+func TestMuxEndStream(t *testing.T) {
 	c.LogIgnore()
-	addr := "localhost:9101"
-	callb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
-
-		respch <- testResponseStream
-		close(respch)
-	}
-	s := startServer(b, addr, callb)
-	time.Sleep(100 * time.Millisecond)
-
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
-	if err != nil {
-		b.Fatal(err)
+	s := startScanServer(t)
+	defer s.Close()
+	tc := newTestMuxClient(t, s)
+	defer tc.conn.Close()
+
+	// consumer stops early, after exhausting the credits.
+	tc.scan(t, 1, 100, 2)
+	tc.scan(t, 2, 2, 100)
+	if counts := tc.receiveN(t, 2+3); counts["1"] != 2 || counts["2/end"] != 1 {
+		t.Fatalf("unexpected responses %v", counts)
+	}
+	tc.send(t, 1, &protobuf.EndStreamRequest{})
+	if id, resp := tc.receive(t); id != 1 {
+		t.Fatalf("expected end of stream 1, got %v %T", id, resp)
+	} else if _, ok := resp.(*protobuf.StreamEndResponse); !ok {
+		t.Fatalf("expected end of stream 1, got %T", resp)
+	}
+
+	// request handler has quit.
+	for i := 0; s.Statistics().ActiveRequests > 0; i++ {
+		if i == 100 {
+			t.Fatalf("expected no active requests, got %v", s.Statistics())
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
-
-	l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		qc.Range(
-			0x0 /*defnID*/, l, h, 100, true, 1000,
-			func(val client.ResponseReader) bool {
-				return false
-			})
+	if st := s.Statistics(); st.Connections != 1 {
+		t.Fatalf("expected single connection, got %v", st)
 	}
-	b.StopTimer()
-
-	s.Close()
-	qc.Close()
-	time.Sleep(100 * time.Millisecond)
 }
 
-func BenchmarkScanAll(b *testing.B) {
+func TestUntaggedRequest(t *testing.T) {
 	c.LogIgnore()
-	addr := "localhost:9101"
-	callb := func(
-		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+	s := startScanServer(t)
+	defer s.Close()
+	tc := newTestMuxClient(t, s)
+	defer tc.conn.Close()
 
-		respch <- testResponseStream
-		close(respch)
+	// untagged requests are served without credits.
+	tc.scan(t, 0, 10, 0)
+	if counts := tc.receiveN(t, 11); counts["0"] != 10 || counts["0/end"] != 1 {
+		t.Fatalf("unexpected responses %v", counts)
 	}
-	s := startServer(b, addr, callb)
-	time.Sleep(100 * time.Millisecond)
-
-	config := c.SystemConfig.SectionConfig("queryport.client.", true)
-	qc, err := client.NewGsiClient("localhost:9000", config)
-	if err != nil {
-		b.Fatal(err)
-	}
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		qc.ScanAll(
-			0x0 /*defnID*/, 1000,
-			func(val client.ResponseReader) bool {
-				return true
-			})
-	}
-	b.StopTimer()
-
-	s.Close()
-	qc.Close()
-	time.Sleep(100 * time.Millisecond)
 }
 
 func startServer(tb testing.TB, laddr string, callb RequestHandler) *Server {
@@ -18,7 +18,7 @@ func RangeWithClient(indexName, bucketName, server string, low, high []interface
 	scanErr = nil
 	defnID, _ := GetDefnID(client, bucketName, indexName)
 	scanResults := make(tc.ScanResponse)
-	connErr := client.Range(uint64(defnID), c.SecondaryKey(low), c.SecondaryKey(high), qc.Inclusion(inclusion), distinct, limit, func(response qc.ResponseReader) bool {
+	connErr := client.Range(uint64(defnID), c.SecondaryKey(low), c.SecondaryKey(high), qc.Inclusion(inclusion), distinct, limit, c.AnyConsistency, nil, func(response qc.ResponseReader) bool {
 		if err := response.Error(); err != nil {
 			scanErr = err
 			return false
@@ -60,7 +60,7 @@ func Range(indexName, bucketName, server string, low, high []interface{}, inclus
 	client := CreateClient(server, "2itest")
 	defnID, _ := GetDefnID(client, bucketName, indexName)
 	scanResults := make(tc.ScanResponse)
-	connErr := client.Range(uint64(defnID), c.SecondaryKey(low), c.SecondaryKey(high), qc.Inclusion(inclusion), distinct, limit, func(response qc.ResponseReader) bool {
+	connErr := client.Range(uint64(defnID), c.SecondaryKey(low), c.SecondaryKey(high), qc.Inclusion(inclusion), distinct, limit, c.AnyConsistency, nil, func(response qc.ResponseReader) bool {
 		if err := response.Error(); err != nil {
 			scanErr = err
 			return false
@@ -114,7 +114,7 @@ func Lookup(indexName, bucketName, server string, values []interface{}, distinct
 	client := CreateClient(server, "2itest")
 	defnID, _ := GetDefnID(client, bucketName, indexName)
 	scanResults := make(tc.ScanResponse)
-	connErr := client.Lookup(uint64(defnID), []c.SecondaryKey{values}, distinct, limit, func(response qc.ResponseReader) bool {
+	connErr := client.Lookup(uint64(defnID), []c.SecondaryKey{values}, distinct, limit, c.AnyConsistency, nil, func(response qc.ResponseReader) bool {
 		if err := response.Error(); err != nil {
 			scanErr = err
 			return false
@@ -155,7 +155,7 @@ func ScanAll(indexName, bucketName, server string, limit int64) (tc.ScanResponse
 	client := CreateClient(server, "2itest")
 	defnID, _ := GetDefnID(client, bucketName, indexName)
 	scanResults := make(tc.ScanResponse)
-	connErr := client.ScanAll(uint64(defnID), limit, func(response qc.ResponseReader) bool {
+	connErr := client.ScanAll(uint64(defnID), limit, c.AnyConsistency, nil, func(response qc.ResponseReader) bool {
 		if err := response.Error(); err != nil {
 			scanErr = err
 			return false
@@ -256,7 +256,7 @@ func endpointCallback(addr string, msg interface{}) bool {
 					case c.Snapshot:
 						_, start, end := kv.Snapshot()
 						mutations.snapshots[bucket][vbno] = [2]uint64{start, end}
-					case c.Upsert, c.UpsertDeletion, c.Deletion:
+					case c.Upsert, c.UpsertDeletion, c.Deletion, c.Expiration:
 						mutations.seqnos[bucket][vbno] = kv.GetSeqno()
 					}
 				}
@@ -62,6 +62,7 @@ loop:
 			l, h := c.SecondaryKey{[]byte("aaaa")}, c.SecondaryKey{[]byte("zzzz")}
 			err := client.Range(
 				0xABBA /*defnID*/, l, h, 100, true, 1000,
+				c.AnyConsistency, nil,
 				func(val qclient.ResponseReader) bool {
 					switch v := val.(type) {
 					case *protobuf.ResponseStream:
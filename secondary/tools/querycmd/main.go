@@ -74,11 +74,12 @@ type Command struct {
 	equal     c.SecondaryKey
 	inclusion qclient.Inclusion
 	limit     int64
+	cons      c.Consistency
 }
 
 func parseArgs(arguments []string) (*Command, []string) {
 	var fields, bindexes string
-	var inclusion uint
+	var inclusion, cons uint
 	var equal, low, high string
 
 	cmdOptions := &Command{}
@@ -106,6 +107,7 @@ func parseArgs(arguments []string) (*Command, []string) {
 	fset.StringVar(&equal, "equal", "", "Span.Lookup: [key]")
 	fset.UintVar(&inclusion, "incl", 0, "Range: 0|1|2|3")
 	fset.Int64Var(&cmdOptions.limit, "limit", 10, "Row limit")
+	fset.UintVar(&cons, "consistency", uint(c.AnyConsistency), "Scan consistency: 1 (any) | 2 (session)")
 	// options for logging
 	fset.BoolVar(&debug, "debug", false, "run in debug mode")
 	fset.BoolVar(&trace, "trace", false, "run in trace mode")
@@ -123,6 +125,7 @@ func parseArgs(arguments []string) (*Command, []string) {
 	}
 
 	cmdOptions.inclusion = qclient.Inclusion(inclusion)
+	cmdOptions.cons = c.Consistency(cons)
 	cmdOptions.secStrs = make([]string, 0)
 	if fields != "" {
 		for _, field := range strings.Split(fields, ",") {
@@ -316,11 +319,13 @@ func handleCommand(
 		fmt.Println("Scan index:")
 		if cmd.equal != nil {
 			equals := []c.SecondaryKey{cmd.equal}
-			client.Lookup(uint64(defnID), equals, false, limit, callb)
+			client.Lookup(
+				uint64(defnID), equals, false, limit, cmd.cons, nil, callb)
 
 		} else {
 			err = client.Range(
-				uint64(defnID), low, high, incl, false, limit, callb)
+				uint64(defnID), low, high, incl, false, limit, cmd.cons, nil,
+				callb)
 		}
 		if err == nil {
 			fmt.Println("Tota number of entries: ", entries)
@@ -329,7 +334,7 @@ func handleCommand(
 	case "scanAll":
 		defnID, _ := getDefnID(client, bucket, iname)
 		fmt.Println("ScanAll index:")
-		err = client.ScanAll(uint64(defnID), limit, callb)
+		err = client.ScanAll(uint64(defnID), limit, cmd.cons, nil, callb)
 		if err == nil {
 			fmt.Println("Tota number of entries: ", entries)
 		}
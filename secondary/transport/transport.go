@@ -4,16 +4,22 @@
 //
 //      where, packetlen == len(mutation)
 //
-// `flags` used for specifying encoding format, compression etc.
+// `flags` used for specifying encoding format, compression etc. Payloads
+// smaller than the compression threshold are sent uncompressed, and the
+// flags of such packets have compression bits set to CompressionNone.
 
 package transport
 
+import "bytes"
+import "compress/gzip"
 import "encoding/binary"
 import "errors"
 import "net"
 import "io"
+import "io/ioutil"
 
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/golang/snappy"
 
 // error codes
 
@@ -29,6 +35,9 @@ var ErrorEncoderUnknown = errors.New("transport.encoderUnknown")
 // ErrorDecoderUnknown for unknown decoder.
 var ErrorDecoderUnknown = errors.New("transport.decoderUnknown")
 
+// ErrorCompressionUnknown for unknown compression.
+var ErrorCompressionUnknown = errors.New("transport.compressionUnknown")
+
 // packet field offset and size in bytes
 const (
 	pktLenOffset  int = 0
@@ -48,10 +57,13 @@ type transporter interface { // facilitates unit testing
 // TransportPacket to send and receive mutation packets between router
 // and downstream client.
 type TransportPacket struct {
-	flags    TransportFlag
-	buf      []byte
-	encoders map[byte]Encoder
-	decoders map[byte]Decoder
+	flags     TransportFlag
+	buf       []byte
+	encoders  map[byte]Encoder
+	decoders  map[byte]Decoder
+	threshold int // minimum payload size for compression
+	zbuf      bytes.Buffer
+	zw        *gzip.Writer
 }
 
 // Encoder callback
@@ -92,6 +104,13 @@ func (pkt *TransportPacket) SetDecoder(typ byte, callb Decoder) *TransportPacket
 	return pkt
 }
 
+// SetCompressionThreshold in bytes, encoded payloads smaller than
+// `threshold` are sent uncompressed.
+func (pkt *TransportPacket) SetCompressionThreshold(threshold int) *TransportPacket {
+	pkt.threshold = threshold
+	return pkt
+}
+
 // Send payload to the other end using sufficient encoding and compression.
 func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err error) {
 	var data []byte
@@ -102,7 +121,10 @@ func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err err
 		return
 	}
 	// compress
-	if data, err = pkt.compress(data); err != nil {
+	flags := pkt.flags
+	if len(data) < pkt.threshold {
+		flags = flags & TransportFlag(0xFFF0)
+	} else if data, err = pkt.compress(data); err != nil {
 		return
 	}
 	// transport framing
@@ -116,7 +138,7 @@ func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err err
 	a, b := pktLenOffset, pktLenOffset+pktLenSize
 	binary.BigEndian.PutUint32(pkt.buf[a:b], uint32(len(data)))
 	a, b = pktFlagOffset, pktFlagOffset+pktFlagSize
-	binary.BigEndian.PutUint16(pkt.buf[a:b], uint16(pkt.flags))
+	binary.BigEndian.PutUint16(pkt.buf[a:b], uint16(flags))
 	if n, err = conn.Write(pkt.buf[:pktDataOffset]); err == nil {
 		if n, err = conn.Write(data); err == nil && n != len(data) {
 			c.Errorf("transport wrote only %v bytes for data\n", n)
@@ -149,7 +171,7 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 	a, b := pktLenOffset, pktLenOffset+pktLenSize
 	pktlen := binary.BigEndian.Uint32(pkt.buf[a:b])
 	a, b = pktFlagOffset, pktFlagOffset+pktFlagSize
-	pkt.flags = TransportFlag(binary.BigEndian.Uint16(pkt.buf[a:b]))
+	flags := TransportFlag(binary.BigEndian.Uint16(pkt.buf[a:b]))
 	if maxLen := uint32(len(pkt.buf)); pktlen > maxLen {
 		c.Errorf("receiving packet length %v > %v\n", pktlen, maxLen)
 		err = ErrorPacketOverflow
@@ -169,11 +191,11 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 	c.Tracef("read %v bytes on connection %v<-%v", len(data), laddr, raddr)
 
 	// de-compression
-	if data, err = pkt.decompress(data); err != nil {
+	if data, err = pkt.decompress(flags, data); err != nil {
 		return
 	}
 	// decoding
-	if payload, err = pkt.decode(data); err != nil {
+	if payload, err = pkt.decode(flags, data); err != nil {
 		return
 	}
 	return
@@ -193,8 +215,10 @@ func (pkt *TransportPacket) encode(payload interface{}) (data []byte, err error)
 
 // decode array of bytes back to payload, if callback was specified `nil` for
 // a valid type then return `data` as `payload`.
-func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error) {
-	typ := pkt.flags.GetEncoding()
+func (pkt *TransportPacket) decode(
+	flags TransportFlag, data []byte) (payload interface{}, err error) {
+
+	typ := flags.GetEncoding()
 	if callb, ok := pkt.decoders[typ]; ok {
 		return callb(data)
 	} else if callb == nil {
@@ -207,18 +231,63 @@ func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error)
 func (pkt *TransportPacket) compress(big []byte) (small []byte, err error) {
 	switch pkt.flags.GetCompression() {
 	case CompressionNone:
-		small = big
+		return big, nil
+
+	case CompressionSnappy:
+		return snappy.Encode(nil, big), nil
+
+	case CompressionGzip:
+		pkt.zbuf.Reset()
+		if pkt.zw == nil {
+			pkt.zw = gzip.NewWriter(&pkt.zbuf)
+		} else {
+			pkt.zw.Reset(&pkt.zbuf)
+		}
+		if _, err = pkt.zw.Write(big); err != nil {
+			return nil, err
+		} else if err = pkt.zw.Close(); err != nil {
+			return nil, err
+		}
+		return pkt.zbuf.Bytes(), nil
 	}
-	return
+	return nil, ErrorCompressionUnknown
 }
 
-// decompress array of bytes.
-func (pkt *TransportPacket) decompress(small []byte) (big []byte, err error) {
-	switch pkt.flags.GetCompression() {
+// decompress array of bytes, compressed as per `flags`. Decompressed data
+// is limited to maximum packet size.
+func (pkt *TransportPacket) decompress(
+	flags TransportFlag, small []byte) (big []byte, err error) {
+
+	maxLen := len(pkt.buf)
+	switch flags.GetCompression() {
 	case CompressionNone:
-		big = small
+		return small, nil
+
+	case CompressionSnappy:
+		if l, err := snappy.DecodedLen(small); err != nil {
+			return nil, err
+		} else if l > maxLen {
+			c.Errorf("receiving packet length %v > %v\n", l, maxLen)
+			return nil, ErrorPacketOverflow
+		}
+		return snappy.Decode(nil, small)
+
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(small))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		big, err = ioutil.ReadAll(io.LimitReader(zr, int64(maxLen)+1))
+		if err != nil {
+			return nil, err
+		} else if len(big) > maxLen {
+			c.Errorf("receiving packet length > %v\n", maxLen)
+			return nil, ErrorPacketOverflow
+		}
+		return big, nil
 	}
-	return
+	return nil, ErrorCompressionUnknown
 }
 
 // read len(buf) bytes from `conn`.
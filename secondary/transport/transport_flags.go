@@ -30,6 +30,20 @@ const ( // types of compression over the wire.
 // TransportFlag tell packet encoding and compression formats.
 type TransportFlag uint16
 
+// SetCompression will set packet compression by its name, "none", "snappy"
+// or "gzip", as specified in configuration.
+func (flags TransportFlag) SetCompression(name string) (TransportFlag, error) {
+	switch name {
+	case "none", "":
+		return flags & TransportFlag(0xFFF0), nil
+	case "snappy":
+		return flags.SetSnappy(), nil
+	case "gzip":
+		return flags.SetGzip(), nil
+	}
+	return flags, ErrorCompressionUnknown
+}
+
 // GetCompression returns the compression bits from flags
 func (flags TransportFlag) GetCompression() byte {
 	return byte(flags & TransportFlag(0x000F))